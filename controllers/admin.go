@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"path"
@@ -11,9 +12,12 @@ import (
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
 	"github.com/gorilla/websocket"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 	m "maiyajia.com/models"
 	"maiyajia.com/services/daemon"
 	"maiyajia.com/services/mongo"
+	"maiyajia.com/util"
 )
 
 type out struct {
@@ -35,6 +39,8 @@ type AdminController struct {
 	upgradeMod  daemon.UpgradeModels
 	livenessMod daemon.LivenessModel
 	accountMod  daemon.AccountModels
+	workMod     m.WorkModels
+	quotaMod    m.QuotaModels
 }
 
 // NestPrepare 初始化函数
@@ -44,6 +50,8 @@ func (adminCtl *AdminController) NestPrepare() {
 	adminCtl.userMod.MgoSession = adminCtl.MgoClient
 	adminCtl.toolMod.MgoSession = &adminCtl.MgoClient
 	adminCtl.courseMod.MgoSession = &adminCtl.MgoClient
+	adminCtl.workMod.MgoSession = &adminCtl.MgoClient
+	adminCtl.quotaMod.MgoSession = &adminCtl.MgoClient
 	adminCtl.upgradeMod.CourseMod.MgoSession = &adminCtl.MgoClient
 	adminCtl.upgradeMod.ToolMod.MgoSession = &adminCtl.MgoClient
 }
@@ -56,6 +64,12 @@ func (adminCtl *AdminController) GetSystemInfo() {
 	out["code"] = 0
 	out["memory"] = daemon.QueryMemUsage()
 	out["disk"] = daemon.QueryDiskUsage()
+	// 作品文件占用的空间，统计失败不影响其他系统信息
+	if report, _, err := adminCtl.quotaMod.StorageReport(1); err == nil {
+		out["works"] = report
+	} else {
+		logs.Error("StorageReport err:", err)
+	}
 	adminCtl.jsonResult(out)
 }
 
@@ -216,7 +230,7 @@ func (adminCtl *AdminController) DownloadData() {
 		logs.Error("remove dir[%s] fail", path.Join(beego.AppPath, "asset", "tools"))
 		return
 	}
-	if err := os.RemoveAll(path.Join(beego.AppPath, "asset", "courses")); err != nil {
+	if err := m.RemoveAssetDir(path.Join("asset", "courses")); err != nil {
 		logs.Error("remove dir[%s] fail", path.Join(beego.AppPath, "asset", "courses"))
 		return
 	}
@@ -264,6 +278,7 @@ func (adminCtl *AdminController) DownloadData() {
 		logs.Error("error:", err)
 	}
 	ws.WriteMessage(websocket.TextMessage, send_msg)
+	daemon.RefreshSearchIndex()
 	beego.Informational("Courses install complete!")
 }
 
@@ -299,6 +314,106 @@ func (adminCtl *AdminController) GetLivenessCount() {
 	adminCtl.jsonResult(out)
 }
 
+//GetWorksStats Scratch作品统计：角色数、积木数、使用的扩展和积木分类、云变量（管理员权限）
+func (adminCtl *AdminController) GetWorksStats() {
+	token := adminCtl.checkToken()
+	adminCtl.needAdminPermission(token)
+	stats, err := adminCtl.workMod.QueryScratchStats()
+	if err != nil {
+		logs.Error("QueryScratchStats err:", err)
+		adminCtl.abortWithError(m.ERR_READ_WORK_FAIL)
+	}
+	// 封装返回数据
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["scratch"] = stats
+	adminCtl.jsonResult(out)
+}
+
+//GetStorageQuotas 作品存储空间的使用报告：汇总、配额使用率超过 over（默认0.9）的用户、管理员设置的配额和角色的配额（管理员权限）
+func (adminCtl *AdminController) GetStorageQuotas() {
+	token := adminCtl.checkToken()
+	adminCtl.needAdminPermission(token)
+	over, err := adminCtl.GetFloat("over", 0.9)
+	if err != nil || over < 0 {
+		adminCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	report, users, err := adminCtl.quotaMod.StorageReport(over)
+	if err != nil {
+		logs.Error("StorageReport err:", err)
+		adminCtl.abortWithError(m.ERR_QUOTA_QUERY_FAIL)
+	}
+	quotas, err := adminCtl.quotaMod.Quotas()
+	if err != nil {
+		logs.Error("Quotas err:", err)
+		adminCtl.abortWithError(m.ERR_QUOTA_QUERY_FAIL)
+	}
+	roles := make(map[string]int64)
+	for _, role := range []string{m.ROLE_STUDENT, m.ROLE_TEACHER, m.ROLE_ADMIN} {
+		if roles[role], err = adminCtl.quotaMod.RoleQuota(role); err != nil {
+			logs.Error("RoleQuota err:", err)
+			adminCtl.abortWithError(m.ERR_QUOTA_QUERY_FAIL)
+		}
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["report"] = report
+	out["users"] = users
+	out["quotas"] = quotas
+	out["roles"] = roles
+	adminCtl.jsonResult(out)
+}
+
+//PutStorageQuota 设置用户、班级或角色的存储配额（管理员权限）。limitMB 为0表示不限制，小于0表示删除设置、恢复默认配额
+func (adminCtl *AdminController) PutStorageQuota() {
+	token := adminCtl.checkToken()
+	adminCtl.needAdminPermission(token)
+	var form struct {
+		Scope   string `json:"scope"`
+		Target  string `json:"target"`
+		LimitMB int64  `json:"limitMB"`
+	}
+	if err := json.Unmarshal(adminCtl.Ctx.Input.RequestBody, &form); err != nil {
+		adminCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	switch form.Scope {
+	case m.QuotaUser:
+		if !bson.IsObjectIdHex(form.Target) {
+			adminCtl.abortWithError(m.ERR_REQUEST_PARAM)
+		}
+		if _, err := adminCtl.userMod.FindUserByID(bson.ObjectIdHex(form.Target)); err != nil {
+			adminCtl.abortWithError(m.ERR_USER_NONE)
+		}
+	case m.QuotaClass:
+		classMod := m.ClassModels{MgoSession: &adminCtl.MgoClient}
+		if !classMod.HasClassCode(form.Target) {
+			adminCtl.abortWithError(m.ERR_CLASS_NONE)
+		}
+	case m.QuotaRole:
+		if form.Target != m.ROLE_STUDENT && form.Target != m.ROLE_TEACHER && form.Target != m.ROLE_ADMIN {
+			adminCtl.abortWithError(m.ERR_REQUEST_PARAM)
+		}
+	default:
+		adminCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	var err error
+	if form.LimitMB < 0 {
+		err = adminCtl.quotaMod.DeleteQuota(form.Scope, form.Target)
+		if err == mgo.ErrNotFound {
+			err = nil
+		}
+	} else {
+		err = adminCtl.quotaMod.SetQuota(form.Scope, form.Target, form.LimitMB<<20)
+	}
+	if err != nil {
+		logs.Error("SetQuota err:", err)
+		adminCtl.abortWithError(m.ERR_QUOTA_SAVE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	adminCtl.jsonResult(out)
+}
+
 //InsertLiveness 初次用户活跃度统计记录
 func (adminCtl *AdminController) InsertLiveness() {
 
@@ -332,6 +447,77 @@ func (adminCtl *AdminController) InsertLiveness() {
 	adminCtl.jsonResult(out)
 }
 
+//ImportCoursePackage 上传并导入离线课程包（管理员权限）
+func (adminCtl *AdminController) ImportCoursePackage() {
+	token := adminCtl.checkToken()
+	adminCtl.needAdminPermission(token)
+	_, header, err := adminCtl.GetFile("file")
+	if err != nil {
+		adminCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if err := util.CreateDir(path.Join(beego.AppPath, "tmp")); err != nil {
+		adminCtl.abortWithError(m.ERR_DIRECTORY_CREATE)
+	}
+	pkgPath := path.Join(beego.AppPath, "tmp", fmt.Sprintf("course_%d_%s", time.Now().UnixNano(), path.Base(header.Filename)))
+	if err := adminCtl.SaveToFile("file", pkgPath); err != nil {
+		logs.Error("save course package fail:", err)
+		adminCtl.abortWithError(m.ERR_COURSE_PACKAGE_UPLOAD_FAIL)
+	}
+	defer os.Remove(pkgPath)
+	course, err := adminCtl.courseMod.ImportCoursePackage(pkgPath)
+	if err != nil {
+		logs.Error("ImportCoursePackage err:", err)
+		adminCtl.abortWithError(m.ERR_COURSE_PACKAGE_IMPORT_FAIL)
+	}
+	daemon.RefreshSearchIndex()
+	// 封装返回数据
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["course"] = course
+	adminCtl.jsonResult(out)
+}
+
+// CompleteCourseUpload 分块上传的离线课程包全部上传并校验后导入（管理员权限）
+func (adminCtl *AdminController) CompleteCourseUpload() {
+	token := adminCtl.checkToken()
+	adminCtl.needAdminPermission(token)
+	session := adminCtl.needUploadSession(token, m.UploadCourse)
+	pkgPath := path.Join(beego.AppPath, "tmp", fmt.Sprintf("course_%d_%s", time.Now().UnixNano(), path.Base(session.Filename)))
+	adminCtl.completeUpload(session, pkgPath)
+	defer os.Remove(pkgPath)
+	course, err := adminCtl.courseMod.ImportCoursePackage(pkgPath)
+	if err != nil {
+		logs.Error("ImportCoursePackage err:", err)
+		adminCtl.abortWithError(m.ERR_COURSE_PACKAGE_IMPORT_FAIL)
+	}
+	daemon.RefreshSearchIndex()
+	// 封装返回数据
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["course"] = course
+	adminCtl.jsonResult(out)
+}
+
+// CompleteToolUpload 分块上传的工具包全部上传并校验后解压安装，工具信息在创建上传会话时提交（管理员权限）
+func (adminCtl *AdminController) CompleteToolUpload() {
+	token := adminCtl.checkToken()
+	adminCtl.needAdminPermission(token)
+	session := adminCtl.needUploadSession(token, m.UploadTool)
+	if session.Tool == nil || !m.ValidToolName(session.Tool.Name) {
+		adminCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	adminCtl.completeUpload(session, m.ToolPackagePath(session.Tool.Name))
+	if err := adminCtl.toolMod.InstallToolPackage(*session.Tool); err != nil {
+		logs.Error("InstallToolPackage err:", err)
+		adminCtl.abortWithError(m.ERR_TOOL_PACKAGE_IMPORT_FAIL)
+	}
+	// 封装返回数据
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["tool"] = session.Tool
+	adminCtl.jsonResult(out)
+}
+
 /*********************************************************************************************/
 /*********************************** 以下为本控制器的内部函数 *********************************/
 /*********************************** *********************************************************/
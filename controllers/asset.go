@@ -0,0 +1,33 @@
+// @APIVersion 1.0.0
+// @Title 静态资源过滤器
+// @Description 使用S3存储时 /asset 下的文件不在本地，由过滤器从存储后端发送
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"os"
+	"path"
+
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/storage"
+)
+
+// AssetFilter 存储后端不是本地目录时从存储发送 /asset 下的文件，文件不存在时交给静态文件服务处理
+func AssetFilter(ctx *context.Context) {
+	if m.LocalAssets() || (ctx.Input.Method() != "GET" && ctx.Input.Method() != "HEAD") {
+		return
+	}
+	name := path.Clean(ctx.Request.URL.Path)[1:]
+	if !storage.ValidName(name) {
+		return
+	}
+	if err := storage.Serve(ctx.ResponseWriter, ctx.Request, m.Assets(), name); err != nil && !os.IsNotExist(err) {
+		logs.Error("serve asset err:", name, err)
+	}
+}
@@ -0,0 +1,246 @@
+// @APIVersion 1.0.0
+// @Title 班级作业接口服务
+// @Description 老师给班级布置课时作业，学生查看待完成和已逾期的作业
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/token"
+)
+
+// AssignmentController 班级作业控制器
+type AssignmentController struct {
+	BaseController
+	assignMod m.AssignmentModels
+	courseMod m.CourseModels
+}
+
+// NestPrepare 初始化函数
+func (assignCtl *AssignmentController) NestPrepare() {
+	assignCtl.assignMod.MgoSession = &assignCtl.MgoClient
+	assignCtl.assignMod.CourseMod.MgoSession = &assignCtl.MgoClient
+	assignCtl.assignMod.MessageMod.MgoSession = &assignCtl.MgoClient
+	assignCtl.courseMod.MgoSession = &assignCtl.MgoClient
+}
+
+// assignmentCredential 布置或修改作业的请求参数
+type assignmentCredential struct {
+	ID        string    `json:"id"`
+	ClassCode string    `json:"classCode"`
+	CourseID  string    `json:"courseID"`
+	LessonID  string    `json:"lessonID"`
+	OpenTime  time.Time `json:"openTime"`
+	DueTime   time.Time `json:"dueTime"`
+}
+
+// CreateAssignment 给班级布置课时作业（班级创建者或管理员）
+func (assignCtl *AssignmentController) CreateAssignment() {
+	token := assignCtl.checkToken()
+	assignCtl.needAdminOrTeacherPermission(token)
+	var credential assignmentCredential
+	if err := json.Unmarshal(assignCtl.Ctx.Input.RequestBody, &credential); err != nil {
+		assignCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if credential.ClassCode == "" || !bson.IsObjectIdHex(credential.CourseID) || !bson.IsObjectIdHex(credential.LessonID) {
+		assignCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if !credential.DueTime.After(credential.OpenTime) {
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_TIME_INVALID)
+	}
+	assignCtl.needClassOwner(token, credential.ClassCode)
+
+	courseID := bson.ObjectIdHex(credential.CourseID)
+	if ok, err := assignCtl.courseMod.ClassHasCourse(credential.ClassCode, courseID); err != nil || !ok {
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_COURSE_NOT_IN_CLASS)
+	}
+	course, err := assignCtl.courseMod.FindCourseByID(credential.CourseID)
+	if err != nil {
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_COURSE_NOT_IN_CLASS)
+	}
+	lesson, err := assignCtl.courseMod.GetLessonById(credential.CourseID, credential.LessonID)
+	if err != nil || lesson == nil {
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_COURSE_NOT_IN_CLASS)
+	}
+	assignment := &m.Assignment{
+		ClassCode:  credential.ClassCode,
+		CourseID:   courseID,
+		LessonID:   lesson.ID,
+		CourseName: course.Name,
+		LessonName: lesson.Name,
+		OpenTime:   credential.OpenTime,
+		DueTime:    credential.DueTime,
+		Creator:    bson.ObjectIdHex(token.UserID),
+	}
+	if err := assignCtl.assignMod.CreateAssignment(assignment); err != nil {
+		logs.Error("CreateAssignment err:", err)
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["assignment"] = assignment
+	assignCtl.jsonResult(out)
+}
+
+// UpdateAssignment 修改作业的开放和截止时间
+func (assignCtl *AssignmentController) UpdateAssignment() {
+	token := assignCtl.checkToken()
+	assignCtl.needAdminOrTeacherPermission(token)
+	var credential assignmentCredential
+	if err := json.Unmarshal(assignCtl.Ctx.Input.RequestBody, &credential); err != nil {
+		assignCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if !credential.DueTime.After(credential.OpenTime) {
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_TIME_INVALID)
+	}
+	assignment := assignCtl.needAssignmentOwner(token, credential.ID)
+	if err := assignCtl.assignMod.UpdateAssignmentTime(assignment.ID, credential.OpenTime, credential.DueTime); err != nil {
+		logs.Error("UpdateAssignmentTime err:", err)
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	assignCtl.jsonResult(out)
+}
+
+// DeleteAssignment 删除作业
+func (assignCtl *AssignmentController) DeleteAssignment() {
+	token := assignCtl.checkToken()
+	assignCtl.needAdminOrTeacherPermission(token)
+	assignment := assignCtl.needAssignmentOwner(token, assignCtl.GetString("id"))
+	if err := assignCtl.assignMod.DeleteAssignment(assignment.ID); err != nil {
+		logs.Error("DeleteAssignment err:", err)
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	assignCtl.jsonResult(out)
+}
+
+// GetClassAssignments 查询班级的作业列表
+func (assignCtl *AssignmentController) GetClassAssignments() {
+	token := assignCtl.checkToken()
+	assignCtl.needAdminOrTeacherPermission(token)
+	classCode := assignCtl.GetString("classCode")
+	assignCtl.needClassOwner(token, classCode)
+	assignments, err := assignCtl.assignMod.ClassAssignments(classCode)
+	if err != nil {
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["assignments"] = assignments
+	assignCtl.jsonResult(out)
+}
+
+// GetAssignmentMatrix 查询班级作业完成情况矩阵，行为学生，列为作业
+func (assignCtl *AssignmentController) GetAssignmentMatrix() {
+	token := assignCtl.checkToken()
+	assignCtl.needAdminOrTeacherPermission(token)
+	classCode := assignCtl.GetString("classCode")
+	class := assignCtl.needClassOwner(token, classCode)
+	matrix, err := assignCtl.assignMod.AssignmentMatrix(class, time.Now())
+	if err != nil {
+		logs.Error("AssignmentMatrix err:", err)
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["matrix"] = matrix
+	assignCtl.jsonResult(out)
+}
+
+// ExportAssignmentMatrix 把班级作业完成情况和作品得分导出为CSV表格
+func (assignCtl *AssignmentController) ExportAssignmentMatrix() {
+	token := assignCtl.checkToken()
+	assignCtl.needAdminOrTeacherPermission(token)
+	class := assignCtl.needClassOwner(token, assignCtl.GetString("classCode"))
+	matrix, err := assignCtl.assignMod.AssignmentMatrix(class, time.Now())
+	if err != nil {
+		logs.Error("AssignmentMatrix err:", err)
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_QUERY_FAIL)
+	}
+	w := assignCtl.Ctx.ResponseWriter
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(class.Name+"-作业成绩.csv"))
+	w.Write([]byte("\ufeff")) // 让Excel按UTF-8打开
+	writer := csv.NewWriter(w)
+	header := []string{"用户名", "姓名"}
+	for _, a := range matrix.Assignments {
+		name := fmt.Sprintf("%s/%s", a.CourseName, a.LessonName)
+		header = append(header, name+" 完成情况", name+" 得分")
+	}
+	writer.Write(append(header, "总分", "满分"))
+	for _, row := range matrix.Students {
+		record := []string{row.Username, row.Realname}
+		var total, full float64
+		for _, cell := range row.Cells {
+			score := ""
+			if cell.GradeStatus != "" {
+				score = strconv.FormatFloat(cell.Score, 'f', -1, 64)
+				total += cell.Score
+				full += cell.FullScore
+			}
+			record = append(record, assignmentStatusNames[cell.Status], score)
+		}
+		writer.Write(append(record, strconv.FormatFloat(total, 'f', -1, 64), strconv.FormatFloat(full, 'f', -1, 64)))
+	}
+	writer.Flush()
+}
+
+// GetMyAssignments 学生查询自己待完成和已逾期的作业
+func (assignCtl *AssignmentController) GetMyAssignments() {
+	token := assignCtl.checkToken()
+	upcoming, overdue, err := assignCtl.assignMod.StudentAssignments(bson.ObjectIdHex(token.UserID), time.Now())
+	if err != nil {
+		logs.Error("StudentAssignments err:", err)
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["upcoming"] = upcoming
+	out["overdue"] = overdue
+	assignCtl.jsonResult(out)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本控制器的内部函数 *********************************/
+/*********************************** *********************************************************/
+
+// 导出表格中的作业状态名称
+var assignmentStatusNames = map[string]string{
+	m.AssignmentNotOpen:  "未开放",
+	m.AssignmentOpen:     "进行中",
+	m.AssignmentOverdue:  "已逾期",
+	m.AssignmentFinished: "已完成",
+}
+
+// needAssignmentOwner 查询作业并检查是否为作业所在班级的创建者
+func (assignCtl *AssignmentController) needAssignmentOwner(token *token.Token, id string) m.Assignment {
+	if !bson.IsObjectIdHex(id) {
+		assignCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	assignment, err := assignCtl.assignMod.FindAssignment(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_NONE)
+	}
+	if err != nil {
+		assignCtl.abortWithError(m.ERR_ASSIGNMENT_QUERY_FAIL)
+	}
+	assignCtl.needClassOwner(token, assignment.ClassCode)
+	return assignment
+}
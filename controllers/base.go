@@ -11,15 +11,21 @@ package controllers
 import (
 	"errors"
 	"fmt"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 	m "maiyajia.com/models"
 	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/storage"
 	"maiyajia.com/services/token"
+	"maiyajia.com/services/upload"
 )
 
 //BaseController 基础控制器
@@ -79,6 +85,148 @@ func (base *BaseController) needAdminOrTeacherPermission(token *token.Token) {
 	return
 }
 
+// needClassOwner 检查是否为班级的创建者（管理员不受限制），返回班级信息。
+// 班级代码为空表示课程级设置，只允许管理员操作
+func (base *BaseController) needClassOwner(token *token.Token, classCode string) m.Class {
+	if classCode == "" {
+		if token.UserRole != m.ROLE_ADMIN {
+			base.abortWithError(m.ERR_PERMISSION_DENIED)
+		}
+		return m.Class{}
+	}
+	classMod := m.ClassModels{MgoSession: &base.MgoClient}
+	class, err := classMod.FindClassByCode(classCode)
+	if err != nil {
+		base.abortWithError(m.ERR_CLASS_NONE)
+	}
+	if token.UserRole != m.ROLE_ADMIN && class.Creator.Hex() != token.UserID {
+		base.abortWithError(m.ERR_PERMISSION_DENIED)
+	}
+	return class
+}
+
+// needStorageQuota 检查用户写入size字节的作品文件后是否超出存储配额，replaced 为将被覆盖的文件的大小，按新旧文件的差值计算
+func (base *BaseController) needStorageQuota(token *token.Token, size int64, replaced ...int64) {
+	delta := size
+	for _, n := range replaced {
+		delta -= n
+	}
+	quotaMod := m.QuotaModels{MgoSession: &base.MgoClient}
+	_, err := quotaMod.CheckQuota(bson.ObjectIdHex(token.UserID), token.UserRole, delta)
+	if err == m.ErrQuotaExceeded {
+		base.abortWithError(m.ERR_QUOTA_EXCEEDED)
+	}
+	if err != nil {
+		logs.Error("CheckQuota err:", err)
+		base.abortWithError(m.ERR_QUOTA_QUERY_FAIL)
+	}
+}
+
+// needUploadSession 查询参数 id 指定的上传会话，只能操作自己创建的kind类型的会话
+func (base *BaseController) needUploadSession(token *token.Token, kind string) m.UploadSession {
+	id := base.GetString("id")
+	if !bson.IsObjectIdHex(id) {
+		base.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	uploadMod := m.UploadModels{MgoSession: &base.MgoClient}
+	session, err := uploadMod.FindUpload(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		base.abortWithError(m.ERR_UPLOAD_NONE)
+	}
+	if err != nil {
+		logs.Error("FindUpload err:", err)
+		base.abortWithError(m.ERR_UPLOAD_SAVE_FAIL)
+	}
+	if session.UserID.Hex() != token.UserID {
+		base.abortWithError(m.ERR_PERMISSION_DENIED)
+	}
+	if kind != "" && session.Kind != kind {
+		base.abortWithError(m.ERR_UPLOAD_KIND)
+	}
+	return session
+}
+
+// completeUpload 把上传完成的文件移动到dst，文件不完整或校验失败时返回错误，会话保留以便重新上传
+func (base *BaseController) completeUpload(session m.UploadSession, dst string) {
+	uploadMod := m.UploadModels{MgoSession: &base.MgoClient}
+	if err := uploadMod.CompleteUpload(session, dst); err != nil {
+		base.abortWithUploadError(err)
+	}
+}
+
+// abortWithUploadError 返回分块上传错误对应的错误码
+func (base *BaseController) abortWithUploadError(err error) {
+	switch err {
+	case upload.ErrNotFound:
+		base.abortWithError(m.ERR_UPLOAD_NONE)
+	case upload.ErrOffset:
+		base.abortWithError(m.ERR_UPLOAD_OFFSET)
+	case upload.ErrChecksum:
+		base.abortWithError(m.ERR_UPLOAD_CHECKSUM)
+	case upload.ErrSize:
+		base.abortWithError(m.ERR_UPLOAD_SIZE)
+	case upload.ErrIncomplete:
+		base.abortWithError(m.ERR_UPLOAD_INCOMPLETE)
+	case upload.ErrBusy:
+		base.abortWithError(m.ERR_UPLOAD_BUSY)
+	}
+	logs.Error("upload err:", err)
+	base.abortWithError(m.ERR_UPLOAD_SAVE_FAIL)
+}
+
+// uploadSize 请求中上传的文件的总大小
+func (base *BaseController) uploadSize(keys ...string) int64 {
+	form := base.Ctx.Request.MultipartForm
+	if form == nil {
+		return 0
+	}
+	var size int64
+	for _, key := range keys {
+		for _, header := range form.File[key] {
+			size += header.Size
+		}
+	}
+	return size
+}
+
+// downloadAsset 以附件形式发送存储中的文件，文件不存在时返回错误码code
+func (base *BaseController) downloadAsset(name string, code int) {
+	if _, err := m.Assets().Stat(name); err != nil {
+		base.abortWithError(code)
+	}
+	base.Ctx.Output.Header("Content-Disposition", "attachment; filename="+path.Base(name))
+	base.Ctx.Output.Header("Content-Type", "application/octet-stream")
+	if err := storage.Serve(base.Ctx.ResponseWriter, base.Ctx.Request, m.Assets(), name); err != nil {
+		logs.Error("download asset err:", name, err)
+		base.abortWithError(code)
+	}
+}
+
+// needStudentCourse 检查学生所在班级是否选了该课程，且课时或资源所在课时已解锁
+func (base *BaseController) needStudentCourse(uid, courseID, itemID bson.ObjectId) {
+	courseMod := m.CourseModels{MgoSession: &base.MgoClient}
+	unlockMod := m.UnlockModels{MgoSession: &base.MgoClient, CourseMod: courseMod}
+	codes, err := courseMod.StudentCourseClasses(uid, courseID)
+	if err != nil {
+		logs.Error("StudentCourseClasses err:", err)
+		base.abortWithError(m.ERR_LESSIONS_MESSAGE_QUERY_FAIL)
+	}
+	if len(codes) == 0 {
+		base.abortWithError(m.ERR_PERMISSION_DENIED)
+	}
+	locked, err := unlockMod.IsItemLocked(uid, courseID, itemID)
+	if err == m.ErrItemNotInCourse {
+		base.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if err != nil {
+		logs.Error("IsItemLocked err:", err)
+		base.abortWithError(m.ERR_LESSIONS_MESSAGE_QUERY_FAIL)
+	}
+	if locked {
+		base.abortWithError(m.ERR_LESSION_LOCKED)
+	}
+}
+
 // jsonResult 服务端返回json
 func (base *BaseController) jsonResult(out interface{}) {
 	base.Data["json"] = out
@@ -0,0 +1,225 @@
+// @APIVersion 1.0.0
+// @Title 作品评论接口服务
+// @Description 分享作品的评论和回复，作者可以关闭评论，老师和管理员可以删除评论、审核低年级学生的评论
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	m "maiyajia.com/models"
+)
+
+// CommentController 作品评论控制器
+type CommentController struct {
+	BaseController
+	commentMod m.CommentModels
+	workMod    m.WorkModels
+	userMod    m.UserModels
+}
+
+// NestPrepare 初始化函数
+func (commentCtl *CommentController) NestPrepare() {
+	commentCtl.commentMod.MgoSession = &commentCtl.MgoClient
+	commentCtl.commentMod.MessageMod.MgoSession = &commentCtl.MgoClient
+	commentCtl.workMod.MgoSession = &commentCtl.MgoClient
+	commentCtl.userMod.MgoSession = commentCtl.MgoClient
+}
+
+// GetComments 分页查询分享作品的评论，每层评论带有全部回复
+func (commentCtl *CommentController) GetComments() {
+	paging, err := paramPaging(commentCtl.Ctx)
+	if err != nil {
+		commentCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	work := commentCtl.needPublicWork(commentCtl.GetString("workID"))
+	comments, total, err := commentCtl.commentMod.WorkComments(work.ID, paging)
+	if err != nil {
+		logs.Error("WorkComments err:", err)
+		commentCtl.abortWithError(m.ERR_COMMENT_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["comments"] = comments
+	out["total"] = total
+	out["disabled"] = work.CommentsDisabled
+	commentCtl.jsonResult(out)
+}
+
+// PostComment 评论分享作品或回复评论，内容中的@用户名会通知对应用户。需要审核的评论返回的状态为 pending
+func (commentCtl *CommentController) PostComment() {
+	token := commentCtl.checkToken()
+	var form struct {
+		WorkID   string `json:"workID"`
+		ParentID string `json:"parentID"`
+		Content  string `json:"content"`
+	}
+	if err := json.Unmarshal(commentCtl.Ctx.Input.RequestBody, &form); err != nil {
+		commentCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	content := strings.TrimSpace(form.Content)
+	if !m.ValidCommentContent(content) {
+		commentCtl.abortWithError(m.ERR_COMMENT_INVALID)
+	}
+	work := commentCtl.needPublicWork(form.WorkID)
+	if work.CommentsDisabled {
+		commentCtl.abortWithError(m.ERR_COMMENT_DISABLED)
+	}
+	var parent *m.Comment
+	if form.ParentID != "" {
+		p := commentCtl.needComment(form.ParentID)
+		if p.WorkID != work.ID || p.Status != m.CommentApproved {
+			commentCtl.abortWithError(m.ERR_COMMENT_NONE)
+		}
+		parent = &p
+	}
+	user, err := commentCtl.userMod.FindUserByID(bson.ObjectIdHex(token.UserID))
+	if err != nil {
+		commentCtl.abortWithError(m.ERR_USER_NONE)
+	}
+	comment, err := commentCtl.commentMod.AddComment(work, user, parent, content)
+	if err != nil {
+		logs.Error("AddComment err:", err)
+		commentCtl.abortWithError(m.ERR_COMMENT_SAVE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["comment"] = comment
+	commentCtl.jsonResult(out)
+}
+
+// DeleteComment 删除评论（评论者本人、相关班级的老师或管理员）
+func (commentCtl *CommentController) DeleteComment() {
+	token := commentCtl.checkToken()
+	comment := commentCtl.needComment(commentCtl.GetString("id"))
+	if comment.Status == m.CommentDeleted {
+		commentCtl.abortWithError(m.ERR_COMMENT_STATUS)
+	}
+	if comment.UserID.Hex() != token.UserID && !comment.CanModerate(bson.ObjectIdHex(token.UserID), token.UserRole) {
+		commentCtl.abortWithError(m.ERR_PERMISSION_DENIED)
+	}
+	if err := commentCtl.commentMod.DeleteComment(comment.ID); err != nil {
+		logs.Error("DeleteComment err:", err)
+		commentCtl.abortWithError(m.ERR_COMMENT_SAVE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	commentCtl.jsonResult(out)
+}
+
+// GetPendingComments 分页查询等待审核的评论，老师只能看到自己班级学生相关的评论
+func (commentCtl *CommentController) GetPendingComments() {
+	token := commentCtl.checkToken()
+	commentCtl.needAdminOrTeacherPermission(token)
+	paging, err := paramPaging(commentCtl.Ctx)
+	if err != nil {
+		commentCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	var moderator bson.ObjectId
+	if token.UserRole != m.ROLE_ADMIN {
+		moderator = bson.ObjectIdHex(token.UserID)
+	}
+	comments, total, err := commentCtl.commentMod.PendingComments(moderator, paging)
+	if err != nil {
+		logs.Error("PendingComments err:", err)
+		commentCtl.abortWithError(m.ERR_COMMENT_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["comments"] = comments
+	out["total"] = total
+	commentCtl.jsonResult(out)
+}
+
+// ApproveComment 审核通过评论（相关班级的老师或管理员），不通过时直接删除评论
+func (commentCtl *CommentController) ApproveComment() {
+	token := commentCtl.checkToken()
+	commentCtl.needAdminOrTeacherPermission(token)
+	comment := commentCtl.needComment(commentCtl.GetString("id"))
+	if !comment.CanModerate(bson.ObjectIdHex(token.UserID), token.UserRole) {
+		commentCtl.abortWithError(m.ERR_PERMISSION_DENIED)
+	}
+	if comment.Status != m.CommentPending {
+		commentCtl.abortWithError(m.ERR_COMMENT_STATUS)
+	}
+	work, err := commentCtl.workMod.FindWorkByID(comment.WorkID)
+	if err != nil {
+		commentCtl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	}
+	if err := commentCtl.commentMod.ApproveComment(comment, work); err != nil {
+		logs.Error("ApproveComment err:", err)
+		commentCtl.abortWithError(m.ERR_COMMENT_SAVE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	commentCtl.jsonResult(out)
+}
+
+// PutCommentSetting 作者（或管理员）关闭或开启作品的评论
+func (commentCtl *CommentController) PutCommentSetting() {
+	token := commentCtl.checkToken()
+	var form struct {
+		WorkID   string `json:"workID"`
+		Disabled bool   `json:"disabled"`
+	}
+	if err := json.Unmarshal(commentCtl.Ctx.Input.RequestBody, &form); err != nil || !bson.IsObjectIdHex(form.WorkID) {
+		commentCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	work, err := commentCtl.workMod.FindWorkByID(bson.ObjectIdHex(form.WorkID))
+	if err != nil {
+		commentCtl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	}
+	if work.UserID.Hex() != token.UserID {
+		commentCtl.needAdminPermission(token)
+	}
+	if err := commentCtl.workMod.SetCommentsDisabled(work.ID, form.Disabled); err != nil {
+		logs.Error("SetCommentsDisabled err:", err)
+		commentCtl.abortWithError(m.ERR_COMMENT_SAVE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	commentCtl.jsonResult(out)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本控制器的内部函数 *********************************/
+/*********************************** *********************************************************/
+
+// needPublicWork 查询作品，只有已分享的作品可以评论
+func (commentCtl *CommentController) needPublicWork(workID string) m.WorkBody {
+	if !bson.IsObjectIdHex(workID) {
+		commentCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	work, err := commentCtl.workMod.FindWorkByID(bson.ObjectIdHex(workID))
+	if err != nil {
+		commentCtl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	}
+	if !work.Public {
+		commentCtl.abortWithError(m.ERR_COMMENT_NOT_PUBLIC)
+	}
+	return work
+}
+
+// needComment 查询评论，不存在时返回错误
+func (commentCtl *CommentController) needComment(id string) m.Comment {
+	if !bson.IsObjectIdHex(id) {
+		commentCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	comment, err := commentCtl.commentMod.FindComment(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		commentCtl.abortWithError(m.ERR_COMMENT_NONE)
+	}
+	if err != nil {
+		logs.Error("FindComment err:", err)
+		commentCtl.abortWithError(m.ERR_COMMENT_QUERY_FAIL)
+	}
+	return comment
+}
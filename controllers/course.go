@@ -9,23 +9,28 @@ import (
 
 	"github.com/astaxie/beego/logs"
 	"github.com/gorilla/websocket"
+	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
 	"github.com/astaxie/beego"
 	m "maiyajia.com/models"
 	"maiyajia.com/services/daemon"
+	"maiyajia.com/services/media"
 )
 
 type CouresController struct {
 	BaseController
 	CourseMod     m.CourseModels
 	userMod       m.UserModels
+	unlockMod     m.UnlockModels
 	upgradeClient daemon.UpgradeModels
 }
 
 func (courseCtrl *CouresController) NestPrepare() {
 	courseCtrl.CourseMod.MgoSession = &courseCtrl.MgoClient
 	courseCtrl.userMod.MgoSession = courseCtrl.MgoClient
+	courseCtrl.unlockMod.MgoSession = &courseCtrl.MgoClient
+	courseCtrl.unlockMod.CourseMod.MgoSession = &courseCtrl.MgoClient
 	courseCtrl.upgradeClient.MgoSession = &courseCtrl.MgoClient
 	courseCtrl.upgradeClient.ToolMod.MgoSession = &courseCtrl.MgoClient
 	courseCtrl.upgradeClient.CourseMod.MgoSession = &courseCtrl.MgoClient
@@ -59,6 +64,27 @@ func (courseCtrl *CouresController) GetLessions() {
 	if err != nil {
 		courseCtrl.abortWithError(m.ERR_LESSIONS_MESSAGE_QUERY_FAIL)
 	}
+	// 学生登录时返回每个课时的锁定状态，未登录或令牌无效时所有课时都视为锁定
+	token, err := getClientToken(courseCtrl.Ctx.Input.Header("Authorization"))
+	if err != nil {
+		for i := range course.Lessions {
+			course.Lessions[i].Locked = true
+			course.Lessions[i].LockReason = m.LockByLogin
+		}
+	} else if token.UserRole == m.ROLE_STUDENT {
+		locks, err := courseCtrl.unlockMod.StudentLessonLocks(bson.ObjectIdHex(token.UserID), course.ID)
+		if err != nil {
+			logs.Error("StudentLessonLocks err:", err)
+			courseCtrl.abortWithError(m.ERR_LESSIONS_MESSAGE_QUERY_FAIL)
+		}
+		for i := range course.Lessions {
+			if i < len(locks) {
+				course.Lessions[i].Locked = locks[i].Locked
+				course.Lessions[i].LockReason = locks[i].Reason
+				course.Lessions[i].UnlockTime = locks[i].UnlockTime
+			}
+		}
+	}
 	out := make(map[string]interface{})
 	out["code"] = 0
 	out["course"] = course
@@ -79,6 +105,7 @@ func (courseCtrl *CouresController) InstallCourse() {
 		logs.Error("Install Courses fail", err)
 		return
 	}
+	daemon.RefreshSearchIndex()
 }
 
 //CheckCourses 新增课程检测
@@ -350,3 +377,77 @@ func (courseCtrl *CouresController) GetCourses() {
 	out["total"] = total
 	courseCtrl.jsonResult(out)
 }
+
+// SetUnlockRule 设置课时解锁规则，班级代码为空时设置课程默认规则（仅管理员）
+func (courseCtrl *CouresController) SetUnlockRule() {
+	token := courseCtrl.checkToken()
+	courseCtrl.needAdminOrTeacherPermission(token)
+	var rule m.UnlockRule
+	if err := json.Unmarshal(courseCtrl.Ctx.Input.RequestBody, &rule); err != nil {
+		courseCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if !rule.CourseID.Valid() || rule.MinScore < 0 {
+		courseCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	courseCtrl.needClassOwner(token, rule.ClassCode)
+	rule.Creator = bson.ObjectIdHex(token.UserID)
+	if err := courseCtrl.unlockMod.UpsertUnlockRule(rule); err != nil {
+		logs.Error("UpsertUnlockRule err:", err)
+		courseCtrl.abortWithError(m.ERR_UNLOCK_RULE_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	courseCtrl.jsonResult(out)
+}
+
+// GetUnlockRule 查询课程或班级的课时解锁规则
+func (courseCtrl *CouresController) GetUnlockRule() {
+	token := courseCtrl.checkToken()
+	courseCtrl.needAdminOrTeacherPermission(token)
+	courseID := courseCtrl.GetString("courseID")
+	classCode := courseCtrl.GetString("classCode")
+	if !bson.IsObjectIdHex(courseID) {
+		courseCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	rule, err := courseCtrl.unlockMod.FindUnlockRule(bson.ObjectIdHex(courseID), classCode)
+	if err != nil && err != mgo.ErrNotFound {
+		courseCtrl.abortWithError(m.ERR_UNLOCK_RULE_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	if err == nil {
+		out["rule"] = rule
+	}
+	courseCtrl.jsonResult(out)
+}
+
+// GetContentMarkdown 获取学习资源渲染后的课时文档，需要登录，学生只能查看所在班级课程中已解锁的课时；
+// 文档中的视频地址替换为签名播放地址
+func (courseCtrl *CouresController) GetContentMarkdown() {
+	token := courseCtrl.checkToken()
+	courseID := courseCtrl.GetString("courseID")
+	contentID := courseCtrl.GetString("contentID")
+	if !bson.IsObjectIdHex(courseID) || !bson.IsObjectIdHex(contentID) {
+		courseCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	content, err := courseCtrl.CourseMod.GetContentById(bson.ObjectIdHex(courseID), bson.ObjectIdHex(contentID))
+	if err == mgo.ErrNotFound {
+		courseCtrl.abortWithError(m.ERR_MARKDOWN_NOT_FOUND)
+	}
+	if err != nil {
+		courseCtrl.abortWithError(m.ERR_LESSIONS_MESSAGE_QUERY_FAIL)
+	}
+	if token.UserRole == m.ROLE_STUDENT {
+		courseCtrl.needStudentCourse(bson.ObjectIdHex(token.UserID), bson.ObjectIdHex(courseID), content.ID)
+	}
+	result, err := courseCtrl.CourseMod.RenderContentMarkdown(content)
+	if err != nil {
+		logs.Error("RenderContentMarkdown err:", err)
+		courseCtrl.abortWithError(m.ERR_MARKDOWN_NOT_FOUND)
+	}
+	result.HTML = media.SignHTML(result.HTML, token.UserID)
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["markdown"] = result
+	courseCtrl.jsonResult(out)
+}
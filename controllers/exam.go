@@ -0,0 +1,378 @@
+// @APIVersion 1.0.0
+// @Title 班级考试接口服务
+// @Description 老师用课时练习题给班级安排限时考试，学生在开放时段内作答一次，老师公布成绩后学生可查看
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/exercise"
+	"maiyajia.com/services/token"
+)
+
+// ExamController 班级考试控制器
+type ExamController struct {
+	BaseController
+	examMod   m.ExamModels
+	exerMod   m.ExerModels
+	courseMod m.CourseModels
+	classMod  m.ClassModels
+}
+
+// NestPrepare 初始化函数
+func (examCtl *ExamController) NestPrepare() {
+	examCtl.examMod.MgoSession = &examCtl.MgoClient
+	examCtl.exerMod.MgoSession = &examCtl.MgoClient
+	examCtl.courseMod.MgoSession = &examCtl.MgoClient
+	examCtl.classMod.MgoSession = &examCtl.MgoClient
+}
+
+// examCredential 创建或修改考试的请求参数
+type examCredential struct {
+	ID               string    `json:"id"`
+	ClassCode        string    `json:"classCode"`
+	CourseID         string    `json:"courseID"`
+	LessonID         string    `json:"lessonID"`
+	Title            string    `json:"title"`
+	StartTime        time.Time `json:"startTime"`
+	EndTime          time.Time `json:"endTime"`
+	Duration         int       `json:"duration"` //答题时长（分钟）
+	ShuffleQuestions bool      `json:"shuffleQuestions"`
+	ShuffleOptions   bool      `json:"shuffleOptions"`
+}
+
+// examAnswerCredential 学生作答的请求参数
+type examAnswerCredential struct {
+	ID      string            `json:"id"`
+	Answers []exercise.Answer `json:"answers"`
+}
+
+// CreateExam 用课时练习题给班级创建考试（班级创建者或管理员）
+func (examCtl *ExamController) CreateExam() {
+	token := examCtl.checkToken()
+	examCtl.needAdminOrTeacherPermission(token)
+	var credential examCredential
+	if err := json.Unmarshal(examCtl.Ctx.Input.RequestBody, &credential); err != nil {
+		examCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if credential.ClassCode == "" || !bson.IsObjectIdHex(credential.CourseID) || !bson.IsObjectIdHex(credential.LessonID) {
+		examCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	examCtl.needValidExamTime(credential)
+	examCtl.needClassOwner(token, credential.ClassCode)
+
+	courseID := bson.ObjectIdHex(credential.CourseID)
+	if ok, err := examCtl.courseMod.ClassHasCourse(credential.ClassCode, courseID); err != nil || !ok {
+		examCtl.abortWithError(m.ERR_ASSIGNMENT_COURSE_NOT_IN_CLASS)
+	}
+	course, lesson, err := examCtl.exerMod.FindCourseLesson(courseID, bson.ObjectIdHex(credential.LessonID))
+	if err == mgo.ErrNotFound || (err == nil && len(lesson.Questions) == 0) {
+		examCtl.abortWithError(m.ERR_EXERCISE_NONE)
+	}
+	if err != nil {
+		logs.Error("FindCourseLesson err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_QUERY_FAIL)
+	}
+	title := credential.Title
+	if title == "" {
+		title = lesson.Name
+	}
+	exam := &m.Exam{
+		ClassCode:        credential.ClassCode,
+		CourseID:         course.ID,
+		LessonID:         lesson.ID,
+		CourseName:       course.Name,
+		LessonName:       lesson.Name,
+		Title:            title,
+		StartTime:        credential.StartTime,
+		EndTime:          credential.EndTime,
+		Duration:         credential.Duration,
+		ShuffleQuestions: credential.ShuffleQuestions,
+		ShuffleOptions:   credential.ShuffleOptions,
+		Questions:        lesson.Questions,
+		Creator:          bson.ObjectIdHex(token.UserID),
+	}
+	if err := examCtl.examMod.CreateExam(exam); err != nil {
+		logs.Error("CreateExam err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["exam"] = exam
+	examCtl.jsonResult(out)
+}
+
+// UpdateExam 修改考试的标题、时段、时长和乱序设置，考试开始后不能修改
+func (examCtl *ExamController) UpdateExam() {
+	token := examCtl.checkToken()
+	examCtl.needAdminOrTeacherPermission(token)
+	var credential examCredential
+	if err := json.Unmarshal(examCtl.Ctx.Input.RequestBody, &credential); err != nil {
+		examCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	examCtl.needValidExamTime(credential)
+	exam := examCtl.needExamOwner(token, credential.ID)
+	if m.ExamStatus(exam, time.Now()) != m.ExamNotOpen {
+		examCtl.abortWithError(m.ERR_EXAM_STARTED)
+	}
+	if credential.Title != "" {
+		exam.Title = credential.Title
+	}
+	exam.StartTime = credential.StartTime
+	exam.EndTime = credential.EndTime
+	exam.Duration = credential.Duration
+	exam.ShuffleQuestions = credential.ShuffleQuestions
+	exam.ShuffleOptions = credential.ShuffleOptions
+	if err := examCtl.examMod.UpdateExam(exam); err != nil {
+		logs.Error("UpdateExam err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["exam"] = exam
+	examCtl.jsonResult(out)
+}
+
+// DeleteExam 删除考试和学生的作答
+func (examCtl *ExamController) DeleteExam() {
+	token := examCtl.checkToken()
+	examCtl.needAdminOrTeacherPermission(token)
+	exam := examCtl.needExamOwner(token, examCtl.GetString("id"))
+	if err := examCtl.examMod.DeleteExam(exam.ID); err != nil {
+		logs.Error("DeleteExam err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	examCtl.jsonResult(out)
+}
+
+// GetClassExams 查询班级的考试列表
+func (examCtl *ExamController) GetClassExams() {
+	token := examCtl.checkToken()
+	examCtl.needAdminOrTeacherPermission(token)
+	classCode := examCtl.GetString("classCode")
+	examCtl.needClassOwner(token, classCode)
+	exams, err := examCtl.examMod.ClassExams(classCode)
+	if err != nil {
+		logs.Error("ClassExams err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["exams"] = exams
+	examCtl.jsonResult(out)
+}
+
+// ReleaseExam 公布或撤回考试成绩
+func (examCtl *ExamController) ReleaseExam() {
+	token := examCtl.checkToken()
+	examCtl.needAdminOrTeacherPermission(token)
+	var credential struct {
+		ID       string `json:"id"`
+		Released bool   `json:"released"`
+	}
+	if err := json.Unmarshal(examCtl.Ctx.Input.RequestBody, &credential); err != nil {
+		examCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	exam := examCtl.needExamOwner(token, credential.ID)
+	if err := examCtl.examMod.SetExamReleased(exam.ID, credential.Released); err != nil {
+		logs.Error("SetExamReleased err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	examCtl.jsonResult(out)
+}
+
+// GetExamResults 老师查看考试成绩表、题目和每道题的统计
+func (examCtl *ExamController) GetExamResults() {
+	token := examCtl.checkToken()
+	examCtl.needAdminOrTeacherPermission(token)
+	exam := examCtl.needExamOwner(token, examCtl.GetString("id"))
+	class := examCtl.needClassOwner(token, exam.ClassCode)
+	results, err := examCtl.examMod.ExamResults(exam, class)
+	if err != nil {
+		logs.Error("ExamResults err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["results"] = results
+	out["questions"] = exam.Questions
+	examCtl.jsonResult(out)
+}
+
+// GetMyExams 学生查询所在班级的考试
+func (examCtl *ExamController) GetMyExams() {
+	token := examCtl.checkToken()
+	exams, err := examCtl.examMod.StudentExams(bson.ObjectIdHex(token.UserID), time.Now())
+	if err != nil {
+		logs.Error("StudentExams err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["exams"] = exams
+	examCtl.jsonResult(out)
+}
+
+// GetMyExamPaper 学生查看自己的试卷：作答中返回题目、已保存的答案和剩余时间，成绩公布后返回得分和答案
+func (examCtl *ExamController) GetMyExamPaper() {
+	token := examCtl.checkToken()
+	exam := examCtl.needExamStudent(token, examCtl.GetString("id"))
+	now := time.Now()
+	session, err := examCtl.examMod.FindExamSession(exam.ID, bson.ObjectIdHex(token.UserID))
+	if err == mgo.ErrNotFound {
+		examCtl.abortWithError(m.ERR_EXAM_NOT_OPEN)
+	}
+	if err != nil {
+		logs.Error("FindExamSession err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_QUERY_FAIL)
+	}
+	examCtl.examPaperResult(exam, session, now)
+}
+
+// StartExam 学生开始考试，已开始过的返回原来的试卷
+func (examCtl *ExamController) StartExam() {
+	token := examCtl.checkToken()
+	var credential examAnswerCredential
+	if err := json.Unmarshal(examCtl.Ctx.Input.RequestBody, &credential); err != nil {
+		examCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	exam := examCtl.needExamStudent(token, credential.ID)
+	now := time.Now()
+	session, err := examCtl.examMod.StartExam(exam, bson.ObjectIdHex(token.UserID), now)
+	if err == m.ErrExamNotOpen {
+		examCtl.abortWithError(m.ERR_EXAM_NOT_OPEN)
+	}
+	if err != nil && err != m.ErrExamSubmitted {
+		logs.Error("StartExam err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_UPDATE_FAIL)
+	}
+	examCtl.examPaperResult(exam, session, now)
+}
+
+// SaveExamAnswers 保存作答进度，答题时间已到时自动交卷
+func (examCtl *ExamController) SaveExamAnswers() {
+	token := examCtl.checkToken()
+	var credential examAnswerCredential
+	if err := json.Unmarshal(examCtl.Ctx.Input.RequestBody, &credential); err != nil {
+		examCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	exam, session := examCtl.needExamSession(token, credential.ID)
+	now := time.Now()
+	session, err := examCtl.examMod.SaveExamAnswers(exam, session, credential.Answers, now)
+	examCtl.checkExamAnswerError(err)
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["remaining"] = m.ExamRemaining(session, now)
+	examCtl.jsonResult(out)
+}
+
+// SubmitExam 学生交卷，成绩公布前不返回得分
+func (examCtl *ExamController) SubmitExam() {
+	token := examCtl.checkToken()
+	var credential examAnswerCredential
+	if err := json.Unmarshal(examCtl.Ctx.Input.RequestBody, &credential); err != nil {
+		examCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	exam, session := examCtl.needExamSession(token, credential.ID)
+	now := time.Now()
+	session, err := examCtl.examMod.SubmitExam(exam, session, credential.Answers, now)
+	examCtl.checkExamAnswerError(err)
+	examCtl.examPaperResult(exam, session, now)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本控制器的内部函数 *********************************/
+/*********************************** *********************************************************/
+
+// needValidExamTime 检查考试时段和答题时长
+func (examCtl *ExamController) needValidExamTime(credential examCredential) {
+	if !credential.EndTime.After(credential.StartTime) || credential.Duration <= 0 {
+		examCtl.abortWithError(m.ERR_EXAM_TIME_INVALID)
+	}
+}
+
+// needExam 查询考试
+func (examCtl *ExamController) needExam(id string) m.Exam {
+	if !bson.IsObjectIdHex(id) {
+		examCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	exam, err := examCtl.examMod.FindExam(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		examCtl.abortWithError(m.ERR_EXAM_NONE)
+	}
+	if err != nil {
+		logs.Error("FindExam err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_QUERY_FAIL)
+	}
+	return exam
+}
+
+// needExamOwner 查询考试并检查是否为考试所在班级的创建者
+func (examCtl *ExamController) needExamOwner(token *token.Token, id string) m.Exam {
+	exam := examCtl.needExam(id)
+	examCtl.needClassOwner(token, exam.ClassCode)
+	return exam
+}
+
+// needExamStudent 查询考试并检查是否为考试所在班级的成员
+func (examCtl *ExamController) needExamStudent(token *token.Token, id string) m.Exam {
+	exam := examCtl.needExam(id)
+	if !examCtl.classMod.IsClassMember(bson.ObjectIdHex(token.UserID), exam.ClassCode) {
+		examCtl.abortWithError(m.ERR_CLASS_USER_NONE)
+	}
+	return exam
+}
+
+// needExamSession 查询学生已开始的考试作答
+func (examCtl *ExamController) needExamSession(token *token.Token, id string) (m.Exam, m.ExamSession) {
+	exam := examCtl.needExamStudent(token, id)
+	session, err := examCtl.examMod.FindExamSession(exam.ID, bson.ObjectIdHex(token.UserID))
+	if err == mgo.ErrNotFound {
+		examCtl.abortWithError(m.ERR_EXAM_NOT_OPEN)
+	}
+	if err != nil {
+		logs.Error("FindExamSession err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_QUERY_FAIL)
+	}
+	return exam, session
+}
+
+// checkExamAnswerError 把保存作答和交卷的错误转换为错误码
+func (examCtl *ExamController) checkExamAnswerError(err error) {
+	switch err {
+	case nil:
+	case m.ErrExamSubmitted:
+		examCtl.abortWithError(m.ERR_EXAM_SUBMITTED)
+	case m.ErrExamTimeout:
+		examCtl.abortWithError(m.ERR_EXAM_TIMEOUT)
+	default:
+		logs.Error("exam answer err:", err)
+		examCtl.abortWithError(m.ERR_EXAM_UPDATE_FAIL)
+	}
+}
+
+// examPaperResult 返回学生的试卷、作答和剩余时间
+func (examCtl *ExamController) examPaperResult(exam m.Exam, session m.ExamSession, now time.Time) {
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["exam"] = exam
+	out["status"] = m.ExamStatus(exam, now)
+	out["session"] = m.StudentSessionView(exam, session)
+	out["questions"] = m.ExamQuestions(exam, session)
+	out["remaining"] = m.ExamRemaining(session, now)
+	examCtl.jsonResult(out)
+}
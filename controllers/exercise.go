@@ -1,10 +1,19 @@
 package controllers
 
 import (
+	"bytes"
 	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
 
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	m "maiyajia.com/models"
+	"maiyajia.com/services/exercise"
+	"maiyajia.com/services/token"
 )
 
 // ExercisesController 习题控制器
@@ -18,22 +27,372 @@ func (exerCtrl *ExercisesController) NestPrepare() {
 	exerCtrl.exerMod.MgoSession = &exerCtrl.MgoClient
 }
 
-//SaveExer 保存练习信息
-func (exerCtrl *ExercisesController) SaveExer() {
-	userID := exerCtrl.checkToken().UserID
-	var exer m.Exercise
-	if err := json.Unmarshal(exerCtrl.Ctx.Input.RequestBody, &exer); err != nil {
+// GetLessonExercise 获取课时练习，学生在答案公布前看不到答案和解析
+func (exerCtrl *ExercisesController) GetLessonExercise() {
+	token := exerCtrl.checkToken()
+	course, lesson := exerCtrl.needLessonExercise(token, exerCtrl.GetString("courseID"), exerCtrl.GetString("lessonID"))
+	le, err := exerCtrl.exerMod.LessonExercise(bson.ObjectIdHex(token.UserID), course, lesson, token.UserRole != m.ROLE_STUDENT)
+	if err != nil {
+		logs.Error("LessonExercise err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	if token.UserRole == m.ROLE_STUDENT {
+		if err := exerCtrl.exerMod.RecordExerciseStart(bson.ObjectIdHex(token.UserID), lesson.ID); err != nil {
+			logs.Error("RecordExerciseStart err:", err)
+		}
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["exercise"] = le
+	exerCtrl.jsonResult(out)
+}
+
+// SubmitExercise 提交练习作答，服务端评分后返回每道题的得分；按公布方式返回答案
+func (exerCtrl *ExercisesController) SubmitExercise() {
+	token := exerCtrl.checkToken()
+	var form struct {
+		CourseID string            `json:"courseID"`
+		LessonID string            `json:"lessonID"`
+		Answers  []exercise.Answer `json:"answers"`
+	}
+	if err := json.Unmarshal(exerCtrl.Ctx.Input.RequestBody, &form); err != nil {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	course, lesson := exerCtrl.needLessonExercise(token, form.CourseID, form.LessonID)
+	uid := bson.ObjectIdHex(token.UserID)
+	exer, err := exerCtrl.exerMod.SubmitExercise(uid, course, lesson, form.Answers)
+	if err == m.ErrExerciseAttemptsExhausted {
+		exerCtrl.abortWithError(m.ERR_EXERCISE_ATTEMPTS_EXHAUSTED)
+	}
+	if err != nil {
+		logs.Error("SubmitExercise err:", err)
+		exerCtrl.abortWithError(m.ERR_ADD_EXER_FAIL)
+	}
+	le, err := exerCtrl.exerMod.LessonExercise(uid, course, lesson, false)
+	if err != nil {
+		logs.Error("LessonExercise err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["result"] = exer
+	out["exercise"] = le
+	exerCtrl.jsonResult(out)
+}
+
+// GetExerciseHistory 获取学生在课时练习的作答记录
+func (exerCtrl *ExercisesController) GetExerciseHistory() {
+	token := exerCtrl.checkToken()
+	course, lesson := exerCtrl.needLessonExercise(token, exerCtrl.GetString("courseID"), exerCtrl.GetString("lessonID"))
+	uid := bson.ObjectIdHex(token.UserID)
+	history, err := exerCtrl.exerMod.UserExercises(uid, course.ID, lesson.ID)
+	if err != nil {
+		logs.Error("UserExercises err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	le, err := exerCtrl.exerMod.LessonExercise(uid, course, lesson, token.UserRole != m.ROLE_STUDENT)
+	if err != nil {
+		logs.Error("LessonExercise err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["history"] = history
+	out["exercise"] = le
+	exerCtrl.jsonResult(out)
+}
+
+// SaveLessonQuestions 老师或管理员设置课时的练习题、作答次数上限和答案公布方式。
+// 题库由创建它的老师维护，课程包自带的题库只有管理员能修改
+func (exerCtrl *ExercisesController) SaveLessonQuestions() {
+	token := exerCtrl.checkToken()
+	exerCtrl.needAdminOrTeacherPermission(token)
+	var form struct {
+		CourseID    string               `json:"courseID"`
+		LessonID    string               `json:"lessonID"`
+		Questions   []*exercise.Question `json:"questions"`
+		MaxAttempts int                  `json:"maxAttempts"`
+		Reveal      string               `json:"reveal"`
+	}
+	if err := json.Unmarshal(exerCtrl.Ctx.Input.RequestBody, &form); err != nil {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if form.MaxAttempts < 0 {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	course, lesson := exerCtrl.needCourseLesson(form.CourseID, form.LessonID)
+	exerCtrl.needQuestionEditor(token, lesson.ID)
+	if err := exercise.Validate(form.Questions); err != nil || !exercise.ValidReveal(form.Reveal) {
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUESTION_INVALID)
+	}
+	err := exerCtrl.exerMod.SaveLessonQuestions(course.ID, lesson.ID, form.Questions, form.MaxAttempts, form.Reveal, bson.ObjectIdHex(token.UserID))
+	if err != nil {
+		logs.Error("SaveLessonQuestions err:", err)
+		exerCtrl.abortWithError(m.ERR_ADD_EXER_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["questions"] = form.Questions
+	exerCtrl.jsonResult(out)
+}
+
+// ImportLessonQuestions 从xlsx、csv表格或QTI 2.1题目包导入课时练习题。
+// dryRun 为true时只返回校验报告；mode 为replace时替换原有题目，默认追加。
+// 正式导入时校验不通过会返回错误码，校验报告放在data中
+func (exerCtrl *ExercisesController) ImportLessonQuestions() {
+	token := exerCtrl.checkToken()
+	exerCtrl.needAdminOrTeacherPermission(token)
+	course, lesson := exerCtrl.needCourseLesson(exerCtrl.GetString("courseID"), exerCtrl.GetString("lessonID"))
+	exerCtrl.needQuestionEditor(token, lesson.ID)
+	dryRun, err := exerCtrl.GetBool("dryRun", false)
+	mode := exerCtrl.GetString("mode", "append")
+	if err != nil || (mode != "append" && mode != "replace") {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	f, header, err := exerCtrl.GetFile("file")
+	if err != nil {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(f); err != nil {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	data := bytes.NewReader(buf.Bytes())
+
+	var report exercise.ImportReport
+	switch strings.ToLower(path.Ext(header.Filename)) {
+	case ".xlsx":
+		rows, err := exercise.ReadXLSX(data, data.Size())
+		if err != nil {
+			exerCtrl.abortWithError(m.ERR_EXERCISE_IMPORT_FORMAT)
+		}
+		report = exercise.ImportRows(rows)
+	case ".csv":
+		rows, err := exercise.ReadCSV(data)
+		if err != nil {
+			exerCtrl.abortWithError(m.ERR_EXERCISE_IMPORT_FORMAT)
+		}
+		report = exercise.ImportRows(rows)
+	case ".zip", ".xml":
+		if report, err = exercise.ImportQTI(data, data.Size()); err != nil {
+			exerCtrl.abortWithError(m.ERR_EXERCISE_IMPORT_FORMAT)
+		}
+	default:
+		exerCtrl.abortWithError(m.ERR_EXERCISE_IMPORT_FORMAT)
+	}
+
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["report"] = report
+	if !dryRun {
+		if len(report.Issues) > 0 || report.Valid == 0 {
+			// 校验不通过时连同逐行报告一起返回，方便老师修改表格后重新导入
+			out["code"] = m.ERR_EXERCISE_IMPORT_INVALID
+			out["message"] = m.GetErrorMsgs(m.ERR_EXERCISE_IMPORT_INVALID)
+			out["data"] = report
+			exerCtrl.jsonResult(out)
+			return
+		}
+		questions, err := exerCtrl.exerMod.ImportLessonQuestions(course, lesson, report.Questions, mode == "replace", bson.ObjectIdHex(token.UserID))
+		if err != nil {
+			logs.Error("ImportLessonQuestions err:", err)
+			exerCtrl.abortWithError(m.ERR_ADD_EXER_FAIL)
+		}
+		out["questions"] = questions
+	}
+	exerCtrl.jsonResult(out)
+}
+
+// ExportLessonQuestions 把课时练习题导出为QTI 2.1题目包
+func (exerCtrl *ExercisesController) ExportLessonQuestions() {
+	token := exerCtrl.checkToken()
+	exerCtrl.needAdminOrTeacherPermission(token)
+	_, lesson := exerCtrl.needLessonExercise(token, exerCtrl.GetString("courseID"), exerCtrl.GetString("lessonID"))
+	w := exerCtrl.Ctx.ResponseWriter
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(lesson.Name+"-qti.zip"))
+	if err := exercise.ExportQTI(w, lesson.Name, lesson.Questions); err != nil {
+		logs.Error("ExportQTI err:", err)
+	}
+}
+
+// GetClassExerciseReport 老师查看班级学生在课时练习上的作答记录、每道题的统计和需要关注的学生
+func (exerCtrl *ExercisesController) GetClassExerciseReport() {
+	token := exerCtrl.checkToken()
+	exerCtrl.needAdminOrTeacherPermission(token)
+	class := exerCtrl.needClassOwner(token, exerCtrl.GetString("classCode"))
+	course, lesson := exerCtrl.needLessonExercise(token, exerCtrl.GetString("courseID"), exerCtrl.GetString("lessonID"))
+	exerCtrl.needClassCourse(class.Code, course.ID)
+	report, err := exerCtrl.exerMod.ClassExerciseReport(class, course, lesson, exerCtrl.attentionThreshold())
+	if err != nil {
+		logs.Error("ClassExerciseReport err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["report"] = report
+	exerCtrl.jsonResult(out)
+}
+
+// GetClassExerciseAttention 老师查看班级在课程各课时练习中需要关注的学生
+func (exerCtrl *ExercisesController) GetClassExerciseAttention() {
+	token := exerCtrl.checkToken()
+	exerCtrl.needAdminOrTeacherPermission(token)
+	class := exerCtrl.needClassOwner(token, exerCtrl.GetString("classCode"))
+	courseID := exerCtrl.GetString("courseID")
+	if !bson.IsObjectIdHex(courseID) {
 		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	exerCtrl.needClassCourse(class.Code, bson.ObjectIdHex(courseID))
+	course, err := exerCtrl.exerMod.FindCourse(bson.ObjectIdHex(courseID))
+	if err == mgo.ErrNotFound {
+		exerCtrl.abortWithError(m.ERR_EXERCISE_NONE)
+	}
+	if err != nil {
+		logs.Error("FindCourse err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	threshold := exerCtrl.attentionThreshold()
+	lessons, err := exerCtrl.exerMod.ClassExerciseAttention(class, course, threshold)
+	if err != nil {
+		logs.Error("ClassExerciseAttention err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["threshold"] = threshold
+	out["lessons"] = lessons
+	exerCtrl.jsonResult(out)
+}
 
+// ScorePendingAnswer 老师批阅班级学生练习中没有评分关键词的简答题，重新计算练习得分
+func (exerCtrl *ExercisesController) ScorePendingAnswer() {
+	token := exerCtrl.checkToken()
+	exerCtrl.needAdminOrTeacherPermission(token)
+	var form struct {
+		ClassCode  string  `json:"classCode"`
+		ExerciseID string  `json:"exerciseID"`
+		QuestionID string  `json:"questionID"`
+		Score      float64 `json:"score"`
+	}
+	if err := json.Unmarshal(exerCtrl.Ctx.Input.RequestBody, &form); err != nil {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if !bson.IsObjectIdHex(form.ExerciseID) {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
 	}
-	if exer.ID.Hex() == "" {
-		exer.ID = bson.NewObjectId()
-		exer.UserID = bson.ObjectIdHex(userID)
+	class := exerCtrl.needClassOwner(token, form.ClassCode)
+	exer, err := exerCtrl.exerMod.FindExercise(bson.ObjectIdHex(form.ExerciseID))
+	if err == mgo.ErrNotFound {
+		exerCtrl.abortWithError(m.ERR_EXERCISE_NONE)
 	}
-	if err := exerCtrl.exerMod.InsertExercise(exer); err != nil {
+	if err != nil {
+		logs.Error("FindExercise err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	inClass := false
+	for _, s := range class.Students {
+		if s.UserID == exer.UserID {
+			inClass = true
+		}
+	}
+	if !inClass {
+		exerCtrl.abortWithError(m.ERR_PERMISSION_DENIED)
+	}
+	exerCtrl.needClassCourse(class.Code, exer.CourseID)
+	exer, err = exerCtrl.exerMod.ScorePendingAnswer(exer, form.QuestionID, form.Score)
+	if err == exercise.ErrNotPending {
+		exerCtrl.abortWithError(m.ERR_EXERCISE_NOT_PENDING)
+	}
+	if err == exercise.ErrScoreRange {
+		exerCtrl.abortWithError(m.ERR_EXERCISE_SCORE_INVALID)
+	}
+	if err != nil {
+		logs.Error("ScorePendingAnswer err:", err)
 		exerCtrl.abortWithError(m.ERR_ADD_EXER_FAIL)
 	}
 	out := make(map[string]interface{})
 	out["code"] = 0
+	out["result"] = exer
 	exerCtrl.jsonResult(out)
 }
+
+/*********************************************************************************************/
+/*********************************** 以下为本控制器的内部函数 *********************************/
+/*********************************** *********************************************************/
+
+// needLessonExercise 查询配置了练习的课时，学生还需选了该课程且课时已解锁
+func (exerCtrl *ExercisesController) needLessonExercise(token *token.Token, courseID, lessonID string) (*m.Course, *m.Lession) {
+	if !bson.IsObjectIdHex(courseID) || !bson.IsObjectIdHex(lessonID) {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	course, lesson, err := exerCtrl.exerMod.FindCourseLesson(bson.ObjectIdHex(courseID), bson.ObjectIdHex(lessonID))
+	if err == mgo.ErrNotFound || (err == nil && len(lesson.Questions) == 0) {
+		exerCtrl.abortWithError(m.ERR_EXERCISE_NONE)
+	}
+	if err != nil {
+		logs.Error("FindCourseLesson err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	if token.UserRole == m.ROLE_STUDENT {
+		exerCtrl.needStudentCourse(bson.ObjectIdHex(token.UserID), course.ID, lesson.ID)
+	}
+	return course, lesson
+}
+
+// needCourseLesson 查询课时，不要求已配置练习
+func (exerCtrl *ExercisesController) needCourseLesson(courseID, lessonID string) (*m.Course, *m.Lession) {
+	if !bson.IsObjectIdHex(courseID) || !bson.IsObjectIdHex(lessonID) {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	course, lesson, err := exerCtrl.exerMod.FindCourseLesson(bson.ObjectIdHex(courseID), bson.ObjectIdHex(lessonID))
+	if err == mgo.ErrNotFound {
+		exerCtrl.abortWithError(m.ERR_EXERCISE_NONE)
+	}
+	if err != nil {
+		logs.Error("FindCourseLesson err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	return course, lesson
+}
+
+// needClassCourse 检查班级是否选了该课程
+func (exerCtrl *ExercisesController) needClassCourse(classCode string, courseID bson.ObjectId) {
+	courseMod := m.CourseModels{MgoSession: &exerCtrl.MgoClient}
+	ok, err := courseMod.ClassHasCourse(classCode, courseID)
+	if err != nil {
+		logs.Error("ClassHasCourse err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	if !ok {
+		exerCtrl.abortWithError(m.ERR_ASSIGNMENT_COURSE_NOT_IN_CLASS)
+	}
+}
+
+// attentionThreshold 关注线（满分的百分比），未指定时使用配置 exercise_attention_percent
+func (exerCtrl *ExercisesController) attentionThreshold() float64 {
+	threshold, err := exerCtrl.GetFloat("threshold", beego.AppConfig.DefaultFloat("exercise_attention_percent", 60))
+	if err != nil || threshold < 0 || threshold > 100 {
+		exerCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	return threshold
+}
+
+// needQuestionEditor 检查是否可以修改课时题库：管理员可以修改所有题库，老师只能修改自己创建的题库或新建题库
+func (exerCtrl *ExercisesController) needQuestionEditor(token *token.Token, lessonID bson.ObjectId) {
+	if token.UserRole == m.ROLE_ADMIN {
+		return
+	}
+	bank, err := exerCtrl.exerMod.FindLessonQuestions(lessonID)
+	if err == mgo.ErrNotFound {
+		return
+	}
+	if err != nil {
+		logs.Error("FindLessonQuestions err:", err)
+		exerCtrl.abortWithError(m.ERR_EXERCISE_QUERY_FAIL)
+	}
+	if bank.Editor.Hex() != token.UserID {
+		exerCtrl.abortWithError(m.ERR_PERMISSION_DENIED)
+	}
+}
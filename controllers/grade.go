@@ -0,0 +1,206 @@
+// @APIVersion 1.0.0
+// @Title 作品批改接口服务
+// @Description 老师批改班级学生提交的课时作品，学生查看得分和评语
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/storage"
+	"maiyajia.com/services/token"
+)
+
+// GradeController 作品批改控制器
+type GradeController struct {
+	BaseController
+	gradeMod m.GradeModels
+	workMod  m.WorkModels
+}
+
+// NestPrepare 初始化函数
+func (gradeCtl *GradeController) NestPrepare() {
+	gradeCtl.gradeMod.MgoSession = &gradeCtl.MgoClient
+	gradeCtl.gradeMod.MessageMod.MgoSession = &gradeCtl.MgoClient
+	gradeCtl.workMod.MgoSession = &gradeCtl.MgoClient
+}
+
+// 语音评语允许的文件类型
+var feedbackAudioExts = map[string]bool{".mp3": true, ".m4a": true, ".aac": true, ".wav": true, ".ogg": true, ".webm": true}
+
+// GradeWork 老师批改班级学生的作品（multipart表单）：score/fullScore 或 rubric(JSON)给分，
+// feedback 文字评语，audio 语音评语文件，resubmitDue 重新提交截止时间(RFC3339，可选)。批改后给学生发消息
+func (gradeCtl *GradeController) GradeWork() {
+	token := gradeCtl.checkToken()
+	gradeCtl.needAdminOrTeacherPermission(token)
+	classCode := gradeCtl.GetString("classCode")
+	workID := gradeCtl.GetString("workID")
+	if classCode == "" || !bson.IsObjectIdHex(workID) {
+		gradeCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	class := gradeCtl.needClassOwner(token, classCode)
+	work, err := gradeCtl.workMod.FindWorkByID(bson.ObjectIdHex(workID))
+	if err != nil {
+		gradeCtl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	}
+	if !isClassStudent(class, work.UserID) {
+		gradeCtl.abortWithError(m.ERR_GRADE_NOT_CLASS_WORK)
+	}
+
+	score, err1 := gradeCtl.GetFloat("score", 0)
+	fullScore, err2 := gradeCtl.GetFloat("fullScore", 100)
+	if err1 != nil || err2 != nil {
+		gradeCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	var rubric []m.RubricItem
+	if s := gradeCtl.GetString("rubric"); s != "" {
+		if err := json.Unmarshal([]byte(s), &rubric); err != nil {
+			gradeCtl.abortWithError(m.ERR_REQUEST_PARAM)
+		}
+	}
+	score, fullScore, ok := m.RubricScore(score, fullScore, rubric)
+	if !ok {
+		gradeCtl.abortWithError(m.ERR_GRADE_SCORE_INVALID)
+	}
+	var resubmitDue time.Time
+	if s := gradeCtl.GetString("resubmitDue"); s != "" {
+		if resubmitDue, err = time.Parse(time.RFC3339, s); err != nil || !resubmitDue.After(time.Now()) {
+			gradeCtl.abortWithError(m.ERR_GRADE_RESUBMIT_INVALID)
+		}
+	}
+
+	grade := &m.WorkGrade{
+		WorkID:      work.ID,
+		WorkName:    work.Name,
+		UserID:      work.UserID,
+		ClassCode:   class.Code,
+		LessonID:    work.ContentID,
+		Score:       score,
+		FullScore:   fullScore,
+		Rubric:      rubric,
+		Feedback:    gradeCtl.GetString("feedback"),
+		Grader:      bson.ObjectIdHex(token.UserID),
+		ResubmitDue: resubmitDue,
+	}
+	if previous, err := gradeCtl.gradeMod.FindWorkGrade(work.ID); err == nil {
+		grade.AudioURL = previous.AudioURL
+	}
+	if file, header, err := gradeCtl.GetFile("audio"); err == nil {
+		defer file.Close()
+		grade.AudioURL = gradeCtl.saveFeedbackAudio(work.ID, header.Filename, file)
+	} else if err != http.ErrMissingFile {
+		gradeCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if err := gradeCtl.gradeMod.SaveWorkGrade(grade, class); err != nil {
+		logs.Error("SaveWorkGrade err:", err)
+		gradeCtl.abortWithError(m.ERR_GRADE_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["grade"] = grade
+	gradeCtl.jsonResult(out)
+}
+
+// GetWorkGrade 查询作品的批改结果（作品的作者或批改班级的老师）
+func (gradeCtl *GradeController) GetWorkGrade() {
+	token := gradeCtl.checkToken()
+	grade := gradeCtl.needWorkGrade(token, gradeCtl.GetString("workID"))
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["grade"] = grade
+	out["canResubmit"] = grade.CanResubmit(time.Now())
+	gradeCtl.jsonResult(out)
+}
+
+// GetFeedbackAudio 获取作品批改的语音评语（作品的作者或批改班级的老师），支持Range断点播放
+func (gradeCtl *GradeController) GetFeedbackAudio() {
+	token := gradeCtl.checkToken()
+	grade := gradeCtl.needWorkGrade(token, gradeCtl.GetString("workID"))
+	if grade.AudioURL == "" {
+		gradeCtl.abortWithError(m.ERR_GRADE_AUDIO_NONE)
+	}
+	if _, err := m.Assets().Stat(grade.AudioURL); err != nil {
+		gradeCtl.abortWithError(m.ERR_GRADE_AUDIO_NONE)
+	}
+	if err := storage.Serve(gradeCtl.Ctx.ResponseWriter, gradeCtl.Ctx.Request, m.Assets(), grade.AudioURL); err != nil {
+		logs.Error("serve feedback audio err:", err)
+		gradeCtl.abortWithError(m.ERR_GRADE_AUDIO_NONE)
+	}
+}
+
+// GetMyGrades 学生查询自己所有作品的批改结果
+func (gradeCtl *GradeController) GetMyGrades() {
+	token := gradeCtl.checkToken()
+	grades, err := gradeCtl.gradeMod.UserGrades(bson.ObjectIdHex(token.UserID))
+	if err != nil {
+		logs.Error("UserGrades err:", err)
+		gradeCtl.abortWithError(m.ERR_GRADE_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["grades"] = grades
+	gradeCtl.jsonResult(out)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本控制器的内部函数 *********************************/
+/*********************************** *********************************************************/
+
+// needWorkGrade 查询作品的批改结果，只有作品的作者、批改班级的老师可以查看
+func (gradeCtl *GradeController) needWorkGrade(token *token.Token, workID string) m.WorkGrade {
+	if !bson.IsObjectIdHex(workID) {
+		gradeCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	grade, err := gradeCtl.gradeMod.FindWorkGrade(bson.ObjectIdHex(workID))
+	if err == mgo.ErrNotFound {
+		gradeCtl.abortWithError(m.ERR_GRADE_NONE)
+	}
+	if err != nil {
+		logs.Error("FindWorkGrade err:", err)
+		gradeCtl.abortWithError(m.ERR_GRADE_QUERY_FAIL)
+	}
+	if grade.UserID.Hex() != token.UserID {
+		gradeCtl.needAdminOrTeacherPermission(token)
+		gradeCtl.needClassOwner(token, grade.ClassCode)
+	}
+	return grade
+}
+
+// saveFeedbackAudio 保存语音评语到存储的 feedback 目录下，返回文件名。
+// 该目录不在 /asset 静态目录中，只能通过 GetFeedbackAudio 获取
+func (gradeCtl *GradeController) saveFeedbackAudio(workID bson.ObjectId, filename string, file io.Reader) string {
+	ext := strings.ToLower(path.Ext(filename))
+	if !feedbackAudioExts[ext] {
+		gradeCtl.abortWithError(m.ERR_GRADE_AUDIO_INVALID)
+	}
+	relpath := path.Join(m.FeedbackAudioDir, fmt.Sprintf("%s_%d%s", workID.Hex(), time.Now().Unix(), ext))
+	if err := m.Assets().Put(relpath, file); err != nil {
+		logs.Error("save feedback audio fail:", err)
+		gradeCtl.abortWithError(m.ERR_GRADE_UPDATE_FAIL)
+	}
+	return relpath
+}
+
+// isClassStudent 判断用户是否为班级的学生
+func isClassStudent(class m.Class, uid bson.ObjectId) bool {
+	for _, s := range class.Students {
+		if s.UserID == uid {
+			return true
+		}
+	}
+	return false
+}
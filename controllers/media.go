@@ -0,0 +1,116 @@
+// @APIVersion 1.0.0
+// @Title 课程媒体控制器
+// @Description 课程视频的签名地址和断点播放，视频经带宽控制后发送
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"path"
+	"strings"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/media"
+	"maiyajia.com/services/storage"
+)
+
+// MediaController 课程媒体
+type MediaController struct {
+	BaseController
+	courseMod m.CourseModels
+}
+
+func (mediaCtrl *MediaController) NestPrepare() {
+	mediaCtrl.courseMod.MgoSession = &mediaCtrl.MgoClient
+}
+
+// GetMediaURL 获取学习资源视频的签名地址，学生只能获取所在班级课程中已解锁课时的视频
+func (mediaCtrl *MediaController) GetMediaURL() {
+	token := mediaCtrl.checkToken()
+	courseID := mediaCtrl.GetString("courseID")
+	contentID := mediaCtrl.GetString("contentID")
+	if !bson.IsObjectIdHex(courseID) || !bson.IsObjectIdHex(contentID) {
+		mediaCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	content, err := mediaCtrl.courseMod.GetContentById(bson.ObjectIdHex(courseID), bson.ObjectIdHex(contentID))
+	if err == mgo.ErrNotFound {
+		mediaCtrl.abortWithError(m.ERR_MEDIA_NOT_FOUND)
+	}
+	if err != nil {
+		logs.Error("GetContentById err:", err)
+		mediaCtrl.abortWithError(m.ERR_LESSIONS_MESSAGE_QUERY_FAIL)
+	}
+	if token.UserRole == m.ROLE_STUDENT {
+		mediaCtrl.needStudentCourse(bson.ObjectIdHex(token.UserID), bson.ObjectIdHex(courseID), content.ID)
+	}
+	file, ok := mediaFile(content.VideoURL)
+	if !ok {
+		mediaCtrl.abortWithError(m.ERR_MEDIA_NOT_FOUND)
+	}
+	url, expires := media.SignURL(file, token.UserID)
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["url"] = url
+	out["expires"] = expires
+	mediaCtrl.jsonResult(out)
+}
+
+// Stream 按签名地址发送视频，支持Range断点播放，按用户和总带宽限速
+func (mediaCtrl *MediaController) Stream() {
+	file, user, err := media.VerifyURL(mediaCtrl.Ctx.Request.URL.Query())
+	if err == media.ErrSignExpired {
+		mediaCtrl.abortWithError(m.ERR_MEDIA_URL_EXPIRED)
+	}
+	if err != nil {
+		mediaCtrl.abortWithError(m.ERR_MEDIA_URL_INVALID)
+	}
+	name, ok := mediaFile(file)
+	if !ok {
+		mediaCtrl.abortWithError(m.ERR_MEDIA_URL_INVALID)
+	}
+	if _, err := m.Assets().Stat(name); err != nil {
+		mediaCtrl.abortWithError(m.ERR_MEDIA_NOT_FOUND)
+	}
+	w, release := media.Default.ResponseWriter(mediaCtrl.Ctx.ResponseWriter, user)
+	defer release()
+	if !m.LocalAssets() {
+		err = storage.Serve(w, mediaCtrl.Ctx.Request, m.Assets(), name)
+	} else {
+		err = media.ServeFile(w, mediaCtrl.Ctx.Request, path.Join(beego.AppPath, name))
+	}
+	if err != nil {
+		logs.Error("serve media err:", err)
+		mediaCtrl.abortWithError(m.ERR_MEDIA_NOT_FOUND)
+	}
+}
+
+// StaticMediaFilter 禁止直接通过静态目录访问课程音视频，防止盗链，可通过 media_static_access 配置放开
+func StaticMediaFilter(ctx *context.Context) {
+	if !media.StaticAccess && media.IsMedia(ctx.Request.URL.Path) {
+		ctx.Abort(403, "course media must be played through "+media.Route)
+	}
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本控制器的内部函数 *********************************/
+/*********************************** *********************************************************/
+
+// mediaFile 课程视频只能是课程资源目录下的音视频文件，返回清理后的相对路径
+func mediaFile(videoURL string) (string, bool) {
+	if videoURL == "" || strings.Contains(videoURL, "://") {
+		return "", false
+	}
+	file := path.Clean("/" + videoURL)[1:]
+	if !strings.HasPrefix(file, m.CourseAssetPrefix) || !media.IsMedia(file) {
+		return "", false
+	}
+	return file, true
+}
@@ -0,0 +1,289 @@
+// @APIVersion 1.0.0
+// @Title 3D打印接口服务
+// @Description 3D作品的打印检查报告；班级打印队列：学生或老师提交作品，老师审核并调整各打印机的打印顺序
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/daemon"
+	"maiyajia.com/services/printer"
+)
+
+// PrintController 3D打印控制器
+type PrintController struct {
+	BaseController
+	printMod m.PrintModels
+	workMod  m.WorkModels
+}
+
+// NestPrepare 初始化函数
+func (printCtl *PrintController) NestPrepare() {
+	printCtl.printMod.MgoSession = &printCtl.MgoClient
+	printCtl.printMod.MessageMod.MgoSession = &printCtl.MgoClient
+	printCtl.workMod.MgoSession = &printCtl.MgoClient
+}
+
+// GetPrintReport 查询STL作品的打印检查报告（作者、老师或管理员），作品文件修改后重新检查
+func (printCtl *PrintController) GetPrintReport() {
+	token := printCtl.checkToken()
+	workID := printCtl.GetString("workID")
+	if !bson.IsObjectIdHex(workID) {
+		printCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	work, err := printCtl.workMod.FindWorkByID(bson.ObjectIdHex(workID))
+	if err != nil {
+		printCtl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	}
+	if work.UserID.Hex() != token.UserID {
+		printCtl.needAdminOrTeacherPermission(token)
+	}
+	if !strings.HasSuffix(work.Relpath, ".stl") {
+		printCtl.abortWithError(m.ERR_PRINT_NOT_STL)
+	}
+	report, err := printCtl.printMod.WorkPrintReport(work)
+	if err != nil {
+		logs.Error("WorkPrintReport err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_REPORT_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["report"] = report
+	printCtl.jsonResult(out)
+}
+
+// GetPrinters 查询教室里的打印机和最近的状态
+func (printCtl *PrintController) GetPrinters() {
+	printCtl.checkToken()
+	config := printCtl.needPrinterConfig()
+	type printerInfo struct {
+		ID      string          `json:"id"`
+		Name    string          `json:"name"`
+		Profile printer.Profile `json:"profile"`
+		Status  *printer.Status `json:"status"`
+	}
+	printers := make([]printerInfo, 0, len(config.Printers))
+	for _, d := range config.Printers {
+		info := printerInfo{ID: d.ID, Name: d.Name}
+		info.Profile, _ = config.Profile(d.Profile)
+		if st, ok := daemon.PrinterStatus(d.ID); ok {
+			info.Status = &st
+		}
+		printers = append(printers, info)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["printers"] = printers
+	printCtl.jsonResult(out)
+}
+
+// SubmitPrintJob 把STL作品提交到打印机队列（作者、老师或管理员），等待老师审核
+func (printCtl *PrintController) SubmitPrintJob() {
+	token := printCtl.checkToken()
+	workID := printCtl.GetString("workID")
+	printerID := printCtl.GetString("printerID")
+	if !bson.IsObjectIdHex(workID) || printerID == "" {
+		printCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if _, ok := printCtl.needPrinterConfig().Printer(printerID); !ok {
+		printCtl.abortWithError(m.ERR_PRINT_PRINTER_NONE)
+	}
+	work, err := printCtl.workMod.FindWorkByID(bson.ObjectIdHex(workID))
+	if err != nil {
+		printCtl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	}
+	if work.UserID.Hex() != token.UserID {
+		printCtl.needAdminOrTeacherPermission(token)
+	}
+	if !strings.HasSuffix(work.Relpath, ".stl") {
+		printCtl.abortWithError(m.ERR_PRINT_NOT_STL)
+	}
+	job, err := printCtl.printMod.SubmitPrintJob(work, bson.ObjectIdHex(token.UserID), printerID, printCtl.GetString("note"))
+	if err == m.ErrPrintJobExists {
+		printCtl.abortWithError(m.ERR_PRINT_JOB_EXISTS)
+	}
+	if err != nil {
+		logs.Error("SubmitPrintJob err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["job"] = job
+	printCtl.jsonResult(out)
+}
+
+// GetMyPrintJobs 学生查询自己作品的打印任务
+func (printCtl *PrintController) GetMyPrintJobs() {
+	token := printCtl.checkToken()
+	jobs, err := printCtl.printMod.UserPrintJobs(bson.ObjectIdHex(token.UserID))
+	if err != nil {
+		logs.Error("UserPrintJobs err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["jobs"] = jobs
+	printCtl.jsonResult(out)
+}
+
+// GetPrintQueue 老师查询打印机的队列，附带每个作品的打印检查报告
+func (printCtl *PrintController) GetPrintQueue() {
+	token := printCtl.checkToken()
+	printCtl.needAdminOrTeacherPermission(token)
+	printerID := printCtl.GetString("printerID")
+	if _, ok := printCtl.needPrinterConfig().Printer(printerID); !ok {
+		printCtl.abortWithError(m.ERR_PRINT_PRINTER_NONE)
+	}
+	jobs, err := printCtl.printMod.PrintQueue(printerID)
+	if err != nil {
+		logs.Error("PrintQueue err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_QUERY_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["jobs"] = jobs
+	if st, ok := daemon.PrinterStatus(printerID); ok {
+		out["status"] = st
+	}
+	printCtl.jsonResult(out)
+}
+
+// ApprovePrintJob 老师审核通过打印任务，printerID 可以换到另一台打印机，任务排到队列末尾
+func (printCtl *PrintController) ApprovePrintJob() {
+	token := printCtl.checkToken()
+	printCtl.needAdminOrTeacherPermission(token)
+	job := printCtl.needPrintJob(m.PrintPending)
+	printerID := printCtl.GetString("printerID", job.PrinterID)
+	if _, ok := printCtl.needPrinterConfig().Printer(printerID); !ok {
+		printCtl.abortWithError(m.ERR_PRINT_PRINTER_NONE)
+	}
+	if err := printCtl.printMod.ApprovePrintJob(job, printerID, bson.ObjectIdHex(token.UserID)); err != nil {
+		logs.Error("ApprovePrintJob err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_UPDATE_FAIL)
+	}
+	daemon.DispatchPrintQueue()
+	out := make(map[string]interface{})
+	out["code"] = 0
+	printCtl.jsonResult(out)
+}
+
+// RejectPrintJob 老师不通过打印任务，reason 为原因
+func (printCtl *PrintController) RejectPrintJob() {
+	token := printCtl.checkToken()
+	printCtl.needAdminOrTeacherPermission(token)
+	job := printCtl.needPrintJob(m.PrintPending)
+	if err := printCtl.printMod.FinishPrintJob(job, m.PrintRejected, printCtl.GetString("reason")); err != nil {
+		logs.Error("FinishPrintJob err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	printCtl.jsonResult(out)
+}
+
+// ReorderPrintQueue 老师调整打印机队列中已审核任务的顺序，请求体为 {printerID, jobIDs}
+func (printCtl *PrintController) ReorderPrintQueue() {
+	token := printCtl.checkToken()
+	printCtl.needAdminOrTeacherPermission(token)
+	var form struct {
+		PrinterID string          `json:"printerID"`
+		JobIDs    []bson.ObjectId `json:"jobIDs"`
+	}
+	if err := json.Unmarshal(printCtl.Ctx.Input.RequestBody, &form); err != nil || len(form.JobIDs) == 0 {
+		printCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if _, ok := printCtl.needPrinterConfig().Printer(form.PrinterID); !ok {
+		printCtl.abortWithError(m.ERR_PRINT_PRINTER_NONE)
+	}
+	if err := printCtl.printMod.ReorderPrintQueue(form.PrinterID, form.JobIDs); err != nil {
+		logs.Error("ReorderPrintQueue err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_UPDATE_FAIL)
+	}
+	daemon.DispatchPrintQueue()
+	out := make(map[string]interface{})
+	out["code"] = 0
+	printCtl.jsonResult(out)
+}
+
+// CancelPrintJob 取消打印任务：学生可以取消自己还没开始打印的任务，老师可以取消任何未完成的任务，正在打印的同时取消打印机上的打印
+func (printCtl *PrintController) CancelPrintJob() {
+	token := printCtl.checkToken()
+	job := printCtl.needPrintJob(m.PrintPending, m.PrintApproved, m.PrintPrinting)
+	if job.UserID.Hex() != token.UserID || job.Status == m.PrintPrinting {
+		printCtl.needAdminOrTeacherPermission(token)
+	}
+	if job.Status == m.PrintPrinting {
+		printCtl.cancelOnPrinter(job)
+	}
+	if err := printCtl.printMod.FinishPrintJob(job, m.PrintCancelled, ""); err != nil {
+		logs.Error("FinishPrintJob err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_UPDATE_FAIL)
+	}
+	daemon.DispatchPrintQueue()
+	out := make(map[string]interface{})
+	out["code"] = 0
+	printCtl.jsonResult(out)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本控制器的内部函数 *********************************/
+/*********************************** *********************************************************/
+
+// needPrinterConfig 读取打印机配置
+func (printCtl *PrintController) needPrinterConfig() printer.Config {
+	config, err := m.PrinterConfig()
+	if err != nil {
+		logs.Error("PrinterConfig err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_QUERY_FAIL)
+	}
+	return config
+}
+
+// needPrintJob 读取参数jobID对应的打印任务，任务须处于statuses之一
+func (printCtl *PrintController) needPrintJob(statuses ...string) m.PrintJob {
+	jobID := printCtl.GetString("jobID")
+	if !bson.IsObjectIdHex(jobID) {
+		printCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	job, err := printCtl.printMod.FindPrintJob(bson.ObjectIdHex(jobID))
+	if err == mgo.ErrNotFound {
+		printCtl.abortWithError(m.ERR_PRINT_JOB_NONE)
+	}
+	if err != nil {
+		logs.Error("FindPrintJob err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_QUERY_FAIL)
+	}
+	for _, s := range statuses {
+		if job.Status == s {
+			return job
+		}
+	}
+	printCtl.abortWithError(m.ERR_PRINT_JOB_STATUS)
+	return job
+}
+
+// cancelOnPrinter 取消打印机上正在打印的任务
+func (printCtl *PrintController) cancelOnPrinter(job m.PrintJob) {
+	device, ok := printCtl.needPrinterConfig().Printer(job.PrinterID)
+	if !ok {
+		return
+	}
+	drv, err := printer.Open(device)
+	if err == nil {
+		err = drv.Cancel()
+	}
+	if err != nil {
+		logs.Error("cancel print err:", err)
+		printCtl.abortWithError(m.ERR_PRINT_CANCEL_FAIL)
+	}
+}
@@ -16,7 +16,8 @@ import (
 // ProgressController 学习进度相关的控制器
 type ProgressController struct {
 	BaseController
-	ProgMod m.ProgressModels
+	ProgMod   m.ProgressModels
+	unlockMod m.UnlockModels
 }
 
 // NestPrepare 初始化数据库
@@ -24,6 +25,8 @@ func (progressCtrl *ProgressController) NestPrepare() {
 	progressCtrl.ProgMod.MgoSession = &progressCtrl.MgoClient
 	progressCtrl.ProgMod.CourseMod.MgoSession = &progressCtrl.MgoClient
 	progressCtrl.ProgMod.UserMod.MgoSession = progressCtrl.MgoClient
+	progressCtrl.unlockMod.MgoSession = &progressCtrl.MgoClient
+	progressCtrl.unlockMod.CourseMod.MgoSession = &progressCtrl.MgoClient
 }
 
 // UploadLessProgress 上传课节进度
@@ -39,6 +42,25 @@ func (progressCtrl *ProgressController) UploadLessProgress() {
 		progress.ID = bson.NewObjectId()
 		progress.UserID = bson.ObjectIdHex(token.UserID)
 	}
+	// 学生不能提交未解锁课时的进度，课时和资源必须属于提交的课程
+	if token.UserRole == m.ROLE_STUDENT {
+		for _, itemID := range []bson.ObjectId{progress.FinishItemID, progress.CurItemID} {
+			if itemID.Hex() == "" {
+				continue
+			}
+			locked, err := progressCtrl.unlockMod.IsItemLocked(bson.ObjectIdHex(token.UserID), progress.CourseID, itemID)
+			if err == m.ErrItemNotInCourse {
+				progressCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+			}
+			if err != nil {
+				logs.Error("IsItemLocked err:", err)
+				progressCtrl.abortWithError(m.ERR_LESSION_PROGRESS_UPDATE_FAIL)
+			}
+			if locked {
+				progressCtrl.abortWithError(m.ERR_LESSION_LOCKED)
+			}
+		}
+	}
 	if err := progressCtrl.ProgMod.UpsertLessionProgress(progress); err != nil {
 		progressCtrl.abortWithError(m.ERR_LESSION_PROGRESS_UPDATE_FAIL)
 	}
@@ -0,0 +1,52 @@
+// @APIVersion 1.0.0
+// @Title 作品改编接口服务
+// @Description 查询作品的改编关系：原作品、直接改编的作品和所有后代作品
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	m "maiyajia.com/models"
+)
+
+// RemixController 作品改编控制器
+type RemixController struct {
+	BaseController
+	remixMod m.RemixModels
+}
+
+// NestPrepare 初始化函数
+func (remixCtl *RemixController) NestPrepare() {
+	remixCtl.remixMod.MgoSession = &remixCtl.MgoClient
+}
+
+// GetRemixTree 查询作品的改编关系。已分享的作品所有用户可以查询，未分享的作品只有作者、老师和管理员可以查询
+func (remixCtl *RemixController) GetRemixTree() {
+	token := remixCtl.checkToken()
+	workID := remixCtl.GetString("workID")
+	if !bson.IsObjectIdHex(workID) {
+		remixCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	showAll := token.UserRole == m.ROLE_TEACHER || token.UserRole == m.ROLE_ADMIN
+	tree, err := remixCtl.remixMod.WorkRemixTree(bson.ObjectIdHex(workID), bson.ObjectIdHex(token.UserID), showAll)
+	if err == mgo.ErrNotFound {
+		remixCtl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	}
+	if err != nil {
+		logs.Error("WorkRemixTree err:", err)
+		remixCtl.abortWithError(m.ERR_REMIX_QUERY_FAIL)
+	}
+	if !tree.Work.Public && !showAll && tree.Work.UserID.Hex() != token.UserID {
+		remixCtl.abortWithError(m.ERR_PERMISSION_DENIED)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["tree"] = tree
+	remixCtl.jsonResult(out)
+}
@@ -0,0 +1,163 @@
+// @APIVersion 1.0.0
+// @Title 作品版本接口服务
+// @Description 查看作品的历史版本，下载任意版本的作品文件，把作品恢复到历史版本
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/daemon"
+)
+
+// RevisionController 作品版本控制器
+type RevisionController struct {
+	BaseController
+	revMod   m.RevisionModels
+	workMod  m.WorkModels
+	gradeMod m.GradeModels
+}
+
+// NestPrepare 初始化函数
+func (revCtl *RevisionController) NestPrepare() {
+	revCtl.revMod.MgoSession = &revCtl.MgoClient
+	revCtl.revMod.BlobMod.MgoSession = &revCtl.MgoClient
+	revCtl.workMod.MgoSession = &revCtl.MgoClient
+	revCtl.gradeMod.MgoSession = &revCtl.MgoClient
+}
+
+// GetRevisions 查询作品的历史版本（作者、老师或管理员），新版本在前
+func (revCtl *RevisionController) GetRevisions() {
+	token := revCtl.checkToken()
+	work := revCtl.needWork(revCtl.GetString("workID"))
+	if work.UserID.Hex() != token.UserID {
+		revCtl.needAdminOrTeacherPermission(token)
+	}
+	revisions, err := revCtl.revMod.WorkRevisions(work.ID)
+	if err != nil {
+		logs.Error("WorkRevisions err:", err)
+		revCtl.abortWithError(m.ERR_REVISION_QUERY_FAIL)
+	}
+	keep, _ := m.RevisionPolicy()
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["revisions"] = revisions
+	out["keepCount"] = keep
+	revCtl.jsonResult(out)
+}
+
+// DownloadRevision 下载作品在某个版本时的文件（作者、老师或管理员）
+func (revCtl *RevisionController) DownloadRevision() {
+	token := revCtl.checkToken()
+	work := revCtl.needWork(revCtl.GetString("workID"))
+	if work.UserID.Hex() != token.UserID {
+		revCtl.needAdminOrTeacherPermission(token)
+	}
+	number := revCtl.needRevisionNumber(work.ID)
+	rev, err := revCtl.revMod.FileRevision(work.ID, number)
+	if err == mgo.ErrNotFound {
+		revCtl.abortWithError(m.ERR_REVISION_NO_FILE)
+	}
+	if err != nil {
+		logs.Error("FileRevision err:", err)
+		revCtl.abortWithError(m.ERR_REVISION_QUERY_FAIL)
+	}
+	data, err := revCtl.revMod.RevisionData(rev)
+	if err != nil {
+		logs.Error("RevisionData err:", err)
+		revCtl.abortWithError(m.ERR_REVISION_NO_FILE)
+	}
+	revCtl.Ctx.Output.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(fmt.Sprintf("%s_v%d.%s", work.Name, number, rev.Suffix)))
+	revCtl.Ctx.Output.Header("Content-Type", "application/octet-stream")
+	revCtl.Ctx.Output.Body(data)
+}
+
+// RestoreRevision 把作品恢复到某个历史版本（作者或管理员），恢复后记录为一个新版本
+func (revCtl *RevisionController) RestoreRevision() {
+	token := revCtl.checkToken()
+	work := revCtl.needWork(revCtl.GetString("workID"))
+	if work.UserID.Hex() != token.UserID {
+		revCtl.needAdminPermission(token)
+	}
+	number := revCtl.needRevisionNumber(work.ID)
+	// 已批改的作品和直接修改一样受重新提交截止时间限制
+	revCtl.needResubmittable(work.ID)
+	rev, err := revCtl.revMod.RestoreRevision(work, bson.ObjectIdHex(token.UserID), number)
+	if err != nil {
+		logs.Error("RestoreRevision err:", err)
+		revCtl.abortWithError(m.ERR_REVISION_RESTORE_FAIL)
+	}
+	if err := revCtl.gradeMod.MarkResubmitted(work.ID, time.Now()); err != nil {
+		logs.Error("MarkResubmitted err:", err)
+	}
+	keep, before := m.RevisionPolicy()
+	if _, err := revCtl.revMod.PruneRevisions(work.ID, keep, before); err != nil {
+		logs.Error("PruneRevisions err:", err)
+	}
+	quotaMod := m.QuotaModels{MgoSession: &revCtl.MgoClient}
+	if _, err := quotaMod.UpdateWorkStorage(work.ID); err != nil {
+		logs.Error("UpdateWorkStorage err:", err)
+	}
+	daemon.UpdateWorkIndex(work.ID.Hex())
+	daemon.RefreshSimilarity()
+
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["revision"] = rev
+	revCtl.jsonResult(out)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本控制器的内部函数 *********************************/
+/*********************************** *********************************************************/
+
+// needWork 查询作品，不存在时返回错误
+func (revCtl *RevisionController) needWork(workID string) m.WorkBody {
+	if !bson.IsObjectIdHex(workID) {
+		revCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	work, err := revCtl.workMod.FindWorkByID(bson.ObjectIdHex(workID))
+	if err != nil {
+		revCtl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	}
+	return work
+}
+
+// needRevisionNumber 读取版本号参数number，并检查该版本存在
+func (revCtl *RevisionController) needRevisionNumber(workID bson.ObjectId) int {
+	number, err := revCtl.GetInt("number")
+	if err != nil || number <= 0 {
+		revCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	_, err = revCtl.revMod.FindRevision(workID, number)
+	if err == mgo.ErrNotFound {
+		revCtl.abortWithError(m.ERR_REVISION_NONE)
+	}
+	if err != nil {
+		logs.Error("FindRevision err:", err)
+		revCtl.abortWithError(m.ERR_REVISION_QUERY_FAIL)
+	}
+	return number
+}
+
+// needResubmittable 已批改且重新提交已截止的作品不能恢复
+func (revCtl *RevisionController) needResubmittable(workID bson.ObjectId) {
+	ok, err := revCtl.gradeMod.CanResubmit(workID, time.Now())
+	if err != nil {
+		logs.Error("CanResubmit err:", err)
+		revCtl.abortWithError(m.ERR_GRADE_QUERY_FAIL)
+	}
+	if !ok {
+		revCtl.abortWithError(m.ERR_GRADE_LOCKED)
+	}
+}
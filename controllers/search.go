@@ -0,0 +1,46 @@
+// @APIVersion 1.0.0
+// @Title 全文检索控制器
+// @Description 课程、课时、工具、分享作品的全文检索
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"strings"
+
+	m "maiyajia.com/models"
+	"maiyajia.com/services/search"
+)
+
+// SearchController 全文检索
+type SearchController struct {
+	BaseController
+}
+
+// Search 全文检索，参数q为关键词，type为逗号分隔的文档类型(course,lesson,tool,work)，为空时检索全部类型
+func (searchCtrl *SearchController) Search() {
+	paging, err := paramPaging(searchCtrl.Ctx)
+	if err != nil {
+		searchCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	keyword := strings.TrimSpace(searchCtrl.GetString("q"))
+	if keyword == "" {
+		searchCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	var types []string
+	for _, t := range strings.Split(searchCtrl.GetString("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	results, total := search.Default.Search(keyword, types, paging.Offset(), paging.Limit())
+	// 封装返回数据
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["total"] = total
+	out["results"] = results
+	searchCtrl.jsonResult(out)
+}
@@ -101,6 +101,7 @@ func (toolsCtrl *ToolsController) InstallTools() {
 		logs.Error("Install tools fail", err)
 		return
 	}
+	daemon.RefreshSearchIndex()
 	beego.Informational("Tools install complete!")
 }
 
@@ -0,0 +1,134 @@
+// @APIVersion 1.0.0
+// @Title 分块上传接口服务
+// @Description 大文件分块上传：创建上传会话、按偏移量上传带SHA-256校验的分块、断线后查询已上传的大小继续上传。
+// 上传完成后由作品、课程包、工具包各自的接口校验并导入
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+
+	"github.com/astaxie/beego/logs"
+	"gopkg.in/mgo.v2/bson"
+	m "maiyajia.com/models"
+)
+
+// UploadController 分块上传控制器
+type UploadController struct {
+	BaseController
+	uploadMod m.UploadModels
+}
+
+// NestPrepare 初始化函数
+func (uploadCtl *UploadController) NestPrepare() {
+	uploadCtl.uploadMod.MgoSession = &uploadCtl.MgoClient
+}
+
+// CreateUpload 创建上传会话。kind 为 work（作品文件，suffix 为 stl、sgl、sb3、Z1，不指定 workID 时分配新的作品ID）、
+// course（离线课程包）或 tool（工具包，tool 为工具信息），后两种需要管理员权限；sha256 为整个文件的校验值（可选）
+func (uploadCtl *UploadController) CreateUpload() {
+	token := uploadCtl.checkToken()
+	var session m.UploadSession
+	if err := json.Unmarshal(uploadCtl.Ctx.Input.RequestBody, &session); err != nil {
+		uploadCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	maxSize, _ := m.UploadLimits()
+	if session.Size <= 0 || session.Size > maxSize {
+		uploadCtl.abortWithError(m.ERR_UPLOAD_SIZE)
+	}
+	switch session.Kind {
+	case m.UploadWork:
+		if !m.ValidUploadSuffix(session.Suffix) {
+			uploadCtl.abortWithError(m.ERR_UPLOAD_KIND)
+		}
+		if session.WorkID == "" {
+			session.WorkID = m.NewID()
+		}
+		if !bson.IsObjectIdHex(session.WorkID) {
+			uploadCtl.abortWithError(m.ERR_REQUEST_PARAM)
+		}
+		// 提前检查配额，避免上传完成后才发现超出
+		blobMod := m.BlobModels{MgoSession: &uploadCtl.MgoClient}
+		uploadCtl.needStorageQuota(token, session.Size, blobMod.WorkFileSize(session.WorkID, session.Suffix))
+		session.Tool = nil
+	case m.UploadCourse:
+		uploadCtl.needAdminPermission(token)
+		session.WorkID, session.Suffix, session.Tool = "", "", nil
+	case m.UploadTool:
+		uploadCtl.needAdminPermission(token)
+		if session.Tool == nil || !m.ValidToolName(session.Tool.Name) {
+			uploadCtl.abortWithError(m.ERR_REQUEST_PARAM)
+		}
+		session.WorkID, session.Suffix = "", ""
+	default:
+		uploadCtl.abortWithError(m.ERR_UPLOAD_KIND)
+	}
+	session.UserID = bson.ObjectIdHex(token.UserID)
+	session.Filename = path.Base(session.Filename)
+	if err := uploadCtl.uploadMod.CreateUpload(&session); err != nil {
+		logs.Error("CreateUpload err:", err)
+		uploadCtl.abortWithError(m.ERR_UPLOAD_SAVE_FAIL)
+	}
+	_, chunkSize := m.UploadLimits()
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["session"] = session
+	out["chunkSize"] = chunkSize
+	uploadCtl.jsonResult(out)
+}
+
+// GetUpload 查询上传会话，断线后从返回的 offset 继续上传
+func (uploadCtl *UploadController) GetUpload() {
+	token := uploadCtl.checkToken()
+	session := uploadCtl.needUploadSession(token, "")
+	_, chunkSize := m.UploadLimits()
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["session"] = session
+	out["chunkSize"] = chunkSize
+	uploadCtl.jsonResult(out)
+}
+
+// PutChunk 上传一个分块，请求体为分块内容，offset 必须等于已上传的大小，sha256 为分块的校验值（可选）。
+// 偏移量不一致时返回错误，客户端重新查询会话的 offset 后继续，重复发送已经成功的分块不会重复写入
+func (uploadCtl *UploadController) PutChunk() {
+	token := uploadCtl.checkToken()
+	session := uploadCtl.needUploadSession(token, "")
+	offset, err := uploadCtl.GetInt64("offset")
+	if err != nil {
+		uploadCtl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	content := uploadCtl.Ctx.Input.RequestBody
+	if _, chunkSize := m.UploadLimits(); len(content) == 0 || int64(len(content)) > chunkSize {
+		uploadCtl.abortWithError(m.ERR_UPLOAD_SIZE)
+	}
+	received, err := uploadCtl.uploadMod.WriteUploadChunk(&session, offset, bytes.NewReader(content), uploadCtl.GetString("sha256"))
+	if err != nil {
+		logs.Warn("WriteUploadChunk:", session.ID.Hex(), offset, err)
+		uploadCtl.abortWithUploadError(err)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["offset"] = received
+	out["done"] = received == session.Size
+	uploadCtl.jsonResult(out)
+}
+
+// CancelUpload 取消上传并删除已上传的部分
+func (uploadCtl *UploadController) CancelUpload() {
+	token := uploadCtl.checkToken()
+	session := uploadCtl.needUploadSession(token, "")
+	if err := uploadCtl.uploadMod.RemoveUpload(session.ID); err != nil {
+		logs.Error("RemoveUpload err:", err)
+		uploadCtl.abortWithError(m.ERR_UPLOAD_SAVE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	uploadCtl.jsonResult(out)
+}
@@ -1,31 +1,46 @@
 package controllers
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"mime/multipart"
+	"net/http"
 	"os"
 	"path"
 	"strings"
+	"time"
 
+	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
 	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
 	"github.com/astaxie/beego/logs"
 
 	m "maiyajia.com/models"
 	"maiyajia.com/services/daemon"
-	d "maiyajia.com/services/daemon"
+	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/scratch"
+	"maiyajia.com/services/stl"
 )
 
 // WorksController 作品控制器
 type WorksController struct {
 	BaseController
-	workMod m.WorkModels
-	userMod m.UserModels
-	toolMod m.ToolModels
+	workMod  m.WorkModels
+	userMod  m.UserModels
+	toolMod  m.ToolModels
+	gradeMod m.GradeModels
+	simMod   m.SimilarityModels
+	revMod   m.RevisionModels
+	printMod m.PrintModels
+	remixMod m.RemixModels
+	quotaMod m.QuotaModels
+	blobMod  m.BlobModels
 }
 
 // NestPrepare 数据库客户端
@@ -33,6 +48,16 @@ func (workCtrl *WorksController) NestPrepare() {
 	workCtrl.workMod.MgoSession = &workCtrl.MgoClient
 	workCtrl.userMod.MgoSession = workCtrl.MgoClient
 	workCtrl.toolMod.MgoSession = &workCtrl.MgoClient
+	workCtrl.gradeMod.MgoSession = &workCtrl.MgoClient
+	workCtrl.simMod.MgoSession = &workCtrl.MgoClient
+	workCtrl.simMod.BlobMod.MgoSession = &workCtrl.MgoClient
+	workCtrl.revMod.MgoSession = &workCtrl.MgoClient
+	workCtrl.revMod.BlobMod.MgoSession = &workCtrl.MgoClient
+	workCtrl.printMod.MgoSession = &workCtrl.MgoClient
+	workCtrl.remixMod.MgoSession = &workCtrl.MgoClient
+	workCtrl.remixMod.MessageMod.MgoSession = &workCtrl.MgoClient
+	workCtrl.quotaMod.MgoSession = &workCtrl.MgoClient
+	workCtrl.blobMod.MgoSession = &workCtrl.MgoClient
 }
 
 // GetList 获取作品列表
@@ -112,6 +137,8 @@ func (workCtrl *WorksController) PostDescription() {
 		workCtrl.abortWithError(m.ERR_ADD_WORK_FAIL)
 
 	}
+	workCtrl.refreshStorage(workContent.ID)
+	daemon.UpdateWorkIndex(workContent.ID.Hex())
 
 	beego.Debug("end desc")
 
@@ -120,50 +147,30 @@ func (workCtrl *WorksController) PostDescription() {
 	workCtrl.jsonResult(out)
 }
 
-// PostBinaryData 提交二进制文件（stl,sgl）
+// PostBinaryData 提交二进制文件（stl,sgl），每次保存记录一个文件版本
 func (workCtrl *WorksController) PostBinaryData() {
-	var id, name string
-	workCtrl.checkToken()
+	var id, suffix string
+	token := workCtrl.checkToken()
 	if workCtrl.Ctx.Input.Bind(&id, "id") != nil {
 		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
 	}
 	if id != "" {
-		name = id + ".sgl"
+		suffix = "sgl"
 	} else {
 		id = m.NewID()
-		name = id + ".stl"
+		suffix = "stl"
 	}
+	workCtrl.needWorkEditable(id)
 	beego.Info("begin PostBinaryData")
 	content := workCtrl.Ctx.Input.RequestBody
-	workpath := path.Join(beego.AppPath, "asset", "works", name)
-
-	dirPath := path.Join(beego.AppPath, "asset", "works")
-
-	_, e := os.Stat(dirPath)
-
-	if e != nil {
-
-		err := os.Mkdir(dirPath, os.ModePerm)
-		if err != nil {
-			fmt.Printf("mkdir failed![%v]\n", err)
-
-			workCtrl.abortWithError(m.ERR_CREATE_FILE_FAIL)
-
-		} else {
-			fmt.Printf("mkdir success!\n")
-		}
+	workCtrl.needStorageQuota(token, int64(len(content)), workCtrl.blobMod.WorkFileSize(id, suffix))
+	workCtrl.saveWorkFile(id, suffix, bytes.NewReader(content))
+	workCtrl.markResubmitted(id)
+	if bson.IsObjectIdHex(id) {
+		workCtrl.refreshStorage(bson.ObjectIdHex(id))
+		workCtrl.trackPendingFiles(bson.ObjectIdHex(id), token.UserID)
+		workCtrl.recordFileRevision(bson.ObjectIdHex(id), token.UserID, suffix)
 	}
-
-	fp, err := os.Create(workpath)
-
-	if err != nil {
-
-		beego.Debug("fail to create the file")
-		workCtrl.abortWithError(m.ERR_CREATE_FILE_FAIL)
-	}
-
-	defer fp.Close()
-	fp.Write(content)
 	out := make(map[string]interface{})
 
 	var responData m.Data
@@ -174,9 +181,9 @@ func (workCtrl *WorksController) PostBinaryData() {
 	beego.Info("end PostBinaryData")
 }
 
-//PutDescription 更新作品
+//PutDescription 更新作品，每次更新记录一个作品信息版本
 func (workCtrl *WorksController) PutDescription() {
-	workCtrl.checkToken()
+	token := workCtrl.checkToken()
 	var workContent m.WorkBody
 
 	beego.Debug("begin desc")
@@ -188,12 +195,16 @@ func (workCtrl *WorksController) PutDescription() {
 		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
 
 	}
+	workCtrl.needWorkEditable(workContent.ID.Hex())
 
 	if err := workCtrl.workMod.PatchWork(workContent.ID.Hex(), workContent.UserID, workContent.Name, workContent.Tool, workContent.Types, workContent.Picture, workContent.Description, workContent.Data); err != nil {
 
 		workCtrl.abortWithError(m.ERR_ADD_WORK_FAIL)
 
 	}
+	workCtrl.markResubmitted(workContent.ID.Hex())
+	workCtrl.recordRevision(workContent.ID, token.UserID, m.RevisionDescription, "", nil, workContent.Snapshot())
+	daemon.UpdateWorkIndex(workContent.ID.Hex())
 
 	beego.Debug("end desc")
 
@@ -202,9 +213,10 @@ func (workCtrl *WorksController) PutDescription() {
 	workCtrl.jsonResult(out)
 }
 
-// PutBinaryData 更新二进制文件
+// PutBinaryData 更新二进制文件，每次更新保存一个文件版本，message 为版本说明（可选）。
+// 更新stl时可以用 format（stl、obj、3mf）和 unit 上传其他软件导出的模型，转换为以毫米为单位的二进制STL保存
 func (workCtrl *WorksController) PutBinaryData() {
-	workCtrl.checkToken()
+	token := workCtrl.checkToken()
 	var name string
 	id := workCtrl.GetString("id")
 	suffix := workCtrl.GetString("suffix")
@@ -213,13 +225,25 @@ func (workCtrl *WorksController) PutBinaryData() {
 	} else if suffix == "stl" {
 		name = id + ".stl"
 	}
+	workCtrl.needWorkEditable(id)
 	beego.Info("begin PostBinaryData")
 	content := workCtrl.Ctx.Input.RequestBody
-	path := path.Join(beego.AppPath, "asset", "works", name)
-	beego.Debug(path)
-	fp, _ := os.Create(path)
-	defer fp.Close()
-	fp.Write(content)
+	if suffix == "stl" && (workCtrl.GetString("format") != "" || workCtrl.GetString("unit") != "") {
+		content = workCtrl.convertModel(content, workCtrl.GetString("format", stl.FormatSTL))
+	}
+	if name != "" && bson.IsObjectIdHex(id) {
+		workCtrl.needStorageQuota(token, int64(len(content)), workCtrl.blobMod.WorkFileSize(id, suffix))
+		workCtrl.saveWorkFile(id, suffix, bytes.NewReader(content))
+		workCtrl.markResubmitted(id)
+		workCtrl.refreshStorage(bson.ObjectIdHex(id))
+		workCtrl.trackPendingFiles(bson.ObjectIdHex(id), token.UserID)
+		workCtrl.recordFileRevision(bson.ObjectIdHex(id), token.UserID, suffix)
+	}
+	if suffix == "stl" {
+		workCtrl.refreshThumbnail(id)
+		workCtrl.refreshPrintReport(id)
+	}
+	daemon.RefreshSimilarity()
 	out := make(map[string]interface{})
 
 	var responData m.Data
@@ -233,7 +257,6 @@ func (workCtrl *WorksController) PutBinaryData() {
 func (workCtrl *WorksController) DeleteWork() {
 	workCtrl.checkToken()
 	id := workCtrl.GetString("id")
-	suffix := workCtrl.GetString("suffix")
 
 	//检测作品ID是否存在
 	work, err := workCtrl.workMod.FindWorkByID(bson.ObjectIdHex(id))
@@ -242,6 +265,7 @@ func (workCtrl *WorksController) DeleteWork() {
 		logs.Info("查找失败")
 		workCtrl.abortWithError(m.ERR_NO_WORK_EXISTS)
 	}
+	workCtrl.needWorkEditable(id)
 	if workCtrl.workMod.FindWorkFavor(id) != nil {
 		workCtrl.workMod.DeleteFavorWorkByID(id)
 	}
@@ -250,18 +274,20 @@ func (workCtrl *WorksController) DeleteWork() {
 		logs.Info("删除失败")
 		workCtrl.abortWithError(m.ERR_DELETE_WORK_FAIL)
 	}
-	if suffix == "sgl" {
-		name := id + ".sgl"
-		err = os.Remove(path.Join(beego.AppPath, "asset", "works", name))
-		if err != nil {
-			workCtrl.abortWithError(m.ERR_SGL_NO_file_EXISTS)
-		}
+	if err := workCtrl.workMod.RemixCount(work.OriginID, -1); err != nil {
+		logs.Error("RemixCount err:", err)
 	}
-	if work.Relpath != "" {
-		err = os.Remove(work.Relpath)
-		if err != nil {
-			workCtrl.abortWithError(m.ERR_NO_file_EXISTS)
-		}
+	daemon.UpdateWorkIndex(id)
+	// 作品文件的引用和版本历史，内容相同的文件由其他作品引用时仍然保留
+	if err := workCtrl.blobMod.RemoveWorkFiles(id); err != nil {
+		logs.Error("RemoveWorkFiles err:", err)
+	}
+	if err := workCtrl.revMod.RemoveRevisions(work.ID); err != nil {
+		logs.Error("RemoveRevisions err:", err)
+	}
+	// 服务器渲染的预览图，可能不存在
+	if err := m.Assets().Remove(path.Join("asset", "works", id+".png")); err != nil {
+		logs.Error("remove thumbnail err:", err)
 	}
 	//封装返回数据
 	out := make(map[string]interface{})
@@ -286,17 +312,7 @@ func (workCtrl *WorksController) GetWork() {
 		filename = id + ".stl"
 	}
 
-	fullPath := path.Join(beego.AppPath, "asset", "works", filename)
-
-	_, err := os.Stat(fullPath)
-
-	if err != nil {
-
-		workCtrl.abortWithError(m.ERR_NO_WORK_EXISTS)
-
-	}
-
-	workCtrl.Ctx.Output.Download(path.Join(beego.AppPath, "asset", "works", filename))
+	workCtrl.downloadAsset(path.Join("asset", "works", filename), m.ERR_NO_WORK_EXISTS)
 
 }
 
@@ -346,6 +362,7 @@ func (workCtrl *WorksController) ShareWork() {
 		workCtrl.abortWithError(m.ERR_SHARE_WORK_FAIL)
 
 	}
+	daemon.UpdateWorkIndex(sharework.ID.Hex())
 
 	out := make(map[string]interface{})
 	out["code"] = 0
@@ -436,7 +453,8 @@ func (workCtrl *WorksController) GetPersonalShareList() {
 	workCtrl.jsonResult(out)
 }
 
-// GetShareList 获取分享作品列表按浏览量、时间展示
+// GetShareList 获取分享作品列表按浏览量、时间展示。
+// 可按Scratch作品元数据筛选：extension 使用的扩展，blockCategory 使用的积木分类，cloud 使用云变量，minSprites、minBlocks 最少角色数和积木数
 func (workCtrl *WorksController) GetShareList() {
 	var works interface{}
 	var sortCategory int
@@ -447,8 +465,16 @@ func (workCtrl *WorksController) GetShareList() {
 	if err != nil {
 		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
 	}
-	total, _ := workCtrl.workMod.QueryShareWorksCount()
-	works, err = workCtrl.workMod.GetShareWorks(paging, sortCategory)
+	facets := m.ScratchFacets{Extension: workCtrl.GetString("extension"), Category: workCtrl.GetString("blockCategory")}
+	var err1, err2, err3 error
+	facets.Cloud, err1 = workCtrl.GetBool("cloud", false)
+	facets.MinSprites, err2 = workCtrl.GetInt("minSprites", 0)
+	facets.MinBlocks, err3 = workCtrl.GetInt("minBlocks", 0)
+	if err1 != nil || err2 != nil || err3 != nil || !facets.Valid() {
+		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	total, _ := workCtrl.workMod.QueryShareWorksCount(facets)
+	works, err = workCtrl.workMod.GetShareWorks(paging, sortCategory, facets)
 	if err != nil {
 		workCtrl.abortWithError(m.ERR_NO_WORK_EXISTS)
 	}
@@ -580,7 +606,7 @@ func (workCtrl *WorksController) GetMyFavoriteWorks() {
 
 }
 
-//CopyWork 复制收藏作品用于编辑
+//CopyWork 复制收藏作品用于编辑（改编），原作品的被改编数加1并通知原作者
 func (workCtrl *WorksController) CopyWork() {
 	logs.Info("复制收藏")
 	//复制作品不需要stl的工具名
@@ -593,29 +619,22 @@ func (workCtrl *WorksController) CopyWork() {
 	if err != nil {
 		workCtrl.abortWithError(m.ERR_NO_WORK_EXISTS)
 	}
+	workCtrl.needLessonWorkOpen(token.UserID, work.ContentID.Hex())
+	workCtrl.needStorageQuota(token, workCtrl.blobMod.WorkFilesSize(id))
 	if err := workCtrl.workMod.CopyWork(work, token.UserID, id, name, newid, toolName); err != nil {
 		workCtrl.abortWithError(m.ERR_COLLECTION_WORK_FAIL)
 	}
-	if work.Types == "stl" {
-		name := newid.Hex() + ".stl"
-		dstName := path.Join(beego.AppPath, "asset", "works", name)
-		srcName := path.Join(beego.AppPath, work.Relpath)
-		daemon.CopyFile(dstName, srcName)
-	}
-	if work.Tool == "imakeart" {
-		name := newid.Hex() + ".sgl"
-		srcname := id + ".sgl"
-		dstName := path.Join(beego.AppPath, "asset", "works", name)
-		srcName := path.Join(beego.AppPath, "asset", "works", srcname)
-		daemon.CopyFile(dstName, srcName)
+	// 通知原作者作品被改编，通知失败不影响复制
+	if user, err := workCtrl.userMod.FindUserByID(bson.ObjectIdHex(token.UserID)); err == nil {
+		if err := workCtrl.remixMod.NotifyRemix(work, user); err != nil {
+			logs.Error("NotifyRemix err:", err)
+		}
 	}
-	if work.Tool == "scratch" {
-		name := newid.Hex() + ".sb3"
-		srcname := id + ".sb3"
-		dstName := path.Join(beego.AppPath, "asset", "works", name)
-		srcName := path.Join(beego.AppPath, "asset", "works", srcname)
-		daemon.CopyFile(dstName, srcName)
+	// 复制只增加文件的引用，不复制文件内容
+	if err := workCtrl.blobMod.CopyWorkFiles(id, newid.Hex()); err != nil {
+		logs.Error("CopyWorkFiles err:", err)
 	}
+	workCtrl.refreshStorage(newid)
 
 	out := make(map[string]interface{})
 	out["code"] = 0
@@ -663,20 +682,8 @@ func (workCtrl *WorksController) SaveProject() {
 
 		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
 	}
-
-	workDic := "./asset/works"
-
-	b, _ := d.PathExists(workDic)
-
-	if !b {
-
-		err := os.Mkdir(workDic, os.ModePerm)
-
-		if err != nil {
-
-			workCtrl.abortWithError(m.ERR_DIRECTORY_CREATE)
-		}
-	}
+	workCtrl.needLessonWorkOpen(token.UserID, workCtrl.GetString("contentID"))
+	workCtrl.needStorageQuota(token, workCtrl.uploadSize("file"))
 
 	toolURL := ""
 
@@ -692,7 +699,7 @@ func (workCtrl *WorksController) SaveProject() {
 
 	}
 
-	newWorkContent := workCtrl.workMod.NewScratch(token.UserID, name, tool, types, picture, description, toolURL, toolOBJ.Category)
+	newWorkContent := workCtrl.workMod.NewScratch(token.UserID, workCtrl.GetString("contentID"), name, tool, types, picture, description, toolURL, toolOBJ.Category)
 
 	if err := workCtrl.workMod.RegisteredWork(newWorkContent); err != nil {
 
@@ -705,18 +712,30 @@ func (workCtrl *WorksController) SaveProject() {
 	if err != nil {
 		log.Fatal("getfile err ", err)
 	}
+	defer f.Close()
 
-	filePath := "asset/works/" + newWorkContent.ID.Hex() + ".sb3"
-	// 保存位置在 static/upload, 没有文件夹要先创建
-	if workCtrl.SaveToFile("file", filePath); err != nil {
-
+	// 素材拆分后保存，相同的素材只保存一份
+	if _, err := workCtrl.blobMod.SaveWorkFile(newWorkContent.ID.Hex(), "sb3", f); err != nil {
+		logs.Error("SaveWorkFile err:", err)
 		workCtrl.abortWithError(m.ERR_ADD_WORK_FAIL)
 	}
-
-	defer f.Close()
+	workCtrl.refreshStorage(newWorkContent.ID)
+	workCtrl.recordFileRevision(newWorkContent.ID, token.UserID, "sb3")
 
 	out := make(map[string]interface{})
 	out["code"] = 0
+	daemon.RefreshSimilarity()
+	// 评估和提取元数据失败不影响作品保存
+	if assessment, err := workCtrl.workMod.AssessScratchWork(*newWorkContent); err == nil {
+		out["assessment"] = assessment
+	} else {
+		logs.Error("AssessScratchWork err:", err)
+	}
+	if meta, err := workCtrl.workMod.UpdateScratchMetadata(*newWorkContent); err == nil {
+		out["meta"] = meta
+	} else {
+		logs.Error("UpdateScratchMetadata err:", err)
+	}
 	workCtrl.jsonResult(out)
 }
 
@@ -770,17 +789,16 @@ func (workCtrl *WorksController) Save3DOne() {
 	if err := workCtrl.ParseForm(&workContent); err != nil {
 		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
 	}
+	workCtrl.needLessonWorkOpen(token.UserID, workContent.ContentID)
+	workCtrl.needStorageQuota(token, workCtrl.uploadSize("stlData", "Z1Data"))
 	workid := m.NewID()
 	relpath := path.Join("asset", "works", workid+".stl")
-	stlworkpath := path.Join(beego.AppPath, relpath)
-	Z1workpath := path.Join(beego.AppPath, "asset", "works", workid+".Z1")
-	dirPath := path.Join(beego.AppPath, "asset", "works")
 	stlData, err := workCtrl.GetFiles("stlData")
-	if err = UploadFiles(stlworkpath, stlData); err != nil {
+	if err = UploadFiles(&workCtrl.blobMod, workid, "stl", stlData); err != nil {
 		workCtrl.abortWithError(m.ERR_UPLOAD_IMAGES_FAIL)
 	}
 	Z1Data, err := workCtrl.GetFiles("Z1Data")
-	if err = UploadFiles(Z1workpath, Z1Data); err != nil {
+	if err = UploadFiles(&workCtrl.blobMod, workid, "Z1", Z1Data); err != nil {
 		workCtrl.abortWithError(m.ERR_UPLOAD_IMAGES_FAIL)
 
 	}
@@ -788,29 +806,35 @@ func (workCtrl *WorksController) Save3DOne() {
 		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
 	}
 	logs.Info("workContent.ContentID:", workContent.ContentID)
+	// 没有上传封面时用服务器渲染的预览图，渲染失败不影响保存
+	if thumbnail, err := m.RenderWorkThumbnail(workid); err == nil {
+		if workContent.Picture == "" {
+			workContent.Picture = thumbnail
+		}
+	} else {
+		logs.Error("RenderWorkThumbnail err:", err)
+	}
 	newWorkContent := m.NewWork(bson.ObjectIdHex(workid), bson.ObjectIdHex(token.UserID), workContent.ContentID, workContent.Name, workContent.Tool, workContent.Types, relpath, workContent.Picture, workContent.Description, workContent.Data, workContent.ToolURL, workContent.Category, workContent.Public)
 	if err := workCtrl.workMod.RegisteredWork(newWorkContent); err != nil {
 		logs.Info(err)
 		workCtrl.abortWithError(m.ERR_ADD_WORK_FAIL)
 	}
 
-	_, e := os.Stat(dirPath)
-	if e != nil {
-		err := os.Mkdir(dirPath, os.ModePerm)
-		if err != nil {
-			fmt.Printf("mkdir failed![%v]\n", err)
-			workCtrl.abortWithError(m.ERR_CREATE_FILE_FAIL)
-		} else {
-			fmt.Printf("mkdir success!\n")
-		}
+	workCtrl.refreshStorage(bson.ObjectIdHex(workid))
+	workCtrl.recordFileRevision(bson.ObjectIdHex(workid), token.UserID, "stl")
+	if len(Z1Data) > 0 {
+		workCtrl.recordFileRevision(bson.ObjectIdHex(workid), token.UserID, "Z1")
 	}
+	workCtrl.refreshPrintReport(workid)
+	daemon.RefreshSimilarity()
 
 	out := make(map[string]interface{})
 	out["code"] = 0
 	workCtrl.jsonResult(out)
 }
 
-//DownloadWork 下载作品源文件或stl文件
+//DownloadWork 下载作品源文件或stl文件。
+//下载stl时可以用 format（stl、stl-ascii、obj、3mf）转换格式，unit（mm、cm、m、in，默认mm）换算坐标单位
 func (workCtrl *WorksController) DownloadWork() {
 	var id string
 	var suffix string
@@ -826,33 +850,176 @@ func (workCtrl *WorksController) DownloadWork() {
 	} else if suffix == "stl" {
 		filename = id + ".stl"
 	}
-	fullPath := path.Join(beego.AppPath, "asset", "works", filename)
-	_, err := os.Stat(fullPath)
+	name := path.Join("asset", "works", filename)
+	format := workCtrl.GetString("format")
+	if suffix != "stl" || format == "" {
+		workCtrl.downloadAsset(name, m.ERR_NO_WORK_EXISTS)
+		return
+	}
+	if _, err := m.Assets().Stat(name); err != nil {
+		workCtrl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	}
+	ext := stl.Extension(format)
+	if ext == "" {
+		workCtrl.abortWithError(m.ERR_MODEL_FORMAT)
+	}
+	data, err := m.ExportWorkModel(id, format, workCtrl.needModelUnit())
+	if err != nil {
+		logs.Error("ExportWorkModel err:", err)
+		workCtrl.abortWithError(m.ERR_MODEL_CONVERT_FAIL)
+	}
+	workCtrl.Ctx.Output.Header("Content-Disposition", "attachment; filename="+id+"."+ext)
+	workCtrl.Ctx.Output.Header("Content-Type", "application/octet-stream")
+	workCtrl.Ctx.Output.Body(data)
+}
 
+// ImportModel 用其他软件导出的模型文件（stl、obj、3mf）创建3D作品，不限于3D One。
+// 上传字段为 model，unit 为STL和OBJ文件的长度单位（mm、cm、m、in，默认mm），模型统一转换为以毫米为单位的二进制STL保存
+func (workCtrl *WorksController) ImportModel() {
+	token := workCtrl.checkToken()
+	var workContent m.WorkForm
+	if err := workCtrl.ParseForm(&workContent); err != nil {
+		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	file, header, err := workCtrl.GetFile("model")
+	if err != nil {
+		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	defer file.Close()
+	format := stl.FormatOf(header.Filename)
+	if format == "" {
+		workCtrl.abortWithError(m.ERR_MODEL_FORMAT)
+	}
+	data, err := ioutil.ReadAll(file)
 	if err != nil {
+		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	workCtrl.needLessonWorkOpen(token.UserID, workContent.ContentID)
+	content := workCtrl.convertModel(data, format)
+	workCtrl.needStorageQuota(token, int64(len(content)))
 
-		workCtrl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	workid := m.NewID()
+	relpath := path.Join("asset", "works", workid+".stl")
+	workCtrl.saveWorkFile(workid, "stl", bytes.NewReader(content))
+	if workContent.Name == "" {
+		workContent.Name = strings.TrimSuffix(header.Filename, path.Ext(header.Filename))
+	}
+	if thumbnail, err := m.RenderWorkThumbnail(workid); err == nil {
+		if workContent.Picture == "" {
+			workContent.Picture = thumbnail
+		}
+	} else {
+		logs.Error("RenderWorkThumbnail err:", err)
+	}
+	newWorkContent := m.NewWork(bson.ObjectIdHex(workid), bson.ObjectIdHex(token.UserID), workContent.ContentID, workContent.Name, workContent.Tool, "stl", relpath, workContent.Picture, workContent.Description, workContent.Data, workContent.ToolURL, workContent.Category, workContent.Public)
+	if err := workCtrl.workMod.RegisteredWork(newWorkContent); err != nil {
+		logs.Info(err)
+		workCtrl.blobMod.RemoveWorkFiles(workid)
+		workCtrl.abortWithError(m.ERR_ADD_WORK_FAIL)
+	}
+	workCtrl.refreshStorage(bson.ObjectIdHex(workid))
+	workCtrl.recordFileRevision(bson.ObjectIdHex(workid), token.UserID, "stl")
+	workCtrl.refreshPrintReport(workid)
+	daemon.UpdateWorkIndex(workid)
+	daemon.RefreshSimilarity()
+
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["data"] = m.Data{ID: workid}
+	workCtrl.jsonResult(out)
+}
+
+// CompleteUpload 分块上传的作品文件全部上传并校验后保存为作品文件，记录一个文件版本，message 为版本说明（可选）
+func (workCtrl *WorksController) CompleteUpload() {
+	token := workCtrl.checkToken()
+	session := workCtrl.needUploadSession(token, m.UploadWork)
+	id := session.WorkID
+	if work, err := workCtrl.workMod.FindWorkByID(bson.ObjectIdHex(id)); err == nil && work.UserID.Hex() != token.UserID {
+		workCtrl.abortWithError(m.ERR_PERMISSION_DENIED)
+	}
+	workCtrl.needWorkEditable(id)
+	// 创建上传会话时已检查配额，会话按声明的大小计入配额，这里不再检查
+	// 先移出上传目录再存入内容寻址存储，大文件不读入内存
+	filename := path.Join(beego.AppPath, "tmp", "upload_"+session.ID.Hex()+"."+session.Suffix)
+	workCtrl.completeUpload(session, filename)
+	if _, err := workCtrl.blobMod.MoveWorkFile(id, session.Suffix, filename); err != nil {
+		logs.Error("MoveWorkFile err:", err)
+		os.Remove(filename)
+		workCtrl.abortWithError(m.ERR_UPLOAD_SAVE_FAIL)
+	}
+	workCtrl.markResubmitted(id)
+	workCtrl.refreshStorage(bson.ObjectIdHex(id))
+	workCtrl.trackPendingFiles(bson.ObjectIdHex(id), token.UserID)
+	workCtrl.recordFileRevision(bson.ObjectIdHex(id), token.UserID, session.Suffix)
+	if session.Suffix == "stl" {
+		workCtrl.refreshThumbnail(id)
+		workCtrl.refreshPrintReport(id)
+	}
+	daemon.RefreshSimilarity()
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["data"] = m.Data{ID: id}
+	workCtrl.jsonResult(out)
+}
 
+// GetMyStorage 查询自己的作品占用的存储空间和配额
+func (workCtrl *WorksController) GetMyStorage() {
+	token := workCtrl.checkToken()
+	usage, err := workCtrl.quotaMod.UserStorage(bson.ObjectIdHex(token.UserID), token.UserRole)
+	if err != nil {
+		logs.Error("UserStorage err:", err)
+		workCtrl.abortWithError(m.ERR_QUOTA_QUERY_FAIL)
 	}
-	workCtrl.Ctx.Output.Download(path.Join(beego.AppPath, "asset", "works", filename))
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["storage"] = usage
+	workCtrl.jsonResult(out)
 }
 
-//UploadFiles 同时上传数据及文件时multipart
-func UploadFiles(filePath string, fileData []*multipart.FileHeader) error {
-	// 遍历文件上传到指定位置
+//UploadFiles 同时上传数据及文件时multipart，保存为作品文件
+func UploadFiles(blobMod *m.BlobModels, workID, suffix string, fileData []*multipart.FileHeader) error {
+	if len(fileData) == 0 {
+		return http.ErrMissingFile
+	}
 	file, err := fileData[0].Open()
 	if err != nil {
 		logs.Error("err:", err)
 		return err
 	}
 	defer file.Close()
-	f, err := os.Create(filePath)
+	_, err = blobMod.SaveWorkFile(workID, suffix, file)
+	return err
+}
+
+// WorkFileFilter Scratch作品按素材拆分存储，存储中没有 asset/works 下的文件，通过静态目录访问时重新组装。
+// 组装好的文件按内容缓存，支持分段下载和条件请求
+func WorkFileFilter(ctx *context.Context) {
+	name := path.Base(ctx.Request.URL.Path)
+	if path.Ext(name) != ".sb3" {
+		return
+	}
+	if _, err := m.Assets().Stat(path.Join("asset", "works", name)); err == nil {
+		return
+	}
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("WorkFileFilter StartSession err:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	blobMod := m.BlobModels{MgoSession: dbclient}
+	file, data, err := blobMod.ReadWorkFileCached(strings.TrimSuffix(name, ".sb3"), "sb3")
 	if err != nil {
-		return err
+		if err != mgo.ErrNotFound {
+			logs.Error("ReadWorkFileCached err:", name, err)
+		}
+		return
 	}
-	defer f.Close()
-	io.Copy(f, file)
-	return nil
+	ctx.Output.Header("Content-Type", "application/octet-stream")
+	if file.Blob != "" {
+		ctx.Output.Header("ETag", `"`+file.Blob+`"`)
+	}
+	http.ServeContent(ctx.ResponseWriter, ctx.Request, name, file.UpdateTime, bytes.NewReader(data))
 }
 
 //GetClassStudents 获取指定班级学生作品
@@ -877,3 +1044,271 @@ func (workCtrl *WorksController) GetClassStudents() {
 	out["results"] = results
 	workCtrl.jsonResult(out)
 }
+
+// AssessWork 重新评估Scratch作品并提取元数据（作品的作者、老师或管理员），课时检查项修改后可用来刷新结果
+func (workCtrl *WorksController) AssessWork() {
+	token := workCtrl.checkToken()
+	workID := workCtrl.GetString("workID")
+	if !bson.IsObjectIdHex(workID) {
+		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	work, err := workCtrl.workMod.FindWorkByID(bson.ObjectIdHex(workID))
+	if err != nil {
+		workCtrl.abortWithError(m.ERR_NO_WORK_EXISTS)
+	}
+	if work.UserID.Hex() != token.UserID {
+		workCtrl.needAdminOrTeacherPermission(token)
+	}
+	if !strings.HasSuffix(work.Relpath, ".sb3") {
+		workCtrl.abortWithError(m.ERR_SCRATCH_PROJECT_INVALID)
+	}
+	assessment, err := workCtrl.workMod.AssessScratchWork(work)
+	if err != nil {
+		logs.Error("AssessScratchWork err:", err)
+		workCtrl.abortWithError(m.ERR_SCRATCH_PROJECT_INVALID)
+	}
+	meta, err := workCtrl.workMod.UpdateScratchMetadata(work)
+	if err != nil {
+		logs.Error("UpdateScratchMetadata err:", err)
+		workCtrl.abortWithError(m.ERR_SCRATCH_PROJECT_INVALID)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["assessment"] = assessment
+	out["meta"] = meta
+	workCtrl.jsonResult(out)
+}
+
+// GetScratchChecks 老师或管理员查询课时的Scratch作品检查项，没有设置时返回空列表
+func (workCtrl *WorksController) GetScratchChecks() {
+	token := workCtrl.checkToken()
+	workCtrl.needAdminOrTeacherPermission(token)
+	lessonID := workCtrl.GetString("lessonID")
+	if !bson.IsObjectIdHex(lessonID) {
+		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	checks := []*scratch.Check{}
+	lessonChecks, err := workCtrl.workMod.FindLessonScratchChecks(bson.ObjectIdHex(lessonID))
+	if err != nil && err != mgo.ErrNotFound {
+		logs.Error("FindLessonScratchChecks err:", err)
+		workCtrl.abortWithError(m.ERR_READ_WORK_FAIL)
+	}
+	if err == nil && lessonChecks.Checks != nil {
+		checks = lessonChecks.Checks
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["checks"] = checks
+	workCtrl.jsonResult(out)
+}
+
+// SaveScratchChecks 老师或管理员设置课时的Scratch作品检查项，如“使用了循环”“至少有2个角色”。
+// 自己的班级选了该课程的老师都可以修改，管理员可以修改所有课时的检查项
+func (workCtrl *WorksController) SaveScratchChecks() {
+	token := workCtrl.checkToken()
+	workCtrl.needAdminOrTeacherPermission(token)
+	var form struct {
+		CourseID string           `json:"courseID"`
+		LessonID string           `json:"lessonID"`
+		Checks   []*scratch.Check `json:"checks"`
+	}
+	if err := json.Unmarshal(workCtrl.Ctx.Input.RequestBody, &form); err != nil {
+		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if !bson.IsObjectIdHex(form.CourseID) || !bson.IsObjectIdHex(form.LessonID) {
+		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if err := scratch.ValidateChecks(form.Checks); err != nil {
+		workCtrl.abortWithError(m.ERR_SCRATCH_CHECK_INVALID)
+	}
+	courseID := bson.ObjectIdHex(form.CourseID)
+	if token.UserRole != m.ROLE_ADMIN {
+		courseMod := m.CourseModels{MgoSession: &workCtrl.MgoClient}
+		ok, err := courseMod.TeacherHasCourse(bson.ObjectIdHex(token.UserID), courseID)
+		if err != nil {
+			logs.Error("TeacherHasCourse err:", err)
+			workCtrl.abortWithError(m.ERR_SCRATCH_CHECK_UPDATE_FAIL)
+		}
+		if !ok {
+			workCtrl.abortWithError(m.ERR_PERMISSION_DENIED)
+		}
+	}
+	err := workCtrl.workMod.SaveLessonScratchChecks(courseID, bson.ObjectIdHex(form.LessonID), form.Checks, bson.ObjectIdHex(token.UserID))
+	if err == mgo.ErrNotFound {
+		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	if err != nil {
+		logs.Error("SaveLessonScratchChecks err:", err)
+		workCtrl.abortWithError(m.ERR_SCRATCH_CHECK_UPDATE_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["checks"] = form.Checks
+	workCtrl.jsonResult(out)
+}
+
+// GetSimilarityReport 老师查看班级学生在课时作品中互相高度相似的作品
+func (workCtrl *WorksController) GetSimilarityReport() {
+	token := workCtrl.checkToken()
+	workCtrl.needAdminOrTeacherPermission(token)
+	classCode := workCtrl.GetString("classCode")
+	contentID := workCtrl.GetString("contentID")
+	if classCode == "" || !bson.IsObjectIdHex(contentID) {
+		workCtrl.abortWithError(m.ERR_REQUEST_PARAM)
+	}
+	class := workCtrl.needClassOwner(token, classCode)
+	rows, err := workCtrl.simMod.ClassSimilarity(class, bson.ObjectIdHex(contentID))
+	if err != nil {
+		logs.Error("ClassSimilarity err:", err)
+		workCtrl.abortWithError(m.ERR_READ_WORK_FAIL)
+	}
+	out := make(map[string]interface{})
+	out["code"] = 0
+	out["threshold"] = beego.AppConfig.DefaultFloat("similarity_threshold", 0.8)
+	out["pairs"] = rows
+	workCtrl.jsonResult(out)
+}
+
+// needWorkEditable 已批改的作品只能在重新提交截止时间前修改或删除，只检查不修改批改状态
+func (workCtrl *WorksController) needWorkEditable(id string) {
+	if !bson.IsObjectIdHex(id) {
+		return
+	}
+	ok, err := workCtrl.gradeMod.CanResubmit(bson.ObjectIdHex(id), time.Now())
+	if err != nil {
+		logs.Error("CanResubmit err:", err)
+		workCtrl.abortWithError(m.ERR_GRADE_QUERY_FAIL)
+	}
+	if !ok {
+		workCtrl.abortWithError(m.ERR_GRADE_LOCKED)
+	}
+}
+
+// markResubmitted 已批改的作品修改保存后标记为重新提交，等待老师重新批改。作品已经保存，失败只写日志
+func (workCtrl *WorksController) markResubmitted(id string) {
+	if !bson.IsObjectIdHex(id) {
+		return
+	}
+	if err := workCtrl.gradeMod.MarkResubmitted(bson.ObjectIdHex(id), time.Now()); err != nil {
+		logs.Error("MarkResubmitted err:", err)
+	}
+}
+
+// needLessonWorkOpen 学生在课节已有批改且设置的重新提交截止时间已过的作品时，不能再为该课节新建或复制作品
+func (workCtrl *WorksController) needLessonWorkOpen(userID, contentID string) {
+	if !bson.IsObjectIdHex(userID) || !bson.IsObjectIdHex(contentID) {
+		return
+	}
+	locked, err := workCtrl.gradeMod.LessonWorkLocked(bson.ObjectIdHex(userID), bson.ObjectIdHex(contentID), time.Now())
+	if err != nil {
+		logs.Error("LessonWorkLocked err:", err)
+		workCtrl.abortWithError(m.ERR_GRADE_QUERY_FAIL)
+	}
+	if locked {
+		workCtrl.abortWithError(m.ERR_GRADE_LOCKED)
+	}
+}
+
+// recordRevision 记录作品的新版本并按保留策略清理旧版本。作品已经保存，记录版本失败只写日志
+func (workCtrl *WorksController) recordRevision(workID bson.ObjectId, userID, kind, suffix string, data []byte, doc *m.WorkSnapshot) {
+	if !workID.Valid() || !bson.IsObjectIdHex(userID) {
+		return
+	}
+	if _, err := workCtrl.revMod.AddRevision(workID, bson.ObjectIdHex(userID), kind, suffix, data, doc, workCtrl.GetString("message")); err != nil {
+		logs.Error("AddRevision err:", err)
+		return
+	}
+	workCtrl.pruneRevisions(workID)
+}
+
+// recordFileRevision 用保存好的作品文件记录一个文件版本，版本只增加文件的引用
+func (workCtrl *WorksController) recordFileRevision(workID bson.ObjectId, userID, suffix string) {
+	if !workID.Valid() || !bson.IsObjectIdHex(userID) {
+		return
+	}
+	if _, err := workCtrl.revMod.AddWorkFileRevision(workID, bson.ObjectIdHex(userID), m.RevisionData, suffix, workCtrl.GetString("message")); err != nil {
+		logs.Error("AddWorkFileRevision err:", err)
+		return
+	}
+	workCtrl.pruneRevisions(workID)
+}
+
+// saveWorkFile 保存作品文件，失败时返回创建文件失败
+func (workCtrl *WorksController) saveWorkFile(id, suffix string, r io.Reader) {
+	if _, err := workCtrl.blobMod.SaveWorkFile(id, suffix, r); err != nil {
+		logs.Error("SaveWorkFile err:", err)
+		workCtrl.abortWithError(m.ERR_CREATE_FILE_FAIL)
+	}
+}
+
+// pruneRevisions 按保留策略清理作品的旧版本，失败只写日志
+func (workCtrl *WorksController) pruneRevisions(workID bson.ObjectId) {
+	keep, before := m.RevisionPolicy()
+	if _, err := workCtrl.revMod.PruneRevisions(workID, keep, before); err != nil {
+		logs.Error("PruneRevisions err:", err)
+	}
+}
+
+// needModelUnit 解析长度单位参数 unit，默认为毫米
+func (workCtrl *WorksController) needModelUnit() stl.Unit {
+	unit, ok := stl.ParseUnit(workCtrl.GetString("unit"))
+	if !ok {
+		workCtrl.abortWithError(m.ERR_MODEL_UNIT)
+	}
+	return unit
+}
+
+// convertModel 把上传的format格式模型转换为以毫米为单位的二进制STL
+func (workCtrl *WorksController) convertModel(data []byte, format string) []byte {
+	if stl.Extension(format) == "" {
+		workCtrl.abortWithError(m.ERR_MODEL_FORMAT)
+	}
+	content, err := m.ConvertWorkModel(data, format, workCtrl.needModelUnit())
+	if err != nil {
+		logs.Error("ConvertWorkModel err:", err)
+		workCtrl.abortWithError(m.ERR_MODEL_INVALID)
+	}
+	return content
+}
+
+// refreshStorage 重新统计作品文件占用的空间，失败只写日志
+func (workCtrl *WorksController) refreshStorage(id bson.ObjectId) {
+	if _, err := workCtrl.quotaMod.UpdateWorkStorage(id); err != nil {
+		logs.Error("UpdateWorkStorage err:", err)
+	}
+}
+
+// trackPendingFiles 作品还没有保存作品信息时，上传的文件计入上传者的配额
+func (workCtrl *WorksController) trackPendingFiles(id bson.ObjectId, userID string) {
+	if !bson.IsObjectIdHex(userID) {
+		return
+	}
+	if err := workCtrl.quotaMod.TrackPendingWorkFiles(id, bson.ObjectIdHex(userID)); err != nil {
+		logs.Error("TrackPendingWorkFiles err:", err)
+	}
+}
+
+// refreshThumbnail 重新渲染STL作品的预览图，作品没有封面时作为封面
+func (workCtrl *WorksController) refreshThumbnail(id string) {
+	if !bson.IsObjectIdHex(id) {
+		return
+	}
+	thumbnail, err := m.RenderWorkThumbnail(id)
+	if err != nil {
+		logs.Error("RenderWorkThumbnail err:", err)
+		return
+	}
+	if err := workCtrl.workMod.SetDefaultPicture(bson.ObjectIdHex(id), thumbnail); err != nil {
+		logs.Error("SetDefaultPicture err:", err)
+	}
+}
+
+// refreshPrintReport 重新检查STL作品的几何形状并估算打印时间，失败不影响作品保存
+func (workCtrl *WorksController) refreshPrintReport(id string) {
+	if !bson.IsObjectIdHex(id) {
+		return
+	}
+	if _, err := workCtrl.printMod.UpdatePrintReport(bson.ObjectIdHex(id)); err != nil {
+		logs.Error("UpdatePrintReport err:", err)
+	}
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"maiyajia.com/controllers"
+	m "maiyajia.com/models"
 	_ "maiyajia.com/routers"
 	"maiyajia.com/services/daemon"
 	"maiyajia.com/services/initialize"
@@ -50,6 +52,15 @@ func main() {
 		AllowCredentials: true,
 	}))
 
+	// 课程音视频只能通过媒体接口播放
+	beego.InsertFilter("/asset/course/*", beego.BeforeStatic, controllers.StaticMediaFilter)
+
+	// 按素材拆分存储的Scratch作品在访问时组装
+	beego.InsertFilter("/asset/works/*", beego.BeforeStatic, controllers.WorkFileFilter)
+
+	// 使用S3存储时静态文件从对象存储发送
+	beego.InsertFilter("/asset/*", beego.BeforeStatic, controllers.AssetFilter)
+
 	// 注册错误处理函数
 	beego.ErrorController(&controllers.ErrorController{})
 
@@ -76,6 +87,11 @@ func initSystem() {
 		os.Exit(0)
 	}
 	beego.Informational("Mongodb startup successfully")
+	// 存储后端配置有误时不能启动，否则作品文件会写到错误的位置
+	if err := m.CheckAssets(); err != nil {
+		beego.Error("storage config err:", err)
+		os.Exit(1)
+	}
 	//系统安装
 	flag := install()
 	// 临时记录程序运行的进程ID
@@ -87,6 +103,32 @@ func initSystem() {
 	if isBroadcast {
 		daemon.Broadcast()
 	}
+	// 建立全文检索索引
+	daemon.StartSearchIndex()
+	// 班级作业截止提醒
+	daemon.StartAssignmentReminder()
+	// 考试到时自动交卷
+	daemon.StartExamAutoSubmit()
+	// 课时作品相似度检查
+	daemon.StartSimilarityCheck()
+	// 作品历史版本清理
+	daemon.StartRevisionPrune()
+	// 补充Scratch作品的元数据
+	daemon.StartScratchMetadata()
+	// 检查STL作品是否适合打印
+	daemon.StartPrintCheck()
+	// 3D打印队列
+	daemon.StartPrintQueue()
+	// 统计作品的被改编数
+	daemon.StartRemixCount()
+	// 统计作品文件占用的存储空间
+	daemon.StartStorageCount()
+	// 删除上传后没有保存作品信息的作品文件
+	daemon.StartPendingFileCleanup()
+	// 清理过期的分块上传
+	daemon.StartUploadCleanup()
+	// 作品文件导入内容寻址存储，回收不再引用的文件
+	daemon.StartBlobCollect()
 }
 
 // 系统安装
@@ -106,6 +148,32 @@ func install() bool {
 			os.Exit(0)
 		}
 	}
+	// 离线课程包导入导出、存储迁移命令
+	for i, v := range args {
+		switch v {
+		case "-import-course":
+			if i+1 >= len(args) {
+				beego.Error("Usage: -import-course <课程包文件或目录>")
+				os.Exit(1)
+			}
+			importCoursePackages(args[i+1])
+			os.Exit(0)
+		case "-export-course":
+			if i+2 >= len(args) {
+				beego.Error("Usage: -export-course <课程ID> <输出文件>")
+				os.Exit(1)
+			}
+			exportCoursePackage(args[i+1], args[i+2])
+			os.Exit(0)
+		case "-migrate-storage":
+			if i+2 >= len(args) {
+				beego.Error("Usage: -migrate-storage <源存储(local|s3)> <目标存储(local|s3)>")
+				os.Exit(1)
+			}
+			migrateStorage(args[i+1], args[i+2])
+			os.Exit(0)
+		}
+	}
 	logs.Info("flag:", flag)
 	return flag
 
@@ -142,3 +210,69 @@ func installTools() {
 	toolsCtrl.Finish()
 	logs.Info("install tools complete")
 }
+
+// importCoursePackages 导入离线课程包，src 可以是单个课程包或包含课程包(*.zip)的目录（如U盘）
+func importCoursePackages(src string) {
+	var packages []string
+	info, err := os.Stat(src)
+	if err != nil {
+		beego.Error("Can not read course package: ", err)
+		return
+	}
+	if info.IsDir() {
+		packages, _ = filepath.Glob(filepath.Join(src, "*.zip"))
+	} else {
+		packages = append(packages, src)
+	}
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		beego.Error("Can not connect to database: ", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	courseMod := m.CourseModels{MgoSession: dbclient}
+	for _, pkg := range packages {
+		course, err := courseMod.ImportCoursePackage(pkg)
+		if err != nil {
+			beego.Error("Import course package fail: ", pkg, err)
+			continue
+		}
+		beego.Informational("Course imported: ", course.Name, course.Version)
+	}
+}
+
+// exportCoursePackage 把已安装的课程导出为离线课程包
+func exportCoursePackage(courseID, dest string) {
+	fp, err := os.Create(dest)
+	if err != nil {
+		beego.Error("Can not create course package: ", err)
+		return
+	}
+	defer fp.Close()
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		beego.Error("Can not connect to database: ", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	courseMod := m.CourseModels{MgoSession: dbclient}
+	if err := courseMod.ExportCoursePackage(courseID, fp); err != nil {
+		beego.Error("Export course package fail: ", err)
+		return
+	}
+	beego.Informational("Course exported: ", dest)
+}
+
+// migrateStorage 把作品、头像和课程等文件从一个存储后端复制到另一个，复制完成后修改 storage 配置切换后端
+func migrateStorage(from, to string) {
+	if from == to {
+		beego.Error("Source and target storage are the same: ", from)
+		return
+	}
+	copied, err := m.MigrateStorage(from, to)
+	if err != nil {
+		beego.Error("Migrate storage fail: ", err, ", copied: ", copied)
+		return
+	}
+	beego.Informational("Storage migrated, copied files: ", copied)
+}
@@ -0,0 +1,350 @@
+// @Title 班级作业模型
+// @Description 老师把课程中的课时布置给班级，设定开放和截止时间；完成情况根据学习进度(courseprogress)计算
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+)
+
+type AssignmentModels struct {
+	MgoSession *mongo.MgoClient
+	CourseMod  CourseModels
+	MessageMod MessageModels
+}
+
+// 学生作业状态
+const (
+	AssignmentNotOpen  = "notopen"  //未到开放时间
+	AssignmentOpen     = "open"     //进行中
+	AssignmentOverdue  = "overdue"  //已过截止时间且未完成
+	AssignmentFinished = "finished" //已完成
+)
+
+// Assignment 班级作业：在开放时间到截止时间之间学完指定课时
+type Assignment struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	ClassCode  string        `bson:"classCode" json:"classCode"`
+	CourseID   bson.ObjectId `bson:"courseID" json:"courseID"`
+	LessonID   bson.ObjectId `bson:"lessonID" json:"lessonID"`
+	CourseName string        `bson:"courseName" json:"courseName"`
+	LessonName string        `bson:"lessonName" json:"lessonName"`
+	OpenTime   time.Time     `bson:"openTime" json:"openTime"` //开放时间
+	DueTime    time.Time     `bson:"dueTime" json:"dueTime"`   //截止时间
+	Creator    bson.ObjectId `bson:"creator" json:"creator"`
+	CreateTime time.Time     `bson:"createTime" json:"createTime"`
+	Reminded   bool          `bson:"reminded" json:"-"` //是否已发送截止提醒
+}
+
+// StudentAssignment 学生的作业及完成状态
+type StudentAssignment struct {
+	Assignment
+	Status   string `json:"status"`
+	Finished int    `json:"finished"` //已完成的学习资源数
+	Total    int    `json:"total"`    //课时的学习资源数
+}
+
+// AssignmentCell 完成情况矩阵中一个学生一项作业的完成情况
+type AssignmentCell struct {
+	AssignmentID bson.ObjectId `json:"assignmentID"`
+	Status       string        `json:"status"`
+	Finished     int           `json:"finished"`
+	Total        int           `json:"total"`
+	GradeStatus  string        `json:"gradeStatus,omitempty"` //作品批改状态，未批改时为空
+	Score        float64       `json:"score"`
+	FullScore    float64       `json:"fullScore"`
+}
+
+// AssignmentRow 完成情况矩阵中一个学生的所有作业
+type AssignmentRow struct {
+	UserID   bson.ObjectId    `json:"userID"`
+	Username string           `json:"username"`
+	Realname string           `json:"realname"`
+	Cells    []AssignmentCell `json:"cells"`
+}
+
+// AssignmentMatrix 班级作业完成情况矩阵，行为学生，列为作业
+type AssignmentMatrix struct {
+	Assignments []Assignment    `json:"assignments"`
+	Students    []AssignmentRow `json:"students"`
+}
+
+// CreateAssignment 布置作业
+func (assignMod *AssignmentModels) CreateAssignment(assignment *Assignment) error {
+	assignment.ID = bson.NewObjectId()
+	assignment.CreateTime = time.Now()
+	f := func(col *mgo.Collection) error {
+		return col.Insert(assignment)
+	}
+	return assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "assignment", f)
+}
+
+// UpdateAssignmentTime 修改作业的开放和截止时间，截止时间修改后重新发送提醒
+func (assignMod *AssignmentModels) UpdateAssignmentTime(id bson.ObjectId, openTime, dueTime time.Time) error {
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(id, bson.M{"$set": bson.M{"openTime": openTime, "dueTime": dueTime, "reminded": false}})
+	}
+	return assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "assignment", f)
+}
+
+// DeleteAssignment 删除作业
+func (assignMod *AssignmentModels) DeleteAssignment(id bson.ObjectId) error {
+	f := func(col *mgo.Collection) error {
+		return col.RemoveId(id)
+	}
+	return assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "assignment", f)
+}
+
+// FindAssignment 查询作业
+func (assignMod *AssignmentModels) FindAssignment(id bson.ObjectId) (Assignment, error) {
+	var assignment Assignment
+	f := func(col *mgo.Collection) error {
+		return col.FindId(id).One(&assignment)
+	}
+	err := assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "assignment", f)
+	return assignment, err
+}
+
+// ClassAssignments 查询班级的所有作业，按截止时间排序
+func (assignMod *AssignmentModels) ClassAssignments(classCode string) ([]Assignment, error) {
+	assignments := []Assignment{}
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"classCode": classCode}).Sort("dueTime").All(&assignments)
+	}
+	err := assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "assignment", f)
+	return assignments, err
+}
+
+// StudentAssignments 查询学生所在班级的作业，返回待完成（未开放和进行中）和已逾期的作业
+func (assignMod *AssignmentModels) StudentAssignments(uid bson.ObjectId, now time.Time) (upcoming, overdue []StudentAssignment, err error) {
+	upcoming, overdue = []StudentAssignment{}, []StudentAssignment{}
+	var classes []Class
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"students.userID": uid}).Select(bson.M{"code": 1}).All(&classes)
+	}
+	if err = assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "classes", f); err != nil {
+		return
+	}
+	codes := make([]string, 0, len(classes))
+	for _, class := range classes {
+		codes = append(codes, class.Code)
+	}
+	var assignments []Assignment
+	ff := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"classCode": bson.M{"$in": codes}}).Sort("dueTime").All(&assignments)
+	}
+	if err = assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "assignment", ff); err != nil {
+		return
+	}
+	lessons := make(map[bson.ObjectId]*Lession)
+	progress := make(map[bson.ObjectId]map[bson.ObjectId]bool)
+	for _, a := range assignments {
+		lesson, e := assignMod.lesson(lessons, a)
+		if e != nil {
+			err = e
+			return
+		}
+		finished, ok := progress[a.CourseID]
+		if !ok {
+			all, e := assignMod.finishedItems(a.CourseID, []bson.ObjectId{uid})
+			if e != nil {
+				err = e
+				return
+			}
+			finished = all[uid]
+			progress[a.CourseID] = finished
+		}
+		sa := StudentAssignment{Assignment: a}
+		sa.Status, sa.Finished, sa.Total = AssignmentStatus(a, lesson, finished, now)
+		switch sa.Status {
+		case AssignmentOverdue:
+			overdue = append(overdue, sa)
+		case AssignmentNotOpen, AssignmentOpen:
+			upcoming = append(upcoming, sa)
+		}
+	}
+	return
+}
+
+// AssignmentMatrix 查询班级作业完成情况矩阵，作品已批改的作业带上得分
+func (assignMod *AssignmentModels) AssignmentMatrix(class Class, now time.Time) (AssignmentMatrix, error) {
+	matrix := AssignmentMatrix{Students: []AssignmentRow{}}
+	assignments, err := assignMod.ClassAssignments(class.Code)
+	if err != nil {
+		return matrix, err
+	}
+	matrix.Assignments = assignments
+
+	uids := make([]bson.ObjectId, 0, len(class.Students))
+	for _, s := range class.Students {
+		uids = append(uids, s.UserID)
+	}
+	var users []User
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": bson.M{"$in": uids}}).Select(bson.M{"username": 1, "realname": 1}).Sort("username").All(&users)
+	}
+	if err := assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "users", f); err != nil {
+		return matrix, err
+	}
+
+	gradeMod := GradeModels{MgoSession: assignMod.MgoSession}
+	grades, err := gradeMod.ClassGrades(class.Code)
+	if err != nil {
+		return matrix, err
+	}
+
+	lessons := make(map[bson.ObjectId]*Lession)
+	progress := make(map[bson.ObjectId]map[bson.ObjectId]map[bson.ObjectId]bool)
+	for _, a := range assignments {
+		if _, err := assignMod.lesson(lessons, a); err != nil {
+			return matrix, err
+		}
+		if _, ok := progress[a.CourseID]; !ok {
+			finished, err := assignMod.finishedItems(a.CourseID, uids)
+			if err != nil {
+				return matrix, err
+			}
+			progress[a.CourseID] = finished
+		}
+	}
+	for _, user := range users {
+		row := AssignmentRow{UserID: user.ID, Username: user.Username, Realname: user.Realname, Cells: []AssignmentCell{}}
+		for _, a := range assignments {
+			cell := AssignmentCell{AssignmentID: a.ID}
+			cell.Status, cell.Finished, cell.Total = AssignmentStatus(a, lessons[a.LessonID], progress[a.CourseID][user.ID], now)
+			if grade, ok := grades[user.ID][a.LessonID]; ok {
+				cell.GradeStatus, cell.Score, cell.FullScore = grade.Status, grade.Score, grade.FullScore
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		matrix.Students = append(matrix.Students, row)
+	}
+	return matrix, nil
+}
+
+// SendDueReminders 给在within时间内截止且未完成作业的学生发送提醒消息，每项作业只提醒一次
+func (assignMod *AssignmentModels) SendDueReminders(now time.Time, within time.Duration) error {
+	var assignments []Assignment
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"reminded": bson.M{"$ne": true}, "openTime": bson.M{"$lte": now}, "dueTime": bson.M{"$gt": now, "$lte": now.Add(within)}}
+		return col.Find(query).All(&assignments)
+	}
+	if err := assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "assignment", f); err != nil {
+		return err
+	}
+	lessons := make(map[bson.ObjectId]*Lession)
+	for _, a := range assignments {
+		if err := assignMod.remind(lessons, a, now); err != nil {
+			logs.Error("assignment reminder:", a.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// AssignmentStatus 根据课时学习资源的完成情况计算作业状态，返回状态、已完成数和总数。
+// 没有学习资源的课时不算已完成，按开放和截止时间计算
+func AssignmentStatus(a Assignment, lesson *Lession, finished map[bson.ObjectId]bool, now time.Time) (status string, done, total int) {
+	if lesson != nil {
+		total = len(lesson.Contents)
+		for _, content := range lesson.Contents {
+			if finished[content.ID] {
+				done++
+			}
+		}
+	}
+	switch {
+	case lesson != nil && total > 0 && done == total:
+		status = AssignmentFinished
+	case now.Before(a.OpenTime):
+		status = AssignmentNotOpen
+	case now.After(a.DueTime):
+		status = AssignmentOverdue
+	default:
+		status = AssignmentOpen
+	}
+	return
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// remind 发送一项作业的截止提醒并标记为已提醒
+func (assignMod *AssignmentModels) remind(lessons map[bson.ObjectId]*Lession, a Assignment, now time.Time) error {
+	lesson, err := assignMod.lesson(lessons, a)
+	if err != nil {
+		return err
+	}
+	var class Class
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"code": a.ClassCode}).One(&class)
+	}
+	if err := assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "classes", f); err != nil {
+		return err
+	}
+	uids := make([]bson.ObjectId, 0, len(class.Students))
+	for _, s := range class.Students {
+		uids = append(uids, s.UserID)
+	}
+	progress, err := assignMod.finishedItems(a.CourseID, uids)
+	if err != nil {
+		return err
+	}
+	var subscribers []bson.ObjectId
+	for _, uid := range uids {
+		if status, _, _ := AssignmentStatus(a, lesson, progress[uid], now); status == AssignmentOpen {
+			subscribers = append(subscribers, uid)
+		}
+	}
+	if len(subscribers) > 0 {
+		title := "作业即将截止"
+		content := fmt.Sprintf("《%s》的课时“%s”将于%s截止，请尽快完成学习。", a.CourseName, a.LessonName, a.DueTime.Local().Format("2006-01-02 15:04"))
+		if err := assignMod.MessageMod.PublishSystemMessage(title, content, class.Name, class.Logo, subscribers); err != nil {
+			return err
+		}
+	}
+	ff := func(col *mgo.Collection) error {
+		return col.UpdateId(a.ID, bson.M{"$set": bson.M{"reminded": true}})
+	}
+	return assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "assignment", ff)
+}
+
+// lesson 查询作业对应的课时，结果缓存在lessons中，课时已被删除时返回nil
+func (assignMod *AssignmentModels) lesson(lessons map[bson.ObjectId]*Lession, a Assignment) (*Lession, error) {
+	if lesson, ok := lessons[a.LessonID]; ok {
+		return lesson, nil
+	}
+	lesson, err := assignMod.CourseMod.GetLessonById(a.CourseID.Hex(), a.LessonID.Hex())
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	lessons[a.LessonID] = lesson
+	return lesson, nil
+}
+
+// finishedItems 查询学生们在课程下已完成的学习资源
+func (assignMod *AssignmentModels) finishedItems(courseID bson.ObjectId, uids []bson.ObjectId) (map[bson.ObjectId]map[bson.ObjectId]bool, error) {
+	var progress []LessionProgress
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"courseID": courseID, "userID": bson.M{"$in": uids}}).All(&progress)
+	}
+	if err := assignMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "courseprogress", f); err != nil {
+		return nil, err
+	}
+	result := make(map[bson.ObjectId]map[bson.ObjectId]bool)
+	for _, p := range progress {
+		finished := make(map[bson.ObjectId]bool)
+		for _, id := range p.FinishItems {
+			finished[id] = true
+		}
+		result[p.UserID] = finished
+	}
+	return result, nil
+}
@@ -0,0 +1,598 @@
+// @Title 作品文件存储模型
+// @Description 作品文件按内容(SHA-256)保存，内容相同的文件只保存一份：复制和改编作品、记录版本只增加引用计数；
+// Scratch作品拆分为造型、声音等文件分别保存。作品和版本引用的文件都释放后，由回收任务删除
+
+package models
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/blob"
+	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/storage"
+)
+
+// 存储的文件类型
+const (
+	BlobFile = "file" //普通文件
+	BlobSB3  = "sb3"  //Scratch作品的清单，引用拆分出来的文件
+)
+
+var (
+	blobOnce  sync.Once
+	blobStore *blob.Store
+
+	blobCacheOnce sync.Once
+	blobCache     *blob.Cache
+)
+
+type BlobModels struct {
+	MgoSession *mongo.MgoClient
+}
+
+// Blob 存储中的一个文件，Refs 为作品文件、版本和清单的引用数
+type Blob struct {
+	Sum         string    `bson:"_id" json:"sum"`
+	Kind        string    `bson:"kind" json:"kind"`
+	Size        int64     `bson:"size" json:"size"`
+	Refs        int       `bson:"refs" json:"refs"`
+	Children    []string  `bson:"children,omitempty" json:"children,omitempty"`
+	CreateTime  time.Time `bson:"createTime" json:"createTime"`
+	ReleaseTime time.Time `bson:"releaseTime,omitempty" json:"releaseTime,omitempty"` //引用数变为0的时间
+}
+
+// WorkFile 作品文件 asset/works/<WorkID>.<Suffix> 对应的存储文件，Size 为作品文件本身的大小
+type WorkFile struct {
+	ID         string    `bson:"_id" json:"id"`
+	WorkID     string    `bson:"workID" json:"workID"`
+	Suffix     string    `bson:"suffix" json:"suffix"`
+	Blob       string    `bson:"blob" json:"blob"`
+	Size       int64     `bson:"size" json:"size"`
+	UpdateTime time.Time `bson:"updateTime" json:"updateTime"`
+}
+
+// PutFile 保存文件内容，sb3文件拆分保存。返回的文件带有一个引用，由调用方记录或释放
+func (blobMod *BlobModels) PutFile(r io.Reader, suffix string) (string, int64, error) {
+	t, err := blobs().Temp(r)
+	if err != nil {
+		return "", 0, err
+	}
+	return blobMod.putTemp(t, suffix)
+}
+
+// RetainBlob 增加已有文件的引用，文件已被回收时返回 mgo.ErrNotFound
+func (blobMod *BlobModels) RetainBlob(sum string) (Blob, error) {
+	var b Blob
+	f := func(col *mgo.Collection) error {
+		change := mgo.Change{Update: bson.M{"$inc": bson.M{"refs": 1}, "$unset": bson.M{"releaseTime": ""}}, ReturnNew: true}
+		_, err := col.FindId(sum).Apply(change, &b)
+		return err
+	}
+	err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "blob", f)
+	return b, err
+}
+
+// ReleaseBlob 减少文件的引用，引用数变为0后等待回收
+func (blobMod *BlobModels) ReleaseBlob(sum string) error {
+	var b Blob
+	f := func(col *mgo.Collection) error {
+		change := mgo.Change{Update: bson.M{"$inc": bson.M{"refs": -1}}, ReturnNew: true}
+		if _, err := col.FindId(sum).Apply(change, &b); err != nil {
+			return err
+		}
+		if b.Refs > 0 {
+			return nil
+		}
+		return col.Update(bson.M{"_id": sum, "refs": bson.M{"$lte": 0}}, bson.M{"$set": bson.M{"releaseTime": time.Now()}})
+	}
+	err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "blob", f)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// ReadBlob 读取文件内容，sb3清单重新打包为sb3
+func (blobMod *BlobModels) ReadBlob(sum string) ([]byte, error) {
+	var b Blob
+	f := func(col *mgo.Collection) error {
+		return col.FindId(sum).Select(bson.M{"kind": 1}).One(&b)
+	}
+	if err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "blob", f); err != nil {
+		return nil, err
+	}
+	file, err := blobs().Open(sum)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil || b.Kind != BlobSB3 {
+		return data, err
+	}
+	manifest, err := blob.ParseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	open := func(sum string) (io.ReadCloser, error) {
+		return blobs().Open(sum)
+	}
+	if err := blob.WriteSB3(&buf, manifest, open); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveWorkFile 保存作品文件，内容与已有文件相同时只增加引用
+func (blobMod *BlobModels) SaveWorkFile(workID, suffix string, r io.Reader) (int64, error) {
+	sum, size, err := blobMod.PutFile(r, suffix)
+	if err != nil {
+		return 0, err
+	}
+	return size, blobMod.setWorkFile(workID, suffix, sum, size)
+}
+
+// MoveWorkFile 把已经写好的文件filename（如上传完成的文件）移动到存储中作为作品文件
+func (blobMod *BlobModels) MoveWorkFile(workID, suffix, filename string) (int64, error) {
+	t, err := blob.FileTemp(filename)
+	if err != nil {
+		return 0, err
+	}
+	sum, size, err := blobMod.putTemp(t, suffix)
+	if err != nil {
+		return 0, err
+	}
+	return size, blobMod.setWorkFile(workID, suffix, sum, size)
+}
+
+// SetWorkFile 把作品文件指向已有的文件，用于恢复历史版本
+func (blobMod *BlobModels) SetWorkFile(workID, suffix, sum string, size int64) error {
+	if _, err := blobMod.RetainBlob(sum); err != nil {
+		return err
+	}
+	return blobMod.setWorkFile(workID, suffix, sum, size)
+}
+
+// CopyWorkFiles 复制作品的所有文件，只增加引用，不复制内容
+func (blobMod *BlobModels) CopyWorkFiles(srcID, dstID string) error {
+	if err := blobMod.importWorkFiles(srcID); err != nil {
+		return err
+	}
+	files, err := blobMod.WorkFiles(srcID)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := blobMod.SetWorkFile(dstID, file.Suffix, file.Blob, file.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveWorkFiles 删除作品的所有文件，释放引用
+func (blobMod *BlobModels) RemoveWorkFiles(workID string) error {
+	files, err := blobMod.WorkFiles(workID)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		f := func(col *mgo.Collection) error {
+			return col.RemoveId(file.ID)
+		}
+		if err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workfile", f); err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+		if err := blobMod.ReleaseBlob(file.Blob); err != nil {
+			return err
+		}
+	}
+	for _, suffix := range workFileSuffixes {
+		Assets().Remove(workFileName(workID, suffix))
+	}
+	return nil
+}
+
+// WorkFiles 作品的所有文件记录
+func (blobMod *BlobModels) WorkFiles(workID string) ([]WorkFile, error) {
+	var files []WorkFile
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"workID": workID}).All(&files)
+	}
+	err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workfile", f)
+	return files, err
+}
+
+// StatWorkFile 查询作品文件的大小和修改时间，还没有导入存储的作品文件直接读取文件信息（Blob 为空）
+func (blobMod *BlobModels) StatWorkFile(workID, suffix string) (WorkFile, error) {
+	var file WorkFile
+	f := func(col *mgo.Collection) error {
+		return col.FindId(workID + "." + suffix).One(&file)
+	}
+	err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workfile", f)
+	if err != mgo.ErrNotFound {
+		return file, err
+	}
+	info, err := Assets().Stat(workFileName(workID, suffix))
+	if err != nil {
+		return file, err
+	}
+	return WorkFile{ID: workID + "." + suffix, WorkID: workID, Suffix: suffix, Size: info.Size, UpdateTime: info.ModTime}, nil
+}
+
+// ReadWorkFile 读取作品文件的内容
+func (blobMod *BlobModels) ReadWorkFile(workID, suffix string) ([]byte, error) {
+	file, err := blobMod.StatWorkFile(workID, suffix)
+	if err != nil {
+		return nil, err
+	}
+	if file.Blob == "" {
+		return storage.ReadFile(Assets(), workFileName(workID, suffix))
+	}
+	return blobMod.ReadBlob(file.Blob)
+}
+
+// ReadWorkFileCached 读取作品文件的信息和内容，按内容缓存最近读取的文件，用于通过静态目录频繁访问的sb3
+func (blobMod *BlobModels) ReadWorkFileCached(workID, suffix string) (WorkFile, []byte, error) {
+	file, err := blobMod.StatWorkFile(workID, suffix)
+	if err != nil {
+		return file, nil, err
+	}
+	if file.Blob == "" {
+		data, err := storage.ReadFile(Assets(), workFileName(workID, suffix))
+		return file, data, err
+	}
+	if data, ok := blobsCache().Get(file.Blob); ok {
+		return file, data, nil
+	}
+	data, err := blobMod.ReadBlob(file.Blob)
+	if err != nil {
+		return file, nil, err
+	}
+	blobsCache().Put(file.Blob, data)
+	return file, data, nil
+}
+
+// WorkFileSize 作品文件的大小，文件不存在时为0
+func (blobMod *BlobModels) WorkFileSize(workID, suffix string) int64 {
+	file, err := blobMod.StatWorkFile(workID, suffix)
+	if err != nil {
+		return 0
+	}
+	return file.Size
+}
+
+// WorkFilesSize 作品所有文件的大小，按作品文件本身计算，与其他作品共享的内容也计入
+func (blobMod *BlobModels) WorkFilesSize(workID string) int64 {
+	var size int64
+	for _, suffix := range workFileSuffixes {
+		size += blobMod.WorkFileSize(workID, suffix)
+	}
+	return size
+}
+
+// ImportWorkFiles 把还没有导入存储的作品文件导入存储，返回导入的文件数
+func (blobMod *BlobModels) ImportWorkFiles() (int, error) {
+	var names []string
+	err := Assets().Walk(path.Join("asset", "works"), func(info storage.Info) error {
+		names = append(names, path.Base(info.Name))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	for _, name := range names {
+		ext := path.Ext(name)
+		workID := strings.TrimSuffix(name, ext)
+		if !bson.IsObjectIdHex(workID) || !isWorkFileSuffix(strings.TrimPrefix(ext, ".")) {
+			continue
+		}
+		n, err := blobMod.importWorkFile(workID, strings.TrimPrefix(ext, "."))
+		if err != nil {
+			return imported, err
+		}
+		imported += n
+	}
+	return imported, nil
+}
+
+// CollectBlobs 删除引用数为0超过grace的文件，以及写入中断留下的文件，返回删除的文件数。
+// 释放后等待一段时间再删除，避免与正在增加引用的保存操作冲突
+func (blobMod *BlobModels) CollectBlobs(grace time.Duration) (int, error) {
+	before := time.Now().Add(-grace)
+	removed := 0
+	for {
+		var released []Blob
+		f := func(col *mgo.Collection) error {
+			query := bson.M{"refs": bson.M{"$lte": 0}, "releaseTime": bson.M{"$lt": before}}
+			return col.Find(query).Limit(1000).All(&released)
+		}
+		if err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "blob", f); err != nil {
+			return removed, err
+		}
+		if len(released) == 0 {
+			break
+		}
+		for _, b := range released {
+			ff := func(col *mgo.Collection) error {
+				return col.Remove(bson.M{"_id": b.Sum, "refs": bson.M{"$lte": 0}, "releaseTime": bson.M{"$lt": before}})
+			}
+			err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "blob", ff)
+			if err == mgo.ErrNotFound {
+				// 回收前又被引用
+				continue
+			}
+			if err != nil {
+				return removed, err
+			}
+			if err := blobs().Remove(b.Sum); err != nil {
+				return removed, err
+			}
+			removed++
+			for _, child := range b.Children {
+				if err := blobMod.ReleaseBlob(child); err != nil {
+					return removed, err
+				}
+			}
+		}
+	}
+	// 清单释放的文件在下一次回收时删除；没有记录的文件是保存时中断留下的
+	known := make(map[string]bool)
+	var all []Blob
+	f := func(col *mgo.Collection) error {
+		return col.Find(nil).Select(bson.M{"_id": 1}).All(&all)
+	}
+	if err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "blob", f); err != nil {
+		return removed, err
+	}
+	for _, b := range all {
+		known[b.Sum] = true
+	}
+	err := blobs().Walk(func(sum string, info storage.Info) error {
+		if known[sum] || !info.ModTime.Before(before) {
+			return nil
+		}
+		var n int
+		fc := func(col *mgo.Collection) error {
+			var err error
+			n, err = col.FindId(sum).Count()
+			return err
+		}
+		if err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "blob", fc); err != nil || n > 0 {
+			return err
+		}
+		removed++
+		return blobs().Remove(sum)
+	})
+	if err != nil {
+		return removed, err
+	}
+	return removed, blobs().CleanTemp(before)
+}
+
+// BlobGracePeriod 文件释放后保留的时间 blob_gc_hours，默认24小时
+func BlobGracePeriod() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("blob_gc_hours", 24)) * time.Hour
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// blobs 存储后端中的目录 blob_dir，写入前的临时文件放在本地的 tmp/blobs
+func blobs() *blob.Store {
+	blobOnce.Do(func() {
+		blobStore = blob.NewStore(Assets(), blobDir(), path.Join(beego.AppPath, "tmp", "blobs"))
+	})
+	return blobStore
+}
+
+// blobsCache 最近读取的作品文件，最多缓存 work_file_cache_mb（默认64）MB
+func blobsCache() *blob.Cache {
+	blobCacheOnce.Do(func() {
+		blobCache = blob.NewCache(beego.AppConfig.DefaultInt64("work_file_cache_mb", 64) << 20)
+	})
+	return blobCache
+}
+
+// blobDir 存储目录 blob_dir，默认为 blobs，不能放在 asset 下以免被直接访问
+func blobDir() string {
+	return beego.AppConfig.DefaultString("blob_dir", "blobs")
+}
+
+// workFileName 作品文件在存储后端中的文件名
+func workFileName(workID, suffix string) string {
+	return path.Join("asset", "works", workID+"."+suffix)
+}
+
+func isWorkFileSuffix(suffix string) bool {
+	for _, s := range workFileSuffixes {
+		if s == suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// putTemp 先增加引用再保存文件，回收任务不会删除正在保存的文件。sb3文件拆分保存，不是有效的sb3时按普通文件保存
+func (blobMod *BlobModels) putTemp(t *blob.Temp, suffix string) (string, int64, error) {
+	if suffix == "sb3" {
+		sum, err := blobMod.putSB3(t)
+		if err == nil {
+			t.Discard()
+			return sum, t.Size, nil
+		}
+		if err != errNotSB3 {
+			t.Discard()
+			return "", 0, err
+		}
+	}
+	if _, err := blobMod.retainNew(t.Sum, BlobFile, t.Size, nil); err != nil {
+		t.Discard()
+		return "", 0, err
+	}
+	if err := blobs().Commit(t); err != nil {
+		blobMod.ReleaseBlob(t.Sum)
+		return "", 0, err
+	}
+	return t.Sum, t.Size, nil
+}
+
+var errNotSB3 = errors.New("not a sb3 file")
+
+// putSB3 把sb3中的文件分别保存，再保存引用这些文件的清单
+func (blobMod *BlobModels) putSB3(t *blob.Temp) (string, error) {
+	file, err := os.Open(t.Name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	// 每次保存都增加一个引用，清单只持有每个文件的一个引用，多余的最后释放
+	counts := make(map[string]int)
+	put := func(r io.Reader) (string, int64, error) {
+		et, err := blobs().Temp(r)
+		if err != nil {
+			return "", 0, err
+		}
+		if _, err := blobMod.retainNew(et.Sum, BlobFile, et.Size, nil); err != nil {
+			et.Discard()
+			return "", 0, err
+		}
+		counts[et.Sum]++
+		return et.Sum, et.Size, blobs().Commit(et)
+	}
+	release := func(keep int) {
+		for sum, n := range counts {
+			for i := keep; i < n; i++ {
+				blobMod.ReleaseBlob(sum)
+			}
+		}
+	}
+	manifest, err := blob.SplitSB3(file, t.Size, put)
+	if err != nil {
+		release(0)
+		return "", errNotSB3
+	}
+	mt, err := blobs().Temp(bytes.NewReader(manifest.Encode()))
+	if err != nil {
+		release(0)
+		return "", err
+	}
+	created, err := blobMod.retainNew(mt.Sum, BlobSB3, mt.Size, manifest.Sums())
+	if err != nil {
+		mt.Discard()
+		release(0)
+		return "", err
+	}
+	if created {
+		release(1)
+	} else {
+		// 相同的清单已经引用了这些文件
+		release(0)
+	}
+	if err := blobs().Commit(mt); err != nil {
+		blobMod.ReleaseBlob(mt.Sum)
+		return "", err
+	}
+	return mt.Sum, nil
+}
+
+// retainNew 增加文件的引用，文件还没有记录时创建记录，返回是否新建
+func (blobMod *BlobModels) retainNew(sum, kind string, size int64, children []string) (bool, error) {
+	var created bool
+	f := func(col *mgo.Collection) error {
+		change := mgo.Change{
+			Update: bson.M{
+				"$inc":         bson.M{"refs": 1},
+				"$unset":       bson.M{"releaseTime": ""},
+				"$setOnInsert": bson.M{"kind": kind, "size": size, "children": children, "createTime": time.Now()},
+			},
+			Upsert: true,
+		}
+		info, err := col.FindId(sum).Apply(change, nil)
+		if err != nil {
+			return err
+		}
+		created = info.UpsertedId != nil
+		return nil
+	}
+	err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "blob", f)
+	return created, err
+}
+
+// setWorkFile 把作品文件指向sum（调用方已持有一个引用），并释放原来的文件。sb3清单不生成作品目录中的文件，通过 WorkFileFilter 访问
+func (blobMod *BlobModels) setWorkFile(workID, suffix, sum string, size int64) error {
+	if suffix == "sb3" && blobMod.isManifest(sum) {
+		Assets().Remove(workFileName(workID, suffix))
+	} else if err := blobs().Link(sum, workFileName(workID, suffix)); err != nil {
+		blobMod.ReleaseBlob(sum)
+		return err
+	}
+	var old WorkFile
+	f := func(col *mgo.Collection) error {
+		file := WorkFile{ID: workID + "." + suffix, WorkID: workID, Suffix: suffix, Blob: sum, Size: size, UpdateTime: time.Now()}
+		_, err := col.FindId(file.ID).Apply(mgo.Change{Update: file, Upsert: true}, &old)
+		return err
+	}
+	if err := blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workfile", f); err != nil {
+		blobMod.ReleaseBlob(sum)
+		return err
+	}
+	if old.Blob != "" {
+		return blobMod.ReleaseBlob(old.Blob)
+	}
+	return nil
+}
+
+func (blobMod *BlobModels) isManifest(sum string) bool {
+	var b Blob
+	f := func(col *mgo.Collection) error {
+		return col.FindId(sum).Select(bson.M{"kind": 1}).One(&b)
+	}
+	return blobMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "blob", f) == nil && b.Kind == BlobSB3
+}
+
+// importWorkFiles 导入作品还没有导入存储的文件
+func (blobMod *BlobModels) importWorkFiles(workID string) error {
+	for _, suffix := range workFileSuffixes {
+		if _, err := blobMod.importWorkFile(workID, suffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importWorkFile 导入作品目录中的文件，已经导入或文件不存在时返回0
+func (blobMod *BlobModels) importWorkFile(workID, suffix string) (int, error) {
+	file, err := blobMod.StatWorkFile(workID, suffix)
+	if err != nil || file.Blob != "" {
+		return 0, nil
+	}
+	f, err := Assets().Open(workFileName(workID, suffix))
+	if err != nil {
+		return 0, nil
+	}
+	sum, size, err := blobMod.PutFile(f, suffix)
+	// 关闭后才能替换为链接
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+	if err := blobMod.setWorkFile(workID, suffix, sum, size); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
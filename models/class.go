@@ -332,7 +332,7 @@ func (classMod *ClassModels) classCodeBuilder() string {
 func (classMod *ClassModels) classLogoBuilder(creator bson.ObjectId) string {
 	// 获取创建者的头像
 	u, _ := classMod.UserMod.FetchGivenUserInfo(creator, "avatar")
-	creatorAvatar, _ := avatar.ReadAvatarFromFile(u.Avatar)
+	creatorAvatar, _ := readAvatar(u.Avatar)
 
 	//把创建者的头像设置为组合头像中的主要构图
 	var images []image.Image
@@ -355,7 +355,7 @@ func (classMod *ClassModels) classLogoBuilder(creator bson.ObjectId) string {
 	salt := strconv.FormatInt(time.Now().UnixNano(), 10)
 	file := "asset/users/avatar/class_" + salt + ".png"
 
-	if err := avatar.SaveAvatarToFile(image, file); err != nil {
+	if err := saveAvatar(image, file); err != nil {
 		return "asset/users/avatar_default.png"
 	}
 
@@ -0,0 +1,306 @@
+// @Title 作品评论模型
+// @Description 分享作品的评论和回复，支持@提及通知、作者关闭评论、老师和管理员删除；
+// 低年级学生发表或收到的评论需要老师审核后才显示
+
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/astaxie/beego"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+)
+
+type CommentModels struct {
+	MgoSession *mongo.MgoClient
+	MessageMod MessageModels
+}
+
+// 评论状态
+const (
+	CommentPending  = "pending"  //等待老师审核
+	CommentApproved = "approved" //已显示
+	CommentDeleted  = "deleted"  //已删除，保留位置以免回复失去上下文
+)
+
+// 评论的最大字数和一条评论最多通知的用户数
+const (
+	MaxCommentLength = 500
+	maxMentions      = 10
+)
+
+// @提及的用户名，规则与注册时的用户名一致
+var mentionPattern = regexp.MustCompile(`@([a-zA-Z0-9_-]{4,20})`)
+
+// Comment 作品评论。RootID 为所在楼层的第一条评论，ParentID 为回复的评论，楼层的第一条评论两者都为空
+type Comment struct {
+	ID         bson.ObjectId   `bson:"_id" json:"id"`
+	WorkID     bson.ObjectId   `bson:"workID" json:"workID"`
+	RootID     bson.ObjectId   `bson:"rootID,omitempty" json:"rootID,omitempty"`
+	ParentID   bson.ObjectId   `bson:"parentID,omitempty" json:"parentID,omitempty"`
+	ReplyTo    string          `bson:"replyTo,omitempty" json:"replyTo,omitempty"` //被回复者的用户名
+	UserID     bson.ObjectId   `bson:"userID" json:"userID"`
+	Username   string          `bson:"username" json:"username"`
+	Realname   string          `bson:"realname" json:"realname"`
+	Avatar     string          `bson:"avatar" json:"avatar"`
+	Content    string          `bson:"content" json:"content"`
+	Mentions   []bson.ObjectId `bson:"mentions,omitempty" json:"-"`   //@提及的用户
+	Moderators []bson.ObjectId `bson:"moderators,omitempty" json:"-"` //可以审核和删除该评论的老师
+	Status     string          `bson:"status" json:"status"`
+	CreateTime time.Time       `bson:"createTime" json:"createTime"`
+}
+
+// CommentThread 一条楼层评论和它的所有回复
+type CommentThread struct {
+	Comment `bson:",inline"`
+	Replies []Comment `json:"replies"`
+}
+
+// ValidCommentContent 评论内容不能为空，不能超过 MaxCommentLength 个字
+func ValidCommentContent(content string) bool {
+	n := utf8.RuneCountInString(content)
+	return n > 0 && n <= MaxCommentLength
+}
+
+// AddComment 发表评论，parent 不为nil时为回复。评论者或作品作者是需要审核的低年级学生时，评论等待老师审核；
+// 评论显示时通知被@提及的用户
+func (commentMod *CommentModels) AddComment(work WorkBody, user *User, parent *Comment, content string) (*Comment, error) {
+	comment := &Comment{
+		ID:         bson.NewObjectId(),
+		WorkID:     work.ID,
+		UserID:     user.ID,
+		Username:   user.Username,
+		Realname:   user.Realname,
+		Avatar:     user.Avatar,
+		Content:    content,
+		Status:     CommentApproved,
+		CreateTime: time.Now(),
+	}
+	if parent != nil {
+		comment.RootID = parent.RootID
+		if comment.RootID == "" {
+			comment.RootID = parent.ID
+		}
+		comment.ParentID = parent.ID
+		comment.ReplyTo = parent.Username
+	}
+	var err error
+	if comment.Mentions, err = commentMod.mentionedUsers(content, user.ID); err != nil {
+		return nil, err
+	}
+	if comment.Moderators, err = commentMod.classTeachers(user.ID, work.UserID); err != nil {
+		return nil, err
+	}
+	premoderated, err := commentMod.needPremoderation(user.ID, work.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if premoderated {
+		comment.Status = CommentPending
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Insert(comment)
+	}
+	if err := commentMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workcomment", f); err != nil {
+		return nil, err
+	}
+	if comment.Status == CommentApproved {
+		commentMod.notifyMentions(*comment, work)
+	}
+	return comment, nil
+}
+
+// FindComment 查询评论
+func (commentMod *CommentModels) FindComment(id bson.ObjectId) (Comment, error) {
+	var comment Comment
+	f := func(col *mgo.Collection) error {
+		return col.FindId(id).One(&comment)
+	}
+	err := commentMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workcomment", f)
+	return comment, err
+}
+
+// WorkComments 分页查询作品已显示的楼层评论（新的在前）和每层的回复（按时间顺序），返回楼层总数
+func (commentMod *CommentModels) WorkComments(workID bson.ObjectId, paging PagingInfo) ([]CommentThread, int, error) {
+	visible := bson.M{"$in": []string{CommentApproved, CommentDeleted}}
+	threads := []CommentThread{}
+	var total int
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"workID": workID, "rootID": bson.M{"$exists": false}, "status": visible}
+		var err error
+		if total, err = col.Find(query).Count(); err != nil {
+			return err
+		}
+		if err := col.Find(query).Sort("-createTime").Skip(paging.Offset()).Limit(paging.Limit()).All(&threads); err != nil {
+			return err
+		}
+		if len(threads) == 0 {
+			return nil
+		}
+		roots := make([]bson.ObjectId, len(threads))
+		index := make(map[bson.ObjectId]int)
+		for i, t := range threads {
+			roots[i] = t.ID
+			index[t.ID] = i
+			threads[i].Replies = []Comment{}
+		}
+		var replies []Comment
+		if err := col.Find(bson.M{"rootID": bson.M{"$in": roots}, "status": visible}).Sort("createTime").All(&replies); err != nil {
+			return err
+		}
+		for _, r := range replies {
+			i := index[r.RootID]
+			threads[i].Replies = append(threads[i].Replies, r)
+		}
+		return nil
+	}
+	err := commentMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workcomment", f)
+	return threads, total, err
+}
+
+// PendingComments 分页查询等待审核的评论，早的在前。moderator 为空时查询所有评论（管理员），否则只查询该老师可以审核的评论
+func (commentMod *CommentModels) PendingComments(moderator bson.ObjectId, paging PagingInfo) ([]Comment, int, error) {
+	query := bson.M{"status": CommentPending}
+	if moderator != "" {
+		query["moderators"] = moderator
+	}
+	comments := []Comment{}
+	var total int
+	f := func(col *mgo.Collection) error {
+		var err error
+		if total, err = col.Find(query).Count(); err != nil {
+			return err
+		}
+		return col.Find(query).Sort("createTime").Skip(paging.Offset()).Limit(paging.Limit()).All(&comments)
+	}
+	err := commentMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workcomment", f)
+	return comments, total, err
+}
+
+// ApproveComment 审核通过评论，评论开始显示并通知被@提及的用户
+func (commentMod *CommentModels) ApproveComment(comment Comment, work WorkBody) error {
+	f := func(col *mgo.Collection) error {
+		return col.Update(bson.M{"_id": comment.ID, "status": CommentPending}, bson.M{"$set": bson.M{"status": CommentApproved}})
+	}
+	if err := commentMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workcomment", f); err != nil {
+		return err
+	}
+	commentMod.notifyMentions(comment, work)
+	return nil
+}
+
+// DeleteComment 删除评论。回复仍然保留，被删除的评论只保留位置，不再保留内容
+func (commentMod *CommentModels) DeleteComment(id bson.ObjectId) error {
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(id, bson.M{"$set": bson.M{"status": CommentDeleted, "content": ""}, "$unset": bson.M{"mentions": ""}})
+	}
+	return commentMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workcomment", f)
+}
+
+// CanModerate 是否可以审核和删除评论：管理员，或者评论者、作品作者所在班级的老师
+func (comment *Comment) CanModerate(userID bson.ObjectId, role string) bool {
+	if role == ROLE_ADMIN {
+		return true
+	}
+	if role != ROLE_TEACHER {
+		return false
+	}
+	for _, id := range comment.Moderators {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCommentsDisabled 作者关闭或开启作品的评论
+func (workMod *WorkModels) SetCommentsDisabled(workID bson.ObjectId, disabled bool) error {
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(workID, bson.M{"$set": bson.M{"commentsDisabled": disabled}})
+	}
+	return workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// mentionedUsers 评论中@提及的用户，不包括评论者自己
+func (commentMod *CommentModels) mentionedUsers(content string, self bson.ObjectId) ([]bson.ObjectId, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		if !seen[m[1]] && len(names) < maxMentions {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	var users []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"username": bson.M{"$in": names}, "_id": bson.M{"$ne": self}}).Select(bson.M{"_id": 1}).All(&users)
+	}
+	if err := commentMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "users", f); err != nil {
+		return nil, err
+	}
+	ids := make([]bson.ObjectId, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return ids, nil
+}
+
+// classTeachers 学生所在班级的老师（班级创建者）
+func (commentMod *CommentModels) classTeachers(uids ...bson.ObjectId) ([]bson.ObjectId, error) {
+	var teachers []bson.ObjectId
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"students.userID": bson.M{"$in": uids}}).Distinct("creator", &teachers)
+	}
+	err := commentMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "classes", f)
+	return teachers, err
+}
+
+// needPremoderation 用户中是否有需要审核评论的低年级学生，年级不超过 comment_premoderation_grade 配置（默认3，即小学三年级，0表示不审核）
+func (commentMod *CommentModels) needPremoderation(uids ...bson.ObjectId) (bool, error) {
+	maxGrade := beego.AppConfig.DefaultInt("comment_premoderation_grade", 3)
+	if maxGrade <= 0 {
+		return false, nil
+	}
+	var students []Student
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": bson.M{"$in": uids}, "role.name": ROLE_STUDENT}).Select(bson.M{"grade": 1}).All(&students)
+	}
+	if err := commentMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "users", f); err != nil {
+		return false, err
+	}
+	for _, s := range students {
+		if level := gradeLevel(s.Grade); level > 0 && level <= maxGrade {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// notifyMentions 通知被@提及的用户，通知失败不影响评论
+func (commentMod *CommentModels) notifyMentions(comment Comment, work WorkBody) {
+	if len(comment.Mentions) == 0 {
+		return
+	}
+	name := comment.Realname
+	if name == "" {
+		name = comment.Username
+	}
+	content := fmt.Sprintf("%s在作品《%s》的评论中提到了你：%s", name, work.Name, comment.Content)
+	if err := commentMod.MessageMod.PublishSystemMessage("作品评论", content, "", "", comment.Mentions); err != nil {
+		beego.Error("notify mentions err:", err)
+	}
+}
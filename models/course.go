@@ -16,6 +16,7 @@ import (
 	"github.com/mholt/archiver"
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/exercise"
 	"maiyajia.com/services/mongo"
 	"maiyajia.com/util"
 )
@@ -107,12 +108,17 @@ type Course struct {
 
 // Lession 课时,即一节课
 type Lession struct {
-	ID          bson.ObjectId `bson:"_id" json:"id"`
-	Name        string        `bson:"name" json:"name"`                 //课节名称
-	IconURL     string        `bson:"icon_url" json:"icon_url"`         //图标路径
-	QuestionURL string        `bson:"question_url" json:"question_url"` //答题路径
-	Contents    []*Content    `bson:"content" json:"content"`           //课节视频
-	Tool        string        `bson:"tool" json:"tool"`
+	ID          bson.ObjectId        `bson:"_id" json:"id"`
+	Name        string               `bson:"name" json:"name"`         //课节名称
+	IconURL     string               `bson:"icon_url" json:"icon_url"` //图标路径
+	Contents    []*Content           `bson:"content" json:"content"`   //课节视频
+	Tool        string               `bson:"tool" json:"tool"`
+	Questions   []*exercise.Question `bson:"-" json:"-"`                    //课时练习题，保存在 lesson_questions 中，不随课程返回
+	MaxAttempts int                  `bson:"-" json:"-"`                    //练习作答次数上限，0表示不限
+	Reveal      string               `bson:"-" json:"-"`                    //练习答案公布方式
+	Locked      bool                 `bson:"-" json:"locked"`               //对当前学生是否锁定
+	LockReason  string               `bson:"-" json:"lockReason,omitempty"` //锁定原因
+	UnlockTime  *time.Time           `bson:"-" json:"unlockTime,omitempty"` //按日期解锁的开放时间
 }
 
 //Content 学习资源信息
@@ -138,6 +144,72 @@ func (courseMod *CourseModels) RecordBrowseOfCourse(courseID string) error {
 	return err
 }
 
+// StudentCourseClasses 查询学生所在班级中选了该课程的班级代码
+func (courseMod *CourseModels) StudentCourseClasses(uid, courseID bson.ObjectId) ([]string, error) {
+	var classes []Class
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"students.userID": uid}).Select(bson.M{"code": 1}).All(&classes)
+	}
+	if err := courseMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "classes", f); err != nil {
+		return nil, err
+	}
+	joined := make([]string, 0, len(classes))
+	for _, class := range classes {
+		joined = append(joined, class.Code)
+	}
+	var custom []CustomCourse
+	ff := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"course._id": courseID, "class.code": bson.M{"$in": joined}}).All(&custom)
+	}
+	if err := courseMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "customcourse", ff); err != nil {
+		return nil, err
+	}
+	codes := []string{}
+	for _, item := range custom {
+		for _, class := range item.Class {
+			for _, code := range joined {
+				if class.Code == code {
+					codes = append(codes, code)
+				}
+			}
+		}
+	}
+	return codes, nil
+}
+
+// ClassHasCourse 班级是否选了该课程
+func (courseMod *CourseModels) ClassHasCourse(classCode string, courseID bson.ObjectId) (bool, error) {
+	var n int
+	f := func(col *mgo.Collection) (err error) {
+		n, err = col.Find(bson.M{"course._id": courseID, "class.code": classCode}).Count()
+		return err
+	}
+	err := courseMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "customcourse", f)
+	return n > 0, err
+}
+
+// TeacherHasCourse 老师创建的班级中是否有班级选了该课程
+func (courseMod *CourseModels) TeacherHasCourse(teacherID, courseID bson.ObjectId) (bool, error) {
+	var classes []Class
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"creator": teacherID}).Select(bson.M{"code": 1}).All(&classes)
+	}
+	if err := courseMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "classes", f); err != nil {
+		return false, err
+	}
+	codes := make([]string, 0, len(classes))
+	for _, class := range classes {
+		codes = append(codes, class.Code)
+	}
+	var n int
+	ff := func(col *mgo.Collection) (err error) {
+		n, err = col.Find(bson.M{"course._id": courseID, "class.code": bson.M{"$in": codes}}).Count()
+		return err
+	}
+	err := courseMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "customcourse", ff)
+	return n > 0, err
+}
+
 // GetAllCourses 根据是否已安装/是否已购买/所有获取所有的课程列表
 func (courseMod *CourseModels) GetAllCourses(paging PagingInfo) (interface{}, error) {
 	var courses []Course
@@ -316,6 +388,10 @@ func (courseMod *CourseModels) DownloadCourses(courses []Course, ws *websocket.C
 								logs.Info("File extract fail!", error)
 								return error
 							}
+							if err := publishAssetDir(path.Join("asset", "courses", course.ID.Hex())); err != nil {
+								logs.Error("publish course files fail:", err)
+								return err
+							}
 							if err := courseMod.handleCourse(course); err != nil {
 								return err
 							}
@@ -404,6 +480,25 @@ func (courseMod *CourseModels) GetLessonById(courseID, lessonID string) (*Lessio
 	return nil, err
 }
 
+// GetContentById 查询课程下的学习资源，课程或资源不存在时返回mgo.ErrNotFound
+func (courseMod *CourseModels) GetContentById(courseID, contentID bson.ObjectId) (*Content, error) {
+	var course Course
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": courseID}).One(&course)
+	}
+	if err := courseMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "course", f); err != nil {
+		return nil, err
+	}
+	for _, lesson := range course.Lessions {
+		for _, content := range lesson.Contents {
+			if content.ID == contentID {
+				return content, nil
+			}
+		}
+	}
+	return nil, mgo.ErrNotFound
+}
+
 // OrderInstallCourses 后台指令获取课程并下载，写入课程信息到本地
 func (courseMod *CourseModels) OrderInstallCourses(url string, productKey string, productSerial string) error {
 	logs.Info("begin Install course")
@@ -433,7 +528,7 @@ func (courseMod *CourseModels) OrderInstallCourses(url string, productKey string
 		}
 	} else {
 		// 清空本地课程文件夹
-		if err := os.RemoveAll(path.Join(beego.AppPath, "asset", "courses")); err != nil {
+		if err := RemoveAssetDir(path.Join("asset", "courses")); err != nil {
 			logs.Error("remove dir[%s] fail", path.Join(beego.AppPath, "asset", "courses"))
 			return err
 		}
@@ -507,6 +602,10 @@ func (courseMod *CourseModels) OrderDownloadCourses(courses []Course) error {
 						if err := courseMod.handleCourse(course); err != nil {
 							return err
 						}
+						if err := publishAssetDir(path.Join("asset", "courses")); err != nil {
+							logs.Error("publish course files fail:", err)
+							return err
+						}
 					}
 					// mark completed
 					responses[i] = nil
@@ -622,8 +721,7 @@ func (courseMod *CourseModels) handleCourse(course Course) error {
 	//course.Relpath = relpath
 	//4. 课程信息写入数据库
 	logs.Info("course:", course.Lessions)
-	recourse := ReviseCourse(course)
-	if err := courseMod.InsertCourse(recourse); err != nil {
+	if err := courseMod.saveInstalledCourse(course); err != nil {
 		logs.Info("InsertCourse(course)", err)
 	}
 	name := course.Name + ".zip"
@@ -772,11 +870,15 @@ func (courseMod *CourseModels) CustomCourseExit(userID string, courseID string)
 
 	return course, err
 }
+
+// CourseAssetPrefix 已安装课程资源文件的路径前缀
+const CourseAssetPrefix = "asset/course/"
+
+// ReviseCourse 为课时资源路径加上课程资源目录前缀
 func ReviseCourse(course Course) Course {
-	prefix := "asset/course/"
+	prefix := CourseAssetPrefix
 	for _, lesson := range course.Lessions {
 		lesson.IconURL = prefix + lesson.IconURL
-		lesson.QuestionURL = prefix + lesson.QuestionURL
 		for _, content := range lesson.Contents {
 			content.VideoURL = prefix + content.VideoURL
 			content.MdURL = prefix + content.MdURL
@@ -785,6 +887,12 @@ func ReviseCourse(course Course) Course {
 	return course
 }
 
+// saveInstalledCourse 写入已解压安装的课程信息。
+// 在线安装与离线课程包导入共用此规则：课程、课时、资源ID沿用课程服务器下发的ID，按课程名称覆盖写入
+func (courseMod *CourseModels) saveInstalledCourse(course Course) error {
+	return courseMod.InsertCourse(ReviseCourse(course))
+}
+
 //GetClassCourse 获取班级课程
 func (courseMod *CourseModels) GetClassCourse(code string) (interface{}, error) {
 	var query []interface{}
@@ -0,0 +1,173 @@
+// @Title 离线课程包模型
+// @Description 离线课程包的导入与导出，课程包格式见 services/coursepkg
+
+package models
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/coursepkg"
+	"maiyajia.com/services/exercise"
+)
+
+// ImportCoursePackage 导入离线课程包：校验课程包，解压资源文件并写入课程信息
+func (courseMod *CourseModels) ImportCoursePackage(filename string) (Course, error) {
+	pkg, err := coursepkg.Open(filename)
+	if err != nil {
+		return Course{}, err
+	}
+	defer pkg.Close()
+
+	course, err := courseFromManifest(pkg.Manifest)
+	if err != nil {
+		return Course{}, err
+	}
+	if err := pkg.Extract(Assets(), CourseAssetPrefix); err != nil {
+		return Course{}, err
+	}
+	if err := courseMod.saveInstalledCourse(course); err != nil {
+		return Course{}, err
+	}
+	if err := courseMod.savePackageQuestions(course); err != nil {
+		return Course{}, err
+	}
+	logs.Info("course package imported:", course.Name, course.Version)
+	return course, nil
+}
+
+// ExportCoursePackage 把已安装的课程导出为离线课程包
+func (courseMod *CourseModels) ExportCoursePackage(courseID string, w io.Writer) error {
+	if !bson.IsObjectIdHex(courseID) {
+		return errors.New("invalid course id")
+	}
+	course, err := courseMod.getUserCouById(courseID)
+	if err != nil {
+		return err
+	}
+	if err := loadLessonQuestions(courseMod.MgoSession, course.Lessions); err != nil {
+		return err
+	}
+	manifest := coursepkg.Manifest{Course: manifestCourse(course)}
+	return coursepkg.Write(w, manifest, Assets(), CourseAssetPrefix)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// courseFromManifest 把课程包清单转换为课程信息，ID必须是课程服务器下发的ObjectId
+func courseFromManifest(manifest coursepkg.Manifest) (Course, error) {
+	mc := manifest.Course
+	if !bson.IsObjectIdHex(mc.ID) {
+		return Course{}, errors.New("manifest course id is not an ObjectId")
+	}
+	course := Course{
+		ID:         bson.ObjectIdHex(mc.ID),
+		Name:       mc.Name,
+		Version:    mc.Version,
+		Icon:       mc.Icon,
+		Category:   mc.Category,
+		Desc:       mc.Desc,
+		Onsell:     true,
+		Purchased:  true,
+		CreateTime: time.Now().Unix(),
+	}
+	if course.Icon != "" && !isRemoteURL(course.Icon) {
+		course.Icon = CourseAssetPrefix + course.Icon
+	}
+	for _, ml := range mc.Lessons {
+		if !bson.IsObjectIdHex(ml.ID) {
+			return Course{}, errors.New("manifest lesson id is not an ObjectId")
+		}
+		if err := exercise.Validate(ml.Questions); err != nil {
+			return Course{}, err
+		}
+		lesson := &Lession{
+			ID:          bson.ObjectIdHex(ml.ID),
+			Name:        ml.Name,
+			IconURL:     ml.IconURL,
+			Tool:        ml.Tool,
+			Questions:   ml.Questions,
+			MaxAttempts: ml.MaxAttempts,
+			Reveal:      ml.Reveal,
+		}
+		for _, mct := range ml.Contents {
+			if !bson.IsObjectIdHex(mct.ID) {
+				return Course{}, errors.New("manifest content id is not an ObjectId")
+			}
+			lesson.Contents = append(lesson.Contents, &Content{
+				ID:        bson.ObjectIdHex(mct.ID),
+				VideoName: mct.VideoName,
+				VideoURL:  mct.VideoURL,
+				MdURL:     mct.MdURL,
+			})
+		}
+		course.Lessions = append(course.Lessions, lesson)
+	}
+	return course, nil
+}
+
+// manifestCourse 把已安装的课程转换为课程包清单中的课程信息，资源路径去掉课程资源目录前缀
+func manifestCourse(course Course) coursepkg.Course {
+	mc := coursepkg.Course{
+		ID:       course.ID.Hex(),
+		Name:     course.Name,
+		Version:  course.Version,
+		Icon:     strings.TrimPrefix(course.Icon, CourseAssetPrefix),
+		Category: course.Category,
+		Desc:     course.Desc,
+	}
+	for _, lesson := range course.Lessions {
+		ml := coursepkg.Lesson{
+			ID:          lesson.ID.Hex(),
+			Name:        lesson.Name,
+			IconURL:     strings.TrimPrefix(lesson.IconURL, CourseAssetPrefix),
+			Tool:        lesson.Tool,
+			Questions:   lesson.Questions,
+			MaxAttempts: lesson.MaxAttempts,
+			Reveal:      lesson.Reveal,
+		}
+		for _, content := range lesson.Contents {
+			ml.Contents = append(ml.Contents, coursepkg.Content{
+				ID:        content.ID.Hex(),
+				VideoName: content.VideoName,
+				VideoURL:  strings.TrimPrefix(content.VideoURL, CourseAssetPrefix),
+				MdURL:     strings.TrimPrefix(content.MdURL, CourseAssetPrefix),
+			})
+		}
+		mc.Lessons = append(mc.Lessons, ml)
+	}
+	return mc
+}
+
+// savePackageQuestions 保存课程包自带的练习题，老师编辑过的题库保留不覆盖
+func (courseMod *CourseModels) savePackageQuestions(course Course) error {
+	exerMod := ExerModels{MgoSession: courseMod.MgoSession}
+	for _, lesson := range course.Lessions {
+		if len(lesson.Questions) == 0 {
+			continue
+		}
+		bank, err := exerMod.FindLessonQuestions(lesson.ID)
+		if err == nil && bank.Editor.Valid() {
+			logs.Info("keep lesson questions edited by teacher:", lesson.Name)
+			continue
+		}
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+		if err := exerMod.SaveLessonQuestions(course.ID, lesson.ID, lesson.Questions, lesson.MaxAttempts, lesson.Reveal, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isRemoteURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
@@ -149,6 +149,125 @@ const (
 	ERR_TOOL_REALPATH
 
 	RUNTIME_ERROR
+
+	// 离线课程包
+	ERR_COURSE_PACKAGE_UPLOAD_FAIL
+	ERR_COURSE_PACKAGE_IMPORT_FAIL
+
+	// 课时解锁
+	ERR_LESSION_LOCKED
+	ERR_UNLOCK_RULE_UPDATE_FAIL
+	ERR_UNLOCK_RULE_QUERY_FAIL
+
+	// 课程媒体
+	ERR_MEDIA_URL_INVALID
+	ERR_MEDIA_URL_EXPIRED
+	ERR_MEDIA_NOT_FOUND
+
+	// 班级作业
+	ERR_ASSIGNMENT_NONE
+	ERR_ASSIGNMENT_TIME_INVALID
+	ERR_ASSIGNMENT_COURSE_NOT_IN_CLASS
+	ERR_ASSIGNMENT_UPDATE_FAIL
+	ERR_ASSIGNMENT_QUERY_FAIL
+
+	// 课时文档
+	ERR_MARKDOWN_NOT_FOUND
+
+	// 课时练习
+	ERR_EXERCISE_NONE
+	ERR_EXERCISE_QUESTION_INVALID
+	ERR_EXERCISE_ATTEMPTS_EXHAUSTED
+	ERR_EXERCISE_QUERY_FAIL
+	ERR_EXERCISE_IMPORT_FORMAT
+	ERR_EXERCISE_IMPORT_INVALID
+
+	// 班级考试
+	ERR_EXAM_NONE
+	ERR_EXAM_TIME_INVALID
+	ERR_EXAM_NOT_OPEN
+	ERR_EXAM_STARTED
+	ERR_EXAM_SUBMITTED
+	ERR_EXAM_TIMEOUT
+	ERR_EXAM_NOT_RELEASED
+	ERR_EXAM_UPDATE_FAIL
+	ERR_EXAM_QUERY_FAIL
+
+	// 作品批改
+	ERR_GRADE_NONE
+	ERR_GRADE_NOT_CLASS_WORK
+	ERR_GRADE_SCORE_INVALID
+	ERR_GRADE_RESUBMIT_INVALID
+	ERR_GRADE_AUDIO_INVALID
+	ERR_GRADE_LOCKED
+	ERR_GRADE_UPDATE_FAIL
+	ERR_GRADE_QUERY_FAIL
+
+	// Scratch作品评估
+	ERR_SCRATCH_PROJECT_INVALID
+	ERR_SCRATCH_CHECK_INVALID
+	ERR_SCRATCH_CHECK_UPDATE_FAIL
+
+	// 作品版本
+	ERR_REVISION_NONE
+	ERR_REVISION_NO_FILE
+	ERR_REVISION_SAVE_FAIL
+	ERR_REVISION_RESTORE_FAIL
+	ERR_REVISION_QUERY_FAIL
+
+	// 3D打印
+	ERR_PRINT_NOT_STL
+	ERR_PRINT_REPORT_FAIL
+
+	// 3D打印队列
+	ERR_PRINT_PRINTER_NONE
+	ERR_PRINT_JOB_EXISTS
+	ERR_PRINT_JOB_NONE
+	ERR_PRINT_JOB_STATUS
+	ERR_PRINT_CANCEL_FAIL
+	ERR_PRINT_UPDATE_FAIL
+	ERR_PRINT_QUERY_FAIL
+
+	// 模型格式转换
+	ERR_MODEL_FORMAT
+	ERR_MODEL_UNIT
+	ERR_MODEL_INVALID
+	ERR_MODEL_CONVERT_FAIL
+
+	// 作品评论
+	ERR_COMMENT_INVALID
+	ERR_COMMENT_NOT_PUBLIC
+	ERR_COMMENT_DISABLED
+	ERR_COMMENT_NONE
+	ERR_COMMENT_STATUS
+	ERR_COMMENT_SAVE_FAIL
+	ERR_COMMENT_QUERY_FAIL
+
+	// 作品改编
+	ERR_REMIX_QUERY_FAIL
+
+	// 存储配额
+	ERR_QUOTA_EXCEEDED
+	ERR_QUOTA_QUERY_FAIL
+	ERR_QUOTA_SAVE_FAIL
+
+	// 分块上传
+	ERR_UPLOAD_NONE
+	ERR_UPLOAD_KIND
+	ERR_UPLOAD_SIZE
+	ERR_UPLOAD_OFFSET
+	ERR_UPLOAD_CHECKSUM
+	ERR_UPLOAD_INCOMPLETE
+	ERR_UPLOAD_BUSY
+	ERR_UPLOAD_SAVE_FAIL
+	ERR_TOOL_PACKAGE_IMPORT_FAIL
+
+	// 练习批阅
+	ERR_EXERCISE_NOT_PENDING
+	ERR_EXERCISE_SCORE_INVALID
+
+	// 语音评语
+	ERR_GRADE_AUDIO_NONE
 )
 
 // ErrorResult 服务端错误响应
@@ -293,6 +412,105 @@ func ErrorMsgs() map[int]string {
 		errorMsgs[ERR_ADD_EXER_FAIL] = "练习保存失败"
 		errorMsgs[RUNTIME_ERROR] = "系统错误，请稍后重试"
 
+		errorMsgs[ERR_COURSE_PACKAGE_UPLOAD_FAIL] = "课程包上传失败，请稍后重试"
+		errorMsgs[ERR_COURSE_PACKAGE_IMPORT_FAIL] = "课程包校验或导入失败，请检查课程包是否完整"
+
+		errorMsgs[ERR_LESSION_LOCKED] = "课时尚未解锁"
+		errorMsgs[ERR_UNLOCK_RULE_UPDATE_FAIL] = "设置课时解锁规则失败，请稍后重试"
+		errorMsgs[ERR_UNLOCK_RULE_QUERY_FAIL] = "查询课时解锁规则失败，请稍后重试"
+
+		errorMsgs[ERR_MEDIA_URL_INVALID] = "视频地址无效"
+		errorMsgs[ERR_MEDIA_URL_EXPIRED] = "视频地址已过期，请刷新页面"
+		errorMsgs[ERR_MEDIA_NOT_FOUND] = "视频文件不存在"
+
+		errorMsgs[ERR_ASSIGNMENT_NONE] = "作业不存在"
+		errorMsgs[ERR_ASSIGNMENT_TIME_INVALID] = "作业的截止时间必须晚于开放时间"
+		errorMsgs[ERR_ASSIGNMENT_COURSE_NOT_IN_CLASS] = "班级没有选择该课程或课时不存在"
+		errorMsgs[ERR_ASSIGNMENT_UPDATE_FAIL] = "作业保存失败，请稍后重试"
+		errorMsgs[ERR_ASSIGNMENT_QUERY_FAIL] = "作业查询失败，请稍后重试"
+
+		errorMsgs[ERR_MARKDOWN_NOT_FOUND] = "课时文档不存在"
+
+		errorMsgs[ERR_EXERCISE_NONE] = "课时不存在或没有练习"
+		errorMsgs[ERR_EXERCISE_QUESTION_INVALID] = "练习题设置有误，请检查题型、选项、答案和分值"
+		errorMsgs[ERR_EXERCISE_ATTEMPTS_EXHAUSTED] = "练习作答次数已用完"
+		errorMsgs[ERR_EXERCISE_QUERY_FAIL] = "练习查询失败，请稍后重试"
+		errorMsgs[ERR_EXERCISE_IMPORT_FORMAT] = "只支持导入xlsx、csv表格和QTI 2.1题目包（zip或xml）"
+		errorMsgs[ERR_EXERCISE_IMPORT_INVALID] = "部分题目校验未通过，请先预检查看问题"
+
+		errorMsgs[ERR_EXAM_NONE] = "考试不存在"
+		errorMsgs[ERR_EXAM_TIME_INVALID] = "考试的结束时间必须晚于开始时间，答题时长必须大于0"
+		errorMsgs[ERR_EXAM_NOT_OPEN] = "考试未开始或已结束"
+		errorMsgs[ERR_EXAM_STARTED] = "考试已开始，不能修改"
+		errorMsgs[ERR_EXAM_SUBMITTED] = "已经交卷"
+		errorMsgs[ERR_EXAM_TIMEOUT] = "答题时间已到，试卷已自动提交"
+		errorMsgs[ERR_EXAM_NOT_RELEASED] = "成绩尚未公布"
+		errorMsgs[ERR_EXAM_UPDATE_FAIL] = "考试保存失败，请稍后重试"
+		errorMsgs[ERR_EXAM_QUERY_FAIL] = "考试查询失败，请稍后重试"
+
+		errorMsgs[ERR_GRADE_NONE] = "作品尚未批改"
+		errorMsgs[ERR_GRADE_NOT_CLASS_WORK] = "该作品不是本班学生的作品"
+		errorMsgs[ERR_GRADE_SCORE_INVALID] = "分数必须在0到满分之间，评分细则每项都需要名称和满分"
+		errorMsgs[ERR_GRADE_RESUBMIT_INVALID] = "重新提交截止时间必须晚于当前时间"
+		errorMsgs[ERR_GRADE_AUDIO_INVALID] = "语音评语只支持mp3、m4a、aac、wav、ogg、webm格式"
+		errorMsgs[ERR_GRADE_LOCKED] = "作品已批改，重新提交已截止"
+		errorMsgs[ERR_GRADE_UPDATE_FAIL] = "批改保存失败，请稍后重试"
+		errorMsgs[ERR_GRADE_QUERY_FAIL] = "批改结果查询失败，请稍后重试"
+
+		errorMsgs[ERR_SCRATCH_PROJECT_INVALID] = "不是有效的Scratch 3作品"
+		errorMsgs[ERR_SCRATCH_CHECK_INVALID] = "检查项设置有误，请检查名称、类型、目标和数量"
+		errorMsgs[ERR_SCRATCH_CHECK_UPDATE_FAIL] = "检查项保存失败，请稍后重试"
+
+		errorMsgs[ERR_REVISION_NONE] = "作品版本不存在"
+		errorMsgs[ERR_REVISION_NO_FILE] = "该版本没有作品文件"
+		errorMsgs[ERR_REVISION_SAVE_FAIL] = "作品版本保存失败，请稍后重试"
+		errorMsgs[ERR_REVISION_RESTORE_FAIL] = "恢复作品版本失败，请稍后重试"
+		errorMsgs[ERR_REVISION_QUERY_FAIL] = "作品版本查询失败，请稍后重试"
+
+		errorMsgs[ERR_PRINT_NOT_STL] = "该作品没有STL模型文件"
+		errorMsgs[ERR_PRINT_REPORT_FAIL] = "模型检查失败，请确认是有效的STL文件"
+
+		errorMsgs[ERR_PRINT_PRINTER_NONE] = "打印机不存在"
+		errorMsgs[ERR_PRINT_JOB_EXISTS] = "该作品已在打印队列中"
+		errorMsgs[ERR_PRINT_JOB_NONE] = "打印任务不存在"
+		errorMsgs[ERR_PRINT_JOB_STATUS] = "打印任务当前状态不能进行该操作"
+		errorMsgs[ERR_PRINT_CANCEL_FAIL] = "取消打印失败，请在打印机上取消"
+		errorMsgs[ERR_PRINT_UPDATE_FAIL] = "打印队列保存失败，请稍后重试"
+		errorMsgs[ERR_PRINT_QUERY_FAIL] = "打印队列查询失败，请稍后重试"
+
+		errorMsgs[ERR_MODEL_FORMAT] = "不支持的模型格式，只支持stl、stl-ascii、obj、3mf"
+		errorMsgs[ERR_MODEL_UNIT] = "不支持的长度单位，只支持mm、cm、m、in"
+		errorMsgs[ERR_MODEL_INVALID] = "模型文件无法解析"
+		errorMsgs[ERR_MODEL_CONVERT_FAIL] = "模型格式转换失败"
+
+		errorMsgs[ERR_COMMENT_INVALID] = "评论内容不能为空，且不能超过500字"
+		errorMsgs[ERR_COMMENT_NOT_PUBLIC] = "只能评论已分享的作品"
+		errorMsgs[ERR_COMMENT_DISABLED] = "作者已关闭该作品的评论"
+		errorMsgs[ERR_COMMENT_NONE] = "评论不存在"
+		errorMsgs[ERR_COMMENT_STATUS] = "评论已经审核或已删除"
+		errorMsgs[ERR_COMMENT_SAVE_FAIL] = "评论保存失败，请稍后重试"
+		errorMsgs[ERR_COMMENT_QUERY_FAIL] = "评论查询失败，请稍后重试"
+
+		errorMsgs[ERR_REMIX_QUERY_FAIL] = "改编关系查询失败，请稍后重试"
+
+		errorMsgs[ERR_QUOTA_EXCEEDED] = "作品存储空间已超出配额，请删除不需要的作品或联系管理员"
+		errorMsgs[ERR_QUOTA_QUERY_FAIL] = "存储配额查询失败，请稍后重试"
+		errorMsgs[ERR_QUOTA_SAVE_FAIL] = "存储配额保存失败，请稍后重试"
+
+		errorMsgs[ERR_UPLOAD_NONE] = "上传会话不存在或已过期，请重新上传"
+		errorMsgs[ERR_UPLOAD_KIND] = "上传的文件类型不正确"
+		errorMsgs[ERR_UPLOAD_SIZE] = "文件大小不正确或超过上传限制"
+		errorMsgs[ERR_UPLOAD_OFFSET] = "分块的偏移量与已上传的大小不一致，请从已上传的位置继续"
+		errorMsgs[ERR_UPLOAD_CHECKSUM] = "文件校验失败，请重新上传该分块"
+		errorMsgs[ERR_UPLOAD_INCOMPLETE] = "文件还没有上传完成"
+		errorMsgs[ERR_UPLOAD_BUSY] = "该文件正在上传另一个分块，请稍后重试"
+		errorMsgs[ERR_UPLOAD_SAVE_FAIL] = "上传保存失败，请稍后重试"
+		errorMsgs[ERR_TOOL_PACKAGE_IMPORT_FAIL] = "导入工具包失败"
+
+		errorMsgs[ERR_EXERCISE_NOT_PENDING] = "该题不需要老师批阅或已批阅"
+		errorMsgs[ERR_EXERCISE_SCORE_INVALID] = "批阅分数不能小于0或超过题目分值"
+		errorMsgs[ERR_GRADE_AUDIO_NONE] = "该作品的批改没有语音评语"
+
 	}
 	return errorMsgs
 }
@@ -0,0 +1,470 @@
+// @Title 班级考试模型
+// @Description 老师用课时练习题给班级安排限时考试：开放时段内每个学生只能作答一次，题目和选项顺序随机，
+// 到时由服务端自动交卷，成绩由老师决定何时公布
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/exercise"
+	"maiyajia.com/services/mongo"
+)
+
+var (
+	// ErrExamNotOpen 不在考试开放时段内
+	ErrExamNotOpen = errors.New("exam is not open")
+	// ErrExamSubmitted 已经交卷
+	ErrExamSubmitted = errors.New("exam already submitted")
+	// ErrExamTimeout 答题时间已到，试卷按已保存的答案自动提交
+	ErrExamTimeout = errors.New("exam time is up")
+)
+
+// examSubmitGrace 交卷允许的网络延迟
+const examSubmitGrace = 30 * time.Second
+
+type ExamModels struct {
+	MgoSession *mongo.MgoClient
+}
+
+// 考试状态
+const (
+	ExamNotOpen = "notopen" //未到开始时间
+	ExamOpen    = "open"    //进行中
+	ExamEnded   = "ended"   //已结束
+)
+
+// Exam 班级考试
+type Exam struct {
+	ID               bson.ObjectId        `bson:"_id" json:"id"`
+	ClassCode        string               `bson:"classCode" json:"classCode"`
+	CourseID         bson.ObjectId        `bson:"courseID" json:"courseID"`
+	LessonID         bson.ObjectId        `bson:"lessonID" json:"lessonID"`
+	CourseName       string               `bson:"courseName" json:"courseName"`
+	LessonName       string               `bson:"lessonName" json:"lessonName"`
+	Title            string               `bson:"title" json:"title"`
+	StartTime        time.Time            `bson:"startTime" json:"startTime"`               //开放时间
+	EndTime          time.Time            `bson:"endTime" json:"endTime"`                   //结束时间，过后不能再开始作答
+	Duration         int                  `bson:"duration" json:"duration"`                 //答题时长（分钟）
+	ShuffleQuestions bool                 `bson:"shuffleQuestions" json:"shuffleQuestions"` //打乱题目顺序
+	ShuffleOptions   bool                 `bson:"shuffleOptions" json:"shuffleOptions"`     //打乱选项顺序
+	Questions        []*exercise.Question `bson:"questions" json:"-"`                       //创建考试时从课时复制的题目
+	FullScore        float64              `bson:"fullScore" json:"fullScore"`
+	Released         bool                 `bson:"released" json:"released"` //是否已公布成绩
+	Creator          bson.ObjectId        `bson:"creator" json:"creator"`
+	CreateTime       time.Time            `bson:"createTime" json:"createTime"`
+}
+
+// ExamSession 学生的一次考试作答，每个学生每场考试只有一份
+type ExamSession struct {
+	ID            bson.ObjectId             `bson:"_id" json:"id"`
+	ExamID        bson.ObjectId             `bson:"examID" json:"examID"`
+	UserID        bson.ObjectId             `bson:"userID" json:"userID"`
+	Paper         exercise.Paper            `bson:"paper" json:"-"`
+	StartTime     time.Time                 `bson:"startTime" json:"startTime"`
+	Deadline      time.Time                 `bson:"deadline" json:"deadline"`   //交卷截止时间
+	Answers       []exercise.Answer         `bson:"answers" json:"answers"`     //按试卷显示的选项字母保存的作答
+	Submitted     bool                      `bson:"submitted" json:"submitted"` //是否已交卷
+	AutoSubmitted bool                      `bson:"autoSubmitted" json:"autoSubmitted"`
+	SubmitTime    time.Time                 `bson:"submitTime" json:"submitTime"`
+	Results       []exercise.QuestionResult `bson:"results" json:"results,omitempty"`
+	Score         float64                   `bson:"score" json:"score"`
+	Pending       bool                      `bson:"pending" json:"pending"`
+}
+
+// StudentExam 学生的考试列表项
+type StudentExam struct {
+	Exam
+	Status    string       `json:"status"`
+	Session   *ExamSession `json:"session,omitempty"`
+	Remaining int64        `json:"remaining"` //剩余答题时间（秒）
+}
+
+// ExamResultRow 考试成绩表中一个学生的成绩
+type ExamResultRow struct {
+	UserID   bson.ObjectId `json:"userID"`
+	Username string        `json:"username"`
+	Realname string        `json:"realname"`
+	Session  *ExamSession  `json:"session"` //还没开始作答时为空
+}
+
+// ExamResults 考试成绩表和每道题的统计
+type ExamResults struct {
+	Exam     Exam                `json:"exam"`
+	Summary  exercise.Summary    `json:"summary"`
+	Items    []exercise.ItemStat `json:"items"`
+	Students []ExamResultRow     `json:"students"`
+}
+
+// ExamStatus 根据开放和结束时间计算考试状态
+func ExamStatus(exam Exam, now time.Time) string {
+	switch {
+	case now.Before(exam.StartTime):
+		return ExamNotOpen
+	case now.Before(exam.EndTime):
+		return ExamOpen
+	}
+	return ExamEnded
+}
+
+// CreateExam 创建考试
+func (examMod *ExamModels) CreateExam(exam *Exam) error {
+	exam.ID = bson.NewObjectId()
+	exam.CreateTime = time.Now()
+	exam.FullScore = exercise.FullScore(exam.Questions)
+	f := func(col *mgo.Collection) error {
+		return col.Insert(exam)
+	}
+	return examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exam", f)
+}
+
+// UpdateExam 修改考试的标题、时段、时长和乱序设置
+func (examMod *ExamModels) UpdateExam(exam Exam) error {
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(exam.ID, bson.M{"$set": bson.M{
+			"title":            exam.Title,
+			"startTime":        exam.StartTime,
+			"endTime":          exam.EndTime,
+			"duration":         exam.Duration,
+			"shuffleQuestions": exam.ShuffleQuestions,
+			"shuffleOptions":   exam.ShuffleOptions,
+		}})
+	}
+	return examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exam", f)
+}
+
+// SetExamReleased 公布或撤回考试成绩
+func (examMod *ExamModels) SetExamReleased(id bson.ObjectId, released bool) error {
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(id, bson.M{"$set": bson.M{"released": released}})
+	}
+	return examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exam", f)
+}
+
+// DeleteExam 删除考试和学生的作答
+func (examMod *ExamModels) DeleteExam(id bson.ObjectId) error {
+	f := func(col *mgo.Collection) error {
+		_, err := col.RemoveAll(bson.M{"examID": id})
+		return err
+	}
+	if err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "examsession", f); err != nil {
+		return err
+	}
+	f = func(col *mgo.Collection) error {
+		return col.RemoveId(id)
+	}
+	return examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exam", f)
+}
+
+// FindExam 查询考试
+func (examMod *ExamModels) FindExam(id bson.ObjectId) (Exam, error) {
+	var exam Exam
+	f := func(col *mgo.Collection) error {
+		return col.FindId(id).One(&exam)
+	}
+	err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exam", f)
+	return exam, err
+}
+
+// ClassExams 查询班级的所有考试，按开始时间倒序
+func (examMod *ExamModels) ClassExams(classCode string) ([]Exam, error) {
+	exams := []Exam{}
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"classCode": classCode}).Sort("-startTime").All(&exams)
+	}
+	err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exam", f)
+	return exams, err
+}
+
+// StudentExams 查询学生所在班级的考试和自己的作答情况，成绩未公布时不返回得分
+func (examMod *ExamModels) StudentExams(uid bson.ObjectId, now time.Time) ([]StudentExam, error) {
+	list := []StudentExam{}
+	var classes []Class
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"students.userID": uid}).Select(bson.M{"code": 1}).All(&classes)
+	}
+	if err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "classes", f); err != nil {
+		return list, err
+	}
+	codes := make([]string, 0, len(classes))
+	for _, class := range classes {
+		codes = append(codes, class.Code)
+	}
+	var exams []Exam
+	f = func(col *mgo.Collection) error {
+		return col.Find(bson.M{"classCode": bson.M{"$in": codes}}).Sort("-startTime").All(&exams)
+	}
+	if err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exam", f); err != nil {
+		return list, err
+	}
+	for _, exam := range exams {
+		session, err := examMod.FindExamSession(exam.ID, uid)
+		if err != nil && err != mgo.ErrNotFound {
+			return list, err
+		}
+		se := StudentExam{Exam: exam, Status: ExamStatus(exam, now)}
+		if err == nil {
+			se.Session = StudentSessionView(exam, session)
+			se.Remaining = ExamRemaining(session, now)
+		}
+		list = append(list, se)
+	}
+	return list, nil
+}
+
+// FindExamSession 查询学生的考试作答
+func (examMod *ExamModels) FindExamSession(examID, uid bson.ObjectId) (ExamSession, error) {
+	var session ExamSession
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"examID": examID, "userID": uid}).One(&session)
+	}
+	err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "examsession", f)
+	return session, err
+}
+
+// StartExam 学生开始考试：生成随机顺序的试卷，交卷截止时间为开始后的答题时长与考试结束时间中较早的一个。
+// 已开始过的直接返回原来的作答，答题时间已到的先自动交卷
+func (examMod *ExamModels) StartExam(exam Exam, uid bson.ObjectId, now time.Time) (ExamSession, error) {
+	session, err := examMod.FindExamSession(exam.ID, uid)
+	if err == nil {
+		if !session.Submitted && now.After(session.Deadline.Add(examSubmitGrace)) {
+			return examMod.finishSession(exam, session, session.Answers, true, now)
+		}
+		return session, nil
+	}
+	if err != mgo.ErrNotFound {
+		return session, err
+	}
+	if ExamStatus(exam, now) != ExamOpen {
+		return session, ErrExamNotOpen
+	}
+	deadline := now.Add(time.Duration(exam.Duration) * time.Minute)
+	if deadline.After(exam.EndTime) {
+		deadline = exam.EndTime
+	}
+	session = ExamSession{
+		ID:        bson.NewObjectId(),
+		ExamID:    exam.ID,
+		UserID:    uid,
+		Paper:     exercise.NewPaper(exam.Questions, now.UnixNano(), exam.ShuffleQuestions, exam.ShuffleOptions),
+		StartTime: now,
+		Deadline:  deadline,
+		Answers:   []exercise.Answer{},
+	}
+	// 并发开始时只保留先写入的一份
+	f := func(col *mgo.Collection) error {
+		_, err := col.Upsert(bson.M{"examID": exam.ID, "userID": uid}, bson.M{"$setOnInsert": session})
+		return err
+	}
+	if err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "examsession", f); err != nil {
+		return session, err
+	}
+	return examMod.FindExamSession(exam.ID, uid)
+}
+
+// SaveExamAnswers 保存作答进度；答题时间已到时按已保存的答案自动交卷并返回ErrExamTimeout
+func (examMod *ExamModels) SaveExamAnswers(exam Exam, session ExamSession, answers []exercise.Answer, now time.Time) (ExamSession, error) {
+	if session.Submitted {
+		return session, ErrExamSubmitted
+	}
+	if now.After(session.Deadline.Add(examSubmitGrace)) {
+		session, err := examMod.finishSession(exam, session, session.Answers, true, now)
+		if err != nil {
+			return session, err
+		}
+		return session, ErrExamTimeout
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Update(bson.M{"_id": session.ID, "submitted": false}, bson.M{"$set": bson.M{"answers": answers}})
+	}
+	err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "examsession", f)
+	if err == mgo.ErrNotFound {
+		return session, ErrExamSubmitted
+	}
+	session.Answers = answers
+	return session, err
+}
+
+// SubmitExam 交卷并评分；答题时间已到时忽略本次提交的答案，按已保存的答案自动交卷并返回ErrExamTimeout
+func (examMod *ExamModels) SubmitExam(exam Exam, session ExamSession, answers []exercise.Answer, now time.Time) (ExamSession, error) {
+	if session.Submitted {
+		return session, ErrExamSubmitted
+	}
+	if now.After(session.Deadline.Add(examSubmitGrace)) {
+		session, err := examMod.finishSession(exam, session, session.Answers, true, now)
+		if err != nil {
+			return session, err
+		}
+		return session, ErrExamTimeout
+	}
+	return examMod.finishSession(exam, session, answers, false, now)
+}
+
+// AutoSubmitExpired 把答题时间已到还没有交卷的作答按已保存的答案自动交卷。
+// 单个作答交卷失败时记录日志并继续处理其他作答，考试已被删除的作答直接关闭
+func (examMod *ExamModels) AutoSubmitExpired(now time.Time) error {
+	var sessions []ExamSession
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"submitted": false, "deadline": bson.M{"$lt": now.Add(-examSubmitGrace)}}
+		return col.Find(query).All(&sessions)
+	}
+	if err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "examsession", f); err != nil {
+		return err
+	}
+	exams := make(map[bson.ObjectId]Exam)
+	deleted := make(map[bson.ObjectId]bool)
+	for _, session := range sessions {
+		exam, ok := exams[session.ExamID]
+		if !ok && !deleted[session.ExamID] {
+			var err error
+			exam, err = examMod.FindExam(session.ExamID)
+			if err == mgo.ErrNotFound {
+				deleted[session.ExamID] = true
+			} else if err != nil {
+				logs.Error("exam auto submit:", session.ID.Hex(), err)
+				continue
+			} else {
+				exams[session.ExamID] = exam
+			}
+		}
+		if deleted[session.ExamID] {
+			if err := examMod.closeSession(session, now); err != nil {
+				logs.Error("exam auto submit:", session.ID.Hex(), err)
+			}
+			continue
+		}
+		if _, err := examMod.finishSession(exam, session, session.Answers, true, now); err != nil && err != ErrExamSubmitted {
+			logs.Error("exam auto submit:", session.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// ExamResults 考试成绩表，包含班级全部学生和每道题的统计
+func (examMod *ExamModels) ExamResults(exam Exam, class Class) (ExamResults, error) {
+	results := ExamResults{Exam: exam, Items: []exercise.ItemStat{}, Students: []ExamResultRow{}}
+	uids := make([]bson.ObjectId, 0, len(class.Students))
+	for _, s := range class.Students {
+		uids = append(uids, s.UserID)
+	}
+	var users []User
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": bson.M{"$in": uids}}).Select(bson.M{"username": 1, "realname": 1}).Sort("username").All(&users)
+	}
+	if err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "users", f); err != nil {
+		return results, err
+	}
+	var sessions []ExamSession
+	f = func(col *mgo.Collection) error {
+		return col.Find(bson.M{"examID": exam.ID}).All(&sessions)
+	}
+	if err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "examsession", f); err != nil {
+		return results, err
+	}
+	byUser := make(map[bson.ObjectId]*ExamSession)
+	var subs []exercise.Submission
+	for i := range sessions {
+		s := &sessions[i]
+		byUser[s.UserID] = s
+		if !s.Submitted {
+			continue
+		}
+		subs = append(subs, exercise.Submission{
+			UserID:   s.UserID.Hex(),
+			Attempt:  1,
+			Answers:  s.Paper.ToOriginal(s.Answers),
+			Results:  s.Results,
+			Score:    s.Score,
+			Duration: int64(s.SubmitTime.Sub(s.StartTime) / time.Second),
+		})
+	}
+	results.Items, results.Summary = exercise.Analyze(exam.Questions, subs)
+	for _, user := range users {
+		results.Students = append(results.Students, ExamResultRow{
+			UserID:   user.ID,
+			Username: user.Username,
+			Realname: user.Realname,
+			Session:  byUser[user.ID],
+		})
+	}
+	return results, nil
+}
+
+// ExamQuestions 按学生试卷的顺序返回题目，成绩公布后才带答案和解析
+func ExamQuestions(exam Exam, session ExamSession) []*exercise.Question {
+	return session.Paper.Questions(exam.Questions, exam.Released && session.Submitted)
+}
+
+// StudentSessionView 学生看到的作答，成绩未公布时去掉得分
+func StudentSessionView(exam Exam, session ExamSession) *ExamSession {
+	if !exam.Released {
+		session.Results = nil
+		session.Score = 0
+		session.Pending = false
+	}
+	return &session
+}
+
+// ExamRemaining 剩余答题时间（秒）
+func ExamRemaining(session ExamSession, now time.Time) int64 {
+	if session.Submitted || !now.Before(session.Deadline) {
+		return 0
+	}
+	return int64(session.Deadline.Sub(now) / time.Second)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// finishSession 评分并交卷，只更新还未交卷的作答
+func (examMod *ExamModels) finishSession(exam Exam, session ExamSession, answers []exercise.Answer, auto bool, now time.Time) (ExamSession, error) {
+	result := exercise.Grade(exam.Questions, session.Paper.ToOriginal(answers))
+	session.Answers = answers
+	session.Submitted = true
+	session.AutoSubmitted = auto
+	session.SubmitTime = now
+	if auto && now.After(session.Deadline) {
+		session.SubmitTime = session.Deadline
+	}
+	session.Results = result.Questions
+	session.Score = result.Score
+	session.Pending = result.Pending
+	f := func(col *mgo.Collection) error {
+		return col.Update(bson.M{"_id": session.ID, "submitted": false}, bson.M{"$set": bson.M{
+			"answers":       session.Answers,
+			"submitted":     true,
+			"autoSubmitted": session.AutoSubmitted,
+			"submitTime":    session.SubmitTime,
+			"results":       session.Results,
+			"score":         session.Score,
+			"pending":       session.Pending,
+		}})
+	}
+	err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "examsession", f)
+	if err == mgo.ErrNotFound {
+		return session, ErrExamSubmitted
+	}
+	return session, err
+}
+
+// closeSession 关闭所属考试已被删除的作答，不再评分
+func (examMod *ExamModels) closeSession(session ExamSession, now time.Time) error {
+	f := func(col *mgo.Collection) error {
+		return col.Update(bson.M{"_id": session.ID, "submitted": false}, bson.M{"$set": bson.M{
+			"submitted":     true,
+			"autoSubmitted": true,
+			"submitTime":    now,
+		}})
+	}
+	err := examMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "examsession", f)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
@@ -3,33 +3,453 @@
 package models
 
 import (
+	"errors"
+	"time"
+
 	"github.com/astaxie/beego"
-	"github.com/astaxie/beego/logs"
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/exercise"
 	"maiyajia.com/services/mongo"
 )
 
+// ErrExerciseAttemptsExhausted 练习作答次数已用完
+var ErrExerciseAttemptsExhausted = errors.New("exercise attempts exhausted")
+
 type ExerModels struct {
 	MgoSession *mongo.MgoClient
 }
+
+// Exercise 学生的一次练习作答记录
 type Exercise struct {
-	ID          bson.ObjectId `bson:"_id" json:"id"`                  //ID
-	CourseID    bson.ObjectId `bson:"courseID" json:"courseID"`       //课程ID
-	LessonID    bson.ObjectId `bson:"lessonID" json:"lessonID"`       //课时ID
-	UserID      bson.ObjectId `bson:"userID" json:"userID"`           //用户ID
-	CouerseName string        `bson:"couerseName" json:"couerseName"` //课程名字
-	LessonName  string        `bson:"lessonName" json:"lessonName"`   //课时名字
-	Answer      string        `bson:"answer" json:"answer"`           //习题答案
+	ID          bson.ObjectId             `bson:"_id" json:"id"`                  //ID
+	CourseID    bson.ObjectId             `bson:"courseID" json:"courseID"`       //课程ID
+	LessonID    bson.ObjectId             `bson:"lessonID" json:"lessonID"`       //课时ID
+	UserID      bson.ObjectId             `bson:"userID" json:"userID"`           //用户ID
+	CouerseName string                    `bson:"couerseName" json:"couerseName"` //课程名字
+	LessonName  string                    `bson:"lessonName" json:"lessonName"`   //课时名字
+	Attempt     int                       `bson:"attempt" json:"attempt"`         //第几次作答
+	Answers     []exercise.Answer         `bson:"answers" json:"answers"`         //学生的作答
+	Results     []exercise.QuestionResult `bson:"results" json:"results"`         //每道题的得分
+	Score       float64                   `bson:"score" json:"score"`             //练习得分
+	FullScore   float64                   `bson:"fullScore" json:"fullScore"`     //练习总分
+	Pending     bool                      `bson:"pending" json:"pending"`         //有简答题需老师批阅
+	SubmitTime  time.Time                 `bson:"submitTime" json:"submitTime"`   //提交时间
+	Duration    int64                     `bson:"duration" json:"duration"`       //作答用时（秒），0表示未知
 }
 
-func (exerMod *ExerModels) InsertExercise(exer Exercise) error {
-	logs.Info(exer)
+// 需要关注的原因
+const (
+	AttentionNotSubmitted = "not_submitted" //还没有作答
+	AttentionLowScore     = "low_score"     //最高分低于关注线
+)
+
+// maxExerciseDuration 超过该时长的作答用时视为中途离开，不计入统计
+const maxExerciseDuration = 2 * time.Hour
+
+// ExerciseStudentRow 班级练习报告中一个学生的作答情况
+type ExerciseStudentRow struct {
+	UserID      bson.ObjectId `json:"userID"`
+	Username    string        `json:"username"`
+	Realname    string        `json:"realname"`
+	Attempts    int           `json:"attempts"`
+	BestScore   float64       `json:"bestScore"`
+	LastScore   float64       `json:"lastScore"`
+	LastSubmit  *time.Time    `json:"lastSubmit,omitempty"`
+	Pending     bool          `json:"pending"` //最近一次作答有待批阅的简答题
+	Attention   string        `json:"attention,omitempty"`
+	Submissions []Exercise    `json:"submissions"`
+}
+
+// ClassExerciseReport 班级在一个课时练习上的作答报告
+type ClassExerciseReport struct {
+	CourseID   bson.ObjectId        `json:"courseID"`
+	LessonID   bson.ObjectId        `json:"lessonID"`
+	LessonName string               `json:"lessonName"`
+	FullScore  float64              `json:"fullScore"`
+	Threshold  float64              `json:"threshold"` //关注线，满分的百分比
+	Summary    exercise.Summary     `json:"summary"`
+	Items      []exercise.ItemStat  `json:"items"`
+	Students   []ExerciseStudentRow `json:"students"`
+	Attention  []ExerciseStudentRow `json:"attention"` //需要关注的学生，不含作答记录
+}
+
+// LessonQuestions 课时的练习题库，按课时ID单独保存，重新安装或升级课程时不会被覆盖
+type LessonQuestions struct {
+	LessonID    bson.ObjectId        `bson:"_id" json:"lessonID"`
+	CourseID    bson.ObjectId        `bson:"courseID" json:"courseID"`
+	Questions   []*exercise.Question `bson:"questions" json:"questions"`
+	MaxAttempts int                  `bson:"maxAttempts" json:"maxAttempts"` //作答次数上限，0表示不限
+	Reveal      string               `bson:"reveal" json:"reveal"`           //答案公布方式
+	Editor      bson.ObjectId        `bson:"editor,omitempty" json:"editor"` //创建题库的老师，课程包自带的题库为空，只有管理员能修改
+	UpdateTime  time.Time            `bson:"updateTime" json:"updateTime"`
+}
+
+// LessonExercise 课时练习的题目和学生的作答情况
+type LessonExercise struct {
+	CourseID    bson.ObjectId        `json:"courseID"`
+	LessonID    bson.ObjectId        `json:"lessonID"`
+	LessonName  string               `json:"lessonName"`
+	Questions   []*exercise.Question `json:"questions"`
+	FullScore   float64              `json:"fullScore"`
+	MaxAttempts int                  `json:"maxAttempts"` //作答次数上限，0表示不限
+	Reveal      string               `json:"reveal"`      //答案公布方式
+	Attempts    int                  `json:"attempts"`    //已作答次数
+	BestScore   float64              `json:"bestScore"`   //最高分
+	Revealed    bool                 `json:"revealed"`    //是否已公布答案
+}
+
+// FindCourse 查询课程及其全部课时和课时的练习题
+func (exerMod *ExerModels) FindCourse(courseID bson.ObjectId) (*Course, error) {
+	var course Course
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": courseID}).One(&course)
+	}
+	if err := exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "course", f); err != nil {
+		return nil, err
+	}
+	if err := loadLessonQuestions(exerMod.MgoSession, course.Lessions); err != nil {
+		return nil, err
+	}
+	return &course, nil
+}
+
+// FindLessonQuestions 查询课时的练习题库，没有题库时返回mgo.ErrNotFound
+func (exerMod *ExerModels) FindLessonQuestions(lessonID bson.ObjectId) (LessonQuestions, error) {
+	var bank LessonQuestions
+	f := func(col *mgo.Collection) error {
+		return col.FindId(lessonID).One(&bank)
+	}
+	err := exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "lesson_questions", f)
+	return bank, err
+}
+
+// FindCourseLesson 查询课程和其中的课时，不存在时返回mgo.ErrNotFound
+func (exerMod *ExerModels) FindCourseLesson(courseID, lessonID bson.ObjectId) (*Course, *Lession, error) {
+	course, err := exerMod.FindCourse(courseID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, lesson := range course.Lessions {
+		if lesson.ID == lessonID {
+			return course, lesson, nil
+		}
+	}
+	return nil, nil, mgo.ErrNotFound
+}
+
+// SaveLessonQuestions 设置课时的练习题、作答次数上限和答案公布方式，没有ID的题目自动生成ID。
+// editor 为创建题库的老师，只在新建题库时记录，课程包自带的题库为空
+func (exerMod *ExerModels) SaveLessonQuestions(courseID, lessonID bson.ObjectId, questions []*exercise.Question, maxAttempts int, reveal string, editor bson.ObjectId) error {
+	for _, q := range questions {
+		if q.ID == "" {
+			q.ID = bson.NewObjectId().Hex()
+		}
+	}
+	insert := bson.M{"courseID": courseID}
+	if editor.Valid() {
+		insert["editor"] = editor
+	}
+	f := func(col *mgo.Collection) error {
+		update := bson.M{
+			"$set": bson.M{
+				"questions":   questions,
+				"maxAttempts": maxAttempts,
+				"reveal":      reveal,
+				"updateTime":  time.Now(),
+			},
+			"$setOnInsert": insert,
+		}
+		_, err := col.UpsertId(lessonID, update)
+		return err
+	}
+	return exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "lesson_questions", f)
+}
+
+// ImportLessonQuestions 把导入的题目追加到课时练习或替换原有题目，导入的题目重新生成ID
+func (exerMod *ExerModels) ImportLessonQuestions(course *Course, lesson *Lession, questions []*exercise.Question, replace bool, editor bson.ObjectId) ([]*exercise.Question, error) {
+	for _, q := range questions {
+		q.ID = ""
+	}
+	merged := questions
+	if !replace {
+		merged = append(append([]*exercise.Question{}, lesson.Questions...), questions...)
+	}
+	err := exerMod.SaveLessonQuestions(course.ID, lesson.ID, merged, lesson.MaxAttempts, lesson.Reveal, editor)
+	return merged, err
+}
+
+// FindExercise 查询一次作答记录
+func (exerMod *ExerModels) FindExercise(id bson.ObjectId) (Exercise, error) {
+	var exer Exercise
+	f := func(col *mgo.Collection) error {
+		return col.FindId(id).One(&exer)
+	}
+	err := exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exercise", f)
+	return exer, err
+}
+
+// ScorePendingAnswer 老师为作答中需批阅的简答题评分，重新计算练习得分
+func (exerMod *ExerModels) ScorePendingAnswer(exer Exercise, questionID string, score float64) (Exercise, error) {
+	total, pending, err := exercise.ScorePending(exer.Results, questionID, score)
+	if err != nil {
+		return exer, err
+	}
+	exer.Score, exer.Pending = total, pending
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(exer.ID, bson.M{"$set": bson.M{"results": exer.Results, "score": exer.Score, "pending": exer.Pending}})
+	}
+	err = exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exercise", f)
+	return exer, err
+}
+
+// UserExercises 查询学生在课时下的全部作答记录，按作答先后排序
+func (exerMod *ExerModels) UserExercises(uid, courseID, lessonID bson.ObjectId) ([]Exercise, error) {
+	var exercises []Exercise
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"userID": uid, "courseID": courseID, "lessonID": lessonID}
+		return col.Find(query).Sort("attempt", "submitTime").All(&exercises)
+	}
+	err := exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exercise", f)
+	return exercises, err
+}
+
+// LessonExercise 返回学生视角的课时练习：未公布答案时去掉题目的答案和解析。
+// withAnswers 为true时（老师、管理员）总是返回答案
+func (exerMod *ExerModels) LessonExercise(uid bson.ObjectId, course *Course, lesson *Lession, withAnswers bool) (LessonExercise, error) {
+	le := LessonExercise{
+		CourseID:    course.ID,
+		LessonID:    lesson.ID,
+		LessonName:  lesson.Name,
+		FullScore:   exercise.FullScore(lesson.Questions),
+		MaxAttempts: lesson.MaxAttempts,
+		Reveal:      lesson.Reveal,
+	}
+	if uid.Valid() {
+		history, err := exerMod.UserExercises(uid, course.ID, lesson.ID)
+		if err != nil {
+			return le, err
+		}
+		le.Attempts = len(history)
+		for _, item := range history {
+			if item.Score > le.BestScore {
+				le.BestScore = item.Score
+			}
+		}
+		le.Revealed = exercise.ShouldReveal(lesson.Reveal, le.Attempts, lesson.MaxAttempts, le.Attempts > 0 && le.BestScore >= le.FullScore)
+	}
+	if withAnswers || le.Revealed {
+		le.Questions = lesson.Questions
+	} else {
+		le.Questions = exercise.Public(lesson.Questions)
+	}
+	return le, nil
+}
+
+// SubmitExercise 评分并保存学生的一次作答，超过作答次数上限时返回ErrExerciseAttemptsExhausted
+func (exerMod *ExerModels) SubmitExercise(uid bson.ObjectId, course *Course, lesson *Lession, answers []exercise.Answer) (Exercise, error) {
+	history, err := exerMod.UserExercises(uid, course.ID, lesson.ID)
+	if err != nil {
+		return Exercise{}, err
+	}
+	if lesson.MaxAttempts > 0 && len(history) >= lesson.MaxAttempts {
+		return Exercise{}, ErrExerciseAttemptsExhausted
+	}
+	now := time.Now()
+	duration, err := exerMod.takeExerciseStart(uid, lesson.ID, now)
+	if err != nil {
+		return Exercise{}, err
+	}
+	result := exercise.Grade(lesson.Questions, answers)
+	exer := Exercise{
+		ID:          bson.NewObjectId(),
+		CourseID:    course.ID,
+		LessonID:    lesson.ID,
+		UserID:      uid,
+		CouerseName: course.Name,
+		LessonName:  lesson.Name,
+		Attempt:     len(history) + 1,
+		Answers:     answers,
+		Results:     result.Questions,
+		Score:       result.Score,
+		FullScore:   result.FullScore,
+		Pending:     result.Pending,
+		SubmitTime:  now,
+		Duration:    duration,
+	}
 	f := func(col *mgo.Collection) error {
 		return col.Insert(exer)
 	}
-	return exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exercise", f)
+	err = exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exercise", f)
+	return exer, err
+}
+
+// RecordExerciseStart 记录学生打开课时练习的时间，用于统计作答用时
+func (exerMod *ExerModels) RecordExerciseStart(uid, lessonID bson.ObjectId) error {
+	f := func(col *mgo.Collection) error {
+		_, err := col.Upsert(bson.M{"userID": uid, "lessonID": lessonID}, bson.M{"$set": bson.M{"startTime": time.Now()}})
+		return err
+	}
+	return exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exercisestart", f)
+}
+
+// ClassExerciseReport 统计班级学生在课时练习上的作答情况。
+// threshold 为关注线（满分的百分比），最高分低于关注线或还没有作答的学生列入关注名单
+func (exerMod *ExerModels) ClassExerciseReport(class Class, course *Course, lesson *Lession, threshold float64) (ClassExerciseReport, error) {
+	report := ClassExerciseReport{
+		CourseID:   course.ID,
+		LessonID:   lesson.ID,
+		LessonName: lesson.Name,
+		FullScore:  exercise.FullScore(lesson.Questions),
+		Threshold:  threshold,
+		Items:      []exercise.ItemStat{},
+		Students:   []ExerciseStudentRow{},
+		Attention:  []ExerciseStudentRow{},
+	}
+	uids := make([]bson.ObjectId, 0, len(class.Students))
+	for _, s := range class.Students {
+		uids = append(uids, s.UserID)
+	}
+	var users []User
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": bson.M{"$in": uids}}).Select(bson.M{"username": 1, "realname": 1}).Sort("username").All(&users)
+	}
+	if err := exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "users", f); err != nil {
+		return report, err
+	}
+	var exercises []Exercise
+	f = func(col *mgo.Collection) error {
+		query := bson.M{"courseID": course.ID, "lessonID": lesson.ID, "userID": bson.M{"$in": uids}}
+		return col.Find(query).Sort("attempt", "submitTime").All(&exercises)
+	}
+	if err := exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exercise", f); err != nil {
+		return report, err
+	}
+
+	byUser := make(map[bson.ObjectId][]Exercise)
+	subs := make([]exercise.Submission, 0, len(exercises))
+	for _, exer := range exercises {
+		byUser[exer.UserID] = append(byUser[exer.UserID], exer)
+		subs = append(subs, exercise.Submission{
+			UserID:   exer.UserID.Hex(),
+			Attempt:  exer.Attempt,
+			Answers:  exer.Answers,
+			Results:  exer.Results,
+			Score:    exer.Score,
+			Duration: exer.Duration,
+		})
+	}
+	report.Items, report.Summary = exercise.Analyze(lesson.Questions, subs)
+
+	line := report.FullScore * threshold / 100
+	for _, user := range users {
+		row := ExerciseStudentRow{UserID: user.ID, Username: user.Username, Realname: user.Realname, Submissions: byUser[user.ID]}
+		if row.Submissions == nil {
+			row.Submissions = []Exercise{}
+		}
+		for _, exer := range row.Submissions {
+			if exer.Score > row.BestScore {
+				row.BestScore = exer.Score
+			}
+		}
+		if n := len(row.Submissions); n > 0 {
+			last := row.Submissions[n-1]
+			row.Attempts = n
+			row.LastScore = last.Score
+			row.LastSubmit = &last.SubmitTime
+			row.Pending = last.Pending
+		}
+		switch {
+		case row.Attempts == 0:
+			row.Attention = AttentionNotSubmitted
+		case row.BestScore < line:
+			row.Attention = AttentionLowScore
+		}
+		report.Students = append(report.Students, row)
+		if row.Attention != "" {
+			row.Submissions = nil
+			report.Attention = append(report.Attention, row)
+		}
+	}
+	return report, nil
+}
+
+// LessonAttention 一个课时练习中需要关注的学生
+type LessonAttention struct {
+	LessonID   bson.ObjectId        `json:"lessonID"`
+	LessonName string               `json:"lessonName"`
+	FullScore  float64              `json:"fullScore"`
+	Students   []ExerciseStudentRow `json:"students"`
+}
+
+// ClassExerciseAttention 汇总班级在课程所有课时练习中需要关注的学生
+func (exerMod *ExerModels) ClassExerciseAttention(class Class, course *Course, threshold float64) ([]LessonAttention, error) {
+	list := []LessonAttention{}
+	for _, lesson := range course.Lessions {
+		if len(lesson.Questions) == 0 {
+			continue
+		}
+		report, err := exerMod.ClassExerciseReport(class, course, lesson, threshold)
+		if err != nil {
+			return nil, err
+		}
+		if len(report.Attention) > 0 {
+			list = append(list, LessonAttention{LessonID: lesson.ID, LessonName: lesson.Name, FullScore: report.FullScore, Students: report.Attention})
+		}
+	}
+	return list, nil
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// takeExerciseStart 取出并删除学生打开练习的时间，返回到now的作答用时（秒），没有记录或用时过长时返回0
+func (exerMod *ExerModels) takeExerciseStart(uid, lessonID bson.ObjectId, now time.Time) (int64, error) {
+	var start struct {
+		StartTime time.Time `bson:"startTime"`
+	}
+	f := func(col *mgo.Collection) error {
+		_, err := col.Find(bson.M{"userID": uid, "lessonID": lessonID}).Apply(mgo.Change{Remove: true}, &start)
+		return err
+	}
+	err := exerMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exercisestart", f)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	d := now.Sub(start.StartTime)
+	if d <= 0 || d > maxExerciseDuration {
+		return 0, nil
+	}
+	return int64(d / time.Second), nil
 }
-func (exerMod *ExerModels) GetExercise(courseID bson.ObjectId) {
 
+// loadLessonQuestions 从 lesson_questions 读取各课时的练习题
+func loadLessonQuestions(session *mongo.MgoClient, lessons []*Lession) error {
+	ids := make([]bson.ObjectId, 0, len(lessons))
+	for _, lesson := range lessons {
+		ids = append(ids, lesson.ID)
+	}
+	var banks []LessonQuestions
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": bson.M{"$in": ids}}).All(&banks)
+	}
+	if err := session.Do(beego.AppConfig.String("MongoDB"), "lesson_questions", f); err != nil {
+		return err
+	}
+	byLesson := make(map[bson.ObjectId]LessonQuestions, len(banks))
+	for _, bank := range banks {
+		byLesson[bank.LessonID] = bank
+	}
+	for _, lesson := range lessons {
+		if bank, ok := byLesson[lesson.ID]; ok {
+			lesson.Questions = bank.Questions
+			lesson.MaxAttempts = bank.MaxAttempts
+			lesson.Reveal = bank.Reveal
+		}
+	}
+	return nil
 }
@@ -0,0 +1,203 @@
+// @Title 作品批改模型
+// @Description 老师给学生提交的课时作品打分、按评分细则给分并写文字或语音评语；截止时间前学生可修改作品重新提交
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+)
+
+// 批改状态
+const (
+	GradeGraded      = "graded"      //已批改
+	GradeResubmitted = "resubmitted" //学生已重新提交，等待重新批改
+)
+
+// FeedbackAudioDir 语音评语在存储中的目录，不在 /asset 静态目录下，只能通过批改接口获取
+const FeedbackAudioDir = "feedback"
+
+type GradeModels struct {
+	MgoSession *mongo.MgoClient
+	MessageMod MessageModels
+}
+
+// RubricItem 评分细则中的一项
+type RubricItem struct {
+	Criterion string  `bson:"criterion" json:"criterion"` //评分项
+	Score     float64 `bson:"score" json:"score"`
+	FullScore float64 `bson:"fullScore" json:"fullScore"`
+	Comment   string  `bson:"comment" json:"comment"`
+}
+
+// WorkGrade 作品的批改结果，每个作品一条
+type WorkGrade struct {
+	ID            bson.ObjectId `bson:"_id" json:"id"`
+	WorkID        bson.ObjectId `bson:"workID" json:"workID"`
+	WorkName      string        `bson:"workName" json:"workName"`
+	UserID        bson.ObjectId `bson:"userID" json:"userID"` //作品的作者
+	ClassCode     string        `bson:"classCode" json:"classCode"`
+	LessonID      bson.ObjectId `bson:"lessonID" json:"lessonID"` //作品对应的课节(BaseBody.ContentID)
+	Score         float64       `bson:"score" json:"score"`
+	FullScore     float64       `bson:"fullScore" json:"fullScore"`
+	Rubric        []RubricItem  `bson:"rubric" json:"rubric"`
+	Feedback      string        `bson:"feedback" json:"feedback"` //文字评语
+	AudioURL      string        `bson:"audioURL" json:"audioURL"` //语音评语在存储中的文件名，通过 /works/grade/audio 获取
+	Grader        bson.ObjectId `bson:"grader" json:"grader"`
+	GradeTime     time.Time     `bson:"gradeTime" json:"gradeTime"`
+	ResubmitDue   time.Time     `bson:"resubmitDue" json:"resubmitDue"` //重新提交截止时间，为空时不允许重新提交
+	Status        string        `bson:"status" json:"status"`
+	Resubmissions int           `bson:"resubmissions" json:"resubmissions"` //重新提交次数
+	ResubmitTime  time.Time     `bson:"resubmitTime,omitempty" json:"resubmitTime,omitempty"`
+}
+
+// CanResubmit 在now时是否还能重新提交
+func (grade *WorkGrade) CanResubmit(now time.Time) bool {
+	return !grade.ResubmitDue.IsZero() && now.Before(grade.ResubmitDue)
+}
+
+// RubricScore 校验分数和评分细则；有评分细则时总分和满分由细则累加得到
+func RubricScore(score, fullScore float64, rubric []RubricItem) (float64, float64, bool) {
+	if len(rubric) > 0 {
+		score, fullScore = 0, 0
+		for _, item := range rubric {
+			if item.Criterion == "" || item.FullScore <= 0 || item.Score < 0 || item.Score > item.FullScore {
+				return 0, 0, false
+			}
+			score += item.Score
+			fullScore += item.FullScore
+		}
+	}
+	if fullScore <= 0 || score < 0 || score > fullScore {
+		return 0, 0, false
+	}
+	return score, fullScore, true
+}
+
+// SaveWorkGrade 保存批改结果并通知学生，重新批改时覆盖上一次的结果
+func (gradeMod *GradeModels) SaveWorkGrade(grade *WorkGrade, class Class) error {
+	grade.GradeTime = time.Now()
+	grade.Status = GradeGraded
+	f := func(col *mgo.Collection) error {
+		change := mgo.Change{
+			Update: bson.M{
+				"$set": bson.M{
+					"workName": grade.WorkName, "userID": grade.UserID, "classCode": grade.ClassCode, "lessonID": grade.LessonID,
+					"score": grade.Score, "fullScore": grade.FullScore, "rubric": grade.Rubric, "feedback": grade.Feedback,
+					"audioURL": grade.AudioURL, "grader": grade.Grader, "gradeTime": grade.GradeTime,
+					"resubmitDue": grade.ResubmitDue, "status": grade.Status,
+				},
+				"$setOnInsert": bson.M{"_id": bson.NewObjectId(), "resubmissions": 0},
+			},
+			Upsert:    true,
+			ReturnNew: true,
+		}
+		_, err := col.Find(bson.M{"workID": grade.WorkID}).Apply(change, grade)
+		return err
+	}
+	if err := gradeMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workgrade", f); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("你的作品“%s”已批改，得分%g/%g。", grade.WorkName, grade.Score, grade.FullScore)
+	if !grade.ResubmitDue.IsZero() {
+		content += fmt.Sprintf("可在%s前修改作品后重新提交。", grade.ResubmitDue.Local().Format("2006-01-02 15:04"))
+	}
+	return gradeMod.MessageMod.PublishSystemMessage("作品已批改", content, class.Name, class.Logo, []bson.ObjectId{grade.UserID})
+}
+
+// FindWorkGrade 查询作品的批改结果
+func (gradeMod *GradeModels) FindWorkGrade(workID bson.ObjectId) (WorkGrade, error) {
+	var grade WorkGrade
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"workID": workID}).One(&grade)
+	}
+	err := gradeMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workgrade", f)
+	return grade, err
+}
+
+// UserGrades 查询学生所有作品的批改结果，最近批改的在前
+func (gradeMod *GradeModels) UserGrades(uid bson.ObjectId) ([]WorkGrade, error) {
+	grades := []WorkGrade{}
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"userID": uid}).Sort("-gradeTime").All(&grades)
+	}
+	err := gradeMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workgrade", f)
+	return grades, err
+}
+
+// ClassGrades 查询班级的批改结果，返回 学生 -> 课节 -> 最高分的批改结果
+func (gradeMod *GradeModels) ClassGrades(classCode string) (map[bson.ObjectId]map[bson.ObjectId]WorkGrade, error) {
+	var grades []WorkGrade
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"classCode": classCode}).All(&grades)
+	}
+	if err := gradeMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workgrade", f); err != nil {
+		return nil, err
+	}
+	result := make(map[bson.ObjectId]map[bson.ObjectId]WorkGrade)
+	for _, g := range grades {
+		lessons, ok := result[g.UserID]
+		if !ok {
+			lessons = make(map[bson.ObjectId]WorkGrade)
+			result[g.UserID] = lessons
+		}
+		if best, ok := lessons[g.LessonID]; !ok || g.Score/g.FullScore > best.Score/best.FullScore {
+			lessons[g.LessonID] = g
+		}
+	}
+	return result, nil
+}
+
+// CanResubmit 学生修改作品前检查：未批改的作品或在重新提交截止时间前返回true，只读不修改批改状态
+func (gradeMod *GradeModels) CanResubmit(workID bson.ObjectId, now time.Time) (bool, error) {
+	grade, err := gradeMod.FindWorkGrade(workID)
+	if err == mgo.ErrNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return grade.CanResubmit(now), nil
+}
+
+// MarkResubmitted 学生修改已批改的作品并保存成功后调用，标记为已重新提交等待重新批改；未批改的作品直接返回
+func (gradeMod *GradeModels) MarkResubmitted(workID bson.ObjectId, now time.Time) error {
+	grade, err := gradeMod.FindWorkGrade(workID)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f := func(col *mgo.Collection) error {
+		update := bson.M{"$set": bson.M{"status": GradeResubmitted, "resubmitTime": now}}
+		if grade.Status != GradeResubmitted {
+			update["$inc"] = bson.M{"resubmissions": 1}
+		}
+		return col.UpdateId(grade.ID, update)
+	}
+	return gradeMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workgrade", f)
+}
+
+// LessonWorkLocked 学生在该课节有已批改且设置的重新提交截止时间已过的作品时返回true，此时不能再为该课节新建或复制作品。
+// 没有设置截止时间的批改只锁定作品本身（见 CanResubmit），不影响该课节的其他作品
+func (gradeMod *GradeModels) LessonWorkLocked(uid, lessonID bson.ObjectId, now time.Time) (bool, error) {
+	var grades []WorkGrade
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"userID": uid, "lessonID": lessonID}).All(&grades)
+	}
+	if err := gradeMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workgrade", f); err != nil {
+		return false, err
+	}
+	for _, grade := range grades {
+		if !grade.ResubmitDue.IsZero() && !now.Before(grade.ResubmitDue) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,26 @@
+// @Title 课时markdown模型
+// @Description 渲染学习资源的markdown，渲染服务见 services/markdown
+
+package models
+
+import (
+	"errors"
+	"path"
+
+	"maiyajia.com/services/markdown"
+	"maiyajia.com/services/storage"
+)
+
+// RenderContentMarkdown 渲染学习资源的markdown，相对链接改写到markdown文件所在的课程资源目录
+func (courseMod *CourseModels) RenderContentMarkdown(content *Content) (markdown.Result, error) {
+	if content.MdURL == "" || isRemoteURL(content.MdURL) {
+		return markdown.Result{}, errors.New("content has no local markdown")
+	}
+	file := path.Clean("/" + content.MdURL)
+	opt := markdown.Options{BaseURL: path.Dir(file) + "/"}
+	src, err := storage.ReadFile(Assets(), file[1:])
+	if err != nil {
+		return markdown.Result{}, err
+	}
+	return markdown.DefaultCache.Render(src, opt), nil
+}
@@ -0,0 +1,337 @@
+// @Title 3D打印模型
+// @Description 检查STL作品的几何形状是否适合打印，按打印机配置估算打印时间和耗材用量；
+// 班级打印队列：提交作品、老师审核排序、按顺序发送到打印机，打印状态以消息通知学生
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/astaxie/beego"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/printer"
+	"maiyajia.com/services/stl"
+)
+
+type PrintModels struct {
+	MgoSession *mongo.MgoClient
+	MessageMod MessageModels
+}
+
+// 打印任务状态
+const (
+	PrintPending   = "pending"   //等待老师审核
+	PrintApproved  = "approved"  //已审核，排队等待打印
+	PrintPrinting  = "printing"  //正在打印
+	PrintDone      = "done"      //打印完成
+	PrintFailed    = "failed"    //打印失败
+	PrintRejected  = "rejected"  //老师未通过审核
+	PrintCancelled = "cancelled" //已取消
+)
+
+// 打印任务状态的中文名称，用于消息
+var printStatusNames = map[string]string{
+	PrintApproved:  "已通过审核，正在排队",
+	PrintPrinting:  "开始打印",
+	PrintDone:      "打印完成，请找老师领取",
+	PrintFailed:    "打印失败",
+	PrintRejected:  "未通过审核",
+	PrintCancelled: "已取消",
+}
+
+// ErrPrintJobExists 作品已经在打印队列中
+var ErrPrintJobExists = errors.New("work already in print queue")
+
+// PrintJob 打印任务
+type PrintJob struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	WorkID     bson.ObjectId `bson:"workID" json:"workID"`
+	WorkName   string        `bson:"workName" json:"workName"`
+	UserID     bson.ObjectId `bson:"userID" json:"userID"`       //作品作者，接收打印状态消息
+	Submitter  bson.ObjectId `bson:"submitter" json:"submitter"` //提交人，学生或老师
+	PrinterID  string        `bson:"printerID" json:"printerID"`
+	Status     string        `bson:"status" json:"status"`
+	Position   int           `bson:"position" json:"position"` //审核后在打印机队列中的顺序，从1开始
+	Note       string        `bson:"note" json:"note"`
+	Reason     string        `bson:"reason,omitempty" json:"reason,omitempty"` //未通过审核或打印失败的原因
+	File       string        `bson:"file,omitempty" json:"file,omitempty"`     //打印机上的文件名
+	Progress   float64       `bson:"progress" json:"progress"`
+	Approver   bson.ObjectId `bson:"approver,omitempty" json:"approver,omitempty"`
+	SubmitTime time.Time     `bson:"submitTime" json:"submitTime"`
+	StartTime  time.Time     `bson:"startTime,omitempty" json:"startTime,omitempty"`
+	FinishTime time.Time     `bson:"finishTime,omitempty" json:"finishTime,omitempty"`
+}
+
+// Active 任务还在队列中
+func (job PrintJob) Active() bool {
+	return job.Status == PrintPending || job.Status == PrintApproved || job.Status == PrintPrinting
+}
+
+// PrintQueueItem 打印队列中的任务，附带作者姓名和作品的打印检查报告
+type PrintQueueItem struct {
+	PrintJob `bson:",inline"`
+	Realname string       `bson:"realname" json:"realname"`
+	Report   *PrintReport `bson:"report" json:"report"`
+}
+
+// PrintReport STL作品的打印检查报告，保存在作品的 printReport 字段
+type PrintReport struct {
+	stl.Report `bson:",inline"`
+	Estimates  []printer.Estimate `bson:"estimates" json:"estimates"` //各打印机的估算结果
+	Printable  bool               `bson:"printable" json:"printable"` //网格封闭且至少一台打印机放得下
+	FileTime   time.Time          `bson:"fileTime" json:"fileTime"`   //检查时作品文件的修改时间
+	CheckTime  time.Time          `bson:"checkTime" json:"checkTime"`
+}
+
+// PrinterConfig 读取打印机配置，配置文件为 printer_profiles（默认 conf/printers.json）
+func PrinterConfig() (printer.Config, error) {
+	return printer.LoadConfig(path.Join(beego.AppPath, beego.AppConfig.DefaultString("printer_profiles", "conf/printers.json")))
+}
+
+// PrinterProfiles 读取打印参数
+func PrinterProfiles() ([]printer.Profile, error) {
+	c, err := PrinterConfig()
+	return c.Profiles, err
+}
+
+// UpdatePrintReport 检查作品的STL文件并按各打印机配置估算，报告保存到作品
+func (printMod *PrintModels) UpdatePrintReport(workID bson.ObjectId) (*PrintReport, error) {
+	blobMod := BlobModels{MgoSession: printMod.MgoSession}
+	file, err := blobMod.StatWorkFile(workID.Hex(), "stl")
+	if err != nil {
+		return nil, err
+	}
+	mesh, err := readWorkModel(workID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := PrinterProfiles()
+	if err != nil {
+		return nil, err
+	}
+	report := &PrintReport{Report: mesh.Check(), FileTime: file.UpdateTime, CheckTime: time.Now()}
+	for _, p := range profiles {
+		e := p.Estimate(report.Report)
+		report.Estimates = append(report.Estimates, e)
+		report.Printable = report.Printable || e.FitsBed
+	}
+	report.Printable = report.Printable && report.Watertight && report.Triangles > 0
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(workID, bson.M{"$set": bson.M{"printReport": report}})
+	}
+	return report, printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+}
+
+// WorkPrintReport 查询作品的打印检查报告，没有报告或作品文件修改过时重新检查
+func (printMod *PrintModels) WorkPrintReport(work WorkBody) (*PrintReport, error) {
+	if work.PrintReport != nil {
+		blobMod := BlobModels{MgoSession: printMod.MgoSession}
+		file, err := blobMod.StatWorkFile(work.ID.Hex(), "stl")
+		if err == nil && !file.UpdateTime.After(work.PrintReport.FileTime) {
+			return work.PrintReport, nil
+		}
+	}
+	return printMod.UpdatePrintReport(work.ID)
+}
+
+// STLWorksWithoutReport 查询还没有打印检查报告的STL作品
+func (printMod *PrintModels) STLWorksWithoutReport() ([]WorkBody, error) {
+	var works []WorkBody
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"relpath": bson.M{"$regex": `\.stl$`}, "printReport": bson.M{"$exists": false}}
+		return col.Find(query).Select(bson.M{"_id": 1}).All(&works)
+	}
+	err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+	return works, err
+}
+
+// SubmitPrintJob 把作品提交到打印机的队列，等待老师审核。同一作品只能有一个未完成的任务
+func (printMod *PrintModels) SubmitPrintJob(work WorkBody, submitter bson.ObjectId, printerID, note string) (*PrintJob, error) {
+	var active []PrintJob
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"workID": work.ID, "status": bson.M{"$in": []string{PrintPending, PrintApproved, PrintPrinting}}}).All(&active)
+	}
+	if err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f); err != nil {
+		return nil, err
+	}
+	if len(active) > 0 {
+		return nil, ErrPrintJobExists
+	}
+	job := &PrintJob{
+		ID:         bson.NewObjectId(),
+		WorkID:     work.ID,
+		WorkName:   work.Name,
+		UserID:     work.UserID,
+		Submitter:  submitter,
+		PrinterID:  printerID,
+		Status:     PrintPending,
+		Note:       note,
+		SubmitTime: time.Now(),
+	}
+	ff := func(col *mgo.Collection) error {
+		return col.Insert(job)
+	}
+	return job, printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", ff)
+}
+
+// FindPrintJob 查询打印任务
+func (printMod *PrintModels) FindPrintJob(jobID bson.ObjectId) (PrintJob, error) {
+	var job PrintJob
+	f := func(col *mgo.Collection) error {
+		return col.FindId(jobID).One(&job)
+	}
+	err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f)
+	return job, err
+}
+
+// PrintQueue 查询打印机队列中未完成的任务：正在打印的在前，其次是已审核的按顺序，最后是待审核的按提交时间
+func (printMod *PrintModels) PrintQueue(printerID string) ([]PrintQueueItem, error) {
+	items := []PrintQueueItem{}
+	pipeline := []bson.M{
+		{"$match": bson.M{"printerID": printerID, "status": bson.M{"$in": []string{PrintPending, PrintApproved, PrintPrinting}}}},
+		{"$lookup": bson.M{"from": "users", "localField": "userID", "foreignField": "_id", "as": "user"}},
+		{"$lookup": bson.M{"from": "works", "localField": "workID", "foreignField": "_id", "as": "work"}},
+		{"$addFields": bson.M{
+			"realname": bson.M{"$arrayElemAt": []interface{}{"$user.realname", 0}},
+			"report":   bson.M{"$arrayElemAt": []interface{}{"$work.printReport", 0}},
+		}},
+		{"$project": bson.M{"user": 0, "work": 0}},
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Pipe(pipeline).All(&items)
+	}
+	if err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f); err != nil {
+		return items, err
+	}
+	rank := map[string]int{PrintPrinting: 0, PrintApproved: 1, PrintPending: 2}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if rank[a.Status] != rank[b.Status] {
+			return rank[a.Status] < rank[b.Status]
+		}
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.SubmitTime.Before(b.SubmitTime)
+	})
+	return items, nil
+}
+
+// UserPrintJobs 查询学生作品的打印任务，新任务在前
+func (printMod *PrintModels) UserPrintJobs(userID bson.ObjectId) ([]PrintJob, error) {
+	jobs := []PrintJob{}
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"userID": userID}).Sort("-submitTime").Limit(100).All(&jobs)
+	}
+	err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f)
+	return jobs, err
+}
+
+// ApprovePrintJob 老师审核通过打印任务，可以换到另一台打印机，排到该打印机队列的末尾
+func (printMod *PrintModels) ApprovePrintJob(job PrintJob, printerID string, approver bson.ObjectId) error {
+	var last PrintJob
+	f := func(col *mgo.Collection) error {
+		err := col.Find(bson.M{"printerID": printerID, "status": PrintApproved}).Sort("-position").One(&last)
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f); err != nil {
+		return err
+	}
+	ff := func(col *mgo.Collection) error {
+		return col.Update(bson.M{"_id": job.ID, "status": PrintPending}, bson.M{"$set": bson.M{
+			"status": PrintApproved, "printerID": printerID, "position": last.Position + 1, "approver": approver}})
+	}
+	if err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", ff); err != nil {
+		return err
+	}
+	return printMod.notify(job, PrintApproved, "")
+}
+
+// ReorderPrintQueue 按jobIDs的顺序重新排列打印机队列中已审核的任务
+func (printMod *PrintModels) ReorderPrintQueue(printerID string, jobIDs []bson.ObjectId) error {
+	for i, id := range jobIDs {
+		f := func(col *mgo.Collection) error {
+			return col.Update(bson.M{"_id": id, "printerID": printerID, "status": PrintApproved}, bson.M{"$set": bson.M{"position": i + 1}})
+		}
+		if err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NextPrintJob 打印机队列中下一个要打印的任务
+func (printMod *PrintModels) NextPrintJob(printerID string) (PrintJob, error) {
+	var job PrintJob
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"printerID": printerID, "status": PrintApproved}).Sort("position", "submitTime").One(&job)
+	}
+	err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f)
+	return job, err
+}
+
+// PrintingJob 打印机正在打印的任务
+func (printMod *PrintModels) PrintingJob(printerID string) (PrintJob, error) {
+	var job PrintJob
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"printerID": printerID, "status": PrintPrinting}).One(&job)
+	}
+	err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f)
+	return job, err
+}
+
+// StartPrintJob 任务已发送到打印机，file 为打印机上的文件名
+func (printMod *PrintModels) StartPrintJob(job PrintJob, file string) error {
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(job.ID, bson.M{"$set": bson.M{"status": PrintPrinting, "file": file, "progress": 0, "startTime": time.Now()}})
+	}
+	if err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f); err != nil {
+		return err
+	}
+	return printMod.notify(job, PrintPrinting, "")
+}
+
+// UpdatePrintProgress 更新打印进度
+func (printMod *PrintModels) UpdatePrintProgress(job PrintJob, progress float64) error {
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(job.ID, bson.M{"$set": bson.M{"progress": progress}})
+	}
+	return printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f)
+}
+
+// FinishPrintJob 结束打印任务：完成、失败、未通过审核或取消，并通知学生
+func (printMod *PrintModels) FinishPrintJob(job PrintJob, status, reason string) error {
+	set := bson.M{"status": status, "reason": reason, "finishTime": time.Now()}
+	if status == PrintDone {
+		set["progress"] = 100
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Update(bson.M{"_id": job.ID, "status": job.Status}, bson.M{"$set": set})
+	}
+	if err := printMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "printjob", f); err != nil {
+		return err
+	}
+	return printMod.notify(job, status, reason)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// notify 给作品作者发送打印状态消息
+func (printMod *PrintModels) notify(job PrintJob, status, reason string) error {
+	content := fmt.Sprintf("你的作品《%s》%s", job.WorkName, printStatusNames[status])
+	if reason != "" {
+		content += "：" + reason
+	}
+	return printMod.MessageMod.PublishSystemMessage("3D打印", content, "", "", []bson.ObjectId{job.UserID})
+}
@@ -150,9 +150,21 @@ func (progMod *ProgressModels) QueryStudentsProgress(classCode, lessionID string
 		{
 			"$match": bson.M{"learned.finishItems": bson.M{"$eq": bson.ObjectIdHex(lessionID)}},
 		},
+		// 学生在该课时的Scratch作品评估结果
+		{"$lookup": bson.M{
+			"from": "works",
+			"let":  bson.M{"userID": "$students.userID"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"contentID": bson.ObjectIdHex(lessionID), "assessment": bson.M{"$exists": true}}},
+				{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$userID", "$$userID"}}}},
+				{"$project": bson.M{"_id": 0, "id": "$_id", "name": 1, "assessment.total": 1, "assessment.level": 1, "assessment.passed": 1, "assessment.checks": 1}},
+			},
+			"as": "assessments",
+		}},
 		{"$project": bson.M{
 			"_id":             0,
 			"students.userID": 1,
+			"assessments":     1,
 		}},
 	}
 	f := func(col *mgo.Collection) error {
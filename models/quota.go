@@ -0,0 +1,465 @@
+// @Title 存储配额模型
+// @Description 统计每个作品文件占用的空间，按用户汇总；还没有保存作品信息的文件和未完成的上传计入上传者。
+// 配额按用户、班级、角色依次确定，管理员可以覆盖班级和角色的默认配额
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+)
+
+// 配额范围
+const (
+	QuotaUser  = "user"  //单个用户
+	QuotaClass = "class" //班级里的学生
+	QuotaRole  = "role"  //角色的默认配额
+)
+
+// ErrQuotaExceeded 写入后超出存储配额
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// 计入配额的作品文件，服务器生成的预览图不计入
+var workFileSuffixes = []string{"stl", "Z1", "sgl", "sb3"}
+
+type QuotaModels struct {
+	MgoSession *mongo.MgoClient
+}
+
+// Quota 管理员设置的配额，Limit 单位为字节，0表示不限制
+type Quota struct {
+	ID         string    `bson:"_id" json:"id"`
+	Scope      string    `bson:"scope" json:"scope"`
+	Target     string    `bson:"target" json:"target"` //用户ID、班级代码或角色名
+	Limit      int64     `bson:"limit" json:"limit"`
+	UpdateTime time.Time `bson:"updateTime" json:"updateTime"`
+}
+
+// StorageUsage 用户的存储空间使用情况，Source 为配额的来源（user、class、role）
+type StorageUsage struct {
+	UserID    bson.ObjectId `bson:"_id" json:"userID"`
+	Username  string        `bson:"username,omitempty" json:"username,omitempty"`
+	Realname  string        `bson:"realname,omitempty" json:"realname,omitempty"`
+	Used      int64         `bson:"used" json:"used"`
+	Works     int           `bson:"works" json:"works"`
+	Pending   int64         `bson:"-" json:"pending"`   //其中还没有保存作品信息的文件和未完成的上传
+	Revisions int64         `bson:"-" json:"revisions"` //其中作品历史版本的文件
+	Limit     int64         `bson:"-" json:"limit"`
+	Source    string        `bson:"-" json:"source"`
+}
+
+// Exceeded 是否已经超出配额
+func (usage *StorageUsage) Exceeded() bool {
+	return usage.Limit > 0 && usage.Used > usage.Limit
+}
+
+// StorageReport 作品存储空间的汇总
+type StorageReport struct {
+	Used     int64 `json:"used"`     //所有作品文件占用的空间
+	Works    int   `json:"works"`    //作品数
+	Users    int   `json:"users"`    //有作品的用户数
+	Exceeded int   `json:"exceeded"` //超出配额的用户数
+}
+
+// PendingWorkFile 已上传但还没有保存作品信息的作品文件，计入上传者的配额
+type PendingWorkFile struct {
+	WorkID     bson.ObjectId `bson:"_id" json:"workID"`
+	UserID     bson.ObjectId `bson:"userID" json:"userID"`
+	Size       int64         `bson:"size" json:"size"`
+	CreateTime time.Time     `bson:"createTime" json:"createTime"`
+	UpdateTime time.Time     `bson:"updateTime" json:"updateTime"`
+}
+
+// QuotaID 配额记录的ID
+func QuotaID(scope, target string) string {
+	return scope + ":" + target
+}
+
+// UpdateWorkStorage 重新统计作品文件占用的空间，保存到作品的 storage 字段
+func (quotaMod *QuotaModels) UpdateWorkStorage(workID bson.ObjectId) (int64, error) {
+	blobMod := BlobModels{MgoSession: quotaMod.MgoSession}
+	size := blobMod.WorkFilesSize(workID.Hex())
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(workID, bson.M{"$set": bson.M{"storage": size}})
+	}
+	err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+	if err == mgo.ErrNotFound {
+		// 先上传文件、后保存作品信息时作品还不存在，保存作品信息时再统计
+		return size, nil
+	}
+	if err != nil {
+		return size, err
+	}
+	// 作品信息已保存，文件改为计入作品
+	ff := func(col *mgo.Collection) error {
+		return col.RemoveId(workID)
+	}
+	if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "pendingworkfile", ff); err != nil && err != mgo.ErrNotFound {
+		return size, err
+	}
+	return size, nil
+}
+
+// TrackPendingWorkFiles 作品还没有保存作品信息时，把作品文件记为上传者userID的待登记文件计入配额。
+// 第一次上传的用户为文件的上传者
+func (quotaMod *QuotaModels) TrackPendingWorkFiles(workID, userID bson.ObjectId) error {
+	exists, err := quotaMod.workExists(workID)
+	if err != nil || exists {
+		return err
+	}
+	blobMod := BlobModels{MgoSession: quotaMod.MgoSession}
+	size := blobMod.WorkFilesSize(workID.Hex())
+	now := time.Now()
+	f := func(col *mgo.Collection) error {
+		update := bson.M{
+			"$set":         bson.M{"size": size, "updateTime": now},
+			"$setOnInsert": bson.M{"userID": userID, "createTime": now},
+		}
+		_, err := col.UpsertId(workID, update)
+		return err
+	}
+	return quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "pendingworkfile", f)
+}
+
+// RemoveExpiredPendingWorkFiles 删除最后一次上传后 pending_work_file_hours（默认24）小时仍没有保存作品信息的作品文件及其版本，
+// 返回删除的作品数
+func (quotaMod *QuotaModels) RemoveExpiredPendingWorkFiles() (int, error) {
+	hours := beego.AppConfig.DefaultInt("pending_work_file_hours", 24)
+	var pending []PendingWorkFile
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"updateTime": bson.M{"$lte": time.Now().Add(-time.Duration(hours) * time.Hour)}}).All(&pending)
+	}
+	if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "pendingworkfile", f); err != nil {
+		return 0, err
+	}
+	blobMod := BlobModels{MgoSession: quotaMod.MgoSession}
+	revMod := RevisionModels{MgoSession: quotaMod.MgoSession, BlobMod: blobMod}
+	removed := 0
+	for _, p := range pending {
+		exists, err := quotaMod.workExists(p.WorkID)
+		if err != nil {
+			return removed, err
+		}
+		// 作品信息已保存但没有重新统计，改为计入作品
+		if exists {
+			if _, err := quotaMod.UpdateWorkStorage(p.WorkID); err != nil {
+				return removed, err
+			}
+			continue
+		}
+		if err := blobMod.RemoveWorkFiles(p.WorkID.Hex()); err != nil {
+			return removed, err
+		}
+		if err := revMod.RemoveRevisions(p.WorkID); err != nil {
+			return removed, err
+		}
+		ff := func(col *mgo.Collection) error {
+			return col.RemoveId(p.WorkID)
+		}
+		if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "pendingworkfile", ff); err != nil && err != mgo.ErrNotFound {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// WorksWithoutStorage 还没有统计占用空间的作品
+func (quotaMod *QuotaModels) WorksWithoutStorage() ([]bson.ObjectId, error) {
+	var works []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"storage": bson.M{"$exists": false}}).Select(bson.M{"_id": 1}).All(&works)
+	}
+	if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f); err != nil {
+		return nil, err
+	}
+	ids := make([]bson.ObjectId, 0, len(works))
+	for _, w := range works {
+		ids = append(ids, w.ID)
+	}
+	return ids, nil
+}
+
+// UserStorage 用户的存储空间使用情况和配额
+func (quotaMod *QuotaModels) UserStorage(userID bson.ObjectId, role string) (*StorageUsage, error) {
+	usage := &StorageUsage{UserID: userID}
+	pipeline := []bson.M{
+		{"$match": bson.M{"userID": userID}},
+		{"$group": bson.M{"_id": "$userID", "used": bson.M{"$sum": "$storage"}, "works": bson.M{"$sum": 1}}},
+	}
+	f := func(col *mgo.Collection) error {
+		err := col.Pipe(pipeline).One(usage)
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f); err != nil {
+		return nil, err
+	}
+	pending, err := quotaMod.pendingStorage(userID)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := quotaMod.revisionStorage(userID)
+	if err != nil {
+		return nil, err
+	}
+	usage.Used += pending + revisions
+	usage.Pending = pending
+	usage.Revisions = revisions
+	usage.Limit, usage.Source, err = quotaMod.UserQuota(userID, role)
+	return usage, err
+}
+
+// CheckQuota 检查用户再写入delta字节（覆盖文件时为新旧文件的差值）后是否超出配额，超出时返回 ErrQuotaExceeded
+func (quotaMod *QuotaModels) CheckQuota(userID bson.ObjectId, role string, delta int64) (*StorageUsage, error) {
+	usage, err := quotaMod.UserStorage(userID, role)
+	if err != nil {
+		return nil, err
+	}
+	if delta > 0 && usage.Limit > 0 && usage.Used+delta > usage.Limit {
+		return usage, ErrQuotaExceeded
+	}
+	return usage, nil
+}
+
+// UserQuota 用户的配额：管理员为用户单独设置的配额优先，其次是学生所在班级的配额（多个班级取最大的），
+// 最后是角色的配额（管理员设置的或 quota_<角色>_mb 配置）
+func (quotaMod *QuotaModels) UserQuota(userID bson.ObjectId, role string) (int64, string, error) {
+	var quota Quota
+	f := func(col *mgo.Collection) error {
+		return col.FindId(QuotaID(QuotaUser, userID.Hex())).One(&quota)
+	}
+	err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "storagequota", f)
+	if err == nil {
+		return quota.Limit, QuotaUser, nil
+	}
+	if err != mgo.ErrNotFound {
+		return 0, "", err
+	}
+
+	var codes []string
+	ff := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"students.userID": userID}).Distinct("code", &codes)
+	}
+	if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "classes", ff); err != nil {
+		return 0, "", err
+	}
+	if len(codes) > 0 {
+		ids := make([]string, len(codes))
+		for i, code := range codes {
+			ids[i] = QuotaID(QuotaClass, code)
+		}
+		var quotas []Quota
+		fq := func(col *mgo.Collection) error {
+			return col.Find(bson.M{"_id": bson.M{"$in": ids}}).All(&quotas)
+		}
+		if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "storagequota", fq); err != nil {
+			return 0, "", err
+		}
+		if len(quotas) > 0 {
+			limit := quotas[0].Limit
+			for _, q := range quotas {
+				if q.Limit == 0 || (limit != 0 && q.Limit > limit) {
+					limit = q.Limit
+				}
+			}
+			return limit, QuotaClass, nil
+		}
+	}
+	limit, err := quotaMod.RoleQuota(role)
+	return limit, QuotaRole, err
+}
+
+// RoleQuota 角色的配额，管理员没有设置时使用 quota_<角色>_mb 配置（学生默认200MB，老师默认2GB，管理员默认不限制）
+func (quotaMod *QuotaModels) RoleQuota(role string) (int64, error) {
+	var quota Quota
+	f := func(col *mgo.Collection) error {
+		return col.FindId(QuotaID(QuotaRole, role)).One(&quota)
+	}
+	err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "storagequota", f)
+	if err == nil {
+		return quota.Limit, nil
+	}
+	if err != mgo.ErrNotFound {
+		return 0, err
+	}
+	defaults := map[string]int64{ROLE_STUDENT: 200, ROLE_TEACHER: 2048, ROLE_ADMIN: 0}
+	return beego.AppConfig.DefaultInt64("quota_"+role+"_mb", defaults[role]) << 20, nil
+}
+
+// Quotas 管理员设置的所有配额
+func (quotaMod *QuotaModels) Quotas() ([]Quota, error) {
+	quotas := []Quota{}
+	f := func(col *mgo.Collection) error {
+		return col.Find(nil).Sort("scope", "target").All(&quotas)
+	}
+	err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "storagequota", f)
+	return quotas, err
+}
+
+// SetQuota 设置用户、班级或角色的配额，limit 为0表示不限制
+func (quotaMod *QuotaModels) SetQuota(scope, target string, limit int64) error {
+	quota := Quota{ID: QuotaID(scope, target), Scope: scope, Target: target, Limit: limit, UpdateTime: time.Now()}
+	f := func(col *mgo.Collection) error {
+		_, err := col.UpsertId(quota.ID, quota)
+		return err
+	}
+	return quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "storagequota", f)
+}
+
+// DeleteQuota 删除管理员设置的配额，恢复为默认配额
+func (quotaMod *QuotaModels) DeleteQuota(scope, target string) error {
+	f := func(col *mgo.Collection) error {
+		return col.RemoveId(QuotaID(scope, target))
+	}
+	return quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "storagequota", f)
+}
+
+// StorageReport 汇总作品文件占用的空间，over 为配额使用率超过该比例（如0.9）的用户，按使用量从大到小排列
+func (quotaMod *QuotaModels) StorageReport(over float64) (*StorageReport, []*StorageUsage, error) {
+	var usages []*StorageUsage
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$userID", "used": bson.M{"$sum": "$storage"}, "works": bson.M{"$sum": 1}}},
+		{"$lookup": bson.M{"from": "users", "localField": "_id", "foreignField": "_id", "as": "user"}},
+		{"$unwind": "$user"},
+		{"$project": bson.M{"used": 1, "works": 1, "username": "$user.username", "realname": "$user.realname", "role": "$user.role.name"}},
+		{"$sort": bson.M{"used": -1}},
+	}
+	var rows []struct {
+		StorageUsage `bson:",inline"`
+		Role         string `bson:"role"`
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Pipe(pipeline).All(&rows)
+	}
+	if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f); err != nil {
+		return nil, nil, err
+	}
+	report := &StorageReport{Users: len(rows)}
+	usages = []*StorageUsage{}
+	for i := range rows {
+		usage := &rows[i].StorageUsage
+		var err error
+		if usage.Revisions, err = quotaMod.revisionStorage(usage.UserID); err != nil {
+			return nil, nil, err
+		}
+		usage.Used += usage.Revisions
+		report.Used += usage.Used
+		report.Works += usage.Works
+		if usage.Limit, usage.Source, err = quotaMod.UserQuota(usage.UserID, rows[i].Role); err != nil {
+			return nil, nil, err
+		}
+		if usage.Exceeded() {
+			report.Exceeded++
+		}
+		if usage.Limit > 0 && float64(usage.Used) >= float64(usage.Limit)*over {
+			usages = append(usages, usage)
+		}
+	}
+	return report, usages, nil
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// workExists 作品信息是否已经保存
+func (quotaMod *QuotaModels) workExists(workID bson.ObjectId) (bool, error) {
+	var n int
+	f := func(col *mgo.Collection) error {
+		var err error
+		n, err = col.FindId(workID).Count()
+		return err
+	}
+	err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+	return n > 0, err
+}
+
+// revisionStorage 用户作品的历史版本占用的空间。内容相同的版本文件只算一次，与作品当前文件相同的不重复计算
+func (quotaMod *QuotaModels) revisionStorage(userID bson.ObjectId) (int64, error) {
+	var works []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"userID": userID}).Select(bson.M{"_id": 1}).All(&works)
+	}
+	if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f); err != nil || len(works) == 0 {
+		return 0, err
+	}
+	ids := make([]bson.ObjectId, 0, len(works))
+	hexIDs := make([]string, 0, len(works))
+	for _, work := range works {
+		ids = append(ids, work.ID)
+		hexIDs = append(hexIDs, work.ID.Hex())
+	}
+	var files []WorkFile
+	ff := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"workID": bson.M{"$in": hexIDs}}).Select(bson.M{"blob": 1}).All(&files)
+	}
+	if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workfile", ff); err != nil {
+		return 0, err
+	}
+	counted := make(map[string]bool, len(files))
+	for _, file := range files {
+		counted[file.Blob] = true
+	}
+	var revisions []WorkRevision
+	fr := func(col *mgo.Collection) error {
+		query := bson.M{"workID": bson.M{"$in": ids}, "$or": []bson.M{{"file": bson.M{"$exists": true}}, {"blob": bson.M{"$exists": true}}}}
+		return col.Find(query).Select(bson.M{"blob": 1, "size": 1}).All(&revisions)
+	}
+	if err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", fr); err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, rev := range revisions {
+		if rev.Blob != "" {
+			if counted[rev.Blob] {
+				continue
+			}
+			counted[rev.Blob] = true
+		}
+		total += rev.Size
+	}
+	return total, nil
+}
+
+// pendingStorage 用户还没有保存作品信息的作品文件和未完成的作品文件上传（按声明的大小预留）占用的空间
+func (quotaMod *QuotaModels) pendingStorage(userID bson.ObjectId) (int64, error) {
+	var total int64
+	sum := func(collection string, match bson.M) error {
+		var result struct {
+			Size int64 `bson:"size"`
+		}
+		pipeline := []bson.M{
+			{"$match": match},
+			{"$group": bson.M{"_id": nil, "size": bson.M{"$sum": "$size"}}},
+		}
+		f := func(col *mgo.Collection) error {
+			err := col.Pipe(pipeline).One(&result)
+			if err == mgo.ErrNotFound {
+				return nil
+			}
+			return err
+		}
+		err := quotaMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), collection, f)
+		total += result.Size
+		return err
+	}
+	if err := sum("pendingworkfile", bson.M{"userID": userID}); err != nil {
+		return 0, err
+	}
+	if err := sum("uploadsession", bson.M{"userID": userID, "kind": UploadWork, "expireTime": bson.M{"$gt": time.Now()}}); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
@@ -0,0 +1,216 @@
+// @Title 作品改编模型
+// @Description 按作品的原作品id(originID)整理改编关系：向上追溯原作品，向下列出所有改编作品；作品被改编时通知原作者
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/astaxie/beego"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+)
+
+// 改编关系最多追溯的层数和最多返回的改编作品数
+const (
+	maxRemixDepth = 32
+	maxRemixNodes = 1000
+)
+
+type RemixModels struct {
+	MgoSession *mongo.MgoClient
+	MessageMod MessageModels
+}
+
+// RemixNode 改编关系中的一个作品。未分享的作品只对作者、老师和管理员显示名称和封面；
+// 原作品已被删除时 Deleted 为true，只有ID
+type RemixNode struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	OriginID   string        `bson:"originID" json:"originID,omitempty"`
+	Name       string        `bson:"name" json:"name"`
+	Picture    string        `bson:"picture" json:"picture"`
+	UserID     bson.ObjectId `bson:"userID" json:"userID,omitempty"`
+	Username   string        `bson:"-" json:"username"`
+	Realname   string        `bson:"-" json:"realname"`
+	CreateTime int64         `bson:"createTime" json:"createTime"`
+	Public     bool          `bson:"public" json:"public"`
+	Deleted    bool          `bson:"-" json:"deleted,omitempty"`
+	Remixes    []*RemixNode  `bson:"-" json:"remixes,omitempty"`
+}
+
+// RemixTree 作品的改编关系。Ancestors 从最早的原作品到直接来源排列，Work.Remixes 为直接改编的作品，
+// 每个改编作品的 Remixes 为它再被改编的作品
+type RemixTree struct {
+	Ancestors   []*RemixNode `json:"ancestors"`
+	Work        *RemixNode   `json:"work"`
+	Descendants int          `json:"descendants"` //所有后代作品数
+	Truncated   bool         `json:"truncated"`   //改编作品太多，没有全部返回
+}
+
+// WorkRemixTree 查询作品的改编关系。viewer 为查看者，showAll 为true时（老师、管理员）显示所有作品的名称和封面
+func (remixMod *RemixModels) WorkRemixTree(workID, viewer bson.ObjectId, showAll bool) (*RemixTree, error) {
+	work, err := remixMod.findNode(workID)
+	if err != nil {
+		return nil, err
+	}
+	tree := &RemixTree{Ancestors: []*RemixNode{}, Work: work}
+	nodes := []*RemixNode{work}
+
+	// 向上追溯原作品，seen 防止数据异常时出现环
+	seen := map[bson.ObjectId]bool{work.ID: true}
+	for origin := work.OriginID; bson.IsObjectIdHex(origin) && len(tree.Ancestors) < maxRemixDepth; {
+		id := bson.ObjectIdHex(origin)
+		if seen[id] {
+			break
+		}
+		seen[id] = true
+		node, err := remixMod.findNode(id)
+		if err == mgo.ErrNotFound {
+			tree.Ancestors = append(tree.Ancestors, &RemixNode{ID: id, Deleted: true})
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		tree.Ancestors = append(tree.Ancestors, node)
+		nodes = append(nodes, node)
+		origin = node.OriginID
+	}
+	for i, j := 0, len(tree.Ancestors)-1; i < j; i, j = i+1, j-1 {
+		tree.Ancestors[i], tree.Ancestors[j] = tree.Ancestors[j], tree.Ancestors[i]
+	}
+
+	// 逐层向下查询改编作品
+	level := map[string]*RemixNode{workID.Hex(): work}
+	for depth := 0; len(level) > 0 && depth < maxRemixDepth; depth++ {
+		ids := make([]string, 0, len(level))
+		for id := range level {
+			ids = append(ids, id)
+		}
+		var children []*RemixNode
+		f := func(col *mgo.Collection) error {
+			return col.Find(bson.M{"originID": bson.M{"$in": ids}}).Select(remixNodeFields).Sort("createTime").Limit(maxRemixNodes - tree.Descendants + 1).All(&children)
+		}
+		if err := remixMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f); err != nil {
+			return nil, err
+		}
+		next := make(map[string]*RemixNode)
+		for _, child := range children {
+			if seen[child.ID] {
+				continue
+			}
+			if tree.Descendants >= maxRemixNodes {
+				tree.Truncated = true
+				break
+			}
+			seen[child.ID] = true
+			parent := level[child.OriginID]
+			parent.Remixes = append(parent.Remixes, child)
+			next[child.ID.Hex()] = child
+			nodes = append(nodes, child)
+			tree.Descendants++
+		}
+		if tree.Truncated {
+			break
+		}
+		level = next
+	}
+	if err := remixMod.fillAuthors(nodes); err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if !node.Public && !showAll && node.UserID != viewer {
+			node.Name, node.Picture = "", ""
+		}
+	}
+	return tree, nil
+}
+
+// NotifyRemix 通知原作者作品被改编，改编自己的作品时不通知
+func (remixMod *RemixModels) NotifyRemix(origin WorkBody, user *User) error {
+	if origin.UserID == user.ID {
+		return nil
+	}
+	name := user.Realname
+	if name == "" {
+		name = user.Username
+	}
+	content := fmt.Sprintf("%s改编了你的作品《%s》", name, origin.Name)
+	return remixMod.MessageMod.PublishSystemMessage("作品改编", content, "", "", []bson.ObjectId{origin.UserID})
+}
+
+// RecountRemixes 按改编作品重新统计所有作品的被改编数，用于补全改编数统计之前的作品
+func (remixMod *RemixModels) RecountRemixes() (int, error) {
+	var counts []struct {
+		ID    string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	pipeline := []bson.M{
+		{"$match": bson.M{"originID": bson.M{"$nin": []interface{}{"", nil}}}},
+		{"$group": bson.M{"_id": "$originID", "count": bson.M{"$sum": 1}}},
+	}
+	updated := 0
+	f := func(col *mgo.Collection) error {
+		if err := col.Pipe(pipeline).All(&counts); err != nil {
+			return err
+		}
+		for _, c := range counts {
+			if !bson.IsObjectIdHex(c.ID) {
+				continue
+			}
+			err := col.Update(bson.M{"_id": bson.ObjectIdHex(c.ID), "remix": bson.M{"$ne": c.Count}}, bson.M{"$set": bson.M{"remix": c.Count}})
+			if err == mgo.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	}
+	err := remixMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+	return updated, err
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+var remixNodeFields = bson.M{"originID": 1, "name": 1, "picture": 1, "userID": 1, "createTime": 1, "public": 1}
+
+func (remixMod *RemixModels) findNode(id bson.ObjectId) (*RemixNode, error) {
+	var node RemixNode
+	f := func(col *mgo.Collection) error {
+		return col.FindId(id).Select(remixNodeFields).One(&node)
+	}
+	err := remixMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+	return &node, err
+}
+
+// fillAuthors 填写作品作者的用户名和姓名
+func (remixMod *RemixModels) fillAuthors(nodes []*RemixNode) error {
+	ids := []bson.ObjectId{}
+	for _, node := range nodes {
+		if node.UserID != "" {
+			ids = append(ids, node.UserID)
+		}
+	}
+	var users []User
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"username": 1, "realname": 1}).All(&users)
+	}
+	if err := remixMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "users", f); err != nil {
+		return err
+	}
+	byID := make(map[bson.ObjectId]User)
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	for _, node := range nodes {
+		u := byID[node.UserID]
+		node.Username, node.Realname = u.Username, u.Realname
+	}
+	return nil
+}
@@ -0,0 +1,447 @@
+// @Title 作品版本模型
+// @Description 作品每次保存生成一个不可修改的版本（作品文件或作品信息快照），可以查看、下载和恢复历史版本，旧版本按保留策略清理。
+// 版本文件保存在作品文件存储中，与作品文件内容相同时只增加引用
+
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/storage"
+)
+
+// 版本类型
+const (
+	RevisionData        = "data"        //保存了作品文件
+	RevisionDescription = "description" //修改了作品信息
+	RevisionRestore     = "restore"     //恢复到历史版本
+)
+
+type RevisionModels struct {
+	MgoSession *mongo.MgoClient
+	BlobMod    BlobModels
+}
+
+// WorkSnapshot 作品信息快照
+type WorkSnapshot struct {
+	Name        string `bson:"name" json:"name"`
+	Picture     string `bson:"picture" json:"picture"`
+	Description string `bson:"description" json:"description"`
+	Data        string `bson:"data" json:"data"`
+	Tool        string `bson:"tool" json:"tool"`
+	Types       string `bson:"types" json:"types"`
+}
+
+// Snapshot 作品当前信息的快照
+func (work *WorkBody) Snapshot() *WorkSnapshot {
+	return &WorkSnapshot{Name: work.Name, Picture: work.Picture, Description: work.Description, Data: work.Data, Tool: work.Tool, Types: work.Types}
+}
+
+// WorkRevision 作品的一个版本，Number 在作品内从1递增
+type WorkRevision struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	WorkID     bson.ObjectId `bson:"workID" json:"workID"`
+	UserID     bson.ObjectId `bson:"userID" json:"userID"` //保存者
+	Number     int           `bson:"number" json:"number"`
+	Kind       string        `bson:"kind" json:"kind"`
+	Message    string        `bson:"message" json:"message"`
+	Suffix     string        `bson:"suffix,omitempty" json:"suffix,omitempty"` //作品文件的扩展名(sgl、stl、sb3)
+	File       string        `bson:"file,omitempty" json:"-"`                  //早期版本的文件副本路径
+	Blob       string        `bson:"blob,omitempty" json:"-"`                  //作品文件存储中的文件，只有保存了文件的版本才有
+	Size       int64         `bson:"size" json:"size"`
+	Doc        *WorkSnapshot `bson:"doc,omitempty" json:"doc,omitempty"`
+	CreateTime time.Time     `bson:"createTime" json:"createTime"`
+}
+
+// AddRevision 记录作品的一个新版本。data 不为nil时保存文件，doc 为作品信息快照
+func (revMod *RevisionModels) AddRevision(workID, userID bson.ObjectId, kind, suffix string, data []byte, doc *WorkSnapshot, message string) (*WorkRevision, error) {
+	var file *WorkFile
+	if data != nil {
+		sum, size, err := revMod.BlobMod.PutFile(bytes.NewReader(data), suffix)
+		if err != nil {
+			return nil, err
+		}
+		file = &WorkFile{Suffix: suffix, Blob: sum, Size: size}
+	}
+	return revMod.addRevision(workID, userID, kind, file, doc, message)
+}
+
+// AddWorkFileRevision 用作品当前的文件记录一个文件版本，只增加文件的引用
+func (revMod *RevisionModels) AddWorkFileRevision(workID, userID bson.ObjectId, kind, suffix, message string) (*WorkRevision, error) {
+	file, err := revMod.retainWorkFile(workID, suffix)
+	if err != nil {
+		return nil, err
+	}
+	return revMod.addRevision(workID, userID, kind, file, nil, message)
+}
+
+// RevisionData 读取版本保存的文件内容
+func (revMod *RevisionModels) RevisionData(rev WorkRevision) ([]byte, error) {
+	if rev.Blob != "" {
+		return revMod.BlobMod.ReadBlob(rev.Blob)
+	}
+	return ioutil.ReadFile(rev.File)
+}
+
+// WorkRevisions 查询作品的所有版本，新版本在前
+func (revMod *RevisionModels) WorkRevisions(workID bson.ObjectId) ([]WorkRevision, error) {
+	revisions := []WorkRevision{}
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"workID": workID}).Select(bson.M{"doc.data": 0}).Sort("-number").All(&revisions)
+	}
+	err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", f)
+	return revisions, err
+}
+
+// FindRevision 查询作品的指定版本
+func (revMod *RevisionModels) FindRevision(workID bson.ObjectId, number int) (WorkRevision, error) {
+	var rev WorkRevision
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"workID": workID, "number": number}).One(&rev)
+	}
+	err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", f)
+	return rev, err
+}
+
+// FileRevision 查询版本number时作品文件所在的版本，即不晚于number的最近一个保存了文件的版本
+func (revMod *RevisionModels) FileRevision(workID bson.ObjectId, number int) (WorkRevision, error) {
+	return revMod.latestRevision(workID, number, "file")
+}
+
+// RestoreRevision 把作品恢复到版本number时的状态：恢复当时的作品信息和作品文件，并记录为一个新版本
+func (revMod *RevisionModels) RestoreRevision(work WorkBody, userID bson.ObjectId, number int) (*WorkRevision, error) {
+	if _, err := revMod.FindRevision(work.ID, number); err != nil {
+		return nil, err
+	}
+	doc := work.Snapshot()
+	docRev, err := revMod.latestRevision(work.ID, number, "doc")
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		doc = docRev.Doc
+		f := func(col *mgo.Collection) error {
+			return col.UpdateId(work.ID, bson.M{"$set": doc})
+		}
+		if err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f); err != nil {
+			return nil, err
+		}
+	}
+	var file *WorkFile
+	fileRev, err := revMod.latestRevision(work.ID, number, "file")
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		if fileRev.Blob != "" {
+			err = revMod.BlobMod.SetWorkFile(work.ID.Hex(), fileRev.Suffix, fileRev.Blob, fileRev.Size)
+		} else {
+			err = revMod.restoreFile(work.ID.Hex(), fileRev)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if file, err = revMod.retainWorkFile(work.ID, fileRev.Suffix); err != nil {
+			return nil, err
+		}
+	}
+	return revMod.addRevision(work.ID, userID, RevisionRestore, file, doc, fmt.Sprintf("恢复到版本%d", number))
+}
+
+// PruneRevisions 按保留策略清理作品的旧版本：至少保留最新的keep个版本（不少于1个），更早的版本创建时间早于before时删除。
+// 最早保留的版本恢复时依赖的文件版本和作品信息版本不会被删除
+func (revMod *RevisionModels) PruneRevisions(workID bson.ObjectId, keep int, before time.Time) (int, error) {
+	var revisions []WorkRevision
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"workID": workID}).Select(bson.M{"number": 1, "file": 1, "blob": 1, "doc.name": 1, "createTime": 1}).Sort("-number").All(&revisions)
+	}
+	if err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", f); err != nil {
+		return 0, err
+	}
+	if keep < 1 {
+		keep = 1
+	}
+	if len(revisions) <= keep {
+		return 0, nil
+	}
+	oldestKept := revisions[keep-1].Number
+	// 最早保留的版本恢复时要用到的文件版本和作品信息版本
+	baseFile, baseDoc := -1, -1
+	for _, rev := range revisions {
+		if rev.Number > oldestKept {
+			continue
+		}
+		if baseFile < 0 && (rev.File != "" || rev.Blob != "") {
+			baseFile = rev.Number
+		}
+		if baseDoc < 0 && rev.Doc != nil {
+			baseDoc = rev.Number
+		}
+	}
+	pruned := 0
+	for _, rev := range revisions[keep:] {
+		if rev.Number == baseFile || rev.Number == baseDoc || !rev.CreateTime.Before(before) {
+			continue
+		}
+		ff := func(col *mgo.Collection) error {
+			return col.RemoveId(rev.ID)
+		}
+		if err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", ff); err != nil {
+			return pruned, err
+		}
+		if rev.File != "" {
+			removeRevisionFile(rev.File)
+		}
+		if rev.Blob != "" {
+			if err := revMod.BlobMod.ReleaseBlob(rev.Blob); err != nil {
+				return pruned, err
+			}
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// RemoveRevisions 删除作品的所有版本，释放版本引用的文件
+func (revMod *RevisionModels) RemoveRevisions(workID bson.ObjectId) error {
+	var revisions []WorkRevision
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"workID": workID}).Select(bson.M{"file": 1, "blob": 1}).All(&revisions)
+	}
+	if err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", f); err != nil {
+		return err
+	}
+	for _, rev := range revisions {
+		ff := func(col *mgo.Collection) error {
+			return col.RemoveId(rev.ID)
+		}
+		if err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", ff); err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+		if rev.File != "" {
+			removeRevisionFile(rev.File)
+		}
+		if rev.Blob != "" {
+			if err := revMod.BlobMod.ReleaseBlob(rev.Blob); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WorksToPrune 查询版本数超过keep的作品
+func (revMod *RevisionModels) WorksToPrune(keep int) ([]bson.ObjectId, error) {
+	var result []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$workID", "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": keep}}},
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Pipe(pipeline).All(&result)
+	}
+	if err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", f); err != nil {
+		return nil, err
+	}
+	ids := make([]bson.ObjectId, 0, len(result))
+	for _, r := range result {
+		ids = append(ids, r.ID)
+	}
+	return ids, nil
+}
+
+// OrphanedWorks 查询有版本记录但作品已经删除的作品ID
+func (revMod *RevisionModels) OrphanedWorks() ([]bson.ObjectId, error) {
+	var result []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$workID"}},
+		{"$lookup": bson.M{"from": "works", "localField": "_id", "foreignField": "_id", "as": "work"}},
+		{"$match": bson.M{"work": bson.M{"$size": 0}}},
+		{"$project": bson.M{"_id": 1}},
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Pipe(pipeline).All(&result)
+	}
+	if err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", f); err != nil {
+		return nil, err
+	}
+	ids := make([]bson.ObjectId, 0, len(result))
+	for _, r := range result {
+		ids = append(ids, r.ID)
+	}
+	return ids, nil
+}
+
+// ImportRevisionFiles 把早期版本的文件副本导入作品文件存储，返回导入的版本数
+func (revMod *RevisionModels) ImportRevisionFiles() (int, error) {
+	var revisions []WorkRevision
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"file": bson.M{"$exists": true}, "blob": bson.M{"$exists": false}}
+		return col.Find(query).Select(bson.M{"workID": 1, "suffix": 1, "file": 1}).All(&revisions)
+	}
+	if err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", f); err != nil {
+		return 0, err
+	}
+	imported := 0
+	for _, rev := range revisions {
+		file, err := os.Open(rev.File)
+		if err != nil {
+			continue
+		}
+		sum, size, err := revMod.BlobMod.PutFile(file, rev.Suffix)
+		file.Close()
+		if err != nil {
+			return imported, err
+		}
+		ff := func(col *mgo.Collection) error {
+			return col.UpdateId(rev.ID, bson.M{"$set": bson.M{"blob": sum, "size": size}, "$unset": bson.M{"file": ""}})
+		}
+		if err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", ff); err != nil {
+			revMod.BlobMod.ReleaseBlob(sum)
+			return imported, err
+		}
+		removeRevisionFile(rev.File)
+		// 作品的版本目录空了才会被删除
+		os.Remove(path.Join(revisionDir(), rev.WorkID.Hex()))
+		imported++
+	}
+	return imported, nil
+}
+
+// RevisionPolicy 版本保留策略：revision_keep_count（默认20）和 revision_keep_days（默认30）
+func RevisionPolicy() (keep int, before time.Time) {
+	keep = beego.AppConfig.DefaultInt("revision_keep_count", 20)
+	days := beego.AppConfig.DefaultInt("revision_keep_days", 30)
+	return keep, time.Now().AddDate(0, 0, -days)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// addRevision 记录作品的一个新版本，file 为版本的文件（调用方已持有一个引用，记录失败时释放）
+func (revMod *RevisionModels) addRevision(workID, userID bson.ObjectId, kind string, file *WorkFile, doc *WorkSnapshot, message string) (*WorkRevision, error) {
+	release := func() {
+		if file != nil {
+			revMod.BlobMod.ReleaseBlob(file.Blob)
+		}
+	}
+	number, err := revMod.nextNumber(workID)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	rev := &WorkRevision{
+		ID:         bson.NewObjectId(),
+		WorkID:     workID,
+		UserID:     userID,
+		Number:     number,
+		Kind:       kind,
+		Message:    message,
+		Doc:        doc,
+		CreateTime: time.Now(),
+	}
+	if file != nil {
+		rev.Suffix = file.Suffix
+		rev.Blob = file.Blob
+		rev.Size = file.Size
+	} else if doc != nil {
+		rev.Size = int64(len(doc.Name) + len(doc.Picture) + len(doc.Description) + len(doc.Data) + len(doc.Tool) + len(doc.Types))
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Insert(rev)
+	}
+	if err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", f); err != nil {
+		release()
+		return nil, err
+	}
+	return rev, nil
+}
+
+// retainWorkFile 增加作品当前文件的引用，没有导入存储的文件先导入
+func (revMod *RevisionModels) retainWorkFile(workID bson.ObjectId, suffix string) (*WorkFile, error) {
+	if _, err := revMod.BlobMod.importWorkFile(workID.Hex(), suffix); err != nil {
+		return nil, err
+	}
+	file, err := revMod.BlobMod.StatWorkFile(workID.Hex(), suffix)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := revMod.BlobMod.RetainBlob(file.Blob); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// restoreFile 恢复早期版本的文件副本
+func (revMod *RevisionModels) restoreFile(workID string, rev WorkRevision) error {
+	f, err := os.Open(rev.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = revMod.BlobMod.SaveWorkFile(workID, rev.Suffix, f)
+	return err
+}
+
+// removeRevisionFile 删除早期版本的文件副本，失败时写日志。副本由早期版本直接写在程序目录下的 revision_dir 中，
+// 没有保存到存储后端，所以通过程序目录的本地存储删除，程序目录以外的路径不会被删除
+func removeRevisionFile(file string) {
+	name, err := filepath.Rel(beego.AppPath, file)
+	if err == nil {
+		err = storage.NewLocal(beego.AppPath).Remove(filepath.ToSlash(name))
+	}
+	if err != nil {
+		logs.Error("remove revision file:", file, err)
+	}
+}
+
+// latestRevision 查询不晚于版本number、且带有field（file 或 doc）的最近一个版本
+func (revMod *RevisionModels) latestRevision(workID bson.ObjectId, number int, field string) (WorkRevision, error) {
+	var rev WorkRevision
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"workID": workID, "number": bson.M{"$lte": number}, field: bson.M{"$exists": true}}
+		if field == "file" {
+			// 早期版本保存的是文件副本
+			delete(query, field)
+			query["$or"] = []bson.M{{"file": bson.M{"$exists": true}}, {"blob": bson.M{"$exists": true}}}
+		}
+		return col.Find(query).Sort("-number").One(&rev)
+	}
+	err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevision", f)
+	return rev, err
+}
+
+// nextNumber 分配作品的下一个版本号
+func (revMod *RevisionModels) nextNumber(workID bson.ObjectId) (int, error) {
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	f := func(col *mgo.Collection) error {
+		change := mgo.Change{Update: bson.M{"$inc": bson.M{"seq": 1}}, Upsert: true, ReturnNew: true}
+		_, err := col.FindId(workID).Apply(change, &counter)
+		return err
+	}
+	err := revMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workrevisioncounter", f)
+	return counter.Seq, err
+}
+
+// revisionDir 早期版本文件副本的保存目录
+func revisionDir() string {
+	return path.Join(beego.AppPath, beego.AppConfig.DefaultString("revision_dir", "revisions"))
+}
@@ -0,0 +1,160 @@
+// @Title 全文检索模型
+// @Description 从课程、工具、分享作品中读取被检索的文档，索引见 services/search
+
+package models
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/search"
+	"maiyajia.com/services/storage"
+)
+
+type SearchModels struct {
+	MgoSession *mongo.MgoClient
+}
+
+// SearchDocuments 读取所有需要建立索引的文档：课程、课时（含课时md内容）、工具、分享的作品
+func (searchMod *SearchModels) SearchDocuments() ([]search.Document, error) {
+	var docs []search.Document
+
+	var courses []Course
+	f := func(col *mgo.Collection) error {
+		return col.Find(nil).All(&courses)
+	}
+	if err := searchMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "course", f); err != nil {
+		return nil, err
+	}
+	for _, course := range courses {
+		docs = append(docs, search.Document{
+			Type:  search.TypeCourse,
+			ID:    course.ID.Hex(),
+			Title: course.Name,
+			Body:  course.Category + " " + course.Desc,
+			Icon:  course.Icon,
+		})
+		for _, lesson := range course.Lessions {
+			docs = append(docs, lessonDocument(course, lesson))
+		}
+	}
+
+	var tools []Tool
+	ft := func(col *mgo.Collection) error {
+		return col.Find(nil).All(&tools)
+	}
+	if err := searchMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "tool", ft); err != nil {
+		return nil, err
+	}
+	for _, tool := range tools {
+		docs = append(docs, search.Document{
+			Type:  search.TypeTool,
+			ID:    tool.ID.Hex(),
+			Title: tool.Title,
+			Body:  tool.Name + " " + tool.Category + " " + strings.Join(tool.Types, " "),
+			Icon:  tool.Icon,
+		})
+	}
+
+	var works []WorkBody
+	fw := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"public": true}).Select(bson.M{"data": 0}).All(&works)
+	}
+	if err := searchMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", fw); err != nil {
+		return nil, err
+	}
+	for _, work := range works {
+		docs = append(docs, workDocument(work))
+	}
+	return docs, nil
+}
+
+// WorkSearchDocument 读取作品的检索文档，作品不存在或未分享时public返回false
+func (searchMod *SearchModels) WorkSearchDocument(workID string) (doc search.Document, public bool, err error) {
+	if !bson.IsObjectIdHex(workID) {
+		return doc, false, nil
+	}
+	var work WorkBody
+	f := func(col *mgo.Collection) error {
+		return col.FindId(bson.ObjectIdHex(workID)).Select(bson.M{"data": 0}).One(&work)
+	}
+	err = searchMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+	if err == mgo.ErrNotFound {
+		return doc, false, nil
+	}
+	if err != nil {
+		return doc, false, err
+	}
+	return workDocument(work), work.Public, nil
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// lessonDocument 课时文档：课时名称为标题，视频名称和md内容为正文。
+// md内容需要登录并解锁课时才能查看，摘要只显示视频名称
+func lessonDocument(course Course, lesson *Lession) search.Document {
+	var body, names []string
+	for _, content := range lesson.Contents {
+		body = append(body, content.VideoName)
+		if content.VideoName != "" {
+			names = append(names, content.VideoName)
+		}
+		if content.MdURL == "" || isRemoteURL(content.MdURL) {
+			continue
+		}
+		b, err := storage.ReadFile(Assets(), path.Clean("/" + content.MdURL)[1:])
+		if err != nil {
+			logs.Debug("read lesson markdown:", err)
+			continue
+		}
+		body = append(body, plainMarkdown(string(b)))
+	}
+	return search.Document{
+		Type:     search.TypeLesson,
+		ID:       lesson.ID.Hex(),
+		ParentID: course.ID.Hex(),
+		Title:    lesson.Name,
+		Body:     strings.Join(body, "\n"),
+		Icon:     lesson.IconURL,
+		Private:  true,
+		Snippet:  strings.Join(names, " "),
+	}
+}
+
+// workDocument 作品的检索文档，Scratch作品使用的扩展也可以检索，如“pen”
+func workDocument(work WorkBody) search.Document {
+	body := work.Description
+	if work.Meta != nil && len(work.Meta.Extensions) > 0 {
+		body += "\n" + strings.Join(work.Meta.Extensions, " ")
+	}
+	return search.Document{
+		Type:  search.TypeWork,
+		ID:    work.ID.Hex(),
+		Title: work.Name,
+		Body:  body,
+		Icon:  work.Picture,
+	}
+}
+
+var (
+	mdImage   = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	mdLink    = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	mdHTMLTag = regexp.MustCompile(`<[^>]+>`)
+	mdSymbols = regexp.MustCompile("(?m)^\\s*(#{1,6}|>|[-*+]|\\d+\\.)\\s+|[*_`~|]+")
+)
+
+// plainMarkdown 去掉markdown标记，只保留用于检索的文字
+func plainMarkdown(md string) string {
+	md = mdImage.ReplaceAllString(md, " ")
+	md = mdLink.ReplaceAllString(md, "$1")
+	md = mdHTMLTag.ReplaceAllString(md, " ")
+	return mdSymbols.ReplaceAllString(md, " ")
+}
@@ -0,0 +1,310 @@
+// @Title 作品相似度模型
+// @Description 保存课时作品(sb3、stl)的指纹，找出同一课时下不同学生之间高度相似的作品，供老师查看
+
+package models
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/scratch"
+	"maiyajia.com/services/similarity"
+	"maiyajia.com/services/stl"
+)
+
+type SimilarityModels struct {
+	MgoSession *mongo.MgoClient
+	BlobMod    BlobModels
+}
+
+// WorkFingerprint 作品指纹，_id 为作品ID
+type WorkFingerprint struct {
+	WorkID                 bson.ObjectId `bson:"_id" json:"workID"`
+	UserID                 bson.ObjectId `bson:"userID" json:"userID"`
+	ContentID              bson.ObjectId `bson:"contentID" json:"contentID"`
+	OriginID               string        `bson:"originID" json:"originID"`
+	FileTime               time.Time     `bson:"fileTime" json:"fileTime"` //计算指纹时作品文件的修改时间
+	similarity.Fingerprint `bson:",inline"`
+}
+
+// SimilarPair 同一课时下两个学生的相似作品，WorkA < WorkB
+type SimilarPair struct {
+	ID        bson.ObjectId `bson:"_id" json:"id"`
+	ContentID bson.ObjectId `bson:"contentID" json:"contentID"`
+	Kind      string        `bson:"kind" json:"kind"`
+	WorkA     bson.ObjectId `bson:"workA" json:"workA"`
+	WorkB     bson.ObjectId `bson:"workB" json:"workB"`
+	UserA     bson.ObjectId `bson:"userA" json:"userA"`
+	UserB     bson.ObjectId `bson:"userB" json:"userB"`
+	Score     float64       `bson:"score" json:"score"`
+	CheckTime time.Time     `bson:"checkTime" json:"checkTime"`
+}
+
+// SimilarityWork 相似度报告中的作品
+type SimilarityWork struct {
+	WorkID   bson.ObjectId `json:"workID"`
+	Name     string        `json:"name"`
+	UserID   bson.ObjectId `json:"userID"`
+	Realname string        `json:"realname"`
+}
+
+// SimilarityRow 相似度报告的一行
+type SimilarityRow struct {
+	Kind  string         `json:"kind"`
+	Score float64        `json:"score"`
+	A     SimilarityWork `json:"a"`
+	B     SimilarityWork `json:"b"`
+}
+
+// UpdateFingerprints 为新增或修改过的课时作品计算指纹，返回更新了指纹的作品；作品删除后同时删除其指纹和相似记录
+func (simMod *SimilarityModels) UpdateFingerprints() ([]WorkFingerprint, error) {
+	var works []WorkBody
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"relpath": bson.M{"$regex": `\.(sb3|stl)$`}}
+		return col.Find(query).Select(bson.M{"userID": 1, "contentID": 1, "originID": 1, "relpath": 1}).All(&works)
+	}
+	if err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f); err != nil {
+		return nil, err
+	}
+	var stored []WorkFingerprint
+	ff := func(col *mgo.Collection) error {
+		return col.Find(nil).Select(bson.M{"fileTime": 1}).All(&stored)
+	}
+	if err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workfingerprint", ff); err != nil {
+		return nil, err
+	}
+	fileTimes := make(map[bson.ObjectId]time.Time, len(stored))
+	for _, fp := range stored {
+		fileTimes[fp.WorkID] = fp.FileTime
+	}
+
+	var changed []WorkFingerprint
+	for _, work := range works {
+		fileTime, ok := fileTimes[work.ID]
+		delete(fileTimes, work.ID)
+		ext := path.Ext(work.Relpath)
+		workID, suffix := strings.TrimSuffix(path.Base(work.Relpath), ext), strings.TrimPrefix(ext, ".")
+		file, err := simMod.BlobMod.StatWorkFile(workID, suffix)
+		if err != nil || (ok && !file.UpdateTime.After(fileTime)) {
+			continue
+		}
+		data, err := simMod.BlobMod.ReadWorkFile(workID, suffix)
+		if err != nil {
+			logs.Warn("work fingerprint:", work.ID.Hex(), err)
+			continue
+		}
+		fp, err := workFingerprint(data, strings.ToLower(ext))
+		if err != nil {
+			logs.Warn("work fingerprint:", work.ID.Hex(), err)
+			continue
+		}
+		wf := WorkFingerprint{WorkID: work.ID, UserID: work.UserID, ContentID: work.ContentID, OriginID: work.OriginID, FileTime: file.UpdateTime, Fingerprint: fp}
+		f := func(col *mgo.Collection) error {
+			_, err := col.UpsertId(wf.WorkID, wf)
+			return err
+		}
+		if err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workfingerprint", f); err != nil {
+			return changed, err
+		}
+		changed = append(changed, wf)
+	}
+	// 剩下的指纹对应的作品已被删除
+	for id := range fileTimes {
+		if err := simMod.removeFingerprint(id); err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// FindSimilarPairs 把更新了指纹的作品与同一课时下其他学生的同类作品比较，记录相似度不低于threshold的作品对。
+// 沿OriginID向上追溯，一个是另一个的(间接)改编来源，或两者有共同来源的不算抄袭
+func (simMod *SimilarityModels) FindSimilarPairs(changed []WorkFingerprint, threshold float64) error {
+	origins := make(map[bson.ObjectId]string)
+	for _, fp := range changed {
+		f := func(col *mgo.Collection) error {
+			_, err := col.RemoveAll(bson.M{"$or": []bson.M{{"workA": fp.WorkID}, {"workB": fp.WorkID}}})
+			return err
+		}
+		if err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "worksimilarity", f); err != nil {
+			return err
+		}
+		var others []WorkFingerprint
+		ff := func(col *mgo.Collection) error {
+			return col.Find(bson.M{"contentID": fp.ContentID, "kind": fp.Kind, "userID": bson.M{"$ne": fp.UserID}}).All(&others)
+		}
+		if err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workfingerprint", ff); err != nil {
+			return err
+		}
+		ancestry, err := simMod.remixAncestry(fp.WorkID, fp.OriginID, origins)
+		if err != nil {
+			return err
+		}
+		for _, other := range others {
+			otherAncestry, err := simMod.remixAncestry(other.WorkID, other.OriginID, origins)
+			if err != nil {
+				return err
+			}
+			if isRemix(ancestry, otherAncestry) {
+				continue
+			}
+			score := similarity.Compare(fp.Fingerprint, other.Fingerprint)
+			if score < threshold {
+				continue
+			}
+			pair := SimilarPair{ID: bson.NewObjectId(), ContentID: fp.ContentID, Kind: fp.Kind, WorkA: fp.WorkID, WorkB: other.WorkID, UserA: fp.UserID, UserB: other.UserID, Score: score, CheckTime: time.Now()}
+			if pair.WorkA > pair.WorkB {
+				pair.WorkA, pair.WorkB, pair.UserA, pair.UserB = pair.WorkB, pair.WorkA, pair.UserB, pair.UserA
+			}
+			fff := func(col *mgo.Collection) error {
+				return col.Insert(pair)
+			}
+			if err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "worksimilarity", fff); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ClassSimilarity 查询班级学生在课时作品中的相似作品对，相似度高的在前
+func (simMod *SimilarityModels) ClassSimilarity(class Class, contentID bson.ObjectId) ([]SimilarityRow, error) {
+	rows := []SimilarityRow{}
+	uids := make([]bson.ObjectId, 0, len(class.Students))
+	for _, s := range class.Students {
+		uids = append(uids, s.UserID)
+	}
+	var pairs []SimilarPair
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"contentID": contentID, "userA": bson.M{"$in": uids}, "userB": bson.M{"$in": uids}}
+		return col.Find(query).Sort("-score").All(&pairs)
+	}
+	if err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "worksimilarity", f); err != nil {
+		return rows, err
+	}
+	if len(pairs) == 0 {
+		return rows, nil
+	}
+	var users []User
+	ff := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": bson.M{"$in": uids}}).Select(bson.M{"realname": 1}).All(&users)
+	}
+	if err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "users", ff); err != nil {
+		return rows, err
+	}
+	realnames := make(map[bson.ObjectId]string, len(users))
+	for _, u := range users {
+		realnames[u.ID] = u.Realname
+	}
+	workIDs := make([]bson.ObjectId, 0, len(pairs)*2)
+	for _, p := range pairs {
+		workIDs = append(workIDs, p.WorkA, p.WorkB)
+	}
+	var works []WorkBody
+	fff := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": bson.M{"$in": workIDs}}).Select(bson.M{"name": 1}).All(&works)
+	}
+	if err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", fff); err != nil {
+		return rows, err
+	}
+	names := make(map[bson.ObjectId]string, len(works))
+	for _, w := range works {
+		names[w.ID] = w.Name
+	}
+	for _, p := range pairs {
+		rows = append(rows, SimilarityRow{
+			Kind:  p.Kind,
+			Score: p.Score,
+			A:     SimilarityWork{WorkID: p.WorkA, Name: names[p.WorkA], UserID: p.UserA, Realname: realnames[p.UserA]},
+			B:     SimilarityWork{WorkID: p.WorkB, Name: names[p.WorkB], UserID: p.UserB, Realname: realnames[p.UserB]},
+		})
+	}
+	return rows, nil
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// workFingerprint 用作品文件的内容计算指纹
+func workFingerprint(data []byte, ext string) (similarity.Fingerprint, error) {
+	digest, err := similarity.Digest(bytes.NewReader(data))
+	if err != nil {
+		return similarity.Fingerprint{}, err
+	}
+	if ext == ".sb3" {
+		project, err := scratch.ReadSB3(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return similarity.Fingerprint{}, err
+		}
+		return similarity.Scratch(project, digest), nil
+	}
+	mesh, err := stl.Parse(data)
+	if err != nil {
+		return similarity.Fingerprint{}, err
+	}
+	return similarity.Mesh(mesh, digest), nil
+}
+
+// remixAncestry 沿OriginID向上追溯作品的改编来源，返回作品自身及所有来源作品的ID。
+// origins 缓存已查询过的作品的OriginID，同一轮比较中每个作品只查一次
+func (simMod *SimilarityModels) remixAncestry(workID bson.ObjectId, originID string, origins map[bson.ObjectId]string) (map[bson.ObjectId]bool, error) {
+	origins[workID] = originID
+	ancestry := map[bson.ObjectId]bool{workID: true}
+	for origin := originID; bson.IsObjectIdHex(origin) && len(ancestry) <= maxRemixDepth; {
+		id := bson.ObjectIdHex(origin)
+		// 数据异常时防止出现环
+		if ancestry[id] {
+			break
+		}
+		ancestry[id] = true
+		next, ok := origins[id]
+		if !ok {
+			var work WorkBody
+			f := func(col *mgo.Collection) error {
+				return col.FindId(id).Select(bson.M{"originID": 1}).One(&work)
+			}
+			err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+			if err != nil && err != mgo.ErrNotFound {
+				return nil, err
+			}
+			// 来源作品已删除时追溯到此为止
+			next = work.OriginID
+			origins[id] = next
+		}
+		origin = next
+	}
+	return ancestry, nil
+}
+
+// isRemix 两个作品是否为改编关系：一个(间接)改编自另一个，或有共同的来源作品
+func isRemix(a, b map[bson.ObjectId]bool) bool {
+	for id := range a {
+		if b[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFingerprint 删除作品的指纹和相似记录
+func (simMod *SimilarityModels) removeFingerprint(workID bson.ObjectId) error {
+	f := func(col *mgo.Collection) error {
+		return col.RemoveId(workID)
+	}
+	if err := simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "workfingerprint", f); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	ff := func(col *mgo.Collection) error {
+		_, err := col.RemoveAll(bson.M{"$or": []bson.M{{"workA": workID}, {"workB": workID}}})
+		return err
+	}
+	return simMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "worksimilarity", ff)
+}
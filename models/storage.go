@@ -0,0 +1,128 @@
+// @Title 文件存储模型
+// @Description 作品、头像和课程资源文件通过存储后端读写：本地目录（程序目录）或兼容S3的对象存储，由 storage 配置项选择。
+// 文件名为相对程序目录的路径，如 asset/works/<id>.stl；使用S3时 /asset 下的静态文件由 AssetFilter 从对象存储发送
+
+package models
+
+import (
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"maiyajia.com/services/storage"
+)
+
+var (
+	assetOnce    sync.Once
+	assetStorage storage.Storage
+	assetErr     error
+)
+
+// CheckAssets 按配置创建存储后端，只创建一次。配置有误（如 storage = s3 但缺少S3的配置）时返回错误，程序启动时检查
+func CheckAssets() error {
+	assetOnce.Do(func() {
+		assetStorage, assetErr = storage.New(StorageConfig(""))
+		if assetErr != nil {
+			assetStorage = unavailableStorage{assetErr}
+		}
+	})
+	return assetErr
+}
+
+// Assets 配置的存储后端。配置有误时不退回到本地存储，以免文件写到错误的位置，所有读写都返回配置错误
+func Assets() storage.Storage {
+	CheckAssets()
+	return assetStorage
+}
+
+// LocalAssets 存储后端是否是本地存储，本地存储的 /asset 由静态文件服务直接发送
+func LocalAssets() bool {
+	_, ok := Assets().(*storage.Local)
+	return ok
+}
+
+// StorageConfig 按配置文件生成存储后端的配置，backend 为空时使用 storage 配置项（默认 local）
+func StorageConfig(backend string) storage.Config {
+	if backend == "" {
+		backend = beego.AppConfig.DefaultString("storage", storage.BackendLocal)
+	}
+	return storage.Config{
+		Backend:   backend,
+		Dir:       beego.AppPath,
+		Endpoint:  beego.AppConfig.String("storage_s3_endpoint"),
+		Region:    beego.AppConfig.String("storage_s3_region"),
+		Bucket:    beego.AppConfig.String("storage_s3_bucket"),
+		AccessKey: beego.AppConfig.String("storage_s3_access_key"),
+		SecretKey: beego.AppConfig.String("storage_s3_secret_key"),
+	}
+}
+
+// MigrateStorage 把 asset 目录和作品文件存储目录下的文件从存储后端from复制到to，返回复制的文件数。
+// 已经存在且大小相同的文件跳过，中断后可以重新执行
+func MigrateStorage(from, to string) (int, error) {
+	src, err := storage.New(StorageConfig(from))
+	if err != nil {
+		return 0, err
+	}
+	dst, err := storage.New(StorageConfig(to))
+	if err != nil {
+		return 0, err
+	}
+	copied := 0
+	for _, dir := range []string{"asset", blobDir(), FeedbackAudioDir} {
+		n, err := storage.Migrate(src, dst, dir, func(info storage.Info) {
+			logs.Info("migrate:", info.Name, info.Size)
+		})
+		copied += n
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// RemoveAssetDir 删除目录dir（相对程序目录）下的所有文件，包括本地还没有保存到存储后端的文件
+func RemoveAssetDir(dir string) error {
+	if err := os.RemoveAll(assetPath(dir)); err != nil || LocalAssets() {
+		return err
+	}
+	return storage.RemoveAll(Assets(), dir)
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// assetPath 文件名对应的本地路径，用于只能读写本地文件的第三方库；使用S3时为程序目录下的临时位置
+func assetPath(name string) string {
+	return path.Join(beego.AppPath, name)
+}
+
+// publishAssetDir 本地目录dir（相对程序目录）中解压的文件保存到存储后端；使用S3时保存后删除本地文件
+func publishAssetDir(dir string) error {
+	if LocalAssets() {
+		return nil
+	}
+	if err := storage.PutDir(Assets(), dir, assetPath(dir)); err != nil {
+		return err
+	}
+	return os.RemoveAll(assetPath(dir))
+}
+
+// unavailableStorage 存储后端配置有误时使用，所有操作都返回配置错误
+type unavailableStorage struct {
+	err error
+}
+
+func (st unavailableStorage) Open(name string) (io.ReadCloser, error) { return nil, st.err }
+func (st unavailableStorage) Stat(name string) (storage.Info, error)  { return storage.Info{}, st.err }
+func (st unavailableStorage) Put(name string, r io.Reader) error      { return st.err }
+func (st unavailableStorage) PutFile(name, filename string) error     { return st.err }
+func (st unavailableStorage) Copy(src, dst string) error              { return st.err }
+func (st unavailableStorage) Remove(name string) error                { return st.err }
+func (st unavailableStorage) Walk(dir string, fn func(info storage.Info) error) error {
+	return st.err
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -501,6 +502,36 @@ func getIconURL(indexpath string, tool *Tool) {
 	}
 }
 
+// toolNamePattern 工具名用作 asset/tools 下的目录名
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ValidToolName 工具名只能包含字母、数字、下划线和减号
+func ValidToolName(name string) bool {
+	return toolNamePattern.MatchString(name)
+}
+
+// ToolPackagePath 工具包的保存位置
+func ToolPackagePath(name string) string {
+	return path.Join(beego.AppPath, "asset", "tools", name, "tool.tar")
+}
+
+// InstallToolPackage 解压已上传到 ToolPackagePath 的工具包并写入工具信息，已安装的同名工具被覆盖
+func (toolMod *ToolModels) InstallToolPackage(tool Tool) error {
+	if old, err := toolMod.GetTool(tool.Name); err == nil {
+		tool.ID = old.ID
+	} else if err == mgo.ErrNotFound {
+		tool.ID = bson.NewObjectId()
+	} else {
+		return err
+	}
+	tool.CreateTime = time.Now()
+	if err := archiver.Tar.Open(ToolPackagePath(tool.Name), path.Join(beego.AppPath, "asset", "tools", tool.Name)); err != nil {
+		os.Remove(ToolPackagePath(tool.Name))
+		return err
+	}
+	return toolMod.handleTool(tool)
+}
+
 //InsertTool 写入下载工具信息
 func (toolMod *ToolModels) insertTool(tool Tool) error {
 	f := func(col *mgo.Collection) error {
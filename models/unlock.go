@@ -0,0 +1,268 @@
+// @Title 课时解锁规则模型
+// @Description 课程或班级的课时解锁规则：按顺序解锁、达到练习最低分解锁、按老师设定的日期解锁
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+)
+
+type UnlockModels struct {
+	MgoSession *mongo.MgoClient
+	CourseMod  CourseModels
+}
+
+// 课时锁定原因
+const (
+	LockBySequence = "sequence" //前一课时未学完
+	LockByScore    = "score"    //前一课时练习未达到最低分
+	LockByDate     = "date"     //未到老师设定的开放日期
+	LockByLogin    = "login"    //未登录或登录已过期
+)
+
+// ErrItemNotInCourse 课时或学习资源不属于指定的课程
+var ErrItemNotInCourse = errors.New("item not in course")
+
+// UnlockRule 课时解锁规则，ClassCode为空时表示课程的默认规则，否则为老师对班级设定的规则
+type UnlockRule struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	CourseID   bson.ObjectId `bson:"courseID" json:"courseID"`
+	ClassCode  string        `bson:"classCode" json:"classCode"`
+	Sequential bool          `bson:"sequential" json:"sequential"` //是否按顺序解锁
+	MinScore   float64       `bson:"minScore" json:"minScore"`     //解锁下一课时需要的练习最低分，0表示不限制
+	Dates      []LessonDate  `bson:"dates" json:"dates"`           //课时开放日期
+	Creator    bson.ObjectId `bson:"creator" json:"creator"`
+	UpdateTime time.Time     `bson:"updateTime" json:"updateTime"`
+}
+
+// LessonDate 课时开放日期
+type LessonDate struct {
+	LessonID   bson.ObjectId `bson:"lessonID" json:"lessonID"`
+	UnlockTime time.Time     `bson:"unlockTime" json:"unlockTime"`
+}
+
+// LessonLock 课时的锁定状态
+type LessonLock struct {
+	LessonID   bson.ObjectId `json:"lessonID"`
+	Locked     bool          `json:"locked"`
+	Reason     string        `json:"reason,omitempty"`
+	UnlockTime *time.Time    `json:"unlockTime,omitempty"`
+}
+
+// UpsertUnlockRule 设置课程或班级的解锁规则，按课程和班级确定规则，忽略rule.ID
+func (unlockMod *UnlockModels) UpsertUnlockRule(rule UnlockRule) error {
+	rule.UpdateTime = time.Now()
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"courseID": rule.CourseID, "classCode": rule.ClassCode}
+		existing := UnlockRule{}
+		if err := col.Find(query).One(&existing); err == nil {
+			rule.ID = existing.ID
+		} else if err == mgo.ErrNotFound {
+			rule.ID = bson.NewObjectId()
+		} else {
+			return err
+		}
+		_, err := col.Upsert(query, rule)
+		return err
+	}
+	return unlockMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "unlockrule", f)
+}
+
+// FindUnlockRule 查询课程或班级的解锁规则
+func (unlockMod *UnlockModels) FindUnlockRule(courseID bson.ObjectId, classCode string) (UnlockRule, error) {
+	var rule UnlockRule
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"courseID": courseID, "classCode": classCode}).One(&rule)
+	}
+	err := unlockMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "unlockrule", f)
+	return rule, err
+}
+
+// StudentUnlockRule 查询对学生生效的解锁规则：优先使用学生所在班级的规则，其次为课程默认规则，都没有时返回nil
+func (unlockMod *UnlockModels) StudentUnlockRule(uid, courseID bson.ObjectId) (*UnlockRule, error) {
+	codes, err := unlockMod.CourseMod.StudentCourseClasses(uid, courseID)
+	if err != nil {
+		return nil, err
+	}
+	var rules []UnlockRule
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"courseID": courseID, "classCode": bson.M{"$in": append(codes, "")}}
+		return col.Find(query).Sort("-updateTime").All(&rules)
+	}
+	if err := unlockMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "unlockrule", f); err != nil {
+		return nil, err
+	}
+	var courseRule *UnlockRule
+	for i := range rules {
+		if rules[i].ClassCode != "" {
+			return &rules[i], nil
+		}
+		courseRule = &rules[i]
+	}
+	return courseRule, nil
+}
+
+// StudentLessonLocks 计算学生在指定课程下每个课时的锁定状态
+func (unlockMod *UnlockModels) StudentLessonLocks(uid, courseID bson.ObjectId) ([]LessonLock, error) {
+	course, err := unlockMod.CourseMod.GetAllLessions(courseID)
+	if err != nil {
+		return nil, err
+	}
+	return unlockMod.lessonLocks(uid, course)
+}
+
+// IsItemLocked 判断课程下的课时或学习资源对学生是否处于锁定状态，不属于该课程时返回 ErrItemNotInCourse
+func (unlockMod *UnlockModels) IsItemLocked(uid, courseID, itemID bson.ObjectId) (bool, error) {
+	course, err := unlockMod.CourseMod.GetAllLessions(courseID)
+	if err == mgo.ErrNotFound {
+		return false, ErrItemNotInCourse
+	}
+	if err != nil {
+		return false, err
+	}
+	index := -1
+	for i, lesson := range course.Lessions {
+		if lesson.ID == itemID {
+			index = i
+		}
+		for _, content := range lesson.Contents {
+			if content.ID == itemID {
+				index = i
+			}
+		}
+	}
+	if index < 0 {
+		return false, ErrItemNotInCourse
+	}
+	locks, err := unlockMod.lessonLocks(uid, course)
+	if err != nil {
+		return false, err
+	}
+	return locks[index].Locked, nil
+}
+
+// EvaluateLessonLocks 根据解锁规则、已完成的学习资源和练习最高分计算每个课时的锁定状态
+func EvaluateLessonLocks(lessons []Lession, rule *UnlockRule, finished map[bson.ObjectId]bool, scores map[bson.ObjectId]float64, now time.Time) []LessonLock {
+	locks := make([]LessonLock, len(lessons))
+	for i, lesson := range lessons {
+		locks[i].LessonID = lesson.ID
+		if rule == nil {
+			continue
+		}
+		for _, date := range rule.Dates {
+			if date.LessonID == lesson.ID && now.Before(date.UnlockTime) {
+				unlockTime := date.UnlockTime
+				locks[i].Locked = true
+				locks[i].Reason = LockByDate
+				locks[i].UnlockTime = &unlockTime
+			}
+		}
+		if locks[i].Locked || i == 0 {
+			continue
+		}
+		prev := lessons[i-1]
+		if rule.Sequential && (locks[i-1].Locked || !lessonFinished(prev, finished)) {
+			locks[i].Locked = true
+			locks[i].Reason = LockBySequence
+			continue
+		}
+		if rule.MinScore > 0 && lessonHasExercise(prev) && scores[prev.ID] < rule.MinScore {
+			locks[i].Locked = true
+			locks[i].Reason = LockByScore
+		}
+	}
+	return locks
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// lessonLocks 计算学生在已查询出的课程下每个课时的锁定状态
+func (unlockMod *UnlockModels) lessonLocks(uid bson.ObjectId, course CourseWithLession) ([]LessonLock, error) {
+	courseID := course.ID
+	lessons := make([]*Lession, len(course.Lessions))
+	for i := range course.Lessions {
+		lessons[i] = &course.Lessions[i]
+	}
+	if err := loadLessonQuestions(unlockMod.MgoSession, lessons); err != nil {
+		return nil, err
+	}
+	rule, err := unlockMod.StudentUnlockRule(uid, courseID)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return EvaluateLessonLocks(course.Lessions, nil, nil, nil, time.Now()), nil
+	}
+	finished, err := unlockMod.finishedItems(uid, courseID)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := unlockMod.bestExerciseScores(uid, courseID)
+	if err != nil {
+		return nil, err
+	}
+	return EvaluateLessonLocks(course.Lessions, rule, finished, scores, time.Now()), nil
+}
+
+// finishedItems 查询学生在课程下已完成的学习资源
+func (unlockMod *UnlockModels) finishedItems(uid, courseID bson.ObjectId) (map[bson.ObjectId]bool, error) {
+	var progress LessionProgress
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"courseID": courseID, "userID": uid}).One(&progress)
+	}
+	err := unlockMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "courseprogress", f)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	finished := make(map[bson.ObjectId]bool)
+	for _, id := range progress.FinishItems {
+		finished[id] = true
+	}
+	return finished, nil
+}
+
+// bestExerciseScores 查询学生在课程下每个课时的练习最高分
+func (unlockMod *UnlockModels) bestExerciseScores(uid, courseID bson.ObjectId) (map[bson.ObjectId]float64, error) {
+	var result []struct {
+		LessonID bson.ObjectId `bson:"_id"`
+		Score    float64       `bson:"score"`
+	}
+	pipeline := []bson.M{
+		{"$match": bson.M{"userID": uid, "courseID": courseID}},
+		{"$group": bson.M{"_id": "$lessonID", "score": bson.M{"$max": "$score"}}},
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Pipe(pipeline).All(&result)
+	}
+	if err := unlockMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "exercise", f); err != nil {
+		return nil, err
+	}
+	scores := make(map[bson.ObjectId]float64)
+	for _, item := range result {
+		scores[item.LessonID] = item.Score
+	}
+	return scores, nil
+}
+
+// lessonFinished 课时下的学习资源全部完成即为学完
+func lessonFinished(lesson Lession, finished map[bson.ObjectId]bool) bool {
+	for _, content := range lesson.Contents {
+		if !finished[content.ID] {
+			return false
+		}
+	}
+	return true
+}
+
+// lessonHasExercise 课时是否配置了练习
+func lessonHasExercise(lesson Lession) bool {
+	return len(lesson.Questions) > 0
+}
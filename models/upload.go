@@ -0,0 +1,180 @@
+// @Title 分块上传模型
+// @Description 大文件按分块上传，每个分块带偏移量和SHA-256校验，断线后查询已接收的字节数继续上传；全部接收后校验整个文件，原子地移动到作品、课程包或工具包的位置
+
+package models
+
+import (
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/upload"
+)
+
+// 上传的文件类型
+const (
+	UploadWork   = "work"   //作品文件
+	UploadCourse = "course" //离线课程包（管理员）
+	UploadTool   = "tool"   //工具包（管理员）
+)
+
+// 可以分块上传的作品文件
+var uploadWorkSuffixes = []string{"stl", "sgl", "sb3", "Z1"}
+
+var (
+	uploadOnce  sync.Once
+	uploadStore *upload.Store
+)
+
+type UploadModels struct {
+	MgoSession *mongo.MgoClient
+}
+
+// UploadSession 上传会话，Offset 为已接收的字节数，从临时文件的大小得到
+type UploadSession struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	UserID     bson.ObjectId `bson:"userID" json:"userID"`
+	Kind       string        `bson:"kind" json:"kind"`
+	Filename   string        `bson:"filename,omitempty" json:"filename,omitempty"`
+	Size       int64         `bson:"size" json:"size"`
+	SHA256     string        `bson:"sha256,omitempty" json:"sha256,omitempty"` //整个文件的SHA-256，为空时不校验
+	Offset     int64         `bson:"-" json:"offset"`
+	WorkID     string        `bson:"workID,omitempty" json:"workID,omitempty"`
+	Suffix     string        `bson:"suffix,omitempty" json:"suffix,omitempty"`
+	Tool       *Tool         `bson:"tool,omitempty" json:"tool,omitempty"` //工具包安装后的工具信息
+	CreateTime time.Time     `bson:"createTime" json:"createTime"`
+	ExpireTime time.Time     `bson:"expireTime" json:"expireTime"`
+}
+
+// ValidUploadSuffix 是否是可以分块上传的作品文件
+func ValidUploadSuffix(suffix string) bool {
+	for _, s := range uploadWorkSuffixes {
+		if s == suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadLimits 上传限制：upload_max_mb 单个文件的最大大小（默认1024），upload_chunk_mb 单个分块的最大大小（默认8）
+func UploadLimits() (maxSize, chunkSize int64) {
+	maxSize = int64(beego.AppConfig.DefaultInt("upload_max_mb", 1024)) << 20
+	chunkSize = int64(beego.AppConfig.DefaultInt("upload_chunk_mb", 8)) << 20
+	return maxSize, chunkSize
+}
+
+// CreateUpload 创建上传会话和空的临时文件
+func (uploadMod *UploadModels) CreateUpload(session *UploadSession) error {
+	session.ID = bson.NewObjectId()
+	session.CreateTime = time.Now()
+	session.ExpireTime = uploadExpireTime()
+	session.Offset = 0
+	if err := uploads().Create(session.ID.Hex()); err != nil {
+		return err
+	}
+	f := func(col *mgo.Collection) error {
+		return col.Insert(session)
+	}
+	if err := uploadMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "uploadsession", f); err != nil {
+		uploads().Remove(session.ID.Hex())
+		return err
+	}
+	return nil
+}
+
+// FindUpload 查询上传会话和已接收的字节数，会话过期或临时文件不存在时返回 mgo.ErrNotFound
+func (uploadMod *UploadModels) FindUpload(id bson.ObjectId) (UploadSession, error) {
+	var session UploadSession
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"_id": id, "expireTime": bson.M{"$gt": time.Now()}}).One(&session)
+	}
+	if err := uploadMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "uploadsession", f); err != nil {
+		return session, err
+	}
+	offset, err := uploads().Offset(id.Hex())
+	if err == upload.ErrNotFound {
+		return session, mgo.ErrNotFound
+	}
+	session.Offset = offset
+	return session, err
+}
+
+// WriteUploadChunk 在offset处写入一个分块，sum 为分块的SHA-256，返回已接收的字节数。每写入一个分块会话延长有效期
+func (uploadMod *UploadModels) WriteUploadChunk(session *UploadSession, offset int64, r io.Reader, sum string) (int64, error) {
+	received, err := uploads().Write(session.ID.Hex(), offset, session.Size, r, sum)
+	session.Offset = received
+	if err != nil {
+		return received, err
+	}
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(session.ID, bson.M{"$set": bson.M{"expireTime": uploadExpireTime()}})
+	}
+	return received, uploadMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "uploadsession", f)
+}
+
+// CompleteUpload 检查文件已经全部接收并且校验一致，然后移动到dst并结束会话
+func (uploadMod *UploadModels) CompleteUpload(session UploadSession, dst string) error {
+	if err := uploads().Complete(session.ID.Hex(), session.Size, session.SHA256, dst); err != nil {
+		return err
+	}
+	f := func(col *mgo.Collection) error {
+		return col.RemoveId(session.ID)
+	}
+	return uploadMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "uploadsession", f)
+}
+
+// RemoveUpload 取消上传会话并删除临时文件
+func (uploadMod *UploadModels) RemoveUpload(id bson.ObjectId) error {
+	if err := uploads().Remove(id.Hex()); err != nil {
+		return err
+	}
+	f := func(col *mgo.Collection) error {
+		return col.RemoveId(id)
+	}
+	err := uploadMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "uploadsession", f)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// RemoveExpiredUploads 删除已经过期的上传会话和临时文件
+func (uploadMod *UploadModels) RemoveExpiredUploads() (int, error) {
+	var sessions []UploadSession
+	f := func(col *mgo.Collection) error {
+		return col.Find(bson.M{"expireTime": bson.M{"$lte": time.Now()}}).Select(bson.M{"_id": 1}).All(&sessions)
+	}
+	if err := uploadMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "uploadsession", f); err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, session := range sessions {
+		if err := uploadMod.RemoveUpload(session.ID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本模型的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// uploads 上传临时文件的保存目录 upload_dir，默认为 tmp/uploads
+func uploads() *upload.Store {
+	uploadOnce.Do(func() {
+		uploadStore = upload.NewStore(path.Join(beego.AppPath, beego.AppConfig.DefaultString("upload_dir", "tmp/uploads")))
+	})
+	return uploadStore
+}
+
+// uploadExpireTime 上传会话在最后一次写入 upload_session_hours（默认24）小时后过期
+func uploadExpireTime() time.Time {
+	return time.Now().Add(time.Duration(beego.AppConfig.DefaultInt("upload_session_hours", 24)) * time.Hour)
+}
@@ -12,7 +12,10 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
+	"image"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/astaxie/beego"
@@ -602,7 +605,6 @@ func avatarBuilder(gender int, salt string) string {
 	// 当随机生成头像出错时，会返回默认的系统头像，该头像存放在 asset/users/avatar_default.png
 	random := strconv.FormatInt(time.Now().UnixNano(), 10)
 	avatarDir := "asset/users/avatar/"
-	util.CreateDir(avatarDir)
 	file := avatarDir + salt + "_" + random + ".png"
 
 	// 根据性别和种子字符串(可选)随机产生头像，并保存到filePath指定的文件
@@ -612,15 +614,35 @@ func avatarBuilder(gender int, salt string) string {
 		return "asset/users/avatar_default.png"
 	}
 
-	if err := avatar.SaveAvatarToFile(img, file); err != nil {
+	if err := saveAvatar(img, file); err != nil {
 		beego.Error(err)
-		beego.Debug("SaveAvatarToFile")
+		beego.Debug("saveAvatar")
 		return "asset/users/avatar_default.png"
 	}
 
 	return file
 }
 
+// saveAvatar 把头像图片保存到存储后端
+func saveAvatar(img image.Image, file string) error {
+	var buf bytes.Buffer
+	if err := avatar.Encode(&buf, img, path.Ext(file)); err != nil {
+		return err
+	}
+	return Assets().Put(file, &buf)
+}
+
+// readAvatar 从存储后端读取头像图片
+func readAvatar(file string) (image.Image, error) {
+	rc, err := Assets().Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	img, _, err := image.Decode(rc)
+	return img, err
+}
+
 // gradeTagBuidler 根据年级和班级，生成用户信息中的grade字段信息
 func gradeTagBuidler(grade, class int) string {
 	var text = "%s%d年%d班"
@@ -634,6 +656,30 @@ func gradeTagBuidler(grade, class int) string {
 	}
 }
 
+// gradeLevel 从 gradeTagBuidler 生成的年级标签（如“小学3年2班”）解析出从小学一年级算起的年级数，无法识别时返回0
+func gradeLevel(tag string) int {
+	stages := []struct {
+		name   string
+		offset int
+	}{{"小学", 0}, {"初中", 6}, {"高中", 9}}
+	for _, stage := range stages {
+		if !strings.HasPrefix(tag, stage.name) {
+			continue
+		}
+		rest := strings.TrimPrefix(tag, stage.name)
+		i := strings.Index(rest, "年")
+		if i < 0 {
+			return 0
+		}
+		grade, err := strconv.Atoi(rest[:i])
+		if err != nil || grade <= 0 {
+			return 0
+		}
+		return grade + stage.offset
+	}
+	return 0
+}
+
 func genderTagBuidler(gender int) string {
 	switch gender {
 	case 0:
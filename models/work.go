@@ -1,15 +1,22 @@
 package models
 
 import (
+	"bytes"
 	"fmt"
+	"image/png"
 	"io/ioutil"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/astaxie/beego"
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/render"
+	"maiyajia.com/services/scratch"
+	"maiyajia.com/services/stl"
+	"maiyajia.com/services/storage"
 )
 
 type WorkModels struct {
@@ -32,6 +39,7 @@ type BaseBody struct {
 	Laud        int64         `bson:"laud" json:"laud"`               //作品点赞数
 	Favor       int64         `bson:"favor" json:"favor"`             //作品收藏数
 	Browse      int64         `bson:"browse" json:"browse"`           //作品浏览数
+	Remix       int64         `bson:"remix" json:"remix"`             //作品被改编数
 	Tool        string        `bson:"tool" json:"tool"`               //默认打开的工具
 	Description string        `bson:"description" json:"description"` //作品简介
 	Edit        bool          `bson:"edit" json:"edit"`               //分享后是否允许编辑
@@ -41,14 +49,19 @@ type BaseBody struct {
 
 // WorkBody 作品数据结构
 type WorkBody struct {
-	BaseBody   `bson:",inline"`
-	OriginID   string `bson:"originID" json:"-"`            //原作品id(用于收藏)
-	Data       string `bson:"data" json:"data"`             //作品内容
-	Relpath    string `bson:"relpath" json:"relpath"`       //作品的下载地址
-	ToolURL    string `bson:"toolURL" json:"toolURL"`       //下载地址
-	CreateTime int64  `bson:"createTime" json:"createTime"` //创建时间
-	Public     bool   `bson:"public" json:"public"`         //是否分享true分享
-	Category   string `bson:"category" json:"category"`
+	BaseBody         `bson:",inline"`
+	OriginID         string              `bson:"originID" json:"-"`            //原作品id(用于收藏)
+	Data             string              `bson:"data" json:"data"`             //作品内容
+	Relpath          string              `bson:"relpath" json:"relpath"`       //作品的下载地址
+	ToolURL          string              `bson:"toolURL" json:"toolURL"`       //下载地址
+	CreateTime       int64               `bson:"createTime" json:"createTime"` //创建时间
+	Public           bool                `bson:"public" json:"public"`         //是否分享true分享
+	Category         string              `bson:"category" json:"category"`
+	Assessment       *scratch.Assessment `bson:"assessment,omitempty" json:"assessment,omitempty"`   //Scratch作品的评估结果
+	Meta             *scratch.Metadata   `bson:"meta,omitempty" json:"meta,omitempty"`               //Scratch作品的元数据
+	PrintReport      *PrintReport        `bson:"printReport,omitempty" json:"printReport,omitempty"` //STL作品的打印检查报告
+	CommentsDisabled bool                `bson:"commentsDisabled" json:"commentsDisabled"`           //作者关闭了评论
+	Storage          int64               `bson:"storage" json:"storage"`                             //作品文件占用的空间（字节）
 }
 type WorkForm struct {
 	ID          bson.ObjectId `bson:"_id" form:"id"`                  //作品ID
@@ -180,6 +193,7 @@ func (workMod *WorkModels) GetPreview(id string) (map[string]interface{}, error)
 			"browse":      1,
 			"favor":       1,
 			"laud":        1,
+			"remix":       1,
 			"types":       1,
 			"edit":        1,
 			"contentID":   1,
@@ -232,6 +246,7 @@ func (workMod *WorkModels) GetWorksByCategory(paging PagingInfo, sortCategory in
 			"tool":          1,
 			"toolURL":       1,
 			"favor":         1,
+			"remix":         1,
 			"edit":          1,
 			"user.realname": 1,
 		},
@@ -258,7 +273,7 @@ func (workMod *WorkModels) GetPersonShareWorks(paging PagingInfo, userID string)
 
 	var works []WorkListBody
 	f := func(col *mgo.Collection) error {
-		return col.Find(bson.M{"public": true, "userID": bson.ObjectIdHex(userID)}).Select(bson.M{"_id": 1, "userID": 1, "name": 1, "picture": 1, "description": 1, "createTime": 1, "laud": 1, "browse": 1, "tool": 1, "toolURL": 1, "favor": 1, "edit": 1}).Limit(limit).Skip(offset).All(&works)
+		return col.Find(bson.M{"public": true, "userID": bson.ObjectIdHex(userID)}).Select(bson.M{"_id": 1, "userID": 1, "name": 1, "picture": 1, "description": 1, "createTime": 1, "laud": 1, "browse": 1, "tool": 1, "toolURL": 1, "favor": 1, "remix": 1, "edit": 1}).Limit(limit).Skip(offset).All(&works)
 	}
 
 	err := workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
@@ -278,8 +293,48 @@ func (workMod *WorkModels) GetPersonShareWorksCou(userID string) ([]PerShareCou,
 	return perShareCous, err
 }
 
-// GetShareWorks 获取分享的作品列表
-func (workMod *WorkModels) GetShareWorks(paging PagingInfo, sortCategory int) (interface{}, error) {
+// ScratchFacets 分享作品列表按Scratch作品元数据筛选的条件，零值表示不限
+type ScratchFacets struct {
+	Extension  string //使用了该扩展，如 pen
+	Category   string //使用了该分类的积木，如 sensing
+	Cloud      bool   //使用了云变量
+	MinSprites int    //角色数不少于
+	MinBlocks  int    //积木数不少于
+}
+
+// Valid 分类和扩展名只能是字母数字，避免拼出任意的查询字段
+func (facets ScratchFacets) Valid() bool {
+	for _, r := range facets.Extension + facets.Category {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return facets.MinSprites >= 0 && facets.MinBlocks >= 0
+}
+
+// Query 分享作品的查询条件
+func (facets ScratchFacets) Query() bson.M {
+	query := bson.M{"public": true}
+	if facets.Extension != "" {
+		query["meta.extensions"] = facets.Extension
+	}
+	if facets.Category != "" {
+		query["meta.categories."+facets.Category] = bson.M{"$gt": 0}
+	}
+	if facets.Cloud {
+		query["meta.cloudVariables"] = true
+	}
+	if facets.MinSprites > 0 {
+		query["meta.sprites"] = bson.M{"$gte": facets.MinSprites}
+	}
+	if facets.MinBlocks > 0 {
+		query["meta.blocks"] = bson.M{"$gte": facets.MinBlocks}
+	}
+	return query
+}
+
+// GetShareWorks 获取分享的作品列表，可按Scratch作品元数据筛选
+func (workMod *WorkModels) GetShareWorks(paging PagingInfo, sortCategory int, facets ScratchFacets) (interface{}, error) {
 
 	offset := paging.Offset()
 	limit := paging.Limit()
@@ -294,7 +349,7 @@ func (workMod *WorkModels) GetShareWorks(paging PagingInfo, sortCategory int) (i
 	// 	return col.Find(bson.M{"public": true}).Select(bson.M{"_id": 1, "userID": 1, "name": 1, "picture": 1, "description": 1, "createTime": 1, "laud": 1, "browse": 1, "tool": 1, "toolURL": 1, "favor": 1}).Sort(sort).Limit(limit).Skip(offset).All(&works)
 	// }
 	pipeline := []bson.M{
-		{"$match": bson.M{"public": true}},
+		{"$match": facets.Query()},
 		{"$lookup": bson.M{
 			"from":         "users",
 			"foreignField": "_id",
@@ -314,7 +369,9 @@ func (workMod *WorkModels) GetShareWorks(paging PagingInfo, sortCategory int) (i
 			"tool":          1,
 			"toolURL":       1,
 			"favor":         1,
+			"remix":         1,
 			"edit":          1,
+			"meta":          1,
 			"user.realname": 1,
 		}},
 		{"$sort": bson.M{sort: -1}},
@@ -354,10 +411,10 @@ func (workMod *WorkModels) GetWorksByID(userID string, params string, paging Pag
 }
 
 // QueryShareWorksCount 查询作品分享次数
-func (workMod *WorkModels) QueryShareWorksCount() (TotalBody, error) {
+func (workMod *WorkModels) QueryShareWorksCount(facets ScratchFacets) (TotalBody, error) {
 
 	pipeline := []bson.M{
-		{"$match": bson.M{"public": true}},
+		{"$match": facets.Query()},
 		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": 1}}},
 		{"$project": bson.M{"_id": 0}},
 	}
@@ -435,6 +492,7 @@ func (workMod *WorkModels) CopyWork(work WorkBody, userID, id, name string, newi
 	work.Laud = 0
 	work.Favor = 0
 	work.Browse = 0
+	work.Remix = 0
 	for _, toolName := range toolNames {
 		if work.Tool == toolName {
 			work.Relpath = ""
@@ -447,8 +505,25 @@ func (workMod *WorkModels) CopyWork(work WorkBody, userID, id, name string, newi
 	f := func(col *mgo.Collection) error {
 		return col.Insert(work)
 	}
-	return workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+	if err := workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f); err != nil {
+		return err
+	}
+	return workMod.RemixCount(id, 1)
+}
 
+// RemixCount 改编作品或删除改编作品时增减原作品的被改编数，原作品已删除时忽略
+func (workMod *WorkModels) RemixCount(originID string, delta int) error {
+	if !bson.IsObjectIdHex(originID) {
+		return nil
+	}
+	f := func(col *mgo.Collection) error {
+		err := col.UpdateId(bson.ObjectIdHex(originID), bson.M{"$inc": bson.M{"remix": delta}})
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	return workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
 }
 
 // NewWork 初始化新的作品信息
@@ -659,6 +734,7 @@ func (workMod *WorkModels) GetUserFavoriteWork(workid bson.ObjectId) (bson.M, er
 			"browse":        1,
 			"favor":         1,
 			"laud":          1,
+			"remix":         1,
 			"edit":          1,
 			"user.realname": 1,
 		},
@@ -711,7 +787,10 @@ func (workMod *WorkModels) ScratchProjectCount(userID string) (TotalBody, error)
 }
 
 // NewScratch 初始化新的scratch作品信息
-func (workMod *WorkModels) NewScratch(userID string, name string, tool string, types string, picture string, description string, toolurl string, toolCategory string) *WorkBody {
+func (workMod *WorkModels) NewScratch(userID string, contentID string, name string, tool string, types string, picture string, description string, toolurl string, toolCategory string) *WorkBody {
+	if !bson.IsObjectIdHex(contentID) {
+		contentID = NewID()
+	}
 
 	nowTime := time.Now().Unix()
 	id := bson.NewObjectId()
@@ -727,6 +806,7 @@ func (workMod *WorkModels) NewScratch(userID string, name string, tool string, t
 			Picture:     picture,
 			Description: description,
 			Types:       types,
+			ContentID:   bson.ObjectIdHex(contentID),
 		},
 		Relpath:    relpath,
 		ToolURL:    toolurl,
@@ -780,6 +860,7 @@ func (workMod *WorkModels) GetClassStudents(classCode, contentID string) (interf
 					},
 					"as": "user",
 				}},
+				{"$lookup": bson.M{"from": "workgrade", "localField": "_id", "foreignField": "workID", "as": "grade"}},
 			},
 			"as": "work",
 		},
@@ -809,6 +890,7 @@ func (workMod *WorkModels) GetClassStudents(classCode, contentID string) (interf
 			"work.types":       1,
 			"work.user":        1,
 			"work.userID":      1,
+			"work.grade":       1,
 		}},
 	}
 	f := func(col *mgo.Collection) error {
@@ -817,3 +899,238 @@ func (workMod *WorkModels) GetClassStudents(classCode, contentID string) (interf
 	err := workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "classes", f)
 	return &result, err
 }
+
+// LessonScratchChecks 课时的Scratch作品检查项，按课时ID单独保存，重新安装或升级课程时不会被覆盖
+type LessonScratchChecks struct {
+	LessonID   bson.ObjectId    `bson:"_id" json:"lessonID"`
+	CourseID   bson.ObjectId    `bson:"courseID" json:"courseID"`
+	Checks     []*scratch.Check `bson:"checks" json:"checks"`
+	Editor     bson.ObjectId    `bson:"editor" json:"editor"` //最后修改检查项的老师或管理员
+	UpdateTime time.Time        `bson:"updateTime" json:"updateTime"`
+}
+
+// FindLessonScratchChecks 查询课时的Scratch作品检查项，没有设置时返回mgo.ErrNotFound
+func (workMod *WorkModels) FindLessonScratchChecks(lessonID bson.ObjectId) (LessonScratchChecks, error) {
+	var checks LessonScratchChecks
+	f := func(col *mgo.Collection) error {
+		return col.FindId(lessonID).One(&checks)
+	}
+	err := workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "lesson_scratch_checks", f)
+	return checks, err
+}
+
+// SaveLessonScratchChecks 设置课时的Scratch作品检查项，课程中没有该课时时返回mgo.ErrNotFound。
+// editor 记录最后修改的老师或管理员
+func (workMod *WorkModels) SaveLessonScratchChecks(courseID, lessonID bson.ObjectId, checks []*scratch.Check, editor bson.ObjectId) error {
+	f := func(col *mgo.Collection) error {
+		n, err := col.Find(bson.M{"_id": courseID, "lessions._id": lessonID}).Count()
+		if err == nil && n == 0 {
+			err = mgo.ErrNotFound
+		}
+		return err
+	}
+	if err := workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "course", f); err != nil {
+		return err
+	}
+	f = func(col *mgo.Collection) error {
+		update := bson.M{
+			"$set":         bson.M{"checks": checks, "editor": editor, "updateTime": time.Now()},
+			"$setOnInsert": bson.M{"courseID": courseID},
+		}
+		_, err := col.UpsertId(lessonID, update)
+		return err
+	}
+	return workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "lesson_scratch_checks", f)
+}
+
+// AssessScratchWork 分析Scratch作品的sb3文件，按作品所属课时的检查项逐项检查，结果保存到作品
+func (workMod *WorkModels) AssessScratchWork(work WorkBody) (*scratch.Assessment, error) {
+	project, err := workMod.readScratchWork(work)
+	if err != nil {
+		return nil, err
+	}
+	lessonChecks, err := workMod.FindLessonScratchChecks(work.ContentID)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	assessment := scratch.Assess(project, lessonChecks.Checks)
+	ff := func(col *mgo.Collection) error {
+		return col.UpdateId(work.ID, bson.M{"$set": bson.M{"assessment": assessment}})
+	}
+	return assessment, workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", ff)
+}
+
+// UpdateScratchMetadata 提取Scratch作品sb3文件的元数据并保存到作品
+func (workMod *WorkModels) UpdateScratchMetadata(work WorkBody) (*scratch.Metadata, error) {
+	project, err := workMod.readScratchWork(work)
+	if err != nil {
+		return nil, err
+	}
+	meta := project.Metadata()
+	f := func(col *mgo.Collection) error {
+		return col.UpdateId(work.ID, bson.M{"$set": bson.M{"meta": meta}})
+	}
+	return meta, workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+}
+
+// ScratchWorksWithoutMetadata 查询还没有提取元数据的Scratch作品
+func (workMod *WorkModels) ScratchWorksWithoutMetadata() ([]WorkBody, error) {
+	var works []WorkBody
+	f := func(col *mgo.Collection) error {
+		query := bson.M{"relpath": bson.M{"$regex": `\.sb3$`}, "meta": bson.M{"$exists": false}}
+		return col.Find(query).Select(bson.M{"relpath": 1}).All(&works)
+	}
+	err := workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+	return works, err
+}
+
+// ScratchStats Scratch作品元数据统计
+type ScratchStats struct {
+	Works      int            `bson:"works" json:"works"`           //已提取元数据的作品数
+	Public     int            `bson:"public" json:"public"`         //其中分享的作品数
+	Cloud      int            `bson:"cloud" json:"cloud"`           //使用云变量的作品数
+	AvgSprites float64        `bson:"avgSprites" json:"avgSprites"` //平均角色数
+	AvgBlocks  float64        `bson:"avgBlocks" json:"avgBlocks"`   //平均积木数
+	Extensions map[string]int `bson:"-" json:"extensions"`          //使用各扩展的作品数
+	Categories map[string]int `bson:"-" json:"categories"`          //使用各分类积木的作品数
+}
+
+// QueryScratchStats 统计Scratch作品的元数据
+func (workMod *WorkModels) QueryScratchStats() (ScratchStats, error) {
+	stats := ScratchStats{Extensions: make(map[string]int), Categories: make(map[string]int)}
+	match := bson.M{"$match": bson.M{"meta": bson.M{"$exists": true}}}
+	pipeline := []bson.M{
+		match,
+		{"$group": bson.M{
+			"_id":        nil,
+			"works":      bson.M{"$sum": 1},
+			"public":     bson.M{"$sum": bson.M{"$cond": []interface{}{"$public", 1, 0}}},
+			"cloud":      bson.M{"$sum": bson.M{"$cond": []interface{}{"$meta.cloudVariables", 1, 0}}},
+			"avgSprites": bson.M{"$avg": "$meta.sprites"},
+			"avgBlocks":  bson.M{"$avg": "$meta.blocks"},
+		}},
+	}
+	f := func(col *mgo.Collection) error {
+		err := col.Pipe(pipeline).One(&stats)
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if err := workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f); err != nil {
+		return stats, err
+	}
+	var counts []struct {
+		Name  string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	ff := func(col *mgo.Collection) error {
+		return col.Pipe([]bson.M{
+			match,
+			{"$unwind": "$meta.extensions"},
+			{"$group": bson.M{"_id": "$meta.extensions", "count": bson.M{"$sum": 1}}},
+		}).All(&counts)
+	}
+	if err := workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", ff); err != nil {
+		return stats, err
+	}
+	for _, c := range counts {
+		stats.Extensions[c.Name] = c.Count
+	}
+	counts = nil
+	fff := func(col *mgo.Collection) error {
+		return col.Pipe([]bson.M{
+			match,
+			{"$project": bson.M{"categories": bson.M{"$objectToArray": "$meta.categories"}}},
+			{"$unwind": "$categories"},
+			{"$group": bson.M{"_id": "$categories.k", "count": bson.M{"$sum": 1}}},
+		}).All(&counts)
+	}
+	if err := workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", fff); err != nil {
+		return stats, err
+	}
+	for _, c := range counts {
+		stats.Categories[c.Name] = c.Count
+	}
+	return stats, nil
+}
+
+// RenderWorkThumbnail 从几个标准视角渲染STL作品的预览图，保存为 asset/works/<id>.png，返回图片路径。
+// 图片边长为 work_thumbnail_size 配置（默认512）
+func RenderWorkThumbnail(workID string) (string, error) {
+	mesh, err := readWorkModel(workID)
+	if err != nil {
+		return "", err
+	}
+	img, err := render.Thumbnail(mesh, beego.AppConfig.DefaultInt("work_thumbnail_size", 512))
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	relpath := path.Join("asset", "works", workID+".png")
+	return relpath, Assets().Put(relpath, &buf)
+}
+
+// SetDefaultPicture 作品没有封面时把picture设为封面
+func (workMod *WorkModels) SetDefaultPicture(workID bson.ObjectId, picture string) error {
+	f := func(col *mgo.Collection) error {
+		err := col.Update(bson.M{"_id": workID, "picture": bson.M{"$in": []interface{}{"", nil}}}, bson.M{"$set": bson.M{"picture": picture}})
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	return workMod.MgoSession.Do(beego.AppConfig.String("MongoDB"), "works", f)
+}
+
+// ExportWorkModel 把STL作品转换为format格式（stl、stl-ascii、obj、3mf），坐标从毫米换算为unit
+func ExportWorkModel(workID, format string, unit stl.Unit) ([]byte, error) {
+	mesh, err := readWorkModel(workID)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := stl.Encode(&buf, mesh, format, unit); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ConvertWorkModel 把其他软件导出的模型（stl、obj、3mf）转换为作品统一使用的、以毫米为单位的二进制STL。
+// STL和OBJ没有单位，按unit换算；3MF按文件中的单位换算
+func ConvertWorkModel(data []byte, format string, unit stl.Unit) ([]byte, error) {
+	mesh, err := stl.Decode(data, format, unit)
+	if err != nil {
+		return nil, err
+	}
+	if len(mesh.Triangles) == 0 {
+		return nil, stl.ErrFormat
+	}
+	var buf bytes.Buffer
+	if err := stl.Encode(&buf, mesh, stl.FormatSTL, stl.Millimeter); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readScratchWork 读取并解析Scratch作品的sb3文件
+func (workMod *WorkModels) readScratchWork(work WorkBody) (*scratch.Project, error) {
+	blobMod := BlobModels{MgoSession: workMod.MgoSession}
+	data, err := blobMod.ReadWorkFile(strings.TrimSuffix(path.Base(work.Relpath), ".sb3"), "sb3")
+	if err != nil {
+		return nil, err
+	}
+	return scratch.ReadSB3(bytes.NewReader(data), int64(len(data)))
+}
+
+// readWorkModel 读取STL作品的模型
+func readWorkModel(workID string) (*stl.Mesh, error) {
+	data, err := storage.ReadFile(Assets(), workFileName(workID, "stl"))
+	if err != nil {
+		return nil, err
+	}
+	return stl.Parse(data)
+}
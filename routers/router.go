@@ -44,6 +44,16 @@ func init() {
 			//用户活跃度
 			beego.NSRouter("/user/liveness/:startyear:int/:startmonth:int/:endyear:int/:endmonth:int", &controllers.AdminController{}, "get:GetLivenessCount"),
 			beego.NSRouter("/user/liveness", &controllers.AdminController{}, "get:InsertLiveness"),
+			//作品统计
+			beego.NSRouter("/works/stats", &controllers.AdminController{}, "get:GetWorksStats"),
+			//作品存储空间报告、设置用户、班级或角色的存储配额
+			beego.NSRouter("/works/quota", &controllers.AdminController{}, "get:GetStorageQuotas;put:PutStorageQuota"),
+
+			//上传导入离线课程包
+			beego.NSRouter("/course/package", &controllers.AdminController{}, "post:ImportCoursePackage"),
+			//分块上传完成后导入离线课程包、安装工具包
+			beego.NSRouter("/course/package/upload", &controllers.AdminController{}, "post:CompleteCourseUpload"),
+			beego.NSRouter("/tool/package/upload", &controllers.AdminController{}, "post:CompleteToolUpload"),
 
 			//线上平台下载课程工具（未使用）
 			beego.NSRouter("/data", &controllers.AdminController{}, "get:DownloadData"),
@@ -86,6 +96,13 @@ func init() {
 			beego.NSRouter("/progress", &controllers.ProgressController{}, "post:UploadLessProgress"),
 			//获取课时完成状态
 			beego.NSRouter("/progress", &controllers.ProgressController{}, "get:GetCourseProgress"),
+
+			// 学生待完成和已逾期的作业
+			beego.NSRouter("/assignments", &controllers.AssignmentController{}, "get:GetMyAssignments"),
+			// 学生作品的批改结果
+			beego.NSRouter("/grades", &controllers.GradeController{}, "get:GetMyGrades"),
+			// 学生所在班级的考试
+			beego.NSRouter("/exams", &controllers.ExamController{}, "get:GetMyExams"),
 		),
 
 		beego.NSNamespace("/class",
@@ -101,6 +118,28 @@ func init() {
 
 			// 班级消息接口，这些接口只对教师或管理员开放
 			beego.NSRouter("/message/publish", &controllers.ClassController{}, "post:PublishMessage"),
+
+			// 班级作业，这些接口只对班级的创建者或管理员开放
+			beego.NSRouter("/assignment", &controllers.AssignmentController{}, "post:CreateAssignment"),
+			beego.NSRouter("/assignment", &controllers.AssignmentController{}, "put:UpdateAssignment"),
+			beego.NSRouter("/assignment", &controllers.AssignmentController{}, "delete:DeleteAssignment"),
+			beego.NSRouter("/assignment", &controllers.AssignmentController{}, "get:GetClassAssignments"),
+			beego.NSRouter("/assignment/matrix", &controllers.AssignmentController{}, "get:GetAssignmentMatrix"),
+			beego.NSRouter("/assignment/export", &controllers.AssignmentController{}, "get:ExportAssignmentMatrix"),
+
+			// 班级考试，这些接口只对班级的创建者或管理员开放
+			beego.NSRouter("/exam", &controllers.ExamController{}, "post:CreateExam"),
+			beego.NSRouter("/exam", &controllers.ExamController{}, "put:UpdateExam"),
+			beego.NSRouter("/exam", &controllers.ExamController{}, "delete:DeleteExam"),
+			beego.NSRouter("/exam", &controllers.ExamController{}, "get:GetClassExams"),
+			beego.NSRouter("/exam/release", &controllers.ExamController{}, "put:ReleaseExam"),
+			beego.NSRouter("/exam/results", &controllers.ExamController{}, "get:GetExamResults"),
+
+			// 学生参加班级考试
+			beego.NSRouter("/exam/start", &controllers.ExamController{}, "post:StartExam"),
+			beego.NSRouter("/exam/paper", &controllers.ExamController{}, "get:GetMyExamPaper"),
+			beego.NSRouter("/exam/answer", &controllers.ExamController{}, "put:SaveExamAnswers"),
+			beego.NSRouter("/exam/submit", &controllers.ExamController{}, "post:SubmitExam"),
 		),
 		beego.NSNamespace("/courses",
 			//获取所有课程列表
@@ -117,6 +156,13 @@ func init() {
 			beego.NSRouter("/manager/delete", &controllers.CouresController{}, "post:RemoveCustomCourse"),
 			beego.NSRouter("/manager/edit", &controllers.CouresController{}, "post:EditCustomCourse"),
 			beego.NSRouter("/manager/class", &controllers.CouresController{}, "get:GetClassCourse"),
+			//课时解锁规则
+			beego.NSRouter("/unlock", &controllers.CouresController{}, "put:SetUnlockRule"),
+			beego.NSRouter("/unlock", &controllers.CouresController{}, "get:GetUnlockRule"),
+			//获取学习资源视频的签名播放地址
+			beego.NSRouter("/media/url", &controllers.MediaController{}, "get:GetMediaURL"),
+			//获取学习资源渲染后的课时文档
+			beego.NSRouter("/content/markdown", &controllers.CouresController{}, "get:GetContentMarkdown"),
 			//老师获取指定课时下班级学生学习进度
 			beego.NSRouter("/lesson/students/progress", &controllers.ProgressController{}, "get:GetStudentsProgress"),
 		),
@@ -155,11 +201,80 @@ func init() {
 			beego.NSRouter("/project/:page:int/:number:int", &controllers.WorksController{}, "get:LoadProject"),
 			beego.NSRouter("/3d-one", &controllers.WorksController{}, "post:Save3DOne"),
 			beego.NSRouter("/download", &controllers.WorksController{}, "get:DownloadWork"),
+			//上传其他软件导出的stl、obj、3mf模型创建作品
+			beego.NSRouter("/model", &controllers.WorksController{}, "post:ImportModel"),
+			//自己的作品占用的存储空间和配额
+			beego.NSRouter("/storage", &controllers.WorksController{}, "get:GetMyStorage"),
+			//分块上传完成后保存为作品文件
+			beego.NSRouter("/upload", &controllers.WorksController{}, "post:CompleteUpload"),
 			//**获取指定班级课节下学生作品
 			beego.NSRouter("/class/students", &controllers.WorksController{}, "get:GetClassStudents"),
+			//**批改班级学生的作品、查询作品的批改结果
+			beego.NSRouter("/grade", &controllers.GradeController{}, "post:GradeWork;get:GetWorkGrade"),
+			//**获取批改的语音评语
+			beego.NSRouter("/grade/audio", &controllers.GradeController{}, "get:GetFeedbackAudio"),
+			//重新评估Scratch作品的计算思维和课时检查项
+			beego.NSRouter("/assess", &controllers.WorksController{}, "post:AssessWork"),
+			//**查询、设置课时的Scratch作品检查项
+			beego.NSRouter("/scratch/checks", &controllers.WorksController{}, "get:GetScratchChecks;put:SaveScratchChecks"),
+			//**班级学生课时作品的相似度报告
+			beego.NSRouter("/similarity", &controllers.WorksController{}, "get:GetSimilarityReport"),
+			//作品的历史版本、下载某个版本的文件、恢复到某个版本
+			beego.NSRouter("/revisions", &controllers.RevisionController{}, "get:GetRevisions"),
+			beego.NSRouter("/revision/download", &controllers.RevisionController{}, "get:DownloadRevision"),
+			beego.NSRouter("/revision/restore", &controllers.RevisionController{}, "post:RestoreRevision"),
+			//3D作品的打印检查报告
+			beego.NSRouter("/print/report", &controllers.PrintController{}, "get:GetPrintReport"),
+			//作品的改编关系：原作品、改编作品和所有后代作品
+			beego.NSRouter("/remixes", &controllers.RemixController{}, "get:GetRemixTree"),
+			//分享作品的评论、发表评论或回复、删除评论、作者关闭评论
+			beego.NSRouter("/comments/:page:int/:number:int", &controllers.CommentController{}, "get:GetComments"),
+			beego.NSRouter("/comment", &controllers.CommentController{}, "post:PostComment;delete:DeleteComment"),
+			beego.NSRouter("/comments/setting", &controllers.CommentController{}, "put:PutCommentSetting"),
+			//**等待审核的评论、审核通过评论
+			beego.NSRouter("/comments/pending/:page:int/:number:int", &controllers.CommentController{}, "get:GetPendingComments"),
+			beego.NSRouter("/comment/approve", &controllers.CommentController{}, "put:ApproveComment"),
+		),
+		beego.NSNamespace("/upload",
+			//创建上传会话、查询已上传的大小、取消上传
+			beego.NSRouter("/", &controllers.UploadController{}, "post:CreateUpload;get:GetUpload;delete:CancelUpload"),
+			//按偏移量上传一个分块
+			beego.NSRouter("/chunk", &controllers.UploadController{}, "put:PutChunk"),
+		),
+		beego.NSNamespace("/print",
+			//教室里的打印机、提交作品到打印队列、我的打印任务
+			beego.NSRouter("/printers", &controllers.PrintController{}, "get:GetPrinters"),
+			beego.NSRouter("/jobs", &controllers.PrintController{}, "post:SubmitPrintJob"),
+			beego.NSRouter("/jobs/mine", &controllers.PrintController{}, "get:GetMyPrintJobs"),
+			beego.NSRouter("/job/cancel", &controllers.PrintController{}, "put:CancelPrintJob"),
+			//**打印机队列、审核打印任务、调整打印顺序
+			beego.NSRouter("/queue", &controllers.PrintController{}, "get:GetPrintQueue;put:ReorderPrintQueue"),
+			beego.NSRouter("/job/approve", &controllers.PrintController{}, "put:ApprovePrintJob"),
+			beego.NSRouter("/job/reject", &controllers.PrintController{}, "put:RejectPrintJob"),
 		),
 		beego.NSNamespace("/exercise",
-			beego.NSRouter("/", &controllers.ExercisesController{}, "post:SaveExer"),
+			//获取课时练习、提交练习作答
+			beego.NSRouter("/", &controllers.ExercisesController{}, "get:GetLessonExercise;post:SubmitExercise"),
+			//学生在课时练习的作答记录
+			beego.NSRouter("/history", &controllers.ExercisesController{}, "get:GetExerciseHistory"),
+			//**设置课时练习题
+			beego.NSRouter("/questions", &controllers.ExercisesController{}, "put:SaveLessonQuestions"),
+			//**从表格或QTI题目包导入课时练习题（可只做校验）
+			beego.NSRouter("/import", &controllers.ExercisesController{}, "post:ImportLessonQuestions"),
+			//**把课时练习题导出为QTI题目包
+			beego.NSRouter("/export", &controllers.ExercisesController{}, "get:ExportLessonQuestions"),
+			//**老师批阅学生练习中的简答题
+			beego.NSRouter("/grade", &controllers.ExercisesController{}, "put:ScorePendingAnswer"),
+			//**班级学生在课时练习上的作答记录和每道题的统计
+			beego.NSRouter("/class/report", &controllers.ExercisesController{}, "get:GetClassExerciseReport"),
+			//**班级在课程各课时练习中需要关注的学生
+			beego.NSRouter("/class/attention", &controllers.ExercisesController{}, "get:GetClassExerciseAttention"),
+		),
+		//按签名地址播放课程视频
+		beego.NSRouter("/media", &controllers.MediaController{}, "get:Stream"),
+		beego.NSNamespace("/search",
+			//全文检索课程、课时、工具、分享作品
+			beego.NSRouter("/:page:int/:number:int", &controllers.SearchController{}, "get:Search"),
 		),
 		beego.NSNamespace("/medal",
 			//查询全部勋章
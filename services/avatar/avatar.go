@@ -13,6 +13,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"hash/fnv"
+	"io"
 	"os"
 
 	"image"
@@ -180,15 +181,19 @@ func SaveAvatarToFile(img image.Image, filePath string) error {
 	if err != nil {
 		return err
 	}
-	switch strings.ToLower(filepath.Ext(filePath)) {
+	return Encode(outFile, img, filepath.Ext(filePath))
+}
+
+// Encode 按扩展名ext（.jpg、.gif，其他为png）编码头像
+func Encode(w io.Writer, img image.Image, ext string) error {
+	switch strings.ToLower(ext) {
 	case ".jpeg", ".jpg":
-		err = jpeg.Encode(outFile, img, &jpeg.Options{Quality: 80})
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 80})
 	case ".gif":
-		err = gif.Encode(outFile, img, nil)
+		return gif.Encode(w, img, nil)
 	default:
-		err = png.Encode(outFile, img)
+		return png.Encode(w, img)
 	}
-	return err
 }
 
 // ReadAvatarFromFile 把头像保存到文件系统
@@ -0,0 +1,176 @@
+// Package blob 按内容寻址的文件存储。文件按SHA-256保存为存储后端中的 <dir>/<前两位>/<sha256>，内容相同的文件只保存一份；
+// 作品文件是从存储中复制出来的文件（本地存储为硬链接）。引用计数和回收由调用方维护，本包只负责文件
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"maiyajia.com/services/storage"
+)
+
+// ErrSum 不是有效的SHA-256
+var ErrSum = errors.New("blob: invalid sum")
+
+// Store 存储后端St中的目录Dir，写入前的临时文件放在本地目录TempDir
+type Store struct {
+	St      storage.Storage
+	Dir     string
+	TempDir string
+}
+
+// Temp 写入存储前的临时文件，Commit 后按内容保存到存储中
+type Temp struct {
+	Name string
+	Sum  string
+	Size int64
+}
+
+// NewStore 创建保存在存储后端st的dir目录下的存储
+func NewStore(st storage.Storage, dir, tempDir string) *Store {
+	return &Store{St: st, Dir: dir, TempDir: tempDir}
+}
+
+// ValidSum 是否是小写十六进制的SHA-256
+func ValidSum(sum string) bool {
+	if len(sum) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range sum {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Name 文件在存储后端中的文件名
+func (s *Store) Name(sum string) string {
+	return path.Join(s.Dir, sum[:2], sum)
+}
+
+// Exists 存储中是否有该文件
+func (s *Store) Exists(sum string) bool {
+	if !ValidSum(sum) {
+		return false
+	}
+	_, err := s.St.Stat(s.Name(sum))
+	return err == nil
+}
+
+// Temp 把r的内容写入临时文件并计算SHA-256
+func (s *Store) Temp(r io.Reader) (*Temp, error) {
+	if err := os.MkdirAll(s.TempDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(s.TempDir, "blob")
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &Temp{Name: f.Name(), Sum: hex.EncodeToString(h.Sum(nil)), Size: size}, nil
+}
+
+// Commit 把临时文件保存到存储中，存储中已有相同内容时丢弃临时文件
+func (s *Store) Commit(t *Temp) error {
+	if s.Exists(t.Sum) {
+		return os.Remove(t.Name)
+	}
+	return s.St.PutFile(s.Name(t.Sum), t.Name)
+}
+
+// FileTemp 计算已有文件的SHA-256，Commit 时把该文件移动到存储中，用于上传完成的大文件，不再复制一次
+func FileTemp(filename string) (*Temp, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return &Temp{Name: filename, Sum: hex.EncodeToString(h.Sum(nil)), Size: size}, nil
+}
+
+// Discard 丢弃临时文件
+func (t *Temp) Discard() {
+	os.Remove(t.Name)
+}
+
+// Put 把r的内容保存到存储中，返回SHA-256和大小
+func (s *Store) Put(r io.Reader) (string, int64, error) {
+	t, err := s.Temp(r)
+	if err != nil {
+		return "", 0, err
+	}
+	return t.Sum, t.Size, s.Commit(t)
+}
+
+// Open 打开存储中的文件
+func (s *Store) Open(sum string) (io.ReadCloser, error) {
+	if !ValidSum(sum) {
+		return nil, ErrSum
+	}
+	return s.St.Open(s.Name(sum))
+}
+
+// Remove 从存储中删除文件，已经复制为作品文件的内容不受影响
+func (s *Store) Remove(sum string) error {
+	if !ValidSum(sum) {
+		return ErrSum
+	}
+	return s.St.Remove(s.Name(sum))
+}
+
+// Link 把存储中的文件复制为存储后端中的name，name 已存在时被替换
+func (s *Store) Link(sum, name string) error {
+	if !ValidSum(sum) {
+		return ErrSum
+	}
+	return s.St.Copy(s.Name(sum), name)
+}
+
+// Walk 遍历存储中的所有文件
+func (s *Store) Walk(fn func(sum string, info storage.Info) error) error {
+	return s.St.Walk(s.Dir, func(info storage.Info) error {
+		sum := path.Base(info.Name)
+		if ValidSum(sum) && path.Dir(info.Name) == path.Join(s.Dir, sum[:2]) {
+			return fn(sum, info)
+		}
+		return nil
+	})
+}
+
+// CleanTemp 删除写入中断留下的、修改时间早于before的临时文件
+func (s *Store) CleanTemp(before time.Time) error {
+	infos, err := ioutil.ReadDir(s.TempDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.ModTime().Before(before) {
+			os.Remove(filepath.Join(s.TempDir, info.Name()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package blob
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"maiyajia.com/services/storage"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st := storage.NewLocal(dir)
+	s := NewStore(st, "blobs", filepath.Join(dir, "tmp"))
+	sum, size, err := s.Put(bytes.NewReader([]byte("solid")))
+	if err != nil || size != 5 {
+		t.Fatalf("size = %d err = %v", size, err)
+	}
+	again, _, err := s.Put(bytes.NewReader([]byte("solid")))
+	if err != nil || again != sum || !s.Exists(sum) {
+		t.Fatalf("sum = %s, want %s (err %v)", again, sum, err)
+	}
+	dst := "asset/works/a.stl"
+	if err := s.Link(sum, dst); err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := s.Put(bytes.NewReader([]byte("other")))
+	if err := s.Link(other, dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := storage.ReadFile(st, dst); string(data) != "other" {
+		t.Errorf("dst = %q", data)
+	}
+	var found []string
+	s.Walk(func(sum string, info storage.Info) error {
+		found = append(found, sum)
+		return nil
+	})
+	if len(found) != 2 {
+		t.Errorf("walk = %v", found)
+	}
+	// 删除存储中的文件后作品目录中的链接仍然可用
+	if err := s.Remove(other); err != nil || s.Exists(other) {
+		t.Errorf("remove err = %v", err)
+	}
+	if data, _ := storage.ReadFile(st, dst); string(data) != "other" {
+		t.Errorf("dst = %q", data)
+	}
+	if _, err := s.Open("../../etc/passwd"); err != ErrSum {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestSB3(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewStore(storage.NewLocal(dir), "blobs", filepath.Join(dir, "tmp"))
+	pack := func(project string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range map[string]string{"project.json": project, "cat.svg": "<svg/>", "meow.wav": "RIFF"} {
+			w, _ := zw.Create(name)
+			w.Write([]byte(content))
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+	a, b := pack(`{"targets":[]}`), pack(`{"targets":[{}]}`)
+	ma, err := SplitSB3(bytes.NewReader(a), int64(len(a)), s.Put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb, err := SplitSB3(bytes.NewReader(b), int64(len(b)), s.Put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 两个作品只有 project.json 不同
+	shared := 0
+	for _, x := range ma.Sums() {
+		for _, y := range mb.Sums() {
+			if x == y {
+				shared++
+			}
+		}
+	}
+	if shared != 2 {
+		t.Errorf("shared = %d", shared)
+	}
+	parsed, err := ParseManifest(ma.Encode())
+	if err != nil || len(parsed.Entries) != 3 || parsed.Size() != ma.Size() {
+		t.Fatalf("manifest = %+v err = %v", parsed, err)
+	}
+	var out bytes.Buffer
+	err = WriteSB3(&out, parsed, func(sum string) (io.ReadCloser, error) { return s.Open(sum) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if f.Name == "project.json" && string(data) != `{"targets":[]}` {
+			t.Errorf("project.json = %q", data)
+		}
+	}
+	if _, err := ParseManifest([]byte(`{"entries":[{"name":"x","sum":"../x"}]}`)); err != ErrManifest {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package blob
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache 按SHA-256缓存最近读取的文件内容（如重新打包的sb3），总大小超过Max时淘汰最久没有使用的文件，可以并发使用
+type Cache struct {
+	Max int64
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	sum  string
+	data []byte
+}
+
+// NewCache 最多缓存max字节的缓存
+func NewCache(max int64) *Cache {
+	return &Cache{Max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get 查询缓存的文件内容，返回的内容不能修改
+func (c *Cache) Get(sum string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[sum]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+// Put 缓存文件内容，超过Max的文件不缓存
+func (c *Cache) Put(sum string, data []byte) {
+	if int64(len(data)) > c.Max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[sum]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[sum] = c.order.PushFront(&cacheEntry{sum: sum, data: data})
+	c.size += int64(len(data))
+	for c.size > c.Max {
+		e := c.order.Back()
+		entry := e.Value.(*cacheEntry)
+		c.order.Remove(e)
+		delete(c.items, entry.sum)
+		c.size -= int64(len(entry.data))
+	}
+}
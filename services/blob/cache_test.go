@@ -0,0 +1,31 @@
+package blob
+
+import "testing"
+
+func TestCache(t *testing.T) {
+	c := NewCache(10)
+	c.Put("a", []byte("1234"))
+	c.Put("b", []byte("5678"))
+	if data, ok := c.Get("a"); !ok || string(data) != "1234" {
+		t.Fatalf("Get(a) = %q, %v", data, ok)
+	}
+	// 超过容量时淘汰最久没有使用的b
+	c.Put("c", []byte("90ab"))
+	if _, ok := c.Get("b"); ok {
+		t.Error("b not evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("a evicted")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("c not cached")
+	}
+	// 超过容量的文件不缓存
+	c.Put("d", make([]byte, 11))
+	if _, ok := c.Get("d"); ok {
+		t.Error("oversized file cached")
+	}
+	if c.size != 8 {
+		t.Errorf("size = %d, want 8", c.size)
+	}
+}
@@ -0,0 +1,121 @@
+package blob
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrManifest 不是有效的sb3清单
+var ErrManifest = errors.New("blob: invalid manifest")
+
+// Entry sb3压缩包中的一个文件
+type Entry struct {
+	Name string `json:"name"`
+	Sum  string `json:"sum"`
+	Size int64  `json:"size"`
+}
+
+// Manifest sb3作品的清单。sb3是zip压缩包，造型和声音文件按内容命名，改编的作品之间大多相同，
+// 因此每个文件单独保存，作品只保存清单
+type Manifest struct {
+	Entries []Entry `json:"entries"`
+}
+
+// 已经压缩过的文件格式，重新打包时不再压缩
+var storedExts = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".mp3": true, ".wav": true}
+
+// SplitSB3 把sb3压缩包中的每个文件用put保存，返回清单
+func SplitSB3(r io.ReaderAt, size int64, put func(io.Reader) (string, int64, error)) (*Manifest, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		sum, n, err := put(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		m.Entries = append(m.Entries, Entry{Name: f.Name, Sum: sum, Size: n})
+	}
+	return m, nil
+}
+
+// WriteSB3 按清单把open打开的文件重新打包为sb3
+func WriteSB3(w io.Writer, m *Manifest, open func(sum string) (io.ReadCloser, error)) error {
+	zw := zip.NewWriter(w)
+	for _, e := range m.Entries {
+		header := &zip.FileHeader{Name: e.Name, Method: zip.Deflate}
+		if storedExts[strings.ToLower(path.Ext(e.Name))] {
+			header.Method = zip.Store
+		}
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		rc, err := open(e.Sum)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ParseManifest 解析清单
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, ErrManifest
+	}
+	for _, e := range m.Entries {
+		if !ValidSum(e.Sum) || e.Name == "" {
+			return nil, ErrManifest
+		}
+	}
+	return &m, nil
+}
+
+// Encode 清单的JSON编码
+func (m *Manifest) Encode() []byte {
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// Sums 清单引用的所有文件，不重复
+func (m *Manifest) Sums() []string {
+	seen := make(map[string]bool)
+	var sums []string
+	for _, e := range m.Entries {
+		if !seen[e.Sum] {
+			seen[e.Sum] = true
+			sums = append(sums, e.Sum)
+		}
+	}
+	return sums
+}
+
+// Size 重新打包前所有文件的总大小
+func (m *Manifest) Size() int64 {
+	var size int64
+	for _, e := range m.Entries {
+		size += e.Size
+	}
+	return size
+}
@@ -0,0 +1,338 @@
+// @APIVersion 1.0.0
+// @Title 离线课程包服务
+// @Description 离线课程包的读写与校验，格式说明见同目录下的README.md
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package coursepkg
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"maiyajia.com/services/exercise"
+	"maiyajia.com/services/storage"
+)
+
+const (
+	// FormatVersion 当前课程包格式版本
+	FormatVersion = 1
+
+	// ManifestName 课程包清单文件名
+	ManifestName = "manifest.json"
+	// ChecksumName 清单校验文件名
+	ChecksumName = "checksum.sha256"
+	// FilesDir 课程资源文件在包内的目录
+	FilesDir = "files/"
+)
+
+var (
+	errManifestMissing = errors.New("manifest.json not found in package")
+	errChecksumMissing = errors.New("checksum.sha256 not found in package")
+	errChecksumFail    = errors.New("manifest checksum mismatch")
+	errFormatVersion   = errors.New("unsupported package format version")
+)
+
+// Manifest 课程包清单
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreateTime    time.Time `json:"create_time"`
+	Course        Course    `json:"course"`
+	Files         []File    `json:"files"`
+}
+
+// Course 课程包中的课程信息，ID 与在线安装时课程服务器下发的ID一致
+type Course struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Icon     string   `json:"icon"`
+	Category string   `json:"category"`
+	Desc     string   `json:"desc"`
+	Lessons  []Lesson `json:"lessons"`
+}
+
+// Lesson 课程包中的课时信息
+type Lesson struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name"`
+	IconURL     string               `json:"icon_url"`
+	Tool        string               `json:"tool"`
+	Contents    []Content            `json:"contents"`
+	Questions   []*exercise.Question `json:"questions,omitempty"`
+	MaxAttempts int                  `json:"max_attempts,omitempty"`
+	Reveal      string               `json:"reveal_answer,omitempty"`
+}
+
+// Content 课程包中的学习资源信息
+type Content struct {
+	ID        string `json:"id"`
+	VideoName string `json:"video_name"`
+	VideoURL  string `json:"video_url"`
+	MdURL     string `json:"md_url"`
+}
+
+// File 课程包中的资源文件，Path 为相对课程资源根目录的路径
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Package 一个已打开并通过校验的课程包
+type Package struct {
+	Manifest Manifest
+	reader   *zip.ReadCloser
+	entries  map[string]*zip.File
+}
+
+// Open 打开课程包并校验清单和所有资源文件的完整性
+func Open(filename string) (*Package, error) {
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	pkg := &Package{
+		reader:  reader,
+		entries: make(map[string]*zip.File),
+	}
+	for _, f := range reader.File {
+		pkg.entries[f.Name] = f
+	}
+	if err := pkg.verify(); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// Close 关闭课程包
+func (pkg *Package) Close() error {
+	return pkg.reader.Close()
+}
+
+// Extract 把课程包内的资源文件先解压到本地临时目录，全部文件校验通过后再保存到存储后端st的dir目录下，
+// 解压或校验失败时不会在存储中留下解压了一半的课程
+func (pkg *Package) Extract(st storage.Storage, dir string) error {
+	tmp, err := ioutil.TempDir("", "coursepkg")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	for _, file := range pkg.Manifest.Files {
+		if err := pkg.extractFile(pkg.entries[FilesDir+file.Path], file, filepath.Join(tmp, filepath.FromSlash(file.Path))); err != nil {
+			return err
+		}
+	}
+	return storage.PutDir(st, dir, tmp)
+}
+
+// Write 根据清单把存储后端st中课程资源目录 dir 下的文件打包写入 w，
+// 清单中的 Files 由本函数根据课程引用的资源生成
+func Write(w io.Writer, manifest Manifest, st storage.Storage, dir string) error {
+	manifest.FormatVersion = FormatVersion
+	if manifest.CreateTime.IsZero() {
+		manifest.CreateTime = time.Now()
+	}
+	manifest.Files = nil
+	for _, rel := range referencedFiles(manifest.Course) {
+		sum, size, err := fileChecksum(st, path.Join(dir, rel))
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, File{Path: rel, Size: size, SHA256: sum})
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	if err := writeEntry(zw, ManifestName, bytes.NewReader(manifestJSON)); err != nil {
+		return err
+	}
+	checksum := sha256.Sum256(manifestJSON)
+	if err := writeEntry(zw, ChecksumName, strings.NewReader(hex.EncodeToString(checksum[:])+"\n")); err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		fp, err := st.Open(path.Join(dir, file.Path))
+		if err != nil {
+			return err
+		}
+		err = writeEntry(zw, FilesDir+file.Path, fp)
+		fp.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本服务的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// verify 校验清单的checksum、格式版本，以及每个资源文件的大小和sha256
+func (pkg *Package) verify() error {
+	manifestFile, ok := pkg.entries[ManifestName]
+	if !ok {
+		return errManifestMissing
+	}
+	checksumFile, ok := pkg.entries[ChecksumName]
+	if !ok {
+		return errChecksumMissing
+	}
+	manifestJSON, err := readEntry(manifestFile)
+	if err != nil {
+		return err
+	}
+	checksum, err := readEntry(checksumFile)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(manifestJSON)
+	if strings.TrimSpace(string(checksum)) != hex.EncodeToString(sum[:]) {
+		return errChecksumFail
+	}
+	if err := json.Unmarshal(manifestJSON, &pkg.Manifest); err != nil {
+		return err
+	}
+	if pkg.Manifest.FormatVersion < 1 || pkg.Manifest.FormatVersion > FormatVersion {
+		return errFormatVersion
+	}
+	if pkg.Manifest.Course.ID == "" || pkg.Manifest.Course.Name == "" {
+		return errors.New("manifest course id and name are required")
+	}
+	for _, file := range pkg.Manifest.Files {
+		if !validRelPath(file.Path) {
+			return fmt.Errorf("invalid file path %q", file.Path)
+		}
+		entry, ok := pkg.entries[FilesDir+file.Path]
+		if !ok {
+			return fmt.Errorf("file %q listed in manifest is missing", file.Path)
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		size, err := io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if size != file.Size || hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+			return fmt.Errorf("file %q checksum mismatch", file.Path)
+		}
+	}
+	return nil
+}
+
+// extractFile 把资源文件解压为本地文件filename，并按清单校验大小和sha256
+func (pkg *Package) extractFile(entry *zip.File, file File, filename string) error {
+	rc, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
+	fp, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(fp, h), rc)
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if size != file.Size || hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+		return fmt.Errorf("file %q checksum mismatch", file.Path)
+	}
+	return nil
+}
+
+// referencedFiles 返回课程引用的全部资源文件（去重并排序）
+func referencedFiles(course Course) []string {
+	set := make(map[string]bool)
+	add := func(rel string) {
+		if rel != "" && validRelPath(rel) {
+			set[rel] = true
+		}
+	}
+	add(course.Icon)
+	for _, lesson := range course.Lessons {
+		add(lesson.IconURL)
+		for _, content := range lesson.Contents {
+			add(content.VideoURL)
+			add(content.MdURL)
+		}
+	}
+	files := make([]string, 0, len(set))
+	for rel := range set {
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// validRelPath 资源路径必须是包内的相对路径，不允许跳出课程资源根目录
+func validRelPath(rel string) bool {
+	if rel == "" || strings.HasPrefix(rel, "/") || strings.Contains(rel, "\\") {
+		return false
+	}
+	clean := path.Clean(rel)
+	return clean == rel && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+func fileChecksum(st storage.Storage, name string) (string, int64, error) {
+	fp, err := st.Open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer fp.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, fp)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func readEntry(entry *zip.File) ([]byte, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func writeEntry(zw *zip.Writer, name string, r io.Reader) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
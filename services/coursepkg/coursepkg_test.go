@@ -0,0 +1,133 @@
+package coursepkg
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"maiyajia.com/services/storage"
+)
+
+func TestWriteAndOpen(t *testing.T) {
+	src, err := ioutil.TempDir("", "coursepkg-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	os.MkdirAll(filepath.Join(src, "demo", "001"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(src, "demo", "001", "lesson.md"), []byte("# 第一课"), 0666)
+	ioutil.WriteFile(filepath.Join(src, "demo", "001", "video.mp4"), []byte("video"), 0666)
+
+	manifest := Manifest{
+		Course: Course{
+			ID:   "5b8e1c2f9d1e8a0c4c7b1234",
+			Name: "演示课程",
+			Lessons: []Lesson{{
+				ID:   "5b8e1c2f9d1e8a0c4c7b1235",
+				Name: "第一课",
+				Contents: []Content{{
+					ID:       "5b8e1c2f9d1e8a0c4c7b1236",
+					VideoURL: "demo/001/video.mp4",
+					MdURL:    "demo/001/lesson.md",
+				}},
+			}},
+		},
+	}
+	pkgFile := filepath.Join(src, "demo.zip")
+	fp, _ := os.Create(pkgFile)
+	if err := Write(fp, manifest, storage.NewLocal(src), ""); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+	fp.Close()
+
+	pkg, err := Open(pkgFile)
+	if err != nil {
+		t.Fatalf("open package: %v", err)
+	}
+	defer pkg.Close()
+	if len(pkg.Manifest.Files) != 2 {
+		t.Errorf("files: got %d, want 2", len(pkg.Manifest.Files))
+	}
+
+	dest, _ := ioutil.TempDir("", "coursepkg-dest")
+	defer os.RemoveAll(dest)
+	if err := pkg.Extract(storage.NewLocal(dest), ""); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dest, "demo", "001", "lesson.md"))
+	if err != nil || string(b) != "# 第一课" {
+		t.Errorf("extracted lesson.md: %q %v", b, err)
+	}
+}
+
+func TestExtractLeavesNothingOnMismatch(t *testing.T) {
+	src, err := ioutil.TempDir("", "coursepkg-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	os.MkdirAll(filepath.Join(src, "demo"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(src, "demo", "a.md"), []byte("a"), 0666)
+	ioutil.WriteFile(filepath.Join(src, "demo", "b.md"), []byte("b"), 0666)
+	manifest := Manifest{Course: Course{ID: "5b8e1c2f9d1e8a0c4c7b1234", Name: "演示课程", Icon: "demo/a.md",
+		Lessons: []Lesson{{ID: "5b8e1c2f9d1e8a0c4c7b1235", IconURL: "demo/b.md"}}}}
+	pkgFile := filepath.Join(src, "demo.zip")
+	fp, _ := os.Create(pkgFile)
+	if err := Write(fp, manifest, storage.NewLocal(src), ""); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+	fp.Close()
+	pkg, err := Open(pkgFile)
+	if err != nil {
+		t.Fatalf("open package: %v", err)
+	}
+	defer pkg.Close()
+	// 清单在打开后被改动，第二个文件解压后校验失败
+	pkg.Manifest.Files[1].SHA256 = pkg.Manifest.Files[0].SHA256
+
+	dest, _ := ioutil.TempDir("", "coursepkg-dest")
+	defer os.RemoveAll(dest)
+	if err := pkg.Extract(storage.NewLocal(dest), "course"); err == nil {
+		t.Fatal("extract: want checksum error")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "course")); !os.IsNotExist(err) {
+		t.Errorf("partly extracted course left in storage: %v", err)
+	}
+}
+
+func TestOpenRejectsTamperedManifest(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create(ManifestName)
+	w.Write([]byte(`{"format_version":1,"course":{"id":"x","name":"y"}}`))
+	w, _ = zw.Create(ChecksumName)
+	w.Write([]byte("0000"))
+	zw.Close()
+
+	f, _ := ioutil.TempFile("", "coursepkg")
+	f.Write(buf.Bytes())
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if _, err := Open(f.Name()); err != errChecksumFail {
+		t.Errorf("got %v, want %v", err, errChecksumFail)
+	}
+}
+
+func TestValidRelPath(t *testing.T) {
+	cases := map[string]bool{
+		"demo/001/video.mp4": true,
+		"../etc/passwd":      false,
+		"/abs/path":          false,
+		"demo/../../x":       false,
+		"demo\\x":            false,
+	}
+	for rel, want := range cases {
+		if got := validRelPath(rel); got != want {
+			t.Errorf("validRelPath(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
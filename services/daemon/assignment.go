@@ -0,0 +1,38 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+)
+
+// StartAssignmentReminder 定时检查即将截止的班级作业，在截止前 assignment_remind_hours 小时（默认24小时）提醒未完成的学生
+func StartAssignmentReminder() {
+	within := time.Duration(beego.AppConfig.DefaultInt("assignment_remind_hours", 24)) * time.Hour
+	go func() {
+		for {
+			sendAssignmentReminders(within)
+			time.Sleep(5 * time.Minute)
+		}
+	}()
+}
+
+func sendAssignmentReminders(within time.Duration) {
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("assignment reminder:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	assignMod := m.AssignmentModels{
+		MgoSession: dbclient,
+		CourseMod:  m.CourseModels{MgoSession: dbclient},
+		MessageMod: m.MessageModels{MgoSession: dbclient},
+	}
+	if err := assignMod.SendDueReminders(time.Now(), within); err != nil {
+		logs.Error("assignment reminder:", err)
+	}
+}
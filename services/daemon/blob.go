@@ -0,0 +1,59 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+)
+
+// StartBlobCollect 启动时把旧的作品文件和版本文件导入存储，之后每天回收不再引用的文件
+func StartBlobCollect() {
+	go func() {
+		importBlobs()
+		for {
+			collectBlobs()
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
+
+func importBlobs() {
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("blob import:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	blobMod := m.BlobModels{MgoSession: dbclient}
+	revMod := m.RevisionModels{MgoSession: dbclient, BlobMod: blobMod}
+	n, err := blobMod.ImportWorkFiles()
+	if err != nil {
+		logs.Error("blob import:", err)
+	}
+	r, err := revMod.ImportRevisionFiles()
+	if err != nil {
+		logs.Error("blob import:", err)
+	}
+	if n > 0 || r > 0 {
+		logs.Info("blob import:", n, "work files,", r, "revision files")
+	}
+}
+
+func collectBlobs() {
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("blob collect:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	blobMod := m.BlobModels{MgoSession: dbclient}
+	n, err := blobMod.CollectBlobs(m.BlobGracePeriod())
+	if err != nil {
+		logs.Error("blob collect:", err)
+	}
+	if n > 0 {
+		logs.Info("blob collect: removed", n, "blobs")
+	}
+}
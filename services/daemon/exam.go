@@ -0,0 +1,32 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+)
+
+// StartExamAutoSubmit 定时把答题时间已到还没有交卷的考试按已保存的答案自动交卷
+func StartExamAutoSubmit() {
+	go func() {
+		for {
+			autoSubmitExams()
+			time.Sleep(30 * time.Second)
+		}
+	}()
+}
+
+func autoSubmitExams() {
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("exam auto submit:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	examMod := m.ExamModels{MgoSession: dbclient}
+	if err := examMod.AutoSubmitExpired(time.Now()); err != nil {
+		logs.Error("exam auto submit:", err)
+	}
+}
@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	mgo "gopkg.in/mgo.v2"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/printer"
+)
+
+// 打印队列调度请求，缓冲为1，多次请求合并为一次调度
+var dispatchPrint = make(chan struct{}, 1)
+
+// 各打印机最近一次查询到的状态
+var (
+	printerStatusMu sync.Mutex
+	printerStatus   = map[string]printer.Status{}
+)
+
+// StartPrintCheck 为还没有打印检查报告的STL作品生成报告，新保存的作品在保存时检查
+func StartPrintCheck() {
+	go func() {
+		dbclient := &mongo.MgoClient{}
+		if err := dbclient.StartSession(); err != nil {
+			logs.Error("print check:", err)
+			return
+		}
+		defer dbclient.CloseSession()
+		printMod := m.PrintModels{MgoSession: dbclient}
+		works, err := printMod.STLWorksWithoutReport()
+		if err != nil {
+			logs.Error("print check:", err)
+			return
+		}
+		for _, work := range works {
+			if _, err := printMod.UpdatePrintReport(work.ID); err != nil {
+				logs.Warn("print check:", work.ID.Hex(), err)
+			}
+		}
+	}()
+}
+
+// StartPrintQueue 定时查询各打印机的状态，更新正在打印的任务，打印机空闲时发送队列中的下一个任务。
+// 间隔为 print_poll_seconds 配置（默认30秒）
+func StartPrintQueue() {
+	interval := time.Duration(beego.AppConfig.DefaultInt("print_poll_seconds", 30)) * time.Second
+	go func() {
+		for {
+			select {
+			case <-dispatchPrint:
+			case <-time.After(interval):
+			}
+			pollPrinters()
+		}
+	}()
+}
+
+// DispatchPrintQueue 请求立即调度打印队列，审核或调整队列后调用
+func DispatchPrintQueue() {
+	select {
+	case dispatchPrint <- struct{}{}:
+	default:
+	}
+}
+
+// PrinterStatus 打印机最近一次查询到的状态
+func PrinterStatus(printerID string) (printer.Status, bool) {
+	printerStatusMu.Lock()
+	defer printerStatusMu.Unlock()
+	st, ok := printerStatus[printerID]
+	return st, ok
+}
+
+func pollPrinters() {
+	config, err := m.PrinterConfig()
+	if err != nil {
+		logs.Error("print queue:", err)
+		return
+	}
+	if len(config.Printers) == 0 {
+		return
+	}
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("print queue:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	printMod := m.PrintModels{MgoSession: dbclient}
+	printMod.MessageMod.MgoSession = dbclient
+	for _, device := range config.Printers {
+		if err := pollPrinter(&printMod, device); err != nil {
+			logs.Error("print queue:", device.ID, err)
+		}
+	}
+}
+
+// pollPrinter 查询一台打印机：有正在打印的任务时更新其状态，否则在空闲时发送下一个任务
+func pollPrinter(printMod *m.PrintModels, device printer.Device) error {
+	drv, err := printer.Open(device)
+	if err != nil {
+		return err
+	}
+	st, err := drv.Status()
+	printerStatusMu.Lock()
+	printerStatus[device.ID] = st
+	printerStatusMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	job, err := printMod.PrintingJob(device.ID)
+	if err == nil {
+		switch printer.Judge(job.File, st) {
+		case printer.OutcomePrinting:
+			return printMod.UpdatePrintProgress(job, st.Progress)
+		case printer.OutcomeDone:
+			return printMod.FinishPrintJob(job, m.PrintDone, "")
+		case printer.OutcomeFailed:
+			return printMod.FinishPrintJob(job, m.PrintFailed, st.Message)
+		}
+		// 打印机空闲但迟迟没有开始打印这个任务，如切片失败
+		timeout := time.Duration(beego.AppConfig.DefaultInt("print_start_timeout_minutes", 30)) * time.Minute
+		if st.State == printer.StateIdle && time.Since(job.StartTime) > timeout {
+			return printMod.FinishPrintJob(job, m.PrintFailed, "打印机没有开始打印")
+		}
+		return nil
+	}
+	if err != mgo.ErrNotFound {
+		return err
+	}
+	if st.State != printer.StateIdle {
+		return nil
+	}
+
+	job, err = printMod.NextPrintJob(device.ID)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	blobMod := m.BlobModels{MgoSession: printMod.MgoSession}
+	data, err := blobMod.ReadWorkFile(job.WorkID.Hex(), "stl")
+	if err != nil {
+		return printMod.FinishPrintJob(job, m.PrintFailed, "作品文件不存在")
+	}
+	file, err := drv.Print(job.ID.Hex()+".stl", data)
+	if err == printer.ErrBusy {
+		return nil
+	}
+	if err != nil {
+		logs.Error("print queue:", device.ID, err)
+		return printMod.FinishPrintJob(job, m.PrintFailed, "发送到打印机失败")
+	}
+	return printMod.StartPrintJob(job, file)
+}
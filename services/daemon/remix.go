@@ -0,0 +1,28 @@
+package daemon
+
+import (
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+)
+
+// StartRemixCount 启动时按原作品id重新统计作品的被改编数，之后在复制和删除作品时增减
+func StartRemixCount() {
+	go func() {
+		dbclient := &mongo.MgoClient{}
+		if err := dbclient.StartSession(); err != nil {
+			logs.Error("remix count:", err)
+			return
+		}
+		defer dbclient.CloseSession()
+		remixMod := m.RemixModels{MgoSession: dbclient}
+		updated, err := remixMod.RecountRemixes()
+		if err != nil {
+			logs.Error("remix count:", err)
+			return
+		}
+		if updated > 0 {
+			logs.Info("remix count: updated", updated, "works")
+		}
+	}()
+}
@@ -0,0 +1,58 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+)
+
+// StartRevisionPrune 每天按保留策略清理作品的旧版本，并删除已删除作品留下的版本
+func StartRevisionPrune() {
+	go func() {
+		for {
+			pruneRevisions()
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
+
+func pruneRevisions() {
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("revision prune:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	revMod := m.RevisionModels{MgoSession: dbclient, BlobMod: m.BlobModels{MgoSession: dbclient}}
+	keep, before := m.RevisionPolicy()
+	ids, err := revMod.WorksToPrune(keep)
+	if err != nil {
+		logs.Error("revision prune:", err)
+		return
+	}
+	total := 0
+	for _, id := range ids {
+		n, err := revMod.PruneRevisions(id, keep, before)
+		if err != nil {
+			logs.Error("revision prune:", id.Hex(), err)
+		}
+		total += n
+	}
+	orphans, err := revMod.OrphanedWorks()
+	if err != nil {
+		logs.Error("revision prune:", err)
+	}
+	for _, id := range orphans {
+		if err := revMod.RemoveRevisions(id); err != nil {
+			logs.Error("revision prune:", id.Hex(), err)
+		}
+	}
+	if len(orphans) > 0 {
+		logs.Info("revision prune: removed revisions of", len(orphans), "deleted works")
+	}
+	if total > 0 {
+		logs.Info("revision prune: removed", total, "revisions")
+	}
+}
@@ -0,0 +1,30 @@
+package daemon
+
+import (
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+)
+
+// StartScratchMetadata 为还没有元数据的Scratch作品提取元数据，新保存的作品在保存时提取
+func StartScratchMetadata() {
+	go func() {
+		dbclient := &mongo.MgoClient{}
+		if err := dbclient.StartSession(); err != nil {
+			logs.Error("scratch metadata:", err)
+			return
+		}
+		defer dbclient.CloseSession()
+		workMod := m.WorkModels{MgoSession: dbclient}
+		works, err := workMod.ScratchWorksWithoutMetadata()
+		if err != nil {
+			logs.Error("scratch metadata:", err)
+			return
+		}
+		for _, work := range works {
+			if _, err := workMod.UpdateScratchMetadata(work); err != nil {
+				logs.Warn("scratch metadata:", work.ID.Hex(), err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,106 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+	"maiyajia.com/services/search"
+)
+
+// 索引重建请求，缓冲为1，多次请求合并为一次重建
+var rebuildSearch = make(chan struct{}, 1)
+
+// 待更新索引的作品ID，和重建在同一个协程中依次处理，重建时的更新不会被覆盖
+var workIndexUpdates = make(chan string, 256)
+
+// StartSearchIndex 加载词典并在后台建立全文索引，之后按 search_rebuild_minutes 配置（默认10分钟）定时重建
+func StartSearchIndex() {
+	if dict := beego.AppConfig.DefaultString("search_dict", "conf/search_dict.txt"); dict != "" {
+		if err := search.Default.Segmenter().LoadDictionary(dict); err != nil {
+			logs.Warn("load search dictionary:", err)
+		}
+	}
+	interval := time.Duration(beego.AppConfig.DefaultInt("search_rebuild_minutes", 10)) * time.Minute
+	go func() {
+		for {
+			rebuildSearchIndex()
+			serveWorkIndexUpdates(interval)
+		}
+	}()
+}
+
+// RefreshSearchIndex 请求重建全文索引，课程、工具安装或导入后调用
+func RefreshSearchIndex() {
+	select {
+	case rebuildSearch <- struct{}{}:
+	default:
+	}
+}
+
+// UpdateWorkIndex 作品信息或分享状态变化后请求更新该作品的索引，队列已满时改为重建整个索引
+func UpdateWorkIndex(workID string) {
+	select {
+	case workIndexUpdates <- workID:
+	default:
+		RefreshSearchIndex()
+	}
+}
+
+// serveWorkIndexUpdates 依次更新作品索引，直到收到重建请求或到了定时重建的时间
+func serveWorkIndexUpdates(interval time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case workID := <-workIndexUpdates:
+			updateWorkIndex(workID)
+		case <-rebuildSearch:
+			return
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// updateWorkIndex 从数据库读取作品信息更新索引
+func updateWorkIndex(workID string) {
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("update work index:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	searchMod := m.SearchModels{MgoSession: dbclient}
+	doc, public, err := searchMod.WorkSearchDocument(workID)
+	if err != nil {
+		logs.Error("update work index:", err)
+		return
+	}
+	if public {
+		search.Default.Add(doc)
+	} else {
+		search.Default.Remove(search.TypeWork, workID)
+	}
+}
+
+// rebuildSearchIndex 从数据库读取所有文档重建索引
+func rebuildSearchIndex() {
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("rebuild search index:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	searchMod := m.SearchModels{MgoSession: dbclient}
+	start := time.Now()
+	docs, err := searchMod.SearchDocuments()
+	if err != nil {
+		logs.Error("rebuild search index:", err)
+		return
+	}
+	search.Default.Reset(docs)
+	logs.Info("search index rebuilt:", len(docs), "documents in", time.Since(start))
+}
@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+)
+
+// 相似度检查请求，缓冲为1，多次请求合并为一次检查
+var checkSimilarity = make(chan struct{}, 1)
+
+// StartSimilarityCheck 定时为新增或修改的课时作品计算指纹并查找相似作品，
+// 间隔为 similarity_check_minutes 配置（默认30分钟），相似度阈值为 similarity_threshold（默认0.8）
+func StartSimilarityCheck() {
+	interval := time.Duration(beego.AppConfig.DefaultInt("similarity_check_minutes", 30)) * time.Minute
+	go func() {
+		for {
+			select {
+			case <-checkSimilarity:
+			case <-time.After(interval):
+			}
+			checkWorkSimilarity()
+		}
+	}()
+	RefreshSimilarity()
+}
+
+// RefreshSimilarity 请求检查作品相似度，作品文件保存后调用
+func RefreshSimilarity() {
+	select {
+	case checkSimilarity <- struct{}{}:
+	default:
+	}
+}
+
+func checkWorkSimilarity() {
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("work similarity:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	simMod := m.SimilarityModels{MgoSession: dbclient, BlobMod: m.BlobModels{MgoSession: dbclient}}
+	changed, err := simMod.UpdateFingerprints()
+	if err != nil {
+		logs.Error("work fingerprints:", err)
+	}
+	if err := simMod.FindSimilarPairs(changed, beego.AppConfig.DefaultFloat("similarity_threshold", 0.8)); err != nil {
+		logs.Error("work similarity:", err)
+	}
+}
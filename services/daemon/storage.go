@@ -0,0 +1,59 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+)
+
+// StartStorageCount 统计还没有统计过占用空间的作品，新保存的作品在保存时统计
+func StartStorageCount() {
+	go func() {
+		dbclient := &mongo.MgoClient{}
+		if err := dbclient.StartSession(); err != nil {
+			logs.Error("storage count:", err)
+			return
+		}
+		defer dbclient.CloseSession()
+		quotaMod := m.QuotaModels{MgoSession: dbclient}
+		works, err := quotaMod.WorksWithoutStorage()
+		if err != nil {
+			logs.Error("storage count:", err)
+			return
+		}
+		for _, id := range works {
+			if _, err := quotaMod.UpdateWorkStorage(id); err != nil {
+				logs.Warn("storage count:", id.Hex(), err)
+			}
+		}
+	}()
+}
+
+// StartPendingFileCleanup 每小时删除上传后长时间没有保存作品信息的作品文件
+func StartPendingFileCleanup() {
+	go func() {
+		for {
+			cleanupPendingFiles()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func cleanupPendingFiles() {
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("pending file cleanup:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	quotaMod := m.QuotaModels{MgoSession: dbclient}
+	n, err := quotaMod.RemoveExpiredPendingWorkFiles()
+	if err != nil {
+		logs.Error("pending file cleanup:", err)
+	}
+	if n > 0 {
+		logs.Info("pending file cleanup: removed", n, "works")
+	}
+}
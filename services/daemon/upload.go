@@ -0,0 +1,36 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	m "maiyajia.com/models"
+	"maiyajia.com/services/mongo"
+)
+
+// StartUploadCleanup 每小时清理过期的上传会话和临时文件
+func StartUploadCleanup() {
+	go func() {
+		for {
+			cleanupUploads()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func cleanupUploads() {
+	dbclient := &mongo.MgoClient{}
+	if err := dbclient.StartSession(); err != nil {
+		logs.Error("upload cleanup:", err)
+		return
+	}
+	defer dbclient.CloseSession()
+	uploadMod := m.UploadModels{MgoSession: dbclient}
+	n, err := uploadMod.RemoveExpiredUploads()
+	if err != nil {
+		logs.Error("upload cleanup:", err)
+	}
+	if n > 0 {
+		logs.Info("upload cleanup: removed", n, "sessions")
+	}
+}
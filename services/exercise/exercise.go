@@ -0,0 +1,295 @@
+// Package exercise 课时练习的题目定义、校验与自动评分
+package exercise
+
+import (
+	"errors"
+	"strings"
+)
+
+// 题目类型
+const (
+	TypeSingle   = "single"   //单选题
+	TypeMultiple = "multiple" //多选题
+	TypeJudge    = "judge"    //判断题
+	TypeBlank    = "blank"    //填空题
+	TypeShort    = "short"    //简答题
+)
+
+// 答案公布方式
+const (
+	RevealNever  = "never"  //从不公布答案
+	RevealSubmit = "submit" //每次提交后公布
+	RevealFinish = "finish" //答对或用完作答次数后公布（默认）
+)
+
+var (
+	ErrQuestionType    = errors.New("exercise: unknown question type")
+	ErrQuestionStem    = errors.New("exercise: question stem is empty")
+	ErrQuestionOptions = errors.New("exercise: choice question needs at least two options")
+	ErrQuestionAnswer  = errors.New("exercise: question answer is invalid")
+	ErrQuestionScore   = errors.New("exercise: question score must be positive")
+	ErrNotPending      = errors.New("exercise: question is not waiting for grading")
+	ErrScoreRange      = errors.New("exercise: score is out of range")
+)
+
+// Question 题目。Answers 的含义随题型不同：
+// 单选、多选为选项字母（A、B、C...）；判断为 true 或 false；
+// 填空为每一空的答案，同一空可接受多个答案时用 | 分隔；简答为评分关键词，没有关键词时需老师批阅
+type Question struct {
+	ID          string   `bson:"id" json:"id"`
+	Type        string   `bson:"type" json:"type"`
+	Stem        string   `bson:"stem" json:"stem"`                           //题干
+	Options     []string `bson:"options,omitempty" json:"options,omitempty"` //选项
+	Answers     []string `bson:"answers" json:"answers,omitempty"`           //标准答案
+	Score       float64  `bson:"score" json:"score"`                         //分值
+	Explanation string   `bson:"explanation" json:"explanation,omitempty"`   //答案解析
+}
+
+// Answer 学生对一道题的作答
+type Answer struct {
+	QuestionID string   `bson:"questionID" json:"questionID"`
+	Values     []string `bson:"values" json:"values"`
+}
+
+// QuestionResult 一道题的评分结果
+type QuestionResult struct {
+	QuestionID string  `bson:"questionID" json:"questionID"`
+	Score      float64 `bson:"score" json:"score"`
+	FullScore  float64 `bson:"fullScore" json:"fullScore"`
+	Correct    bool    `bson:"correct" json:"correct"`
+	Pending    bool    `bson:"pending" json:"pending"` //需老师批阅
+}
+
+// Result 一次作答的评分结果
+type Result struct {
+	Score     float64          `json:"score"`
+	FullScore float64          `json:"fullScore"`
+	Pending   bool             `json:"pending"`
+	Questions []QuestionResult `json:"questions"`
+}
+
+// Validate 校验题目设置，返回第一个错误
+func Validate(questions []*Question) error {
+	for _, q := range questions {
+		if strings.TrimSpace(q.Stem) == "" {
+			return ErrQuestionStem
+		}
+		if q.Score <= 0 {
+			return ErrQuestionScore
+		}
+		switch q.Type {
+		case TypeSingle, TypeMultiple:
+			if len(q.Options) < 2 {
+				return ErrQuestionOptions
+			}
+			if len(q.Answers) == 0 || (q.Type == TypeSingle && len(q.Answers) != 1) {
+				return ErrQuestionAnswer
+			}
+			for _, a := range q.Answers {
+				if i := optionIndex(a); i < 0 || i >= len(q.Options) {
+					return ErrQuestionAnswer
+				}
+			}
+		case TypeJudge:
+			if len(q.Answers) != 1 || parseJudge(q.Answers[0]) == "" {
+				return ErrQuestionAnswer
+			}
+		case TypeBlank:
+			if len(q.Answers) == 0 {
+				return ErrQuestionAnswer
+			}
+		case TypeShort:
+		default:
+			return ErrQuestionType
+		}
+	}
+	return nil
+}
+
+// ValidReveal 判断答案公布方式是否合法，空值按默认方式处理
+func ValidReveal(reveal string) bool {
+	return reveal == "" || reveal == RevealNever || reveal == RevealSubmit || reveal == RevealFinish
+}
+
+// ShouldReveal 根据公布方式、已作答次数和是否已得满分判断能否公布答案
+func ShouldReveal(reveal string, attempts, maxAttempts int, fullMarks bool) bool {
+	switch reveal {
+	case RevealNever:
+		return false
+	case RevealSubmit:
+		return attempts > 0
+	}
+	return fullMarks || (maxAttempts > 0 && attempts >= maxAttempts)
+}
+
+// Public 返回去掉答案和解析的题目，用于发给学生作答
+func Public(questions []*Question) []*Question {
+	out := make([]*Question, len(questions))
+	for i, q := range questions {
+		p := *q
+		p.Answers = nil
+		p.Explanation = ""
+		out[i] = &p
+	}
+	return out
+}
+
+// FullScore 题目总分
+func FullScore(questions []*Question) float64 {
+	total := 0.0
+	for _, q := range questions {
+		total += q.Score
+	}
+	return total
+}
+
+// Grade 按标准答案为一次作答评分，未作答的题目得0分
+func Grade(questions []*Question, answers []Answer) Result {
+	byID := make(map[string][]string, len(answers))
+	for _, a := range answers {
+		byID[a.QuestionID] = a.Values
+	}
+	var result Result
+	for _, q := range questions {
+		qr := gradeQuestion(q, byID[q.ID])
+		result.Score += qr.Score
+		result.FullScore += qr.FullScore
+		result.Pending = result.Pending || qr.Pending
+		result.Questions = append(result.Questions, qr)
+	}
+	return result
+}
+
+// ScorePending 老师为需批阅的题目评分，score 不能超过题目分值。返回评分后的总分和是否还有需批阅的题目
+func ScorePending(results []QuestionResult, questionID string, score float64) (total float64, pending bool, err error) {
+	found := false
+	for i := range results {
+		qr := &results[i]
+		if qr.QuestionID == questionID {
+			if !qr.Pending {
+				return 0, false, ErrNotPending
+			}
+			if score < 0 || score > qr.FullScore {
+				return 0, false, ErrScoreRange
+			}
+			qr.Score, qr.Correct, qr.Pending = score, score == qr.FullScore, false
+			found = true
+		}
+		total += qr.Score
+		pending = pending || qr.Pending
+	}
+	if !found {
+		return 0, false, ErrNotPending
+	}
+	return total, pending, nil
+}
+
+func gradeQuestion(q *Question, values []string) QuestionResult {
+	qr := QuestionResult{QuestionID: q.ID, FullScore: q.Score}
+	switch q.Type {
+	case TypeSingle, TypeMultiple:
+		want := optionSet(q.Answers)
+		got := optionSet(values)
+		hit := 0
+		for k := range got {
+			if !want[k] {
+				// 选错任何一项不得分
+				return qr
+			}
+			hit++
+		}
+		switch {
+		case hit == len(want):
+			qr.Score, qr.Correct = q.Score, true
+		case hit > 0 && q.Type == TypeMultiple:
+			// 多选题少选得一半分
+			qr.Score = q.Score / 2
+		}
+	case TypeJudge:
+		if len(values) == 1 && parseJudge(values[0]) != "" && parseJudge(values[0]) == parseJudge(q.Answers[0]) {
+			qr.Score, qr.Correct = q.Score, true
+		}
+	case TypeBlank:
+		hit := 0
+		for i, accepted := range q.Answers {
+			if i < len(values) && blankMatch(accepted, values[i]) {
+				hit++
+			}
+		}
+		qr.Score = q.Score * float64(hit) / float64(len(q.Answers))
+		qr.Correct = hit == len(q.Answers)
+	case TypeShort:
+		if len(q.Answers) == 0 {
+			qr.Pending = true
+			return qr
+		}
+		text := normalize(strings.Join(values, " "))
+		hit := 0
+		for _, keyword := range q.Answers {
+			if k := normalize(keyword); k != "" && strings.Contains(text, k) {
+				hit++
+			}
+		}
+		qr.Score = q.Score * float64(hit) / float64(len(q.Answers))
+		qr.Correct = hit == len(q.Answers)
+	}
+	return qr
+}
+
+// optionIndex 把选项字母转换为选项序号，非法时返回-1
+func optionIndex(s string) int {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) != 1 || s[0] < 'A' || s[0] > 'Z' {
+		return -1
+	}
+	return int(s[0] - 'A')
+}
+
+func optionSet(values []string) map[int]bool {
+	set := make(map[int]bool)
+	for _, v := range values {
+		if i := optionIndex(v); i >= 0 {
+			set[i] = true
+		}
+	}
+	return set
+}
+
+// parseJudge 统一判断题答案的写法，非法时返回空串
+func parseJudge(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "t", "1", "对", "正确", "√":
+		return "true"
+	case "false", "f", "0", "错", "错误", "×":
+		return "false"
+	}
+	return ""
+}
+
+func blankMatch(accepted, value string) bool {
+	v := normalize(value)
+	if v == "" {
+		return false
+	}
+	for _, a := range strings.Split(accepted, "|") {
+		if normalize(a) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize 去掉空白并转为小写，全角字母数字转为半角
+func normalize(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '　' || r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			continue
+		case r >= '！' && r <= '～':
+			r -= 0xfee0
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToLower(b.String())
+}
@@ -0,0 +1,195 @@
+package exercise
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testQuestions() []*Question {
+	return []*Question{
+		{ID: "1", Type: TypeSingle, Stem: "LED的长脚是", Options: []string{"正极", "负极"}, Answers: []string{"A"}, Score: 10},
+		{ID: "2", Type: TypeMultiple, Stem: "属于输入设备的是", Options: []string{"按钮", "蜂鸣器", "光敏电阻"}, Answers: []string{"A", "C"}, Score: 10},
+		{ID: "3", Type: TypeJudge, Stem: "Arduino使用C语言编程", Answers: []string{"true"}, Score: 10},
+		{ID: "4", Type: TypeBlank, Stem: "欧姆定律 U=__×__", Answers: []string{"I|电流", "R|电阻"}, Score: 10},
+		{ID: "5", Type: TypeShort, Stem: "说说舵机的作用", Answers: []string{"角度", "控制"}, Score: 10},
+		{ID: "6", Type: TypeShort, Stem: "谈谈你的作品", Score: 10},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(testQuestions()); err != nil {
+		t.Fatal(err)
+	}
+	bad := []*Question{{ID: "1", Type: TypeSingle, Stem: "x", Options: []string{"a", "b"}, Answers: []string{"C"}, Score: 1}}
+	if err := Validate(bad); err != ErrQuestionAnswer {
+		t.Errorf("err = %v, want ErrQuestionAnswer", err)
+	}
+	bad = []*Question{{ID: "1", Type: "essay", Stem: "x", Score: 1}}
+	if err := Validate(bad); err != ErrQuestionType {
+		t.Errorf("err = %v, want ErrQuestionType", err)
+	}
+}
+
+func TestGrade(t *testing.T) {
+	answers := []Answer{
+		{QuestionID: "1", Values: []string{"a"}},
+		{QuestionID: "2", Values: []string{"A"}},
+		{QuestionID: "3", Values: []string{"对"}},
+		{QuestionID: "4", Values: []string{"ｉ", "电 阻"}},
+		{QuestionID: "5", Values: []string{"控制转动的角度"}},
+		{QuestionID: "6", Values: []string{"一个小车"}},
+	}
+	result := Grade(testQuestions(), answers)
+	want := []float64{10, 5, 10, 10, 10, 0}
+	for i, qr := range result.Questions {
+		if qr.Score != want[i] {
+			t.Errorf("question %s score = %v, want %v", qr.QuestionID, qr.Score, want[i])
+		}
+	}
+	if result.Score != 45 || result.FullScore != 60 || !result.Pending {
+		t.Errorf("result = %+v", result)
+	}
+
+	// 老师批阅没有关键词的简答题
+	if _, _, err := ScorePending(result.Questions, "6", 11); err != ErrScoreRange {
+		t.Errorf("score over full marks: %v", err)
+	}
+	if _, _, err := ScorePending(result.Questions, "5", 5); err != ErrNotPending {
+		t.Errorf("score graded question: %v", err)
+	}
+	total, pending, err := ScorePending(result.Questions, "6", 8)
+	if err != nil || total != 53 || pending || result.Questions[5].Score != 8 {
+		t.Errorf("ScorePending = %v, %v, %v", total, pending, err)
+	}
+
+	wrong := Grade(testQuestions(), []Answer{{QuestionID: "2", Values: []string{"A", "B", "C"}}})
+	if wrong.Questions[1].Score != 0 {
+		t.Errorf("multiple choice with wrong option scored %v", wrong.Questions[1].Score)
+	}
+}
+
+func TestShouldReveal(t *testing.T) {
+	cases := []struct {
+		reveal               string
+		attempts, maxAttempt int
+		fullMarks, want      bool
+	}{
+		{RevealNever, 3, 3, true, false},
+		{RevealSubmit, 0, 3, false, false},
+		{RevealSubmit, 1, 3, false, true},
+		{"", 1, 3, false, false},
+		{"", 3, 3, false, true},
+		{RevealFinish, 1, 0, true, true},
+		{RevealFinish, 5, 0, false, false},
+	}
+	for _, c := range cases {
+		if got := ShouldReveal(c.reveal, c.attempts, c.maxAttempt, c.fullMarks); got != c.want {
+			t.Errorf("ShouldReveal(%+v) = %v", c, got)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	questions := testQuestions()[:3]
+	grade := func(user string, attempt int, duration int64, answers ...Answer) Submission {
+		r := Grade(questions, answers)
+		return Submission{UserID: user, Attempt: attempt, Answers: answers, Results: r.Questions, Score: r.Score, Duration: duration}
+	}
+	subs := []Submission{
+		grade("u1", 1, 60, Answer{"1", []string{"B"}}, Answer{"2", []string{"B"}}),
+		grade("u1", 2, 0, Answer{"1", []string{"A"}}, Answer{"2", []string{"A", "C"}}, Answer{"3", []string{"true"}}),
+		grade("u2", 1, 120, Answer{"1", []string{"B"}}, Answer{"2", []string{"C", "B"}}, Answer{"3", []string{"false"}}),
+	}
+	items, summary := Analyze(questions, subs)
+	if items[0].Answered != 3 || items[0].Correct != 1 || items[0].CommonWrong != "B" || items[0].WrongCount != 2 {
+		t.Errorf("item 1 = %+v", items[0])
+	}
+	if items[1].CommonWrong != "B" || items[2].Answered != 2 {
+		t.Errorf("items = %+v", items)
+	}
+	if summary.Students != 2 || summary.Submissions != 3 || summary.AverageAttempts != 1.5 || summary.AverageDuration != 90 {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestPaper(t *testing.T) {
+	questions := testQuestions()
+	paper := NewPaper(questions, 42, true, true)
+	if len(paper.Order) != len(questions) {
+		t.Fatalf("order = %v", paper.Order)
+	}
+	if again := NewPaper(questions, 42, true, true); fmt.Sprint(again) != fmt.Sprint(paper) {
+		t.Errorf("same seed gives different paper")
+	}
+	shown := paper.Questions(questions, true)
+	var answers []Answer
+	for _, q := range shown {
+		if q.Type == TypeSingle || q.Type == TypeMultiple {
+			if q.Answers == nil {
+				t.Fatalf("question %s lost answers", q.ID)
+			}
+			answers = append(answers, Answer{QuestionID: q.ID, Values: q.Answers})
+		}
+	}
+	result := Grade(questions, paper.ToOriginal(answers))
+	if result.Score != 20 {
+		t.Errorf("score with displayed answers = %v, want 20", result.Score)
+	}
+	for _, q := range paper.Questions(questions, false) {
+		if q.Answers != nil || q.Explanation != "" {
+			t.Errorf("question %s leaks answers", q.ID)
+		}
+	}
+}
+
+func TestImportRows(t *testing.T) {
+	rows, err := ReadCSV(strings.NewReader("\ufeff题型,题干,选项A,选项B,选项C,答案,分值\n" +
+		"单选,LED的长脚是,正极,负极,,A,5\n" +
+		"多选,属于输入设备的是,按钮,蜂鸣器,光敏电阻,\"A,C\",\n" +
+		"填空,电阻的单位是,,,,欧姆|Ω,2\n" +
+		"判断,Arduino使用C语言编程,,,,对,\n" +
+		"单选,没有选项的题,,,,A,1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := ImportRows(rows)
+	if report.Total != 5 || report.Valid != 4 || len(report.Issues) != 1 || report.Issues[0].Item != "第6行" {
+		t.Fatalf("report = %+v", report)
+	}
+	if q := report.Questions[1]; fmt.Sprint(q.Answers) != "[A C]" || q.Score != 1 {
+		t.Errorf("multiple = %+v", q)
+	}
+}
+
+func TestQTIRoundTrip(t *testing.T) {
+	questions := testQuestions()
+	var buf bytes.Buffer
+	if err := ExportQTI(&buf, "第一课", questions); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ImportQTI(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid != len(questions) || len(report.Issues) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	for i, q := range report.Questions {
+		want := questions[i]
+		if q.Type != want.Type || q.Score != want.Score || len(q.Options) != len(want.Options) {
+			t.Errorf("question %d = %+v, want %+v", i, q, want)
+		}
+	}
+	full := make([]Answer, 0, len(questions))
+	for _, q := range questions {
+		full = append(full, Answer{QuestionID: q.ID, Values: q.Answers})
+	}
+	for i, q := range report.Questions {
+		q.ID = questions[i].ID
+	}
+	if a, b := Grade(questions, full).Score, Grade(report.Questions, full).Score; a != b {
+		t.Errorf("imported questions grade %v, want %v", b, a)
+	}
+}
@@ -0,0 +1,100 @@
+package exercise
+
+import "math/rand"
+
+// Paper 一份打乱了题目顺序和选项顺序的试卷。
+// Order 为显示顺序的题目ID，Options[题目ID][显示序号] 为原选项序号。
+// 学生按显示的选项字母作答，评分前用 ToOriginal 换回原选项字母
+type Paper struct {
+	Order   []string         `bson:"order" json:"-"`
+	Options map[string][]int `bson:"options" json:"-"`
+}
+
+// NewPaper 用seed生成一份试卷，同一seed总是得到相同的顺序
+func NewPaper(questions []*Question, seed int64, shuffleQuestions, shuffleOptions bool) Paper {
+	rnd := rand.New(rand.NewSource(seed))
+	p := Paper{Options: make(map[string][]int)}
+	for _, q := range questions {
+		p.Order = append(p.Order, q.ID)
+	}
+	if shuffleQuestions {
+		rnd.Shuffle(len(p.Order), func(i, j int) { p.Order[i], p.Order[j] = p.Order[j], p.Order[i] })
+	}
+	for _, q := range questions {
+		if q.Type != TypeSingle && q.Type != TypeMultiple {
+			continue
+		}
+		perm := make([]int, len(q.Options))
+		for i := range perm {
+			perm[i] = i
+		}
+		if shuffleOptions {
+			rnd.Shuffle(len(perm), func(i, j int) { perm[i], perm[j] = perm[j], perm[i] })
+		}
+		p.Options[q.ID] = perm
+	}
+	return p
+}
+
+// Questions 按试卷的顺序返回题目；withAnswers 为false时去掉答案和解析，选择题答案换成显示的选项字母
+func (p Paper) Questions(questions []*Question, withAnswers bool) []*Question {
+	byID := make(map[string]*Question, len(questions))
+	for _, q := range questions {
+		byID[q.ID] = q
+	}
+	out := make([]*Question, 0, len(p.Order))
+	for _, id := range p.Order {
+		q, ok := byID[id]
+		if !ok {
+			continue
+		}
+		d := *q
+		if perm, ok := p.Options[id]; ok && len(perm) == len(q.Options) {
+			d.Options = make([]string, len(perm))
+			for i, orig := range perm {
+				d.Options[i] = q.Options[orig]
+			}
+			d.Answers = p.mapLetters(id, q.Answers, false)
+		}
+		if !withAnswers {
+			d.Answers = nil
+			d.Explanation = ""
+		}
+		out = append(out, &d)
+	}
+	return out
+}
+
+// ToOriginal 把按显示选项字母作答的答案换回原选项字母
+func (p Paper) ToOriginal(answers []Answer) []Answer {
+	out := make([]Answer, len(answers))
+	for i, a := range answers {
+		out[i] = Answer{QuestionID: a.QuestionID, Values: a.Values}
+		if _, ok := p.Options[a.QuestionID]; ok {
+			out[i].Values = p.mapLetters(a.QuestionID, a.Values, true)
+		}
+	}
+	return out
+}
+
+// mapLetters 在显示字母和原字母之间转换，toOriginal 为true时从显示字母转为原字母
+func (p Paper) mapLetters(id string, letters []string, toOriginal bool) []string {
+	perm := p.Options[id]
+	out := make([]string, 0, len(letters))
+	for _, l := range letters {
+		i := optionIndex(l)
+		if i < 0 || i >= len(perm) {
+			continue
+		}
+		if toOriginal {
+			out = append(out, string(rune('A'+perm[i])))
+			continue
+		}
+		for display, orig := range perm {
+			if orig == i {
+				out = append(out, string(rune('A'+display)))
+			}
+		}
+	}
+	return out
+}
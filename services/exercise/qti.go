@@ -0,0 +1,524 @@
+package exercise
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrQTI 不是可以识别的QTI 2.1题目
+var ErrQTI = errors.New("exercise: unsupported QTI item")
+
+const (
+	qtiNamespace = "http://www.imsglobal.org/xsd/imsqti_v2p1"
+	qtiSchema    = "http://www.imsglobal.org/xsd/imsqti_v2p1 http://www.imsglobal.org/xsd/qti/qtiv2p1/imsqti_v2p1.xsd"
+	qtiItemType  = "imsqti_item_xmlv2p1"
+	qtiMatch     = "http://www.imsglobal.org/question/qti_v2p1/rptemplates/match_correct"
+	qtiMapScore  = "http://www.imsglobal.org/question/qti_v2p1/rptemplates/map_response"
+)
+
+// reBlankMark 题干中的填空位置：连续两个以上的下划线或中文括号
+var reBlankMark = regexp.MustCompile(`_{2,}|（\s*）`)
+
+// xnode 保留文字和子元素先后顺序的XML节点，文字节点的name为空
+type xnode struct {
+	name     string
+	attrs    map[string]string
+	children []*xnode
+	text     string
+}
+
+func (n *xnode) attr(name string) string {
+	return n.attrs[name]
+}
+
+// find 深度优先查找第一个指定名称的后代节点
+func (n *xnode) find(name string) *xnode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+		if found := c.find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findAll 查找全部指定名称的后代节点
+func (n *xnode) findAll(name string) []*xnode {
+	var out []*xnode
+	for _, c := range n.children {
+		if c.name == name {
+			out = append(out, c)
+		}
+		out = append(out, c.findAll(name)...)
+	}
+	return out
+}
+
+// plainText 提取节点的文字，块级元素之间换行；replace 可以把某些元素替换为指定文字
+func (n *xnode) plainText(replace func(*xnode) (string, bool)) string {
+	var b strings.Builder
+	var walk func(*xnode)
+	walk = func(node *xnode) {
+		for _, c := range node.children {
+			if c.name == "" {
+				b.WriteString(c.text)
+				continue
+			}
+			if replace != nil {
+				if s, ok := replace(c); ok {
+					b.WriteString(s)
+					continue
+				}
+			}
+			switch c.name {
+			case "br":
+				b.WriteString("\n")
+			case "p", "div", "li", "blockquote":
+				b.WriteString("\n")
+				walk(c)
+				b.WriteString("\n")
+			default:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseXNode(data []byte) (*xnode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	root := &xnode{}
+	stack := []*xnode{root}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &xnode{name: t.Name.Local, attrs: make(map[string]string)}
+			for _, a := range t.Attr {
+				node.attrs[a.Name.Local] = a.Value
+			}
+			top.children = append(top.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			top.children = append(top.children, &xnode{text: string(t)})
+		}
+	}
+	for _, c := range root.children {
+		if c.name != "" {
+			return c, nil
+		}
+	}
+	return nil, ErrQTI
+}
+
+// qtiResponse 题目的responseDeclaration
+type qtiResponse struct {
+	correct []string
+	mapped  []string //mapping中得分为正的答案
+}
+
+// ParseQTIItem 把一个QTI 2.1 assessmentItem转换为题目
+func ParseQTIItem(data []byte) (*Question, error) {
+	root, err := parseXNode(data)
+	if err != nil || root.name != "assessmentItem" {
+		return nil, ErrQTI
+	}
+	responses := make(map[string]*qtiResponse)
+	for _, decl := range root.findAll("responseDeclaration") {
+		resp := &qtiResponse{}
+		if cr := decl.find("correctResponse"); cr != nil {
+			for _, v := range cr.findAll("value") {
+				resp.correct = append(resp.correct, strings.TrimSpace(v.plainText(nil)))
+			}
+		}
+		for _, entry := range decl.findAll("mapEntry") {
+			if v, err := strconv.ParseFloat(entry.attr("mappedValue"), 64); err == nil && v > 0 {
+				resp.mapped = append(resp.mapped, entry.attr("mapKey"))
+			}
+		}
+		responses[decl.attr("identifier")] = resp
+	}
+	q := &Question{ID: root.attr("identifier"), Score: qtiMaxScore(root)}
+	if fb := root.find("modalFeedback"); fb != nil {
+		q.Explanation = fb.plainText(nil)
+	}
+	body := root.find("itemBody")
+	if body == nil {
+		return nil, ErrQTI
+	}
+
+	if choice := body.find("choiceInteraction"); choice != nil {
+		resp := responses[choice.attr("responseIdentifier")]
+		if resp == nil {
+			return nil, ErrQTI
+		}
+		var ids []string
+		for _, c := range choice.findAll("simpleChoice") {
+			ids = append(ids, c.attr("identifier"))
+			q.Options = append(q.Options, c.plainText(nil))
+		}
+		stem := body.plainText(func(n *xnode) (string, bool) {
+			if n == choice {
+				return "", true
+			}
+			return "", false
+		})
+		if prompt := choice.find("prompt"); prompt != nil {
+			stem = strings.TrimSpace(stem + "\n" + prompt.plainText(nil))
+		}
+		q.Stem = stem
+		if len(ids) == 2 && isJudgeChoice(ids) {
+			q.Type = TypeJudge
+			q.Options = nil
+			if len(resp.correct) > 0 {
+				q.Answers = []string{strings.ToLower(resp.correct[0])}
+			}
+			return q, nil
+		}
+		q.Type = TypeMultiple
+		if choice.attr("maxChoices") == "1" {
+			q.Type = TypeSingle
+		}
+		for _, id := range resp.correct {
+			for i, cid := range ids {
+				if cid == id {
+					q.Answers = append(q.Answers, string(rune('A'+i)))
+				}
+			}
+		}
+		return q, nil
+	}
+
+	if entries := body.findAll("textEntryInteraction"); len(entries) > 0 {
+		q.Type = TypeBlank
+		q.Stem = body.plainText(func(n *xnode) (string, bool) {
+			if n.name == "textEntryInteraction" {
+				return "____", true
+			}
+			return "", false
+		})
+		for _, entry := range entries {
+			resp := responses[entry.attr("responseIdentifier")]
+			if resp == nil {
+				return nil, ErrQTI
+			}
+			q.Answers = append(q.Answers, strings.Join(uniqueStrings(append(resp.correct, resp.mapped...)), "|"))
+		}
+		return q, nil
+	}
+
+	if ext := body.find("extendedTextInteraction"); ext != nil {
+		q.Type = TypeShort
+		q.Stem = body.plainText(func(n *xnode) (string, bool) {
+			if n == ext {
+				return "", true
+			}
+			return "", false
+		})
+		if prompt := ext.find("prompt"); prompt != nil {
+			q.Stem = strings.TrimSpace(q.Stem + "\n" + prompt.plainText(nil))
+		}
+		if resp := responses[ext.attr("responseIdentifier")]; resp != nil {
+			q.Answers = uniqueStrings(append(resp.correct, resp.mapped...))
+		}
+		return q, nil
+	}
+	return nil, ErrQTI
+}
+
+// ImportQTI 导入QTI 2.1内容包（zip）或单个assessmentItem文件，按清单中的顺序导入题目
+func ImportQTI(r io.ReaderAt, size int64) (ImportReport, error) {
+	report := ImportReport{Issues: []ImportIssue{}, Questions: []*Question{}}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		data, err := ioutil.ReadAll(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return report, err
+		}
+		q, err := ParseQTIItem(data)
+		if err == ErrQTI {
+			return report, err
+		}
+		report.add("item", q, err)
+		return report, nil
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	items := qtiManifestItems(files)
+	if items == nil {
+		for name := range files {
+			if strings.HasSuffix(strings.ToLower(name), ".xml") && path.Base(name) != "imsmanifest.xml" {
+				items = append(items, name)
+			}
+		}
+		sort.Strings(items)
+	}
+	for _, name := range items {
+		f, ok := files[name]
+		if !ok {
+			report.add(name, nil, errors.New("文件不存在"))
+			continue
+		}
+		data, err := readZipFile(f)
+		if err != nil {
+			report.add(name, nil, err)
+			continue
+		}
+		q, err := ParseQTIItem(data)
+		if err == ErrQTI {
+			err = errors.New("不是支持的QTI 2.1题目")
+		}
+		report.add(name, q, err)
+	}
+	return report, nil
+}
+
+// ExportQTI 把题目导出为QTI 2.1内容包（zip），每道题一个assessmentItem文件
+func ExportQTI(w io.Writer, title string, questions []*Question) error {
+	zw := zip.NewWriter(w)
+	var manifest strings.Builder
+	manifest.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	manifest.WriteString(`<manifest xmlns="http://www.imsglobal.org/xsd/imscp_v1p1" identifier="MANIFEST">` + "\n")
+	manifest.WriteString(`  <metadata><schema>QTIv2.1 Package</schema><schemaversion>1.0.0</schemaversion></metadata>` + "\n")
+	manifest.WriteString("  <organizations/>\n  <resources>\n")
+	for i, q := range questions {
+		id := qtiIdentifier(q.ID, i)
+		href := "items/" + id + ".xml"
+		fw, err := zw.Create(href)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, qtiItemXML(id, fmt.Sprintf("%s %d", title, i+1), q)); err != nil {
+			return err
+		}
+		fmt.Fprintf(&manifest, "    <resource identifier=\"RES-%s\" type=\"%s\" href=\"%s\"><file href=\"%s\"/></resource>\n", id, qtiItemType, href, href)
+	}
+	manifest.WriteString("  </resources>\n</manifest>\n")
+	fw, err := zw.Create("imsmanifest.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(fw, manifest.String()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本服务的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// qtiItemXML 生成一道题的assessmentItem
+func qtiItemXML(id, title string, q *Question) string {
+	var b strings.Builder
+	score := strconv.FormatFloat(q.Score, 'f', -1, 64)
+	fmt.Fprintf(&b, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<assessmentItem xmlns=\"%s\" xmlns:xsi=\"http://www.w3.org/2001/XMLSchema-instance\" xsi:schemaLocation=\"%s\" identifier=\"%s\" title=\"%s\" adaptive=\"false\" timeDependent=\"false\">\n",
+		qtiNamespace, qtiSchema, id, esc(title))
+
+	var body strings.Builder
+	template := qtiMatch
+	switch q.Type {
+	case TypeSingle, TypeMultiple, TypeJudge:
+		cardinality, maxChoices := "single", "1"
+		if q.Type == TypeMultiple {
+			cardinality, maxChoices = "multiple", "0"
+		}
+		ids, labels := make([]string, len(q.Options)), q.Options
+		for i := range q.Options {
+			ids[i] = string(rune('A' + i))
+		}
+		var correct []string
+		for _, a := range q.Answers {
+			correct = append(correct, strings.ToUpper(strings.TrimSpace(a)))
+		}
+		if q.Type == TypeJudge {
+			ids, labels = []string{"true", "false"}, []string{"正确", "错误"}
+			correct = []string{parseJudge(q.Answers[0])}
+		}
+		fmt.Fprintf(&b, "  <responseDeclaration identifier=\"RESPONSE\" cardinality=\"%s\" baseType=\"identifier\">\n    <correctResponse>\n", cardinality)
+		for _, c := range correct {
+			fmt.Fprintf(&b, "      <value>%s</value>\n", esc(c))
+		}
+		b.WriteString("    </correctResponse>\n  </responseDeclaration>\n")
+		fmt.Fprintf(&body, "    <choiceInteraction responseIdentifier=\"RESPONSE\" shuffle=\"false\" maxChoices=\"%s\">\n      <prompt>%s</prompt>\n", maxChoices, esc(q.Stem))
+		for i, label := range labels {
+			fmt.Fprintf(&body, "      <simpleChoice identifier=\"%s\">%s</simpleChoice>\n", ids[i], esc(label))
+		}
+		body.WriteString("    </choiceInteraction>\n")
+	case TypeBlank:
+		template = qtiMapScore
+		per := strconv.FormatFloat(q.Score/float64(len(q.Answers)), 'f', -1, 64)
+		for i, accepted := range q.Answers {
+			alts := strings.Split(accepted, "|")
+			fmt.Fprintf(&b, "  <responseDeclaration identifier=\"RESPONSE%d\" cardinality=\"single\" baseType=\"string\">\n", i+1)
+			fmt.Fprintf(&b, "    <correctResponse>\n      <value>%s</value>\n    </correctResponse>\n    <mapping defaultValue=\"0\">\n", esc(alts[0]))
+			for _, alt := range alts {
+				fmt.Fprintf(&b, "      <mapEntry mapKey=\"%s\" mappedValue=\"%s\"/>\n", esc(alt), per)
+			}
+			b.WriteString("    </mapping>\n  </responseDeclaration>\n")
+		}
+		n := 0
+		stem := reBlankMark.ReplaceAllStringFunc(esc(q.Stem), func(string) string {
+			if n >= len(q.Answers) {
+				return "____"
+			}
+			n++
+			return fmt.Sprintf("<textEntryInteraction responseIdentifier=\"RESPONSE%d\"/>", n)
+		})
+		for n < len(q.Answers) {
+			n++
+			stem += fmt.Sprintf(" <textEntryInteraction responseIdentifier=\"RESPONSE%d\"/>", n)
+		}
+		fmt.Fprintf(&body, "    <p>%s</p>\n", stem)
+	case TypeShort:
+		template = ""
+		b.WriteString("  <responseDeclaration identifier=\"RESPONSE\" cardinality=\"multiple\" baseType=\"string\">\n")
+		if len(q.Answers) > 0 {
+			b.WriteString("    <correctResponse>\n")
+			for _, k := range q.Answers {
+				fmt.Fprintf(&b, "      <value>%s</value>\n", esc(k))
+			}
+			b.WriteString("    </correctResponse>\n")
+		}
+		b.WriteString("  </responseDeclaration>\n")
+		fmt.Fprintf(&body, "    <extendedTextInteraction responseIdentifier=\"RESPONSE\">\n      <prompt>%s</prompt>\n    </extendedTextInteraction>\n", esc(q.Stem))
+	}
+	fmt.Fprintf(&b, "  <outcomeDeclaration identifier=\"SCORE\" cardinality=\"single\" baseType=\"float\" normalMaximum=\"%s\">\n    <defaultValue>\n      <value>0</value>\n    </defaultValue>\n  </outcomeDeclaration>\n", score)
+	fmt.Fprintf(&b, "  <outcomeDeclaration identifier=\"MAXSCORE\" cardinality=\"single\" baseType=\"float\">\n    <defaultValue>\n      <value>%s</value>\n    </defaultValue>\n  </outcomeDeclaration>\n", score)
+	if q.Explanation != "" {
+		b.WriteString("  <outcomeDeclaration identifier=\"FEEDBACK\" cardinality=\"single\" baseType=\"identifier\"/>\n")
+	}
+	b.WriteString("  <itemBody>\n" + body.String() + "  </itemBody>\n")
+	if template != "" {
+		fmt.Fprintf(&b, "  <responseProcessing template=\"%s\"/>\n", template)
+	}
+	if q.Explanation != "" {
+		fmt.Fprintf(&b, "  <modalFeedback outcomeIdentifier=\"FEEDBACK\" identifier=\"EXPLANATION\" showHide=\"show\">%s</modalFeedback>\n", esc(q.Explanation))
+	}
+	b.WriteString("</assessmentItem>\n")
+	return b.String()
+}
+
+// qtiMaxScore 读取题目的满分：MAXSCORE的默认值，其次SCORE的normalMaximum，都没有时为1分
+func qtiMaxScore(root *xnode) float64 {
+	for _, decl := range root.findAll("outcomeDeclaration") {
+		switch decl.attr("identifier") {
+		case "MAXSCORE":
+			if v := decl.find("value"); v != nil {
+				if score, err := strconv.ParseFloat(strings.TrimSpace(v.plainText(nil)), 64); err == nil && score > 0 {
+					return score
+				}
+			}
+		case "SCORE":
+			if score, err := strconv.ParseFloat(decl.attr("normalMaximum"), 64); err == nil && score > 0 {
+				return score
+			}
+		}
+	}
+	return 1
+}
+
+// qtiManifestItems 按清单顺序返回题目文件，没有清单时返回nil
+func qtiManifestItems(files map[string]*zip.File) []string {
+	f, ok := files["imsmanifest.xml"]
+	if !ok {
+		return nil
+	}
+	data, err := readZipFile(f)
+	if err != nil {
+		return nil
+	}
+	root, err := parseXNode(data)
+	if err != nil {
+		return nil
+	}
+	items := []string{}
+	for _, res := range root.findAll("resource") {
+		if strings.HasPrefix(res.attr("type"), "imsqti_item") && res.attr("href") != "" {
+			items = append(items, path.Clean(res.attr("href")))
+		}
+	}
+	return items
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(io.LimitReader(rc, 16<<20))
+}
+
+// qtiIdentifier 生成合法的QTI标识符
+func qtiIdentifier(id string, i int) string {
+	valid := id != ""
+	for _, r := range id {
+		if !(r == '_' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			valid = false
+			break
+		}
+	}
+	if valid && !(id[0] >= '0' && id[0] <= '9') && id[0] != '-' {
+		return id
+	}
+	if valid {
+		return "Q" + id
+	}
+	return fmt.Sprintf("Q%d", i+1)
+}
+
+func isJudgeChoice(ids []string) bool {
+	a, b := strings.ToLower(ids[0]), strings.ToLower(ids[1])
+	return (a == "true" && b == "false") || (a == "false" && b == "true")
+}
+
+func uniqueStrings(list []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func esc(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
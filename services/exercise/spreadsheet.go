@@ -0,0 +1,313 @@
+package exercise
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ErrSpreadsheet 表格文件无法解析
+var ErrSpreadsheet = errors.New("exercise: invalid spreadsheet")
+
+// ImportIssue 导入时一道题的问题
+type ImportIssue struct {
+	Item    string `json:"item"` //出错的行号或题目文件
+	Message string `json:"message"`
+}
+
+// ImportReport 导入校验报告，Questions 只包含校验通过的题目
+type ImportReport struct {
+	Total     int           `json:"total"`
+	Valid     int           `json:"valid"`
+	Issues    []ImportIssue `json:"issues"`
+	Questions []*Question   `json:"questions"`
+}
+
+// add 校验一道题，通过的加入题目列表，否则记录问题
+func (report *ImportReport) add(item string, q *Question, err error) {
+	report.Total++
+	if err == nil {
+		err = Validate([]*Question{q})
+	}
+	if err != nil {
+		report.Issues = append(report.Issues, ImportIssue{Item: item, Message: issueMessage(err)})
+		return
+	}
+	report.Valid++
+	report.Questions = append(report.Questions, q)
+}
+
+func issueMessage(err error) string {
+	switch err {
+	case ErrQuestionType:
+		return "题型不正确"
+	case ErrQuestionStem:
+		return "题干为空"
+	case ErrQuestionOptions:
+		return "选择题至少需要两个选项"
+	case ErrQuestionAnswer:
+		return "答案不正确或与选项不符"
+	case ErrQuestionScore:
+		return "分值必须大于0"
+	}
+	return err.Error()
+}
+
+// 表头名称对应的列
+var columnNames = map[string]string{
+	"type": "type", "题型": "type", "类型": "type",
+	"stem": "stem", "题干": "stem", "题目": "stem",
+	"answer": "answer", "answers": "answer", "答案": "answer", "正确答案": "answer",
+	"score": "score", "分值": "score", "分数": "score",
+	"explanation": "explanation", "解析": "explanation", "答案解析": "explanation",
+}
+
+// 题型的中英文名称
+var typeNames = map[string]string{
+	"single": TypeSingle, "单选": TypeSingle, "单选题": TypeSingle,
+	"multiple": TypeMultiple, "多选": TypeMultiple, "多选题": TypeMultiple,
+	"judge": TypeJudge, "truefalse": TypeJudge, "判断": TypeJudge, "判断题": TypeJudge,
+	"blank": TypeBlank, "填空": TypeBlank, "填空题": TypeBlank,
+	"short": TypeShort, "简答": TypeShort, "简答题": TypeShort,
+}
+
+// ImportRows 把表格转换为题目。第一行为表头：题型、题干、选项A、选项B...、答案、分值、解析。
+// 选择题答案写选项字母（如 AC 或 A,C），填空题每空的答案和简答题的关键词用分号分隔，分值为空时按1分计
+func ImportRows(rows [][]string) ImportReport {
+	report := ImportReport{Issues: []ImportIssue{}, Questions: []*Question{}}
+	if len(rows) == 0 {
+		return report
+	}
+	columns := make(map[string]int)
+	options := make(map[int]int) //选项序号 -> 列
+	for i, name := range rows[0] {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if col, ok := columnNames[key]; ok {
+			columns[col] = i
+			continue
+		}
+		key = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(key, "选项"), "option"))
+		if len(key) == 1 && key[0] >= 'a' && key[0] <= 'z' {
+			options[int(key[0]-'a')] = i
+		}
+	}
+	cell := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	for n, row := range rows[1:] {
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		item := fmt.Sprintf("第%d行", n+2)
+		q := &Question{
+			Type:        typeNames[strings.ToLower(cell(row, "type"))],
+			Stem:        cell(row, "stem"),
+			Explanation: cell(row, "explanation"),
+			Score:       1,
+		}
+		if s := cell(row, "score"); s != "" {
+			score, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				report.add(item, q, ErrQuestionScore)
+				continue
+			}
+			q.Score = score
+		}
+		for i := 0; i < len(options); i++ {
+			col, ok := options[i]
+			if !ok || col >= len(row) || strings.TrimSpace(row[col]) == "" {
+				break
+			}
+			q.Options = append(q.Options, strings.TrimSpace(row[col]))
+		}
+		q.Answers = parseAnswerCell(q.Type, cell(row, "answer"))
+		report.add(item, q, nil)
+	}
+	return report
+}
+
+// parseAnswerCell 按题型拆分答案单元格
+func parseAnswerCell(typ, s string) []string {
+	switch typ {
+	case TypeSingle, TypeMultiple:
+		var letters []string
+		for _, r := range strings.ToUpper(s) {
+			if r >= 'A' && r <= 'Z' {
+				letters = append(letters, string(r))
+			}
+		}
+		return letters
+	case TypeJudge:
+		if s == "" {
+			return nil
+		}
+		return []string{s}
+	}
+	var parts []string
+	for _, p := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '；' || r == '\n' }) {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// ReadCSV 读取CSV表格
+func ReadCSV(r io.Reader) ([][]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+	return rows, err
+}
+
+// ReadXLSX 读取xlsx工作簿中第一个工作表的全部单元格文本
+func ReadXLSX(r io.ReaderAt, size int64) ([][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrSpreadsheet
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	var shared []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		var sst struct {
+			Items []struct {
+				T    string `xml:"t"`
+				Runs []struct {
+					T string `xml:"t"`
+				} `xml:"r"`
+			} `xml:"si"`
+		}
+		if err := decodeZipXML(f, &sst); err != nil {
+			return nil, err
+		}
+		for _, si := range sst.Items {
+			text := si.T
+			for _, run := range si.Runs {
+				text += run.T
+			}
+			shared = append(shared, text)
+		}
+	}
+	f, ok := files[firstSheet(files)]
+	if !ok {
+		return nil, ErrSpreadsheet
+	}
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodeZipXML(f, &sheet); err != nil {
+		return nil, err
+	}
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		var cells []string
+		for i, c := range row.Cells {
+			col := columnIndex(c.Ref)
+			if col < 0 {
+				col = i
+			}
+			for len(cells) <= col {
+				cells = append(cells, "")
+			}
+			switch c.Type {
+			case "s":
+				if k, err := strconv.Atoi(c.Value); err == nil && k >= 0 && k < len(shared) {
+					cells[col] = shared[k]
+				}
+			case "inlineStr":
+				cells[col] = c.Inline
+			default:
+				cells[col] = c.Value
+			}
+		}
+		rows = append(rows, cells)
+	}
+	return rows, nil
+}
+
+// firstSheet 根据workbook.xml和关系文件找到第一个工作表的路径
+func firstSheet(files map[string]*zip.File) string {
+	const fallback = "xl/worksheets/sheet1.xml"
+	var workbook struct {
+		Sheets []struct {
+			RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Items []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	wb, ok1 := files["xl/workbook.xml"]
+	rf, ok2 := files["xl/_rels/workbook.xml.rels"]
+	if !ok1 || !ok2 || decodeZipXML(wb, &workbook) != nil || decodeZipXML(rf, &rels) != nil || len(workbook.Sheets) == 0 {
+		return fallback
+	}
+	for _, rel := range rels.Items {
+		if rel.ID == workbook.Sheets[0].RID {
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/")
+			}
+			return path.Join("xl", rel.Target)
+		}
+	}
+	return fallback
+}
+
+// columnIndex 把单元格引用（如 C12）转换为从0开始的列号
+func columnIndex(ref string) int {
+	col := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		n++
+	}
+	if n == 0 {
+		return -1
+	}
+	return col - 1
+}
+
+func decodeZipXML(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, 64<<20))
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return ErrSpreadsheet
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package exercise
+
+import (
+	"sort"
+	"strings"
+)
+
+// Submission 统计用的一次作答
+type Submission struct {
+	UserID   string
+	Attempt  int
+	Answers  []Answer
+	Results  []QuestionResult
+	Score    float64
+	Duration int64 //作答用时（秒），0表示未知
+}
+
+// ItemStat 一道题的作答统计
+type ItemStat struct {
+	QuestionID   string  `json:"questionID"`
+	Type         string  `json:"type"`
+	Stem         string  `json:"stem"`
+	Answered     int     `json:"answered"`     //作答次数
+	Correct      int     `json:"correct"`      //答对次数
+	CorrectRate  float64 `json:"correctRate"`  //正确率，0到1
+	AverageScore float64 `json:"averageScore"` //平均得分
+	CommonWrong  string  `json:"commonWrong"`  //最常见的错误答案
+	WrongCount   int     `json:"wrongCount"`   //最常见错误答案的次数
+}
+
+// Summary 一个课时练习的整体统计
+type Summary struct {
+	Students        int     `json:"students"`        //作答人数
+	Submissions     int     `json:"submissions"`     //作答总次数
+	AverageAttempts float64 `json:"averageAttempts"` //人均作答次数
+	AverageDuration float64 `json:"averageDuration"` //平均作答用时（秒），只统计有用时的作答
+	AverageBest     float64 `json:"averageBest"`     //学生最高分的平均值
+}
+
+// Analyze 按题目统计正确率、平均得分和最常见的错误答案，并汇总作答次数和用时
+func Analyze(questions []*Question, subs []Submission) ([]ItemStat, Summary) {
+	items := make([]ItemStat, len(questions))
+	index := make(map[string]int, len(questions))
+	wrong := make([]map[string]int, len(questions))
+	for i, q := range questions {
+		items[i] = ItemStat{QuestionID: q.ID, Type: q.Type, Stem: q.Stem}
+		index[q.ID] = i
+		wrong[i] = make(map[string]int)
+	}
+
+	var summary Summary
+	best := make(map[string]float64)
+	var durationSum float64
+	var durationCount int
+	for _, sub := range subs {
+		summary.Submissions++
+		if b, ok := best[sub.UserID]; !ok || sub.Score > b {
+			best[sub.UserID] = sub.Score
+		}
+		if sub.Duration > 0 {
+			durationSum += float64(sub.Duration)
+			durationCount++
+		}
+		answers := make(map[string][]string, len(sub.Answers))
+		for _, a := range sub.Answers {
+			answers[a.QuestionID] = a.Values
+		}
+		for _, r := range sub.Results {
+			i, ok := index[r.QuestionID]
+			if !ok || r.Pending {
+				continue
+			}
+			key := answerKey(questions[i], answers[r.QuestionID])
+			if key == "" && !r.Correct {
+				// 未作答不计入统计
+				continue
+			}
+			items[i].Answered++
+			items[i].AverageScore += r.Score
+			if r.Correct {
+				items[i].Correct++
+			} else {
+				wrong[i][key]++
+			}
+		}
+	}
+	for i := range items {
+		if items[i].Answered > 0 {
+			items[i].CorrectRate = float64(items[i].Correct) / float64(items[i].Answered)
+			items[i].AverageScore /= float64(items[i].Answered)
+		}
+		for key, n := range wrong[i] {
+			if n > items[i].WrongCount || (n == items[i].WrongCount && key < items[i].CommonWrong) {
+				items[i].CommonWrong, items[i].WrongCount = key, n
+			}
+		}
+	}
+
+	summary.Students = len(best)
+	if summary.Students > 0 {
+		summary.AverageAttempts = float64(summary.Submissions) / float64(summary.Students)
+		for _, b := range best {
+			summary.AverageBest += b
+		}
+		summary.AverageBest /= float64(summary.Students)
+	}
+	if durationCount > 0 {
+		summary.AverageDuration = durationSum / float64(durationCount)
+	}
+	return items, summary
+}
+
+// answerKey 把作答转换为便于归类比较的文本：选择题为排序后的选项字母，其它题型为规整后的答案
+func answerKey(q *Question, values []string) string {
+	switch q.Type {
+	case TypeSingle, TypeMultiple:
+		var letters []string
+		for i := range optionSet(values) {
+			letters = append(letters, string(rune('A'+i)))
+		}
+		sort.Strings(letters)
+		return strings.Join(letters, ",")
+	case TypeJudge:
+		if len(values) == 1 {
+			return parseJudge(values[0])
+		}
+		return ""
+	}
+	parts := make([]string, len(values))
+	empty := true
+	for i, v := range values {
+		parts[i] = strings.TrimSpace(v)
+		if parts[i] != "" {
+			empty = false
+		}
+	}
+	if empty {
+		return ""
+	}
+	return strings.Join(parts, " / ")
+}
@@ -0,0 +1,59 @@
+package markdown
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"sync"
+)
+
+// Cache 渲染结果缓存，键为文件内容的sha256和渲染选项，超过容量时淘汰最早写入的结果
+type Cache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]Result
+	order   []string
+}
+
+// NewCache 创建最多保存max个渲染结果的缓存
+func NewCache(max int) *Cache {
+	return &Cache{max: max, entries: make(map[string]Result)}
+}
+
+// DefaultCache 服务进程共享的渲染缓存
+var DefaultCache = NewCache(512)
+
+// Render 渲染markdown，内容未变化时直接返回缓存的结果
+func (c *Cache) Render(src []byte, opt Options) Result {
+	sum := sha256.Sum256(src)
+	key := hex.EncodeToString(sum[:]) + "|" + opt.BaseURL
+
+	c.mu.Lock()
+	result, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		return result
+	}
+
+	result = Render(src, opt)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = result
+		c.order = append(c.order, key)
+		for len(c.order) > c.max {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	return result
+}
+
+// RenderFile 读取并渲染markdown文件
+func (c *Cache) RenderFile(filename string, opt Options) (Result, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Result{}, err
+	}
+	return c.Render(src, opt), nil
+}
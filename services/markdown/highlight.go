@@ -0,0 +1,190 @@
+package markdown
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 代码高亮使用的样式类名，前端按类名设置颜色
+const (
+	classKeyword = "hl-kw"
+	classString  = "hl-str"
+	classComment = "hl-com"
+	classNumber  = "hl-num"
+)
+
+type language struct {
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+	keywords     map[string]bool
+}
+
+func words(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	langPython = &language{
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords: words(`False None True and as assert async await break class continue def del elif else except
+			finally for from global if import in is lambda nonlocal not or pass raise return try while with yield print`),
+	}
+	langC = &language{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		keywords: words(`auto break case char const continue default do double else enum extern float for goto if
+			int long register return short signed sizeof static struct switch typedef union unsigned void volatile while
+			bool true false class public private protected new delete this namespace using template virtual include define
+			byte boolean String setup loop HIGH LOW INPUT OUTPUT INPUT_PULLUP`),
+	}
+	langJava = &language{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		keywords: words(`abstract boolean break byte case catch char class const continue default do double else enum
+			extends final finally float for if implements import instanceof int interface long new package private
+			protected public return short static super switch this throw throws try void while true false null`),
+	}
+	langJS = &language{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+		keywords: words(`async await break case catch class const continue debugger default delete do else export extends
+			false finally for function if import in instanceof let new null return super switch this throw true try
+			typeof undefined var void while with yield`),
+	}
+	langGo = &language{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+		keywords: words(`break case chan const continue default defer else fallthrough for func go goto if import
+			interface map package range return select struct switch type var true false nil`),
+	}
+	langShell = &language{
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords:     words(`if then else elif fi for while do done case esac function in return export echo`),
+	}
+	langLua = &language{
+		lineComments: []string{"--"},
+		quotes:       `"'`,
+		keywords: words(`and break do else elseif end false for function goto if in local nil not or repeat return
+			then true until while`),
+	}
+)
+
+var languages = map[string]*language{
+	"python": langPython, "py": langPython,
+	"c": langC, "cpp": langC, "c++": langC, "h": langC, "arduino": langC, "ino": langC,
+	"java":       langJava,
+	"javascript": langJS, "js": langJS, "json": langJS, "typescript": langJS, "ts": langJS,
+	"go": langGo, "golang": langGo,
+	"shell": langShell, "sh": langShell, "bash": langShell,
+	"lua": langLua,
+}
+
+// Highlight 对代码做语法高亮，输出转义后的HTML；不支持的语言只做转义
+func Highlight(lang, code string) string {
+	l, ok := languages[strings.ToLower(lang)]
+	if !ok {
+		return html.EscapeString(code)
+	}
+	var b strings.Builder
+	span := func(class, text string) {
+		b.WriteString(`<span class="` + class + `">` + html.EscapeString(text) + "</span>")
+	}
+	for i := 0; i < len(code); {
+		rest := code[i:]
+		if n := l.comment(rest); n > 0 {
+			span(classComment, rest[:n])
+			i += n
+			continue
+		}
+		c := code[i]
+		if strings.IndexByte(l.quotes, c) >= 0 {
+			n := stringLen(rest)
+			span(classString, rest[:n])
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(rest)
+		switch {
+		case unicode.IsDigit(r):
+			n := 0
+			for n < len(rest) && (isIdentByte(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			span(classNumber, rest[:n])
+			i += n
+		case r == '_' || unicode.IsLetter(r):
+			n := 0
+			for n < len(rest) {
+				r, s := utf8.DecodeRuneInString(rest[n:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				n += s
+			}
+			if l.keywords[rest[:n]] {
+				span(classKeyword, rest[:n])
+			} else {
+				b.WriteString(html.EscapeString(rest[:n]))
+			}
+			i += n
+		default:
+			b.WriteString(html.EscapeString(rest[:size]))
+			i += size
+		}
+	}
+	return b.String()
+}
+
+// comment 返回以注释开头时注释的长度
+func (l *language) comment(s string) int {
+	for _, lc := range l.lineComments {
+		if strings.HasPrefix(s, lc) {
+			if end := strings.IndexByte(s, '\n'); end >= 0 {
+				return end
+			}
+			return len(s)
+		}
+	}
+	if l.blockComment[0] != "" && strings.HasPrefix(s, l.blockComment[0]) {
+		if end := strings.Index(s[len(l.blockComment[0]):], l.blockComment[1]); end >= 0 {
+			return len(l.blockComment[0]) + end + len(l.blockComment[1])
+		}
+		return len(s)
+	}
+	return 0
+}
+
+// stringLen 返回字符串字面量的长度，支持反斜杠转义，普通引号的字符串不跨行
+func stringLen(s string) int {
+	quote := s[0]
+	for j := 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		case '\n':
+			if quote != '`' {
+				return j
+			}
+		}
+	}
+	return len(s)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
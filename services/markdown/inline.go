@@ -0,0 +1,294 @@
+package markdown
+
+import (
+	"html"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	reAutolink   = regexp.MustCompile(`^<((?:https?|mailto):[^\s<>]+)>`)
+	reInlineHTML = regexp.MustCompile(`^</?[a-zA-Z][a-zA-Z0-9]*(?:\s+[a-zA-Z_:][-a-zA-Z0-9_:.]*(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*\s*/?>`)
+	reEntity     = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+	reTags       = regexp.MustCompile(`<[^>]*>`)
+)
+
+// escapable 可以用反斜杠转义的字符
+const escapable = "\\`*_{}[]()#+-.!|~<>\"'"
+
+// inline 渲染行内元素：转义、代码、链接、图片、强调、删除线、换行，原样保留行内HTML交给白名单过滤
+func (r *renderer) inline(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		rest := text[i:]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte(escapable, text[i+1]) >= 0:
+			b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+		case c == '`':
+			n := countRun(rest, '`')
+			fence := rest[:n]
+			if end := strings.Index(rest[n:], fence); end >= 0 {
+				code := strings.TrimSpace(strings.Replace(rest[n:n+end], "\n", " ", -1))
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += n + end + n
+			} else {
+				b.WriteString(fence)
+				i += n
+			}
+		case c == '!' && strings.HasPrefix(rest, "!["):
+			if alt, dest, title, n, ok := parseLink(rest[1:]); ok {
+				b.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(stripTags(r.inline(alt))) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">")
+				i += 1 + n
+			} else {
+				b.WriteString("!")
+				i++
+			}
+		case c == '[':
+			if label, dest, title, n, ok := parseLink(rest); ok {
+				b.WriteString(`<a href="` + html.EscapeString(dest) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">" + r.inline(label) + "</a>")
+				i += n
+			} else {
+				b.WriteString("[")
+				i++
+			}
+		case c == '<':
+			if m := reAutolink.FindStringSubmatch(rest); m != nil {
+				b.WriteString(`<a href="` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[1]) + "</a>")
+				i += len(m[0])
+			} else if m := reInlineHTML.FindString(rest); m != "" {
+				b.WriteString(m)
+				i += len(m)
+			} else if strings.HasPrefix(rest, "<!--") {
+				end := strings.Index(rest, "-->")
+				if end < 0 {
+					end = len(rest) - 3
+				}
+				i += end + 3
+			} else {
+				b.WriteString("&lt;")
+				i++
+			}
+		case c == '&':
+			if m := reEntity.FindString(rest); m != "" {
+				b.WriteString(m)
+				i += len(m)
+			} else {
+				b.WriteString("&amp;")
+				i++
+			}
+		case c == '*' || c == '_' || c == '~':
+			if out, n, ok := r.emphasis(text, i); ok {
+				b.WriteString(out)
+				i += n
+			} else {
+				n := countRun(rest, c)
+				b.WriteString(rest[:n])
+				i += n
+			}
+		case c == '\n':
+			// 行尾两个以上空格为强制换行
+			s := b.String()
+			if strings.HasSuffix(s, "  ") {
+				trimmed := strings.TrimRight(s, " ")
+				b.Reset()
+				b.WriteString(trimmed + "<br>")
+			}
+			b.WriteByte('\n')
+			i++
+		case c == '>':
+			b.WriteString("&gt;")
+			i++
+		case c == '"':
+			b.WriteString("&#34;")
+			i++
+		default:
+			_, size := utf8.DecodeRuneInString(rest)
+			b.WriteString(rest[:size])
+			i += size
+		}
+	}
+	return b.String()
+}
+
+// emphasis 渲染 *斜体*、**粗体**、~~删除线~~，_只在单词边界生效
+func (r *renderer) emphasis(text string, i int) (string, int, bool) {
+	c := text[i]
+	n := countRun(text[i:], c)
+	if c == '~' && n != 2 {
+		return "", 0, false
+	}
+	if n > 3 {
+		return "", 0, false
+	}
+	if c == '_' && i > 0 && isWordByte(text, i-1) {
+		return "", 0, false
+	}
+	after := text[i+n:]
+	if after == "" || unicode.IsSpace(firstRune(after)) {
+		return "", 0, false
+	}
+	delim := text[i : i+n]
+	// 查找闭合的分隔符：前面不是空白，长度相同
+	for j := 0; j < len(after); j++ {
+		if after[j] == '\\' {
+			j++
+			continue
+		}
+		if after[j] == '`' {
+			if end := strings.IndexByte(after[j+1:], '`'); end >= 0 {
+				j += end + 1
+			}
+			continue
+		}
+		if !strings.HasPrefix(after[j:], delim) || countRun(after[j:], c) != n || j == 0 {
+			continue
+		}
+		if unicode.IsSpace(lastRune(after[:j])) {
+			continue
+		}
+		if c == '_' && i+n+j+n < len(text) && isWordByte(text, i+n+j+n) {
+			continue
+		}
+		inner := r.inline(after[:j])
+		var out string
+		switch {
+		case c == '~':
+			out = "<del>" + inner + "</del>"
+		case n == 1:
+			out = "<em>" + inner + "</em>"
+		case n == 2:
+			out = "<strong>" + inner + "</strong>"
+		default:
+			out = "<em><strong>" + inner + "</strong></em>"
+		}
+		return out, n + j + n, true
+	}
+	return "", 0, false
+}
+
+// parseLink 解析 [文字](地址 "标题")，返回文字、地址、标题和消耗的字节数
+func parseLink(s string) (label, dest, title string, n int, ok bool) {
+	depth := 0
+	end := -1
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				end = j
+			}
+		}
+		if end >= 0 {
+			break
+		}
+	}
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return
+	}
+	label = s[1:end]
+	closeParen := -1
+	depth = 0
+	for j := end + 2; j < len(s); j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if s[j] == '(' {
+			depth++
+		}
+		if s[j] == ')' {
+			if depth == 0 {
+				closeParen = j
+				break
+			}
+			depth--
+		}
+	}
+	if closeParen < 0 {
+		return
+	}
+	inner := strings.TrimSpace(s[end+2 : closeParen])
+	if strings.HasPrefix(inner, "<") {
+		if k := strings.IndexByte(inner, '>'); k > 0 {
+			dest = inner[1:k]
+			inner = strings.TrimSpace(inner[k+1:])
+		}
+	} else if k := strings.IndexAny(inner, " \n"); k >= 0 {
+		dest = inner[:k]
+		inner = strings.TrimSpace(inner[k+1:])
+	} else {
+		dest, inner = inner, ""
+	}
+	if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+		title = inner[1 : len(inner)-1]
+	} else if inner != "" {
+		return
+	}
+	return label, html.UnescapeString(dest), html.UnescapeString(title), closeParen + 1, true
+}
+
+func countRun(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+func isWordByte(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	if r == utf8.RuneError {
+		r, _ = utf8.DecodeLastRuneInString(s[:i+1])
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func stripTags(s string) string {
+	return reTags.ReplaceAllString(s, "")
+}
+
+// rewriteURL 把相对地址改写为课程资源目录下的地址，绝对地址、锚点和带协议的地址保持不变
+func rewriteURL(u, base string) string {
+	if u == "" || base == "" || strings.HasPrefix(u, "/") || strings.HasPrefix(u, "#") || strings.Contains(u, ":") {
+		return u
+	}
+	suffix := ""
+	if k := strings.IndexAny(u, "?#"); k >= 0 {
+		u, suffix = u[:k], u[k:]
+	}
+	joined := path.Join(base, u)
+	if !strings.HasPrefix(joined, "/") {
+		joined = "/" + joined
+	}
+	return joined + suffix
+}
@@ -0,0 +1,452 @@
+// @APIVersion 1.0.0
+// @Title 课时markdown渲染服务
+// @Description 把课时的markdown渲染为安全的HTML：白名单过滤、相对链接改写、目录、代码高亮，渲染结果按文件校验和缓存
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Options 渲染选项
+type Options struct {
+	// BaseURL 相对链接的前缀，例如 /asset/course/scratch/001/
+	BaseURL string
+}
+
+// Heading 目录中的一个标题
+type Heading struct {
+	Level int    `json:"level"`
+	ID    string `json:"id"`
+	Text  string `json:"text"`
+}
+
+// Result 渲染结果
+type Result struct {
+	HTML    string    `json:"html"`
+	TOC     []Heading `json:"toc"`
+	TOCHTML string    `json:"tocHtml"`
+}
+
+// tocMaxLevel 目录收录的最大标题级别
+const tocMaxLevel = 3
+
+// Render 把markdown渲染为经过白名单过滤的HTML
+func Render(src []byte, opt Options) Result {
+	r := &renderer{ids: make(map[string]int)}
+	text := strings.Replace(string(src), "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	text = strings.Replace(text, "\t", "    ", -1)
+	r.blocks(strings.Split(text, "\n"), false)
+
+	rewrite := func(u string) string { return rewriteURL(u, opt.BaseURL) }
+	result := Result{
+		HTML: Sanitize(r.buf.String(), rewrite),
+		TOC:  r.toc,
+	}
+	if result.TOC == nil {
+		result.TOC = []Heading{}
+	}
+	result.TOCHTML = tocHTML(result.TOC)
+	return result
+}
+
+type renderer struct {
+	buf bytes.Buffer
+	toc []Heading
+	ids map[string]int
+}
+
+var (
+	reATXHeading  = regexp.MustCompile(`^ {0,3}(#{1,6})(?:\s+(.*?))?(?:\s+#+)?\s*$`)
+	reHR          = regexp.MustCompile(`^ {0,3}((\*\s*){3,}|(-\s*){3,}|(_\s*){3,})$`)
+	reFence       = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})\\s*([^`\\s]*)")
+	reListItem    = regexp.MustCompile(`^( {0,3})([-*+]|(\d{1,9})[.)])( +|$)`)
+	reTableDelim  = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	reHTMLBlock   = regexp.MustCompile(`^ {0,3}</?([a-zA-Z][a-zA-Z0-9]*)(\s|/?>|$)`)
+	reSetextH1    = regexp.MustCompile(`^ {0,3}=+\s*$`)
+	reSetextH2    = regexp.MustCompile(`^ {0,3}-+\s*$`)
+	reBlockquote  = regexp.MustCompile(`^ {0,3}> ?`)
+	reIndentCode  = regexp.MustCompile(`^    `)
+	reHTMLComment = regexp.MustCompile(`^ {0,3}<!--`)
+)
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// startsBlock 判断一行是否会打断段落
+func startsBlock(line string) bool {
+	return reATXHeading.MatchString(line) || reHR.MatchString(line) || reFence.MatchString(line) ||
+		reBlockquote.MatchString(line) || reListItem.MatchString(line) || reHTMLBlock.MatchString(line) ||
+		reHTMLComment.MatchString(line)
+}
+
+// blocks 渲染块级元素，tight为true时段落不加<p>（紧凑列表）
+func (r *renderer) blocks(lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case reFence.MatchString(line):
+			i = r.fencedCode(lines, i)
+		case reATXHeading.MatchString(line):
+			m := reATXHeading.FindStringSubmatch(line)
+			r.heading(len(m[1]), m[2])
+			i++
+		case reHR.MatchString(line):
+			r.buf.WriteString("<hr>\n")
+			i++
+		case reBlockquote.MatchString(line):
+			i = r.blockquote(lines, i)
+		case reListItem.MatchString(line):
+			i = r.list(lines, i)
+		case reIndentCode.MatchString(line):
+			i = r.indentedCode(lines, i)
+		case reHTMLBlock.MatchString(line) || reHTMLComment.MatchString(line):
+			i = r.htmlBlock(lines, i)
+		case i+1 < len(lines) && strings.Contains(line, "|") && reTableDelim.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "-"):
+			i = r.table(lines, i)
+		default:
+			i = r.paragraph(lines, i, tight)
+		}
+	}
+}
+
+func (r *renderer) heading(level int, text string) {
+	inner := r.inline(strings.TrimSpace(text))
+	plain := strings.TrimSpace(html.UnescapeString(stripTags(inner)))
+	id := r.headingID(plain)
+	if level <= tocMaxLevel {
+		r.toc = append(r.toc, Heading{Level: level, ID: id, Text: plain})
+	}
+	fmt.Fprintf(&r.buf, "<h%d id=\"%s\">%s</h%d>\n", level, html.EscapeString(id), inner, level)
+}
+
+// headingID 根据标题文字生成锚点ID，保留字母、数字和汉字，重复的ID加序号
+func (r *renderer) headingID(text string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-':
+			b.WriteRune(c)
+		case unicode.IsSpace(c):
+			b.WriteRune('-')
+		}
+	}
+	id := b.String()
+	if id == "" {
+		id = "section"
+	}
+	n := r.ids[id]
+	r.ids[id] = n + 1
+	if n > 0 {
+		id += "-" + strconv.Itoa(n)
+	}
+	return id
+}
+
+func (r *renderer) fencedCode(lines []string, i int) int {
+	m := reFence.FindStringSubmatch(lines[i])
+	indent, fence, lang := len(m[1]), m[2], strings.ToLower(m[3])
+	var code []string
+	i++
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence[:3]) && strings.Trim(trimmed, fence[:1]) == "" && len(trimmed) >= len(fence) {
+			i++
+			break
+		}
+		line := lines[i]
+		if n := indentOf(line); n > 0 {
+			if n > indent {
+				n = indent
+			}
+			line = line[n:]
+		}
+		code = append(code, line)
+	}
+	r.code(lang, strings.Join(code, "\n"))
+	return i
+}
+
+func (r *renderer) indentedCode(lines []string, i int) int {
+	var code []string
+	for ; i < len(lines); i++ {
+		if isBlank(lines[i]) {
+			code = append(code, "")
+			continue
+		}
+		if !reIndentCode.MatchString(lines[i]) {
+			break
+		}
+		code = append(code, lines[i][4:])
+	}
+	for len(code) > 0 && code[len(code)-1] == "" {
+		code = code[:len(code)-1]
+	}
+	r.code("", strings.Join(code, "\n"))
+	return i
+}
+
+func (r *renderer) code(lang, code string) {
+	if lang != "" {
+		fmt.Fprintf(&r.buf, "<pre><code class=\"language-%s\">", html.EscapeString(lang))
+	} else {
+		r.buf.WriteString("<pre><code>")
+	}
+	r.buf.WriteString(Highlight(lang, code))
+	r.buf.WriteString("</code></pre>\n")
+}
+
+func (r *renderer) blockquote(lines []string, i int) int {
+	var inner []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if reBlockquote.MatchString(line) {
+			inner = append(inner, reBlockquote.ReplaceAllString(line, ""))
+			continue
+		}
+		// 懒惰续行：引用中段落的后续行可以省略>
+		if isBlank(line) || startsBlock(line) || len(inner) == 0 || isBlank(inner[len(inner)-1]) {
+			break
+		}
+		inner = append(inner, line)
+	}
+	r.buf.WriteString("<blockquote>\n")
+	r.blocks(inner, false)
+	r.buf.WriteString("</blockquote>\n")
+	return i
+}
+
+func (r *renderer) list(lines []string, i int) int {
+	first := reListItem.FindStringSubmatch(lines[i])
+	ordered := first[3] != ""
+	marker := first[2][len(first[2])-1:]
+	if ordered {
+		start, _ := strconv.Atoi(first[3])
+		if start != 1 {
+			fmt.Fprintf(&r.buf, "<ol start=\"%d\">\n", start)
+		} else {
+			r.buf.WriteString("<ol>\n")
+		}
+	} else {
+		r.buf.WriteString("<ul>\n")
+	}
+
+	var items [][]string
+	var contentIndent int
+	tight := true
+	for i < len(lines) {
+		line := lines[i]
+		if m := reListItem.FindStringSubmatch(line); m != nil && (len(items) == 0 || indentOf(line) < contentIndent) && (m[3] != "") == ordered && strings.HasSuffix(m[2], marker) {
+			contentIndent = len(m[0])
+			if m[4] == "" {
+				contentIndent++
+			}
+			items = append(items, []string{line[len(m[0]):]})
+			i++
+			continue
+		}
+		if len(items) == 0 {
+			break
+		}
+		cur := &items[len(items)-1]
+		if isBlank(line) {
+			// 空行后是缩进的内容或同类型的列表项时，列表继续
+			j := i + 1
+			for j < len(lines) && isBlank(lines[j]) {
+				j++
+			}
+			if j >= len(lines) {
+				i = j
+				break
+			}
+			next := lines[j]
+			if indentOf(next) >= contentIndent {
+				*cur = append(*cur, "")
+				tight = false
+				i++
+				continue
+			}
+			if m := reListItem.FindStringSubmatch(next); m != nil && (m[3] != "") == ordered && strings.HasSuffix(m[2], marker) && indentOf(next) < contentIndent {
+				tight = false
+				i = j
+				continue
+			}
+			break
+		}
+		if indentOf(line) >= contentIndent {
+			*cur = append(*cur, line[contentIndent:])
+			i++
+			continue
+		}
+		// 懒惰续行
+		if !startsBlock(line) && !isBlank((*cur)[len(*cur)-1]) {
+			*cur = append(*cur, strings.TrimLeft(line, " "))
+			i++
+			continue
+		}
+		break
+	}
+	for _, item := range items {
+		r.buf.WriteString("<li>")
+		r.blocks(item, tight)
+		r.buf.WriteString("</li>\n")
+	}
+	if ordered {
+		r.buf.WriteString("</ol>\n")
+	} else {
+		r.buf.WriteString("</ul>\n")
+	}
+	return i
+}
+
+func (r *renderer) htmlBlock(lines []string, i int) int {
+	for ; i < len(lines) && !isBlank(lines[i]); i++ {
+		r.buf.WriteString(lines[i])
+		r.buf.WriteByte('\n')
+	}
+	return i
+}
+
+func (r *renderer) table(lines []string, i int) int {
+	header := splitRow(lines[i])
+	var aligns []string
+	for _, cell := range splitRow(lines[i+1]) {
+		left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			aligns = append(aligns, "center")
+		case right:
+			aligns = append(aligns, "right")
+		case left:
+			aligns = append(aligns, "left")
+		default:
+			aligns = append(aligns, "")
+		}
+	}
+	cell := func(tag string, col int, text string) {
+		if col < len(aligns) && aligns[col] != "" {
+			fmt.Fprintf(&r.buf, "<%s align=\"%s\">", tag, aligns[col])
+		} else {
+			fmt.Fprintf(&r.buf, "<%s>", tag)
+		}
+		r.buf.WriteString(r.inline(text))
+		fmt.Fprintf(&r.buf, "</%s>", tag)
+	}
+	r.buf.WriteString("<table>\n<thead>\n<tr>")
+	for col, text := range header {
+		cell("th", col, text)
+	}
+	r.buf.WriteString("</tr>\n</thead>\n<tbody>\n")
+	for i += 2; i < len(lines) && !isBlank(lines[i]) && strings.Contains(lines[i], "|"); i++ {
+		row := splitRow(lines[i])
+		r.buf.WriteString("<tr>")
+		for col := range header {
+			text := ""
+			if col < len(row) {
+				text = row[col]
+			}
+			cell("td", col, text)
+		}
+		r.buf.WriteString("</tr>\n")
+	}
+	r.buf.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+// splitRow 拆分表格行，支持\|转义
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cur strings.Builder
+	for j := 0; j < len(line); j++ {
+		if line[j] == '\\' && j+1 < len(line) && line[j+1] == '|' {
+			cur.WriteByte('|')
+			j++
+			continue
+		}
+		if line[j] == '|' {
+			cells = append(cells, strings.TrimSpace(cur.String()))
+			cur.Reset()
+			continue
+		}
+		cur.WriteByte(line[j])
+	}
+	return append(cells, strings.TrimSpace(cur.String()))
+}
+
+func (r *renderer) paragraph(lines []string, i int, tight bool) int {
+	var para []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if len(para) > 0 && reSetextH1.MatchString(line) {
+			r.heading(1, strings.Join(para, "\n"))
+			return i + 1
+		}
+		if len(para) > 0 && reSetextH2.MatchString(line) {
+			r.heading(2, strings.Join(para, "\n"))
+			return i + 1
+		}
+		if isBlank(line) || (len(para) > 0 && startsBlock(line)) {
+			break
+		}
+		para = append(para, strings.TrimLeft(line, " "))
+	}
+	text := r.inline(strings.Join(para, "\n"))
+	if tight {
+		r.buf.WriteString(text)
+		r.buf.WriteByte('\n')
+	} else {
+		r.buf.WriteString("<p>" + text + "</p>\n")
+	}
+	return i
+}
+
+// tocHTML 生成嵌套的目录列表
+func tocHTML(toc []Heading) string {
+	if len(toc) == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	b.WriteString(`<nav class="toc">`)
+	var levels []int // 当前打开的各层列表的标题级别
+	for _, h := range toc {
+		for len(levels) > 0 && h.Level < levels[len(levels)-1] {
+			b.WriteString("</li></ul>")
+			levels = levels[:len(levels)-1]
+		}
+		if len(levels) == 0 || h.Level > levels[len(levels)-1] {
+			b.WriteString("<ul>")
+			levels = append(levels, h.Level)
+		} else {
+			b.WriteString("</li>")
+		}
+		fmt.Fprintf(&b, `<li><a href="#%s">%s</a>`, html.EscapeString(h.ID), html.EscapeString(h.Text))
+	}
+	for range levels {
+		b.WriteString("</li></ul>")
+	}
+	b.WriteString("</nav>")
+	return b.String()
+}
@@ -0,0 +1,68 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderHeadingTOC(t *testing.T) {
+	src := "# 第一课\n\n## 准备材料\n\n## 准备材料\n\n### Step 1\n"
+	result := Render([]byte(src), Options{})
+	if len(result.TOC) != 4 {
+		t.Fatalf("toc len = %d, want 4", len(result.TOC))
+	}
+	if result.TOC[1].ID == result.TOC[2].ID {
+		t.Errorf("duplicate heading id %q", result.TOC[1].ID)
+	}
+	if !strings.Contains(result.HTML, `<h2 id="`+result.TOC[1].ID+`">准备材料</h2>`) {
+		t.Errorf("heading html = %q", result.HTML)
+	}
+	if !strings.Contains(result.TOCHTML, `href="#`+result.TOC[3].ID+`"`) {
+		t.Errorf("toc html = %q", result.TOCHTML)
+	}
+}
+
+func TestRenderSanitize(t *testing.T) {
+	src := "<script>alert(1)</script>\n\n[点我](javascript:alert(1)) <img src=x onerror=alert(1)>\n\n<a href=\"http://a.com\" onclick=\"x()\">a</a>"
+	out := Render([]byte(src), Options{}).HTML
+	for _, bad := range []string{"<script", "alert(1)</script>", "javascript:", "onerror", "onclick"} {
+		if strings.Contains(out, bad) {
+			t.Errorf("output contains %q: %s", bad, out)
+		}
+	}
+	if !strings.Contains(out, `<a href="http://a.com">a</a>`) {
+		t.Errorf("safe link removed: %s", out)
+	}
+}
+
+func TestRenderRewriteURL(t *testing.T) {
+	src := "![图](img/led.png)\n\n[下一课](../002/index.md) [外链](https://maiyajia.com/) [锚点](#top)"
+	out := Render([]byte(src), Options{BaseURL: "/asset/course/s/001/"}).HTML
+	for _, want := range []string{
+		`src="/asset/course/s/001/img/led.png"`,
+		`href="/asset/course/s/002/index.md"`,
+		`href="https://maiyajia.com/"`,
+		`href="#top"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q: %s", want, out)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	out := Highlight("python", "def f(x):  # 注释\n    return \"<a>\" + 1")
+	for _, want := range []string{
+		`<span class="hl-kw">def</span>`,
+		`<span class="hl-com"># 注释</span>`,
+		`<span class="hl-str">&#34;&lt;a&gt;&#34;</span>`,
+		`<span class="hl-num">1</span>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q: %s", want, out)
+		}
+	}
+	if got := Highlight("unknown", "<b>"); got != "&lt;b&gt;" {
+		t.Errorf("unknown language = %q", got)
+	}
+}
@@ -0,0 +1,299 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// allowedTags 白名单中的标签及其允许的属性，所有标签都允许id、class、title
+var allowedTags = map[string][]string{
+	"h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {},
+	"p": {}, "br": {}, "hr": {}, "blockquote": {}, "pre": {}, "code": {}, "span": {}, "div": {},
+	"em": {}, "strong": {}, "b": {}, "i": {}, "u": {}, "s": {}, "del": {}, "ins": {}, "sub": {}, "sup": {},
+	"mark": {}, "small": {}, "kbd": {}, "abbr": {}, "nav": {}, "section": {},
+	"ul": {}, "ol": {"start"}, "li": {}, "dl": {}, "dt": {}, "dd": {},
+	"table": {}, "thead": {}, "tbody": {}, "tfoot": {}, "tr": {}, "caption": {},
+	"th": {"align", "colspan", "rowspan"}, "td": {"align", "colspan", "rowspan"},
+	"a":      {"href", "target"},
+	"img":    {"src", "alt", "width", "height"},
+	"video":  {"src", "controls", "width", "height", "poster", "preload", "loop", "muted"},
+	"audio":  {"src", "controls", "preload", "loop"},
+	"source": {"src", "type"},
+	"figure": {}, "figcaption": {}, "details": {"open"}, "summary": {},
+}
+
+// droppedTags 这些标签连同其中的内容一起删除
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true, "noscript": true,
+	"textarea": true, "template": true, "svg": true, "math": true, "frame": true, "frameset": true,
+	"applet": true, "title": true, "head": true, "select": true, "xmp": true, "plaintext": true,
+}
+
+// voidTags 没有结束标签的元素
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true, "source": true}
+
+var (
+	reTagName   = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*`)
+	reClassName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	reSizeAttr  = regexp.MustCompile(`^[0-9]+%?$`)
+)
+
+type attr struct {
+	name, value string
+	hasValue    bool
+}
+
+// Sanitize 按白名单过滤HTML：删除不在白名单中的标签和属性、危险协议的链接和注释，补全未闭合的标签。
+// rewrite 用于改写href、src、poster中的地址
+func Sanitize(s string, rewrite func(string) string) string {
+	var b strings.Builder
+	var open []string
+	for i := 0; i < len(s); {
+		if s[i] != '<' {
+			j := strings.IndexByte(s[i:], '<')
+			if j < 0 {
+				j = len(s) - i
+			}
+			b.WriteString(escapeText(s[i : i+j]))
+			i += j
+			continue
+		}
+		rest := s[i:]
+		if strings.HasPrefix(rest, "<!--") {
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+		name, attrs, closing, selfClose, n := parseTag(rest)
+		if n == 0 {
+			b.WriteString("&lt;")
+			i++
+			continue
+		}
+		i += n
+		if droppedTags[name] {
+			if !closing && !selfClose {
+				i += skipElement(s[i:], name)
+			}
+			continue
+		}
+		allowed, ok := allowedTags[name]
+		if !ok {
+			continue
+		}
+		if closing {
+			for k := len(open) - 1; k >= 0; k-- {
+				if open[k] == name {
+					for _, t := range reverse(open[k:]) {
+						b.WriteString("</" + t + ">")
+					}
+					open = open[:k]
+					break
+				}
+			}
+			continue
+		}
+		b.WriteString("<" + name)
+		writeAttrs(&b, name, attrs, allowed, rewrite)
+		b.WriteString(">")
+		if !voidTags[name] {
+			if selfClose {
+				b.WriteString("</" + name + ">")
+			} else {
+				open = append(open, name)
+			}
+		}
+	}
+	for _, t := range reverse(open) {
+		b.WriteString("</" + t + ">")
+	}
+	return b.String()
+}
+
+func writeAttrs(b *strings.Builder, tag string, attrs []attr, allowed []string, rewrite func(string) string) {
+	seen := make(map[string]bool)
+	blankTarget := false
+	for _, a := range attrs {
+		if seen[a.name] || !attrAllowed(a.name, allowed) {
+			continue
+		}
+		seen[a.name] = true
+		value := a.value
+		switch a.name {
+		case "href", "src", "poster":
+			value = strings.TrimSpace(value)
+			if !safeURL(value, a.name == "href") {
+				continue
+			}
+			if rewrite != nil {
+				value = rewrite(value)
+			}
+		case "class":
+			var classes []string
+			for _, c := range strings.Fields(value) {
+				if reClassName.MatchString(c) {
+					classes = append(classes, c)
+				}
+			}
+			if len(classes) == 0 {
+				continue
+			}
+			value = strings.Join(classes, " ")
+		case "target":
+			if value != "_blank" {
+				continue
+			}
+			blankTarget = true
+		case "width", "height", "colspan", "rowspan", "start":
+			if !reSizeAttr.MatchString(value) {
+				continue
+			}
+		case "align":
+			if value != "left" && value != "right" && value != "center" {
+				continue
+			}
+		}
+		if !a.hasValue || a.name == "controls" || a.name == "loop" || a.name == "muted" || a.name == "open" {
+			b.WriteString(" " + a.name)
+			continue
+		}
+		b.WriteString(" " + a.name + `="` + html.EscapeString(value) + `"`)
+	}
+	if tag == "a" && blankTarget {
+		b.WriteString(` rel="noopener noreferrer"`)
+	}
+}
+
+func attrAllowed(name string, allowed []string) bool {
+	if name == "id" || name == "class" || name == "title" {
+		return true
+	}
+	for _, a := range allowed {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL 只允许相对地址、http(s)地址，链接还允许mailto
+func safeURL(u string, link bool) bool {
+	lower := strings.ToLower(u)
+	// 去掉浏览器会忽略的控制字符后再判断协议
+	lower = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, lower)
+	colon := strings.IndexByte(lower, ':')
+	if colon < 0 {
+		return true
+	}
+	// 冒号出现在路径、查询或锚点之后，不是协议
+	if k := strings.IndexAny(lower, "/?#"); k >= 0 && k < colon {
+		return true
+	}
+	scheme := lower[:colon]
+	return scheme == "http" || scheme == "https" || (link && scheme == "mailto")
+}
+
+// parseTag 解析一个开始或结束标签，n为0表示不是合法的标签
+func parseTag(s string) (name string, attrs []attr, closing, selfClose bool, n int) {
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		closing = true
+		i++
+	}
+	name = reTagName.FindString(s[i:])
+	if name == "" {
+		return "", nil, false, false, 0
+	}
+	i += len(name)
+	name = strings.ToLower(name)
+	for i < len(s) {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		switch {
+		case s[i] == '>':
+			return name, attrs, closing, selfClose, i + 1
+		case strings.HasPrefix(s[i:], "/>"):
+			return name, attrs, closing, true, i + 2
+		case s[i] == '/':
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+			i++
+		}
+		a := attr{name: strings.ToLower(s[start:i])}
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			a.hasValue = true
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				end := strings.IndexByte(s[i+1:], s[i])
+				if end < 0 {
+					return "", nil, false, false, 0
+				}
+				a.value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start := i
+				for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				a.value = s[start:i]
+			}
+			a.value = html.UnescapeString(a.value)
+		}
+		if a.name != "" {
+			attrs = append(attrs, a)
+		}
+	}
+	return "", nil, false, false, 0
+}
+
+// skipElement 跳过被删除元素的内容，返回到结束标签之后的字节数
+func skipElement(s, name string) int {
+	lower := strings.ToLower(s)
+	end := strings.Index(lower, "</"+name)
+	if end < 0 {
+		return len(s)
+	}
+	if gt := strings.IndexByte(s[end:], '>'); gt >= 0 {
+		return end + gt + 1
+	}
+	return len(s)
+}
+
+// escapeText 转义文本中的特殊字符，保留已有的实体
+func escapeText(s string) string {
+	return html.EscapeString(html.UnescapeString(s))
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func reverse(tags []string) []string {
+	out := make([]string, len(tags))
+	for i, t := range tags {
+		out[len(tags)-1-i] = t
+	}
+	return out
+}
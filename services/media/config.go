@@ -0,0 +1,41 @@
+package media
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+// Route 媒体接口的地址
+const Route = "/api/media"
+
+var (
+	mediaSecret string
+	urlExpire   time.Duration
+	// Default 服务进程共享的带宽控制
+	Default *Throttler
+	// StaticAccess 是否允许直接通过 /asset 静态目录访问课程音视频
+	StaticAccess bool
+)
+
+func init() {
+	mediaSecret = beego.AppConfig.DefaultString("media_secret", beego.AppConfig.String("token_secret_key"))
+	urlExpire = time.Minute * time.Duration(beego.AppConfig.DefaultInt("media_url_expire", 240))
+	// 带宽单位为KB/s，0表示不限速
+	totalRate := beego.AppConfig.DefaultInt64("media_total_rate", 0)
+	userRate := beego.AppConfig.DefaultInt64("media_user_rate", 0)
+	Default = NewThrottler(totalRate*1024, userRate*1024)
+	StaticAccess = beego.AppConfig.DefaultBool("media_static_access", false)
+}
+
+// SignURL 为用户生成媒体文件的签名地址，返回地址和过期时间
+func SignURL(file, user string) (string, time.Time) {
+	expires := time.Now().Add(urlExpire)
+	return Route + "?" + Sign(mediaSecret, file, user, expires).Encode(), expires
+}
+
+// VerifyURL 校验签名地址的查询参数
+func VerifyURL(q url.Values) (file, user string, err error) {
+	return Verify(mediaSecret, q, time.Now())
+}
@@ -0,0 +1,73 @@
+package media
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Now()
+	q := Sign("secret", "asset/course/a.mp4", "u1", now.Add(time.Minute))
+	file, user, err := Verify("secret", q, now)
+	if err != nil || file != "asset/course/a.mp4" || user != "u1" {
+		t.Fatalf("verify: %q %q %v", file, user, err)
+	}
+	if _, _, err := Verify("secret", q, now.Add(2*time.Minute)); err != ErrSignExpired {
+		t.Errorf("expired: got %v", err)
+	}
+	q.Set(ParamFile, "asset/course/b.mp4")
+	if _, _, err := Verify("secret", q, now); err != ErrSignInvalid {
+		t.Errorf("tampered: got %v", err)
+	}
+}
+
+func TestServeFileRange(t *testing.T) {
+	f, _ := ioutil.TempFile("", "media*.mp4")
+	f.Write([]byte("0123456789"))
+	f.Close()
+	defer os.Remove(f.Name())
+
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/media", nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		tw, release := NewThrottler(0, 0).ResponseWriter(w, "u1")
+		defer release()
+		if err := ServeFile(tw, r, f.Name()); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := serve(http.Header{"Range": {"bytes=2-4"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Fatalf("range: %d %q", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if w = serve(http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+		t.Errorf("if-none-match: %d", w.Code)
+	}
+	w = serve(http.Header{"Range": {"bytes=2-4"}, "If-Range": {`"stale"`}})
+	if w.Code != http.StatusOK || w.Body.Len() != 10 {
+		t.Errorf("stale if-range: %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestThrottledWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, release := NewThrottler(0, 64*1024).Writer(&buf, "u1")
+	defer release()
+	start := time.Now()
+	w.Write(make([]byte, 96*1024))
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("96KB at 64KB/s took %v, want about 0.5s", d)
+	}
+	if buf.Len() != 96*1024 {
+		t.Errorf("written %d", buf.Len())
+	}
+}
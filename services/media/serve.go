@@ -0,0 +1,66 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Extensions 需要通过媒体接口访问的文件类型
+var Extensions = []string{".mp4", ".m4v", ".webm", ".ogv", ".flv", ".mp3", ".m4a", ".ogg", ".wav"}
+
+// IsMedia 判断文件是否为音视频文件
+func IsMedia(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range Extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+var reMediaAttr = regexp.MustCompile(`(src|href)="/(asset/[^"?#]+)"`)
+
+// SignHTML 把HTML中指向 /asset 下音视频文件的地址替换为用户的签名地址
+func SignHTML(s, user string) string {
+	return reMediaAttr.ReplaceAllStringFunc(s, func(m string) string {
+		sub := reMediaAttr.FindStringSubmatch(m)
+		if !IsMedia(sub[2]) {
+			return m
+		}
+		url, _ := SignURL(sub[2], user)
+		return sub[1] + `="` + html.EscapeString(url) + `"`
+	})
+}
+
+// ETag 根据文件大小和修改时间生成ETag
+func ETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// ServeFile 发送媒体文件，支持Range、If-Range、If-None-Match等条件请求
+func ServeFile(w http.ResponseWriter, r *http.Request, filename string) error {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New("media file is a directory")
+	}
+	w.Header().Set("ETag", ETag(info))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), fp)
+	return nil
+}
@@ -0,0 +1,67 @@
+// @APIVersion 1.0.0
+// @Title 课程媒体服务
+// @Description 课程视频的签名地址、Range断点播放与带宽限制
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package media
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 签名地址的查询参数
+const (
+	ParamFile    = "p" // 媒体文件的相对路径
+	ParamUser    = "u" // 用户ID，用于按用户限速
+	ParamExpires = "e" // 过期时间（unix秒）
+	ParamSign    = "s" // 签名
+)
+
+var (
+	ErrSignInvalid = errors.New("media url signature invalid")
+	ErrSignExpired = errors.New("media url expired")
+)
+
+// Sign 生成带签名和有效期的查询参数
+func Sign(secret, file, user string, expires time.Time) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set(ParamFile, file)
+	q.Set(ParamUser, user)
+	q.Set(ParamExpires, exp)
+	q.Set(ParamSign, signature(secret, file, user, exp))
+	return q
+}
+
+// Verify 校验签名和有效期，返回媒体文件路径和用户ID
+func Verify(secret string, q url.Values, now time.Time) (file, user string, err error) {
+	file, user = q.Get(ParamFile), q.Get(ParamUser)
+	exp := q.Get(ParamExpires)
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || file == "" {
+		return "", "", ErrSignInvalid
+	}
+	want := signature(secret, file, user, exp)
+	if !hmac.Equal([]byte(want), []byte(q.Get(ParamSign))) {
+		return "", "", ErrSignInvalid
+	}
+	if now.Unix() > expires {
+		return "", "", ErrSignExpired
+	}
+	return file, user, nil
+}
+
+func signature(secret, file, user, exp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(file + "\n" + user + "\n" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
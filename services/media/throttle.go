@@ -0,0 +1,145 @@
+package media
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// chunkSize 限速写入时每次写入的字节数
+const chunkSize = 16 * 1024
+
+// Bucket 令牌桶，rate为每秒允许发送的字节数，突发量为1秒的流量
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket 创建令牌桶，rate<=0表示不限速，返回nil
+func NewBucket(rate int64) *Bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &Bucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// reserve 预留n个字节的令牌，返回需要等待的时间
+func (b *Bucket) reserve(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type userBucket struct {
+	bucket *Bucket
+	refs   int
+}
+
+// Throttler 带宽控制：所有连接共享总带宽，同一用户的多个连接共享用户带宽
+type Throttler struct {
+	total    *Bucket
+	userRate int64
+
+	mu    sync.Mutex
+	users map[string]*userBucket
+}
+
+// NewThrottler 创建带宽控制，totalRate和userRate单位为字节/秒，<=0表示不限速
+func NewThrottler(totalRate, userRate int64) *Throttler {
+	return &Throttler{
+		total:    NewBucket(totalRate),
+		userRate: userRate,
+		users:    make(map[string]*userBucket),
+	}
+}
+
+// Writer 返回按用户和总带宽限速的Writer，使用完毕必须调用release
+func (t *Throttler) Writer(w io.Writer, user string) (writer io.Writer, release func()) {
+	ub := t.acquire(user)
+	writer = &throttledWriter{w: w, buckets: []*Bucket{ub.bucket, t.total}}
+	return writer, func() { t.release(user) }
+}
+
+// ResponseWriter 返回限速的http.ResponseWriter，使用完毕必须调用release
+func (t *Throttler) ResponseWriter(w http.ResponseWriter, user string) (http.ResponseWriter, func()) {
+	writer, release := t.Writer(w, user)
+	return &throttledResponse{ResponseWriter: w, w: writer}, release
+}
+
+func (t *Throttler) acquire(user string) *userBucket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ub, ok := t.users[user]
+	if !ok {
+		ub = &userBucket{bucket: NewBucket(t.userRate)}
+		t.users[user] = ub
+	}
+	ub.refs++
+	return ub
+}
+
+func (t *Throttler) release(user string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ub, ok := t.users[user]; ok {
+		if ub.refs--; ub.refs <= 0 {
+			delete(t.users, user)
+		}
+	}
+}
+
+type throttledWriter struct {
+	w       io.Writer
+	buckets []*Bucket
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		var wait time.Duration
+		for _, b := range tw.buckets {
+			if d := b.reserve(n); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		m, err := tw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+type throttledResponse struct {
+	http.ResponseWriter
+	w io.Writer
+}
+
+func (tr *throttledResponse) Write(p []byte) (int, error) {
+	return tr.w.Write(p)
+}
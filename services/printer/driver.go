@@ -0,0 +1,104 @@
+package printer
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrDriver 打印机驱动不存在
+var ErrDriver = errors.New("printer: unknown driver")
+
+// ErrBusy 打印机正在打印
+var ErrBusy = errors.New("printer: busy")
+
+// 打印机状态
+const (
+	StateIdle     = "idle"     //空闲，可以开始新的打印
+	StatePrinting = "printing" //正在打印（含暂停）
+	StateError    = "error"    //打印机报错
+	StateOffline  = "offline"  //连接不上打印机
+)
+
+// Status 打印机当前状态，Job 为当前或最近一次打印的文件名，Progress 为0~100
+type Status struct {
+	State    string  `json:"state"`
+	Job      string  `json:"job"`
+	Progress float64 `json:"progress"`
+	Message  string  `json:"message"`
+}
+
+// Driver 打印机驱动
+type Driver interface {
+	// Print 上传模型文件并开始打印，name 为上传的文件名，返回实际打印的文件名（STL切片后为gcode）
+	Print(name string, data []byte) (string, error)
+	// Status 查询打印机状态
+	Status() (Status, error)
+	// Cancel 取消当前打印
+	Cancel() error
+}
+
+// Device 教室里的一台打印机
+type Device struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Driver  string `json:"driver"`  //驱动：octoprint、fake
+	URL     string `json:"url"`     //打印服务器地址
+	APIKey  string `json:"apiKey"`  //打印服务器的API密钥
+	Slicer  string `json:"slicer"`  //OctoPrint上用来切片STL的切片器
+	Profile string `json:"profile"` //打印参数的名称
+}
+
+var (
+	driversMu sync.Mutex
+	drivers   = map[string]func(d Device) (Driver, error){}
+)
+
+// Register 注册打印机驱动
+func Register(name string, open func(d Device) (Driver, error)) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[name] = open
+}
+
+// Open 按打印机配置的驱动连接打印机
+func Open(d Device) (Driver, error) {
+	driversMu.Lock()
+	open, ok := drivers[d.Driver]
+	driversMu.Unlock()
+	if !ok {
+		return nil, ErrDriver
+	}
+	return open(d)
+}
+
+// 正在打印的任务的结果
+const (
+	OutcomePrinting = iota //仍在打印
+	OutcomeDone            //打印完成
+	OutcomeFailed          //打印失败或被取消
+	OutcomeUnknown         //暂时无法判断，如打印机离线
+)
+
+// Judge 根据打印机状态判断正在打印的文件name的结果：打印机空闲且最近的文件是name时，进度100%为完成，
+// 否则是在打印机上被取消；打印机空闲但最近的文件不是name时无法判断，由调用方按超时处理
+func Judge(name string, st Status) int {
+	switch st.State {
+	case StatePrinting:
+		if st.Job == name {
+			return OutcomePrinting
+		}
+		return OutcomeFailed
+	case StateIdle:
+		if st.Job != name {
+			// 还在切片，或打印机上换了文件
+			return OutcomeUnknown
+		}
+		if st.Progress >= 100 {
+			return OutcomeDone
+		}
+		return OutcomeFailed
+	case StateError:
+		return OutcomeFailed
+	}
+	return OutcomeUnknown
+}
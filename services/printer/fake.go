@@ -0,0 +1,80 @@
+package printer
+
+import "sync"
+
+// Fake 模拟打印机，用于测试和没有打印机时演示打印队列。同一ID的打印机共用一个实例
+type Fake struct {
+	mu     sync.Mutex
+	status Status
+	Files  map[string][]byte //收到的文件
+}
+
+var (
+	fakesMu sync.Mutex
+	fakes   = map[string]*Fake{}
+)
+
+func init() {
+	Register("fake", func(d Device) (Driver, error) {
+		return FakePrinter(d.ID), nil
+	})
+}
+
+// FakePrinter 取得ID为id的模拟打印机
+func FakePrinter(id string) *Fake {
+	fakesMu.Lock()
+	defer fakesMu.Unlock()
+	f, ok := fakes[id]
+	if !ok {
+		f = &Fake{status: Status{State: StateIdle}, Files: make(map[string][]byte)}
+		fakes[id] = f
+	}
+	return f
+}
+
+// Print 开始打印
+func (f *Fake) Print(name string, data []byte) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status.State == StatePrinting {
+		return "", ErrBusy
+	}
+	f.Files[name] = data
+	f.status = Status{State: StatePrinting, Job: name}
+	return name, nil
+}
+
+// Status 当前状态
+func (f *Fake) Status() (Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status, nil
+}
+
+// Cancel 取消打印
+func (f *Fake) Cancel() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status.State == StatePrinting {
+		f.status.State = StateIdle
+	}
+	return nil
+}
+
+// SetProgress 模拟打印进度，达到100时打印完成
+func (f *Fake) SetProgress(progress float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status.Progress = progress
+	if progress >= 100 {
+		f.status.State = StateIdle
+	}
+}
+
+// Fail 模拟打印机报错
+func (f *Fake) Fail(message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status.State = StateError
+	f.status.Message = message
+}
@@ -0,0 +1,145 @@
+package printer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// OctoPrint 通过 OctoPrint 兼容的 REST 接口控制打印机。STL文件上传后用打印服务器上的切片器切片并打印
+type OctoPrint struct {
+	URL    string
+	APIKey string
+	Slicer string
+	Client *http.Client
+}
+
+func init() {
+	Register("octoprint", func(d Device) (Driver, error) {
+		if _, err := url.Parse(d.URL); err != nil || d.URL == "" {
+			return nil, fmt.Errorf("printer: invalid octoprint url %q", d.URL)
+		}
+		slicer := d.Slicer
+		if slicer == "" {
+			slicer = "curalegacy"
+		}
+		return &OctoPrint{URL: strings.TrimRight(d.URL, "/"), APIKey: d.APIKey, Slicer: slicer, Client: &http.Client{Timeout: 2 * time.Minute}}, nil
+	})
+}
+
+// Print 上传文件并开始打印，STL文件切片为同名的gcode后打印
+func (o *OctoPrint) Print(name string, data []byte) (string, error) {
+	st, err := o.Status()
+	if err != nil {
+		return "", err
+	}
+	if st.State == StatePrinting {
+		return "", ErrBusy
+	}
+	isSTL := strings.EqualFold(path.Ext(name), ".stl")
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		return "", err
+	}
+	part.Write(data)
+	if !isSTL {
+		w.WriteField("select", "true")
+		w.WriteField("print", "true")
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := o.do("POST", "/api/files/local", w.FormDataContentType(), &body, nil); err != nil {
+		return "", err
+	}
+	if !isSTL {
+		return name, nil
+	}
+	gcode := strings.TrimSuffix(name, path.Ext(name)) + ".gcode"
+	slice, _ := json.Marshal(map[string]interface{}{"command": "slice", "slicer": o.Slicer, "gcode": gcode, "select": true, "print": true})
+	if err := o.do("POST", "/api/files/local/"+url.PathEscape(name), "application/json", bytes.NewReader(slice), nil); err != nil {
+		return "", err
+	}
+	return gcode, nil
+}
+
+// Status 查询当前打印任务，连接不上时返回离线状态
+func (o *OctoPrint) Status() (Status, error) {
+	var job struct {
+		Job struct {
+			File struct {
+				Name string `json:"name"`
+			} `json:"file"`
+		} `json:"job"`
+		Progress struct {
+			Completion *float64 `json:"completion"`
+		} `json:"progress"`
+		State string `json:"state"`
+		Error string `json:"error"`
+	}
+	if err := o.do("GET", "/api/job", "", nil, &job); err != nil {
+		return Status{State: StateOffline, Message: err.Error()}, err
+	}
+	st := Status{Job: job.Job.File.Name, Message: job.State}
+	if job.Progress.Completion != nil {
+		st.Progress = *job.Progress.Completion
+	}
+	switch state := strings.ToLower(job.State); {
+	case strings.Contains(state, "error"):
+		st.State = StateError
+		if job.Error != "" {
+			st.Message = job.Error
+		}
+	case strings.HasPrefix(state, "offline"), strings.HasPrefix(state, "closed"), strings.HasPrefix(state, "opening"),
+		strings.HasPrefix(state, "connecting"), strings.HasPrefix(state, "detecting"):
+		st.State = StateOffline
+	case strings.HasPrefix(state, "operational"):
+		st.State = StateIdle
+	default:
+		// Printing、Paused、Pausing、Resuming、Finishing、Cancelling
+		st.State = StatePrinting
+	}
+	return st, nil
+}
+
+// Cancel 取消当前打印
+func (o *OctoPrint) Cancel() error {
+	return o.do("POST", "/api/job", "application/json", strings.NewReader(`{"command":"cancel"}`), nil)
+}
+
+func (o *OctoPrint) do(method, uri, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, o.URL+uri, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", o.APIKey)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("printer: %s %s: %s %s", method, uri, resp.Status, bytes.TrimSpace(data))
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
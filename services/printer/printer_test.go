@@ -0,0 +1,166 @@
+package printer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"maiyajia.com/services/stl"
+)
+
+func TestEstimate(t *testing.T) {
+	// 20mm的实心立方体
+	r := stl.Report{Size: stl.Vec3{X: 20, Y: 20, Z: 20}, Volume: 8000, Area: 2400}
+	e := DefaultProfile.Estimate(r)
+	if e.Layers != 100 || !e.FitsBed {
+		t.Errorf("estimate = %+v", e)
+	}
+	// 外壁 2400*2*0.4=1920，内部 (8000-1920)*0.2=1216，共3136立方毫米
+	if e.FilamentGrams != 3.9 || e.FilamentMeters != 1.3 {
+		t.Errorf("filament = %v g %v m", e.FilamentGrams, e.FilamentMeters)
+	}
+	if e.Minutes < 15 || e.Minutes > 30 {
+		t.Errorf("minutes = %v", e.Minutes)
+	}
+	// 水平旋转后能放下
+	p := DefaultProfile
+	p.BedX, p.BedY = 100, 300
+	if !p.Estimate(stl.Report{Size: stl.Vec3{X: 250, Y: 50, Z: 10}}).FitsBed {
+		t.Error("rotated model does not fit")
+	}
+	if p.Estimate(stl.Report{Size: stl.Vec3{X: 250, Y: 50, Z: 300}}).FitsBed {
+		t.Error("tall model fits")
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "printer")
+	defer os.RemoveAll(dir)
+	profiles, err := LoadProfiles(filepath.Join(dir, "none.json"))
+	if err != nil || len(profiles) != 1 || profiles[0].Name != DefaultProfile.Name {
+		t.Fatalf("profiles = %v, err = %v", profiles, err)
+	}
+	filename := filepath.Join(dir, "printers.json")
+	ioutil.WriteFile(filename, []byte(`{"profiles": [{"name": "x", "bedX": 100}]}`), 0644)
+	if _, err := LoadProfiles(filename); err != ErrProfile {
+		t.Errorf("err = %v", err)
+	}
+	ioutil.WriteFile(filename, []byte(`{"profiles": [{"name": "标准", "bedX": 220, "bedY": 220, "bedZ": 250, "nozzle": 0.4,
+		"layerHeight": 0.2, "walls": 2, "infill": 0.2, "speed": 50, "filamentDiameter": 1.75, "filamentDensity": 1.24}],
+		"printers": [{"id": "p1", "name": "一号机", "driver": "fake", "profile": "标准"}]}`), 0644)
+	c, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := c.Printer("p1"); !ok || d.Profile != "标准" {
+		t.Errorf("printer = %+v", d)
+	}
+}
+
+func TestFake(t *testing.T) {
+	d, err := Open(Device{ID: "test", Driver: "fake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := d.Print("a.stl", []byte("solid"))
+	if err != nil || name != "a.stl" {
+		t.Fatalf("name = %q err = %v", name, err)
+	}
+	if _, err := d.Print("b.stl", nil); err != ErrBusy {
+		t.Errorf("err = %v", err)
+	}
+	fake := FakePrinter("test")
+	fake.SetProgress(50)
+	st, _ := d.Status()
+	if Judge(name, st) != OutcomePrinting || st.Progress != 50 {
+		t.Errorf("status = %+v", st)
+	}
+	fake.SetProgress(100)
+	st, _ = d.Status()
+	if Judge(name, st) != OutcomeDone {
+		t.Errorf("status = %+v", st)
+	}
+	d.Print("c.stl", nil)
+	fake.Fail("热床温度异常")
+	st, _ = d.Status()
+	if Judge("c.stl", st) != OutcomeFailed || st.Message != "热床温度异常" {
+		t.Errorf("status = %+v", st)
+	}
+	if Judge("d.stl", Status{State: StateIdle, Job: "c.stl"}) != OutcomeUnknown {
+		t.Error("other file on idle printer is not unknown")
+	}
+	if _, err := Open(Device{Driver: "usb"}); err != ErrDriver {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestOctoPrint(t *testing.T) {
+	var uploaded, sliced string
+	state, completion := "Operational", "null"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/job":
+			fmt.Fprintf(w, `{"job": {"file": {"name": %q}}, "progress": {"completion": %s}, "state": %q}`, sliced, completion, state)
+		case r.Method == "POST" && r.URL.Path == "/api/files/local":
+			f, h, err := r.FormFile("file")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			f.Close()
+			uploaded = h.Filename
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "POST" && r.URL.Path == "/api/files/local/"+uploaded:
+			var cmd map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&cmd)
+			if cmd["command"] != "slice" || cmd["print"] != true {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sliced = cmd["gcode"].(string)
+			state, completion = "Printing", "0"
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == "POST" && r.URL.Path == "/api/job":
+			state = "Operational"
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	d, err := Open(Device{ID: "octo", Driver: "octoprint", URL: srv.URL + "/", APIKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := d.Print("job1.stl", []byte("solid x"))
+	if err != nil || name != "job1.gcode" || uploaded != "job1.stl" {
+		t.Fatalf("name = %q uploaded = %q err = %v", name, uploaded, err)
+	}
+	st, err := d.Status()
+	if err != nil || st.State != StatePrinting || Judge(name, st) != OutcomePrinting {
+		t.Errorf("status = %+v err = %v", st, err)
+	}
+	if _, err := d.Print("job2.stl", nil); err != ErrBusy {
+		t.Errorf("err = %v", err)
+	}
+	completion = "37.5"
+	d.Cancel()
+	st, _ = d.Status()
+	if st.State != StateIdle || Judge(name, st) != OutcomeFailed {
+		t.Errorf("status = %+v", st)
+	}
+	bad, _ := Open(Device{ID: "octo", Driver: "octoprint", URL: srv.URL, APIKey: "wrong"})
+	if st, err := bad.Status(); err == nil || st.State != StateOffline {
+		t.Errorf("status = %+v err = %v", st, err)
+	}
+}
@@ -0,0 +1,133 @@
+// Package printer 3D打印机配置，按配置估算模型的打印时间和耗材用量
+package printer
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+
+	"maiyajia.com/services/stl"
+)
+
+// ErrProfile 打印机配置有误：参数不完整，或打印机的ID重复、打印参数不存在
+var ErrProfile = errors.New("printer: invalid profile")
+
+// Profile 打印机配置，长度单位为毫米
+type Profile struct {
+	Name             string  `json:"name"`
+	BedX             float64 `json:"bedX"` //打印尺寸
+	BedY             float64 `json:"bedY"`
+	BedZ             float64 `json:"bedZ"`
+	Nozzle           float64 `json:"nozzle"`           //喷嘴直径
+	LayerHeight      float64 `json:"layerHeight"`      //层高
+	Walls            int     `json:"walls"`            //外壁层数
+	Infill           float64 `json:"infill"`           //填充率，0~1
+	Speed            float64 `json:"speed"`            //打印速度，毫米/秒
+	LayerSeconds     float64 `json:"layerSeconds"`     //每层换层的时间，秒
+	Overhead         float64 `json:"overhead"`         //空驶、加减速等额外时间占打印时间的比例
+	FilamentDiameter float64 `json:"filamentDiameter"` //耗材直径
+	FilamentDensity  float64 `json:"filamentDensity"`  //耗材密度，克/立方厘米
+}
+
+// DefaultProfile 没有配置文件时使用的打印机配置，常见的220mm桌面打印机和PLA耗材
+var DefaultProfile = Profile{
+	Name: "默认打印机", BedX: 220, BedY: 220, BedZ: 250,
+	Nozzle: 0.4, LayerHeight: 0.2, Walls: 2, Infill: 0.2, Speed: 50,
+	LayerSeconds: 2, Overhead: 0.3, FilamentDiameter: 1.75, FilamentDensity: 1.24,
+}
+
+// Estimate 模型在某台打印机上的估算结果
+type Estimate struct {
+	Profile        string  `bson:"profile" json:"profile"`
+	Minutes        float64 `bson:"minutes" json:"minutes"`
+	Layers         int     `bson:"layers" json:"layers"`
+	FilamentMeters float64 `bson:"filamentMeters" json:"filamentMeters"`
+	FilamentGrams  float64 `bson:"filamentGrams" json:"filamentGrams"`
+	FitsBed        bool    `bson:"fitsBed" json:"fitsBed"` //模型尺寸不超过打印尺寸（允许水平旋转90度）
+}
+
+// Valid 检查配置的各项参数
+func (p Profile) Valid() bool {
+	return p.Name != "" && p.BedX > 0 && p.BedY > 0 && p.BedZ > 0 && p.Nozzle > 0 && p.LayerHeight > 0 &&
+		p.Walls > 0 && p.Infill >= 0 && p.Infill <= 1 && p.Speed > 0 && p.LayerSeconds >= 0 && p.Overhead >= 0 &&
+		p.FilamentDiameter > 0 && p.FilamentDensity > 0
+}
+
+// Estimate 估算打印时间和耗材：外壁按表面积乘以壁厚，内部按填充率，挤出量除以喷嘴每秒挤出的体积得到打印时间
+func (p Profile) Estimate(r stl.Report) Estimate {
+	shell := math.Min(r.Volume, r.Area*float64(p.Walls)*p.Nozzle)
+	material := shell + (r.Volume-shell)*p.Infill
+	layers := int(math.Ceil(r.Size.Z / p.LayerHeight))
+	seconds := material/(p.Nozzle*p.LayerHeight*p.Speed)*(1+p.Overhead) + float64(layers)*p.LayerSeconds
+	section := math.Pi * p.FilamentDiameter * p.FilamentDiameter / 4
+	return Estimate{
+		Profile:        p.Name,
+		Minutes:        math.Round(seconds/60*10) / 10,
+		Layers:         layers,
+		FilamentMeters: math.Round(material/section/1000*100) / 100,
+		FilamentGrams:  math.Round(material/1000*p.FilamentDensity*10) / 10,
+		FitsBed: r.Size.Z <= p.BedZ && ((r.Size.X <= p.BedX && r.Size.Y <= p.BedY) ||
+			(r.Size.Y <= p.BedX && r.Size.X <= p.BedY)),
+	}
+}
+
+// Config 打印机配置文件：打印参数和教室里的打印机
+type Config struct {
+	Profiles []Profile `json:"profiles"`
+	Printers []Device  `json:"printers"`
+}
+
+// Profile 按名称查找打印参数
+func (c Config) Profile(name string) (Profile, bool) {
+	for _, p := range c.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Printer 按ID查找打印机
+func (c Config) Printer(id string) (Device, bool) {
+	for _, d := range c.Printers {
+		if d.ID == id {
+			return d, true
+		}
+	}
+	return Device{}, false
+}
+
+// LoadConfig 读取JSON格式的打印机配置文件，文件不存在时只有默认打印参数、没有打印机
+func LoadConfig(filename string) (Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Config{Profiles: []Profile{DefaultProfile}}, nil
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Config{}, err
+	}
+	if len(c.Profiles) == 0 {
+		return Config{}, ErrProfile
+	}
+	for _, p := range c.Profiles {
+		if !p.Valid() {
+			return Config{}, ErrProfile
+		}
+	}
+	ids := make(map[string]bool)
+	for _, d := range c.Printers {
+		if _, ok := c.Profile(d.Profile); !ok || d.ID == "" || ids[d.ID] {
+			return Config{}, ErrProfile
+		}
+		ids[d.ID] = true
+	}
+	return c, nil
+}
+
+// LoadProfiles 读取配置文件中的打印参数
+func LoadProfiles(filename string) ([]Profile, error) {
+	c, err := LoadConfig(filename)
+	return c.Profiles, err
+}
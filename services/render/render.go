@@ -0,0 +1,218 @@
+// Package render 用纯Go软件光栅化把STL模型渲染成带光照的预览图，不需要GPU。
+// 采用正交投影和深度缓冲，按面片法向做漫反射着色，2倍超采样抗锯齿
+package render
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"maiyajia.com/services/stl"
+)
+
+// ErrEmpty 模型没有面片
+var ErrEmpty = errors.New("render: empty mesh")
+
+// 超采样倍数
+const supersample = 2
+
+// View 观察角度，Yaw为绕Z轴的水平角，Pitch为仰角，单位为度。Yaw、Pitch为0时从-Y方向看向模型正面
+type View struct {
+	Name  string
+	Yaw   float64
+	Pitch float64
+}
+
+// Views 预览图使用的标准视角
+var Views = []View{
+	{Name: "iso", Yaw: 35, Pitch: 30},
+	{Name: "front", Yaw: 0, Pitch: 0},
+	{Name: "right", Yaw: 90, Pitch: 0},
+	{Name: "top", Yaw: 0, Pitch: 90},
+}
+
+var (
+	// Background 背景色
+	Background = color.RGBA{245, 246, 248, 255}
+	// ModelColor 模型颜色
+	ModelColor = color.RGBA{86, 156, 230, 255}
+)
+
+const (
+	ambient = 0.35 //环境光
+	diffuse = 0.65 //漫反射光
+)
+
+// Render 从一个视角渲染size×size的图片，模型缩放到图片中间
+func Render(m *stl.Mesh, view View, size int) (*image.RGBA, error) {
+	if len(m.Triangles) == 0 {
+		return nil, ErrEmpty
+	}
+	cam := newCamera(m, view)
+	w := size * supersample
+	scale := float64(w) / 2 * 0.9 / cam.radius
+	depth := make([]float64, w*w)
+	for i := range depth {
+		depth[i] = math.Inf(-1)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, w))
+	fill(img, Background)
+
+	var p [3]point
+	for _, t := range m.Triangles {
+		for i, v := range t.V {
+			d := v.Sub(cam.center)
+			p[i] = point{
+				x: float64(w)/2 + d.Dot(cam.right)*scale,
+				y: float64(w)/2 - d.Dot(cam.up)*scale,
+				z: d.Dot(cam.eye),
+			}
+		}
+		rasterize(img, depth, p, shade(t, cam))
+	}
+	return downsample(img, size), nil
+}
+
+// Thumbnail 把标准视角的渲染结果拼成2×2的size×size预览图
+func Thumbnail(m *stl.Mesh, size int) (*image.RGBA, error) {
+	tile := size / 2
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	fill(img, Background)
+	for i, view := range Views {
+		part, err := Render(m, view, tile)
+		if err != nil {
+			return nil, err
+		}
+		x, y := i%2*tile, i/2*tile
+		for py := 0; py < tile; py++ {
+			copy(img.Pix[img.PixOffset(x, y+py):img.PixOffset(x+tile, y+py)], part.Pix[part.PixOffset(0, py):part.PixOffset(tile, py)])
+		}
+	}
+	return img, nil
+}
+
+// camera 正交相机：eye 指向相机，right、up 为屏幕的横轴和纵轴
+type camera struct {
+	center, eye, right, up, light stl.Vec3
+	radius                        float64
+}
+
+func newCamera(m *stl.Mesh, view View) camera {
+	min, max := m.Bounds()
+	c := camera{center: min.Add(max).Scale(0.5)}
+	for _, t := range m.Triangles {
+		for _, v := range t.V {
+			c.radius = math.Max(c.radius, v.Sub(c.center).Len())
+		}
+	}
+	if c.radius == 0 {
+		c.radius = 1
+	}
+	yaw, pitch := view.Yaw*math.Pi/180, view.Pitch*math.Pi/180
+	c.eye = stl.Vec3{X: math.Cos(pitch) * math.Sin(yaw), Y: -math.Cos(pitch) * math.Cos(yaw), Z: math.Sin(pitch)}
+	forward := c.eye.Scale(-1)
+	c.right = forward.Cross(stl.Vec3{Z: 1})
+	if c.right.Len() < 1e-6 {
+		// 从正上方或正下方看
+		c.right = stl.Vec3{X: math.Cos(yaw), Y: math.Sin(yaw)}
+	}
+	c.right = c.right.Normalize()
+	c.up = c.right.Cross(forward).Normalize()
+	// 光源在相机左上方
+	c.light = c.eye.Scale(0.8).Add(c.up.Scale(0.5)).Sub(c.right.Scale(0.3)).Normalize()
+	return c
+}
+
+// shade 按面片法向计算颜色，文件中的法向可能不可靠，用顶点重新计算；背面按正面着色
+func shade(t stl.Triangle, cam camera) color.RGBA {
+	n := t.V[1].Sub(t.V[0]).Cross(t.V[2].Sub(t.V[0])).Normalize()
+	if n.Dot(cam.eye) < 0 {
+		n = n.Scale(-1)
+	}
+	k := ambient + diffuse*math.Max(0, n.Dot(cam.light))
+	return color.RGBA{
+		R: uint8(math.Min(255, float64(ModelColor.R)*k)),
+		G: uint8(math.Min(255, float64(ModelColor.G)*k)),
+		B: uint8(math.Min(255, float64(ModelColor.B)*k)),
+		A: 255,
+	}
+}
+
+type point struct {
+	x, y, z float64
+}
+
+func edge(a, b point, x, y float64) float64 {
+	return (b.x-a.x)*(y-a.y) - (b.y-a.y)*(x-a.x)
+}
+
+// rasterize 填充三角形覆盖的像素，深度比已有像素更靠近相机时才写入
+func rasterize(img *image.RGBA, depth []float64, p [3]point, c color.RGBA) {
+	w := img.Rect.Dx()
+	area := edge(p[0], p[1], p[2].x, p[2].y)
+	if math.Abs(area) < 1e-12 {
+		return
+	}
+	x0 := clamp(int(math.Floor(math.Min(p[0].x, math.Min(p[1].x, p[2].x)))), w)
+	x1 := clamp(int(math.Ceil(math.Max(p[0].x, math.Max(p[1].x, p[2].x)))), w)
+	y0 := clamp(int(math.Floor(math.Min(p[0].y, math.Min(p[1].y, p[2].y)))), w)
+	y1 := clamp(int(math.Ceil(math.Max(p[0].y, math.Max(p[1].y, p[2].y)))), w)
+	for y := y0; y <= y1; y++ {
+		py := float64(y) + 0.5
+		for x := x0; x <= x1; x++ {
+			px := float64(x) + 0.5
+			b0 := edge(p[1], p[2], px, py) / area
+			b1 := edge(p[2], p[0], px, py) / area
+			b2 := edge(p[0], p[1], px, py) / area
+			if b0 < 0 || b1 < 0 || b2 < 0 {
+				continue
+			}
+			z := b0*p[0].z + b1*p[1].z + b2*p[2].z
+			if i := y*w + x; z > depth[i] {
+				depth[i] = z
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+func clamp(v, w int) int {
+	if v < 0 {
+		return 0
+	}
+	if v >= w {
+		return w - 1
+	}
+	return v
+}
+
+func fill(img *image.RGBA, c color.RGBA) {
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+}
+
+// downsample 把超采样的图片按块平均缩小到size×size
+func downsample(src *image.RGBA, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	const n = supersample * supersample
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var sum [4]int
+			for dy := 0; dy < supersample; dy++ {
+				for dx := 0; dx < supersample; dx++ {
+					i := src.PixOffset(x*supersample+dx, y*supersample+dy)
+					for k := 0; k < 4; k++ {
+						sum[k] += int(src.Pix[i+k])
+					}
+				}
+			}
+			i := dst.PixOffset(x, y)
+			for k := 0; k < 4; k++ {
+				dst.Pix[i+k] = uint8(sum[k] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,64 @@
+package render
+
+import (
+	"testing"
+
+	"maiyajia.com/services/stl"
+)
+
+// cube 边长为1的立方体，面片朝外
+func cube() *stl.Mesh {
+	v := func(x, y, z float64) stl.Vec3 { return stl.Vec3{X: x, Y: y, Z: z} }
+	quads := [][4]stl.Vec3{
+		{v(0, 0, 0), v(0, 1, 0), v(1, 1, 0), v(1, 0, 0)},
+		{v(0, 0, 1), v(1, 0, 1), v(1, 1, 1), v(0, 1, 1)},
+		{v(0, 0, 0), v(1, 0, 0), v(1, 0, 1), v(0, 0, 1)},
+		{v(0, 1, 0), v(0, 1, 1), v(1, 1, 1), v(1, 1, 0)},
+		{v(0, 0, 0), v(0, 0, 1), v(0, 1, 1), v(0, 1, 0)},
+		{v(1, 0, 0), v(1, 1, 0), v(1, 1, 1), v(1, 0, 1)},
+	}
+	m := &stl.Mesh{}
+	for _, q := range quads {
+		m.Triangles = append(m.Triangles, stl.Triangle{V: [3]stl.Vec3{q[0], q[1], q[2]}}, stl.Triangle{V: [3]stl.Vec3{q[0], q[2], q[3]}})
+	}
+	return m
+}
+
+func TestRender(t *testing.T) {
+	for _, view := range Views {
+		img, err := Render(cube(), view, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := img.RGBAAt(32, 32); c == Background {
+			t.Errorf("%s: center is background", view.Name)
+		}
+		if c := img.RGBAAt(0, 0); c != Background {
+			t.Errorf("%s: corner = %v", view.Name, c)
+		}
+	}
+	// 正面朝向光源，比侧面亮
+	front, _ := Render(cube(), View{Yaw: 0, Pitch: 0}, 64)
+	iso, _ := Render(cube(), Views[0], 64)
+	if front.RGBAAt(32, 32).B <= ModelColor.B/2 || iso.Bounds().Dx() != 64 {
+		t.Errorf("front = %v", front.RGBAAt(32, 32))
+	}
+	if _, err := Render(&stl.Mesh{}, Views[0], 64); err != ErrEmpty {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	img, err := Thumbnail(cube(), 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 128 || img.Bounds().Dy() != 128 {
+		t.Fatalf("bounds = %v", img.Bounds())
+	}
+	for _, p := range [][2]int{{32, 32}, {96, 32}, {32, 96}, {96, 96}} {
+		if img.RGBAAt(p[0], p[1]) == Background {
+			t.Errorf("tile at %v is empty", p)
+		}
+	}
+}
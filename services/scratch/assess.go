@@ -0,0 +1,246 @@
+package scratch
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 计算思维维度，参照 Dr. Scratch，每个维度0~3分
+const (
+	Abstraction        = "abstraction"
+	Parallelism        = "parallelism"
+	Logic              = "logic"
+	Synchronization    = "synchronization"
+	FlowControl        = "flowControl"
+	UserInteractivity  = "userInteractivity"
+	DataRepresentation = "dataRepresentation"
+)
+
+// 总分对应的水平
+const (
+	LevelBasic      = "basic"      //0~7分
+	LevelDeveloping = "developing" //8~14分
+	LevelProficient = "proficient" //15~21分
+)
+
+var dimensionNames = []struct{ key, name string }{
+	{Abstraction, "抽象与问题分解"},
+	{Parallelism, "并行"},
+	{Logic, "逻辑"},
+	{Synchronization, "同步"},
+	{FlowControl, "流程控制"},
+	{UserInteractivity, "用户交互"},
+	{DataRepresentation, "数据表示"},
+}
+
+// ErrCheck 检查项设置有误
+var ErrCheck = errors.New("scratch: invalid check")
+
+// 检查项类型
+const (
+	CheckBlock     = "block"     //使用了指定的积木，Target为opcode
+	CheckMetric    = "metric"    //作品统计项达到数量，Target为统计项
+	CheckDimension = "dimension" //计算思维维度达到分数，Target为维度
+)
+
+// Check 老师为课时设置的检查项，如“使用了循环”：{block, control_repeat|control_forever|control_repeat_until, 1}
+type Check struct {
+	Label  string `bson:"label" json:"label"`
+	Kind   string `bson:"kind" json:"kind"`
+	Target string `bson:"target" json:"target"`
+	Min    int    `bson:"min" json:"min"`
+}
+
+// CheckResult 检查项的结果
+type CheckResult struct {
+	Check  `bson:",inline"`
+	Actual int  `bson:"actual" json:"actual"`
+	Passed bool `bson:"passed" json:"passed"`
+}
+
+// Dimension 一个维度的得分
+type Dimension struct {
+	Key   string `bson:"key" json:"key"`
+	Name  string `bson:"name" json:"name"`
+	Score int    `bson:"score" json:"score"`
+}
+
+// Assessment 作品的评估结果
+type Assessment struct {
+	Dimensions []Dimension    `bson:"dimensions" json:"dimensions"`
+	Total      int            `bson:"total" json:"total"`
+	Level      string         `bson:"level" json:"level"`
+	Metrics    map[string]int `bson:"metrics" json:"metrics"`
+	Checks     []CheckResult  `bson:"checks" json:"checks"`
+	Passed     int            `bson:"passed" json:"passed"` //通过的检查项数
+}
+
+// Assess 评估作品，并逐项检查课时的检查项
+func Assess(p *Project, checks []*Check) *Assessment {
+	a := &Assessment{Metrics: p.Metrics(), Checks: []CheckResult{}}
+	scores := p.dimensions()
+	for _, d := range dimensionNames {
+		a.Dimensions = append(a.Dimensions, Dimension{Key: d.key, Name: d.name, Score: scores[d.key]})
+		a.Total += scores[d.key]
+	}
+	switch {
+	case a.Total >= 15:
+		a.Level = LevelProficient
+	case a.Total >= 8:
+		a.Level = LevelDeveloping
+	default:
+		a.Level = LevelBasic
+	}
+	for _, c := range checks {
+		r := CheckResult{Check: *c}
+		switch c.Kind {
+		case CheckBlock:
+			r.Actual = p.Count(c.Target)
+		case CheckMetric:
+			r.Actual = a.Metrics[c.Target]
+		case CheckDimension:
+			r.Actual = scores[c.Target]
+		}
+		r.Passed = r.Actual >= c.Min
+		if r.Passed {
+			a.Passed++
+		}
+		a.Checks = append(a.Checks, r)
+	}
+	return a
+}
+
+// ValidateChecks 校验检查项
+func ValidateChecks(checks []*Check) error {
+	metrics := map[string]bool{
+		MetricSprites: true, MetricScripts: true, MetricBlocks: true, MetricVariables: true, MetricLists: true,
+		MetricBroadcasts: true, MetricCostumes: true, MetricSounds: true, MetricCustomBlocks: true, MetricClones: true,
+	}
+	for i, c := range checks {
+		if c == nil || c.Label == "" || c.Target == "" || c.Min < 1 {
+			return fmt.Errorf("%v: check %d", ErrCheck, i+1)
+		}
+		switch c.Kind {
+		case CheckBlock:
+		case CheckMetric:
+			if !metrics[c.Target] {
+				return fmt.Errorf("%v: check %d unknown metric %q", ErrCheck, i+1, c.Target)
+			}
+		case CheckDimension:
+			if !validDimension(c.Target) || c.Min > 3 {
+				return fmt.Errorf("%v: check %d unknown dimension %q", ErrCheck, i+1, c.Target)
+			}
+		default:
+			return fmt.Errorf("%v: check %d unknown kind %q", ErrCheck, i+1, c.Kind)
+		}
+	}
+	return nil
+}
+
+func validDimension(key string) bool {
+	for _, d := range dimensionNames {
+		if d.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// dimensions 计算各维度得分，每个维度取满足的最高一级
+func (p *Project) dimensions() map[string]int {
+	has := func(opcode string) bool { return p.Count(opcode) > 0 }
+	level := func(conds ...bool) int {
+		for i := len(conds) - 1; i >= 0; i-- {
+			if conds[i] {
+				return i + 1
+			}
+		}
+		return 0
+	}
+	scripts, sequence := 0, false
+	for _, t := range p.Targets {
+		for _, s := range t.Scripts() {
+			scripts++
+			if s.Next != nil {
+				sequence = true
+			}
+		}
+	}
+	hats := p.hatCounts()
+	twice := func(opcodes ...string) bool {
+		for key, n := range hats {
+			for _, op := range opcodes {
+				if key.opcode == op && n >= 2 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return map[string]int{
+		Abstraction: level(
+			scripts > 1 && p.Sprites() > 1,
+			has("procedures_definition"),
+			has("control_start_as_clone"),
+		),
+		Parallelism: level(
+			twice("event_whenflagclicked"),
+			twice("event_whenkeypressed", "event_whenthisspriteclicked", "event_whenstageclicked"),
+			twice("event_whenbroadcastreceived", "control_start_as_clone", "event_whenbackdropswitchesto", "event_whengreaterthan", "videoSensing_whenMotionGreaterThan"),
+		),
+		Logic: level(
+			has("control_if"),
+			has("control_if_else"),
+			has("operator_and|operator_or|operator_not"),
+		),
+		Synchronization: level(
+			has("control_wait"),
+			has("event_broadcast|event_whenbroadcastreceived|control_stop"),
+			has("control_wait_until|event_whenbackdropswitchesto|event_broadcastandwait"),
+		),
+		FlowControl: level(
+			sequence,
+			has("control_repeat|control_forever"),
+			has("control_repeat_until"),
+		),
+		UserInteractivity: level(
+			has("event_whenflagclicked"),
+			has("event_whenkeypressed|event_whenthisspriteclicked|event_whenstageclicked|sensing_askandwait|sensing_keypressed|sensing_mousedown|sensing_mousex|sensing_mousey"),
+			has("videoSensing_*|sensing_loudness|event_whengreaterthan"),
+		),
+		DataRepresentation: level(
+			has("motion_movesteps|motion_gotoxy|motion_goto|motion_glideto|motion_glidesecstoxy|motion_turnright|motion_turnleft|motion_pointindirection|motion_changexby|motion_setx|motion_changeyby|motion_sety|looks_switchcostumeto|looks_nextcostume|looks_changesizeby|looks_setsizeto|looks_show|looks_hide|looks_changeeffectby|looks_seteffectto"),
+			has("data_setvariableto|data_changevariableby|data_showvariable|data_hidevariable"),
+			has("data_addtolist|data_deleteoflist|data_deletealloflist|data_insertatlist|data_replaceitemoflist|data_itemoflist|data_lengthoflist|data_listcontainsitem"),
+		),
+	}
+}
+
+type hatKey struct {
+	target *Target
+	opcode string
+	option string
+}
+
+// hatCounts 统计同一角色中由同一事件启动的脚本数，用于判断并行
+func (p *Project) hatCounts() map[hatKey]int {
+	counts := make(map[hatKey]int)
+	for _, t := range p.Targets {
+		for _, s := range t.Scripts() {
+			key := hatKey{opcode: s.Opcode}
+			switch s.Opcode {
+			case "event_whenflagclicked", "event_whenbroadcastreceived":
+				// 绿旗和广播在整个作品范围内并行
+			default:
+				key.target = t
+			}
+			for _, name := range []string{"KEY_OPTION", "BROADCAST_OPTION", "BACKDROP", "WHENGREATERTHANMENU"} {
+				if v := s.Field(name); v != "" {
+					key.option = v
+				}
+			}
+			counts[key]++
+		}
+	}
+	return counts
+}
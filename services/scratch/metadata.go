@@ -0,0 +1,64 @@
+package scratch
+
+import (
+	"sort"
+	"strings"
+)
+
+// 内置积木分类，其余的opcode前缀为扩展
+var coreCategories = map[string]bool{
+	"motion": true, "looks": true, "sound": true, "event": true, "control": true,
+	"sensing": true, "operators": true, "data": true, "procedures": true, "argument": true,
+}
+
+// Metadata 作品的元数据，保存在作品上用于作品列表筛选和统计
+type Metadata struct {
+	Sprites        int            `bson:"sprites" json:"sprites"`
+	Blocks         int            `bson:"blocks" json:"blocks"`
+	Categories     map[string]int `bson:"categories" json:"categories"` //各分类的积木数，如 motion、looks、pen
+	Extensions     []string       `bson:"extensions" json:"extensions"` //使用的扩展，如 pen、music
+	Costumes       int            `bson:"costumes" json:"costumes"`
+	Sounds         int            `bson:"sounds" json:"sounds"`
+	CloudVariables bool           `bson:"cloudVariables" json:"cloudVariables"`
+}
+
+// Metadata 提取作品的元数据。扩展取 project.json 声明的扩展和积木实际用到的扩展的并集
+func (p *Project) Metadata() *Metadata {
+	meta := &Metadata{Sprites: p.Sprites(), Categories: make(map[string]int)}
+	extensions := make(map[string]bool)
+	for _, ext := range p.Extensions {
+		extensions[ext] = true
+	}
+	for _, t := range p.Targets {
+		meta.Costumes += t.Costumes
+		meta.Sounds += t.Sounds
+		if t.Cloud > 0 {
+			meta.CloudVariables = true
+		}
+		for _, b := range t.Blocks {
+			if b.Shadow {
+				continue
+			}
+			category := BlockCategory(b.Opcode)
+			meta.Blocks++
+			meta.Categories[category]++
+			if !coreCategories[category] {
+				extensions[category] = true
+			}
+		}
+	}
+	meta.Extensions = make([]string, 0, len(extensions))
+	for ext := range extensions {
+		meta.Extensions = append(meta.Extensions, ext)
+	}
+	sort.Strings(meta.Extensions)
+	return meta
+}
+
+// BlockCategory 积木所属的分类，即opcode下划线前的部分，如 motion_movesteps 为 motion
+func BlockCategory(opcode string) string {
+	if i := strings.Index(opcode, "_"); i > 0 {
+		return opcode[:i]
+	}
+	return opcode
+}
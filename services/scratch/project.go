@@ -0,0 +1,241 @@
+// Package scratch 解析Scratch 3作品(sb3)，统计积木并按 Dr. Scratch 的计算思维维度评分
+package scratch
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ErrProject 不是有效的Scratch 3作品
+var ErrProject = errors.New("scratch: invalid sb3 project")
+
+// Block 一个积木
+type Block struct {
+	ID       string
+	Opcode   string                     `json:"opcode"`
+	Next     *string                    `json:"next"`
+	Parent   *string                    `json:"parent"`
+	Inputs   map[string]json.RawMessage `json:"inputs"`
+	Fields   map[string][]interface{}   `json:"fields"`
+	Shadow   bool                       `json:"shadow"`
+	TopLevel bool                       `json:"topLevel"`
+}
+
+// Field 积木字段的值，如按键名、广播名
+func (b *Block) Field(name string) string {
+	if v := b.Fields[name]; len(v) > 0 {
+		if s, ok := v[0].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// Input 输入连接的积木ID，如循环的 SUBSTACK，没有时返回空
+func (b *Block) Input(name string) string {
+	var input []interface{}
+	if err := json.Unmarshal(b.Inputs[name], &input); err != nil || len(input) < 2 {
+		return ""
+	}
+	id, _ := input[1].(string)
+	return id
+}
+
+// Target 舞台或角色
+type Target struct {
+	Name       string
+	IsStage    bool
+	Blocks     map[string]*Block
+	Variables  int
+	Lists      int
+	Broadcasts int
+	Costumes   int
+	Sounds     int
+	Cloud      int //云变量数
+}
+
+// Scripts 顶层积木，即每个脚本的第一块
+func (t *Target) Scripts() []*Block {
+	var scripts []*Block
+	for _, b := range t.Blocks {
+		if b.TopLevel && !b.Shadow {
+			scripts = append(scripts, b)
+		}
+	}
+	return scripts
+}
+
+// Project 解析后的作品
+type Project struct {
+	Targets    []*Target
+	Extensions []string
+}
+
+// Parse 解析 project.json
+func Parse(data []byte) (*Project, error) {
+	var raw struct {
+		Targets []struct {
+			Name       string                     `json:"name"`
+			IsStage    bool                       `json:"isStage"`
+			Blocks     map[string]json.RawMessage `json:"blocks"`
+			Variables  map[string]json.RawMessage `json:"variables"`
+			Lists      map[string]json.RawMessage `json:"lists"`
+			Broadcasts map[string]string          `json:"broadcasts"`
+			Costumes   []json.RawMessage          `json:"costumes"`
+			Sounds     []json.RawMessage          `json:"sounds"`
+		} `json:"targets"`
+		Extensions []string `json:"extensions"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw.Targets) == 0 {
+		return nil, ErrProject
+	}
+	p := &Project{Extensions: raw.Extensions}
+	for _, rt := range raw.Targets {
+		t := &Target{
+			Name:       rt.Name,
+			IsStage:    rt.IsStage,
+			Blocks:     make(map[string]*Block, len(rt.Blocks)),
+			Variables:  len(rt.Variables),
+			Lists:      len(rt.Lists),
+			Broadcasts: len(rt.Broadcasts),
+			Costumes:   len(rt.Costumes),
+			Sounds:     len(rt.Sounds),
+		}
+		// 变量为 [名称, 值] ，云变量多一项 true
+		for _, data := range rt.Variables {
+			var v []interface{}
+			if json.Unmarshal(data, &v) == nil && len(v) >= 3 && v[2] == true {
+				t.Cloud++
+			}
+		}
+		for id, data := range rt.Blocks {
+			// 顶层的变量和列表积木以数组形式保存，不是积木对象
+			if len(data) == 0 || data[0] != '{' {
+				continue
+			}
+			b := &Block{ID: id}
+			if err := json.Unmarshal(data, b); err != nil {
+				return nil, ErrProject
+			}
+			t.Blocks[id] = b
+		}
+		p.Targets = append(p.Targets, t)
+	}
+	return p, nil
+}
+
+// ReadSB3 从sb3压缩包中读取并解析 project.json
+func ReadSB3(r io.ReaderAt, size int64) (*Project, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrProject
+	}
+	for _, f := range zr.File {
+		if f.Name != "project.json" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(rc, 64<<20))
+		if err != nil {
+			return nil, err
+		}
+		return Parse(data)
+	}
+	return nil, ErrProject
+}
+
+// ReadSB3File 读取并解析sb3文件
+func ReadSB3File(filename string) (*Project, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return ReadSB3(f, info.Size())
+}
+
+// Sprites 角色数量（不含舞台）
+func (p *Project) Sprites() int {
+	n := 0
+	for _, t := range p.Targets {
+		if !t.IsStage {
+			n++
+		}
+	}
+	return n
+}
+
+// Count 统计积木数量，opcode以*结尾时按前缀匹配，多个opcode用|分隔
+func (p *Project) Count(opcode string) int {
+	n := 0
+	for _, t := range p.Targets {
+		for _, b := range t.Blocks {
+			if !b.Shadow && matchOpcode(opcode, b.Opcode) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Metrics 作品的基本统计
+func (p *Project) Metrics() map[string]int {
+	m := map[string]int{MetricSprites: p.Sprites()}
+	for _, t := range p.Targets {
+		m[MetricScripts] += len(t.Scripts())
+		m[MetricVariables] += t.Variables
+		m[MetricLists] += t.Lists
+		m[MetricBroadcasts] += t.Broadcasts
+		m[MetricCostumes] += t.Costumes
+		m[MetricSounds] += t.Sounds
+		for _, b := range t.Blocks {
+			if !b.Shadow {
+				m[MetricBlocks]++
+			}
+		}
+	}
+	m[MetricCustomBlocks] = p.Count("procedures_definition")
+	m[MetricClones] = p.Count("control_create_clone_of")
+	return m
+}
+
+// 作品统计项
+const (
+	MetricSprites      = "sprites"
+	MetricScripts      = "scripts"
+	MetricBlocks       = "blocks"
+	MetricVariables    = "variables"
+	MetricLists        = "lists"
+	MetricBroadcasts   = "broadcasts"
+	MetricCostumes     = "costumes"
+	MetricSounds       = "sounds"
+	MetricCustomBlocks = "customBlocks"
+	MetricClones       = "clones"
+)
+
+func matchOpcode(pattern, opcode string) bool {
+	for _, p := range strings.Split(pattern, "|") {
+		p = strings.TrimSpace(p)
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(opcode, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if p == opcode {
+			return true
+		}
+	}
+	return false
+}
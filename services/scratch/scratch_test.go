@@ -0,0 +1,121 @@
+package scratch
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+const testProject = `{
+  "targets": [
+    {"isStage": true, "name": "Stage", "variables": {"v1": ["分数", 0]}, "lists": {}, "broadcasts": {"b1": "开始"},
+     "blocks": {"x": [12, "分数", "v1", 10, 10]}, "costumes": [{}], "sounds": []},
+    {"isStage": false, "name": "小猫", "variables": {}, "lists": {"l1": ["记录", []]}, "broadcasts": {},
+     "blocks": {
+       "a": {"opcode": "event_whenflagclicked", "next": "b", "parent": null, "inputs": {}, "fields": {}, "shadow": false, "topLevel": true},
+       "b": {"opcode": "control_forever", "next": null, "parent": "a", "inputs": {"SUBSTACK": [2, "c"]}, "fields": {}, "shadow": false, "topLevel": false},
+       "c": {"opcode": "control_if_else", "next": null, "parent": "b", "inputs": {}, "fields": {}, "shadow": false, "topLevel": false},
+       "d": {"opcode": "event_whenflagclicked", "next": "e", "parent": null, "inputs": {}, "fields": {}, "shadow": false, "topLevel": true},
+       "e": {"opcode": "data_setvariableto", "next": "f", "parent": "d", "inputs": {}, "fields": {"VARIABLE": ["分数", "v1"]}, "shadow": false, "topLevel": false},
+       "f": {"opcode": "event_broadcast", "next": null, "parent": "e", "inputs": {}, "fields": {}, "shadow": false, "topLevel": false},
+       "g": {"opcode": "event_whenkeypressed", "next": "h", "parent": null, "inputs": {}, "fields": {"KEY_OPTION": ["space", null]}, "shadow": false, "topLevel": true},
+       "h": {"opcode": "motion_movesteps", "next": null, "parent": "g", "inputs": {}, "fields": {}, "shadow": false, "topLevel": false}
+     }, "costumes": [{}, {}], "sounds": [{}]},
+    {"isStage": false, "name": "球", "variables": {}, "lists": {}, "broadcasts": {}, "blocks": {}, "costumes": [{}], "sounds": []}
+  ],
+  "extensions": []
+}`
+
+func TestAssess(t *testing.T) {
+	p, err := Parse([]byte(testProject))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []*Check{
+		{Label: "使用了循环", Kind: CheckBlock, Target: "control_repeat|control_forever|control_repeat_until", Min: 1},
+		{Label: "至少两个角色", Kind: CheckMetric, Target: MetricSprites, Min: 2},
+		{Label: "使用了列表", Kind: CheckDimension, Target: DataRepresentation, Min: 3},
+	}
+	if err := ValidateChecks(checks); err != nil {
+		t.Fatal(err)
+	}
+	a := Assess(p, checks)
+	want := map[string]int{
+		Abstraction: 1, Parallelism: 1, Logic: 2, Synchronization: 2,
+		FlowControl: 2, UserInteractivity: 2, DataRepresentation: 2,
+	}
+	for _, d := range a.Dimensions {
+		if d.Score != want[d.Key] {
+			t.Errorf("%s = %d, want %d", d.Key, d.Score, want[d.Key])
+		}
+	}
+	if a.Total != 12 || a.Level != LevelDeveloping {
+		t.Errorf("total = %d level = %s", a.Total, a.Level)
+	}
+	if a.Metrics[MetricScripts] != 3 || a.Metrics[MetricBlocks] != 8 || a.Metrics[MetricLists] != 1 {
+		t.Errorf("metrics = %v", a.Metrics)
+	}
+	if a.Passed != 2 || !a.Checks[0].Passed || !a.Checks[1].Passed || a.Checks[2].Passed {
+		t.Errorf("checks = %+v", a.Checks)
+	}
+	if ValidateChecks([]*Check{{Label: "x", Kind: CheckMetric, Target: "lines", Min: 1}}) == nil {
+		t.Error("unknown metric accepted")
+	}
+}
+
+func TestReadSB3(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("project.json")
+	w.Write([]byte(testProject))
+	zw.Close()
+	p, err := ReadSB3(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Sprites() != 2 {
+		t.Errorf("sprites = %d", p.Sprites())
+	}
+	if _, err := ReadSB3(bytes.NewReader([]byte("not a zip")), 9); err != ErrProject {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	p, err := Parse([]byte(`{
+  "targets": [
+    {"isStage": true, "name": "Stage", "variables": {"v1": ["☁ 最高分", 0, true], "v2": ["分数", 0]}, "blocks": {}, "costumes": [{}], "sounds": [{}]},
+    {"isStage": false, "name": "画笔", "variables": {}, "blocks": {
+       "a": {"opcode": "event_whenflagclicked", "next": "b", "topLevel": true},
+       "b": {"opcode": "pen_penDown", "next": "c", "parent": "a"},
+       "c": {"opcode": "music_playDrumForBeats", "next": null, "parent": "b", "inputs": {"DRUM": [1, "m"]}},
+       "m": {"opcode": "music_menu_DRUM", "shadow": true, "parent": "c", "fields": {"DRUM": ["1", null]}}
+     }, "costumes": [{}, {}], "sounds": []}
+  ],
+  "extensions": ["pen"]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := p.Metadata()
+	if meta.Sprites != 1 || meta.Blocks != 3 || meta.Costumes != 3 || meta.Sounds != 1 || !meta.CloudVariables {
+		t.Errorf("meta = %+v", meta)
+	}
+	if meta.Categories["event"] != 1 || meta.Categories["pen"] != 1 || meta.Categories["music"] != 1 {
+		t.Errorf("categories = %v", meta.Categories)
+	}
+	if len(meta.Extensions) != 2 || meta.Extensions[0] != "music" || meta.Extensions[1] != "pen" {
+		t.Errorf("extensions = %v", meta.Extensions)
+	}
+	if m := mustParse(t, testProject).Metadata(); m.CloudVariables || len(m.Extensions) != 0 {
+		t.Errorf("meta = %+v", m)
+	}
+}
+
+func mustParse(t *testing.T, s string) *Project {
+	p, err := Parse([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -0,0 +1,25 @@
+package search
+
+// Default 服务进程内的全局索引
+var Default = NewIndex(NewSegmenter(baseWords...))
+
+// baseWords 内置词典，收录课程、工具、作品中的常用词，可通过词典文件补充
+var baseWords = []string{
+	// 编程
+	"编程", "程序", "程序设计", "代码", "变量", "函数", "循环", "条件", "判断", "列表", "数组", "字符串",
+	"算法", "事件", "消息", "广播", "角色", "舞台", "背景", "造型", "积木", "脚本", "坐标", "随机数",
+	"运算", "逻辑", "调试", "输入", "输出", "克隆", "画笔", "声音", "音乐", "动画", "游戏", "故事",
+	"图形化", "图形化编程", "少儿编程", "人工智能", "机器学习", "传感器", "舵机", "电机", "控制器", "主板",
+	"机器人", "开源硬件", "物联网", "电路", "电子", "蜂鸣器", "超声波", "红外", "蓝牙", "无线",
+	// 三维设计与制造
+	"三维", "建模", "三维建模", "设计", "模型", "打印", "打印机", "切片", "激光", "雕刻", "切割",
+	"草图", "拉伸", "旋转", "倒角", "圆角", "布尔", "组合", "阵列", "镜像", "尺寸", "零件", "装配",
+	"结构", "材料", "创客", "创意", "发明", "作品", "工具", "课程", "课时", "练习", "作业",
+	// 学科
+	"数学", "物理", "化学", "生物", "地理", "历史", "语文", "英语", "科学", "美术", "天文",
+	"几何", "方程", "分数", "小数", "平面", "立体", "速度", "重力", "力学", "光学", "能量",
+	// 常用
+	"入门", "基础", "进阶", "高级", "初级", "中级", "教程", "介绍", "认识", "学习", "制作",
+	"实践", "项目", "综合", "案例", "挑战", "任务", "目标", "方法", "步骤", "原理", "应用",
+	"第一课", "第二课", "第三课", "小学", "初中", "高中", "老师", "学生", "班级",
+}
@@ -0,0 +1,298 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// 文档类型
+const (
+	TypeCourse = "course"
+	TypeLesson = "lesson"
+	TypeTool   = "tool"
+	TypeWork   = "work"
+)
+
+// BM25 参数，标题中的词权重高于正文
+const (
+	bm25K1      = 1.2
+	bm25B       = 0.75
+	titleBoost  = 3.0
+	snippetSize = 60
+)
+
+// Document 被索引的文档
+type Document struct {
+	Type     string // 文档类型
+	ID       string // 文档ID
+	ParentID string // 上级ID，课时为所属课程ID
+	Title    string // 标题
+	Body     string // 正文
+	Icon     string // 图标或封面
+	Private  bool   // 正文不能公开显示，检索结果的摘要使用Snippet而不从正文截取
+	Snippet  string // 正文不能公开显示时的摘要
+}
+
+// Result 检索结果
+type Result struct {
+	Type     string  `json:"type"`
+	ID       string  `json:"id"`
+	ParentID string  `json:"parentID,omitempty"`
+	Title    string  `json:"title"`
+	Snippet  string  `json:"snippet"`
+	Icon     string  `json:"icon,omitempty"`
+	Score    float64 `json:"score"`
+}
+
+type posting struct {
+	title int // 词在标题中出现的次数
+	body  int // 词在正文中出现的次数
+}
+
+type entry struct {
+	doc    Document
+	length int // 文档的词数
+}
+
+// Index 内存倒排索引，可并发读写
+type Index struct {
+	seg *Segmenter
+
+	mu       sync.RWMutex
+	docs     map[string]*entry
+	postings map[string]map[string]posting
+	totalLen int
+}
+
+// NewIndex 创建使用指定分词器的索引
+func NewIndex(seg *Segmenter) *Index {
+	return &Index{
+		seg:      seg,
+		docs:     make(map[string]*entry),
+		postings: make(map[string]map[string]posting),
+	}
+}
+
+// Segmenter 索引使用的分词器，可用于补充词典
+func (idx *Index) Segmenter() *Segmenter {
+	return idx.seg
+}
+
+// Len 索引中的文档数
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Add 添加文档，已存在的同类型同ID文档会被替换
+func (idx *Index) Add(doc Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.add(doc)
+}
+
+// Remove 删除文档
+func (idx *Index) Remove(docType, id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(docKey(docType, id))
+}
+
+// Reset 用docs重建整个索引，分词在加锁前完成，重建期间不影响检索
+func (idx *Index) Reset(docs []Document) {
+	fresh := NewIndex(idx.seg)
+	for _, doc := range docs {
+		fresh.add(doc)
+	}
+	idx.mu.Lock()
+	idx.docs, idx.postings, idx.totalLen = fresh.docs, fresh.postings, fresh.totalLen
+	idx.mu.Unlock()
+}
+
+// Search 检索，types为空时检索所有类型，返回按相关度排序的第offset起最多limit条结果和结果总数
+func (idx *Index) Search(query string, types []string, offset, limit int) ([]Result, int) {
+	terms := unique(idx.seg.Cut(query))
+	if len(terms) == 0 {
+		return []Result{}, 0
+	}
+	allowed := make(map[string]bool)
+	for _, t := range types {
+		allowed[t] = true
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	n := float64(len(idx.docs))
+	avgLen := 1.0
+	if len(idx.docs) > 0 && idx.totalLen > 0 {
+		avgLen = float64(idx.totalLen) / n
+	}
+	scores := make(map[string]float64)
+	matched := make(map[string]int)
+	for _, term := range terms {
+		list := idx.postings[term]
+		if len(list) == 0 {
+			continue
+		}
+		df := float64(len(list))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for key, p := range list {
+			e := idx.docs[key]
+			if len(allowed) > 0 && !allowed[e.doc.Type] {
+				continue
+			}
+			tf := titleBoost*float64(p.title) + float64(p.body)
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(e.length)/avgLen)
+			scores[key] += idf * tf * (bm25K1 + 1) / (tf + norm)
+			matched[key]++
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for key, score := range scores {
+		// 命中的查询词越多越靠前
+		score *= float64(matched[key]) / float64(len(terms))
+		e := idx.docs[key]
+		results = append(results, Result{
+			Type:     e.doc.Type,
+			ID:       e.doc.ID,
+			ParentID: e.doc.ParentID,
+			Title:    e.doc.Title,
+			Icon:     e.doc.Icon,
+			Score:    score,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+
+	total := len(results)
+	if offset >= total {
+		return []Result{}, total
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	page := results[offset:end]
+	for i := range page {
+		doc := idx.docs[docKey(page[i].Type, page[i].ID)].doc
+		if doc.Private {
+			page[i].Snippet = doc.Snippet
+		} else {
+			page[i].Snippet = snippet(doc.Body, terms)
+		}
+	}
+	return page, total
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本服务的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+func docKey(docType, id string) string {
+	return docType + ":" + id
+}
+
+// add 添加文档，调用方负责加锁
+func (idx *Index) add(doc Document) {
+	key := docKey(doc.Type, doc.ID)
+	idx.remove(key)
+	counts := make(map[string]posting)
+	titleTokens := idx.seg.Cut(doc.Title)
+	bodyTokens := idx.seg.Cut(doc.Body)
+	for _, t := range append(titleTokens, Chars(doc.Title)...) {
+		p := counts[t]
+		p.title++
+		counts[t] = p
+	}
+	for _, t := range append(bodyTokens, Chars(doc.Body)...) {
+		p := counts[t]
+		p.body++
+		counts[t] = p
+	}
+	for term, p := range counts {
+		list := idx.postings[term]
+		if list == nil {
+			list = make(map[string]posting)
+			idx.postings[term] = list
+		}
+		list[key] = p
+	}
+	length := len(titleTokens) + len(bodyTokens)
+	idx.docs[key] = &entry{doc: doc, length: length}
+	idx.totalLen += length
+}
+
+// remove 删除文档，调用方负责加锁
+func (idx *Index) remove(key string) {
+	e, ok := idx.docs[key]
+	if !ok {
+		return
+	}
+	text := e.doc.Title + " " + e.doc.Body
+	for _, t := range append(idx.seg.Cut(text), Chars(text)...) {
+		if list := idx.postings[t]; list != nil {
+			delete(list, key)
+			if len(list) == 0 {
+				delete(idx.postings, t)
+			}
+		}
+	}
+	idx.totalLen -= e.length
+	delete(idx.docs, key)
+}
+
+func unique(tokens []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, t := range tokens {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// snippet 截取正文中第一个命中词附近的文字作为摘要
+func snippet(body string, terms []string) string {
+	body = strings.Join(strings.Fields(body), " ")
+	lower := strings.ToLower(body)
+	pos := -1
+	for _, t := range terms {
+		if i := strings.Index(lower, t); i >= 0 && (pos < 0 || i < pos) {
+			pos = i
+		}
+	}
+	runes := []rune(body)
+	start := 0
+	if pos > 0 {
+		start = utf8.RuneCountInString(lower[:pos]) - snippetSize/4
+		if start < 0 {
+			start = 0
+		}
+	}
+	end := start + snippetSize
+	if end > len(runes) {
+		end = len(runes)
+	}
+	s := string(runes[start:end])
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
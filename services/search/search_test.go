@@ -0,0 +1,63 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCut(t *testing.T) {
+	seg := NewSegmenter("编程", "图形化编程", "三维建模")
+	cases := map[string][]string{
+		"图形化编程入门":        {"图形化编程", "入门"},
+		"Scratch三维建模":    {"scratch", "三维建模"},
+		"机器人, micro bit": {"机器", "器人", "micro", "bit"},
+		"学":              {"学"},
+	}
+	for text, want := range cases {
+		if got := seg.Cut(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("Cut(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := NewIndex(NewSegmenter("编程", "三维建模"))
+	idx.Reset([]Document{
+		{Type: TypeCourse, ID: "1", Title: "Scratch编程入门", Body: "图形化编程课程"},
+		{Type: TypeLesson, ID: "2", ParentID: "1", Title: "第一课", Body: "认识舞台和角色，开始编程"},
+		{Type: TypeTool, ID: "3", Title: "三维建模工具", Body: "3d"},
+	})
+	results, total := idx.Search("编程", nil, 0, 10)
+	if total != 2 || results[0].ID != "1" {
+		t.Fatalf("search 编程: total %d, results %+v", total, results)
+	}
+	if results, _ = idx.Search("编程", []string{TypeLesson}, 0, 10); len(results) != 1 || results[0].ParentID != "1" {
+		t.Errorf("search with type filter: %+v", results)
+	}
+
+	// 单字查询命中分词后的词语
+	if _, total = idx.Search("程", nil, 0, 10); total != 2 {
+		t.Errorf("search 程: total %d, want 2", total)
+	}
+
+	idx.Remove(TypeCourse, "1")
+	if _, total = idx.Search("编程", nil, 0, 10); total != 1 {
+		t.Errorf("after remove: total %d, want 1", total)
+	}
+	idx.Add(Document{Type: TypeLesson, ID: "2", Title: "第一课", Body: "认识舞台"})
+	if _, total = idx.Search("编程", nil, 0, 10); total != 0 {
+		t.Errorf("after replace: total %d, want 0", total)
+	}
+	if _, total = idx.Search("程", nil, 0, 10); total != 0 {
+		t.Errorf("after replace: search 程 total %d, want 0", total)
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	idx := NewIndex(NewSegmenter())
+	idx.Reset([]Document{{Type: TypeLesson, ID: "1", Title: "第一课", Body: "答案是四十二", Private: true, Snippet: "视频：认识舞台"}})
+	results, _ := idx.Search("答案", nil, 0, 10)
+	if len(results) != 1 || results[0].Snippet != "视频：认识舞台" {
+		t.Errorf("results %+v", results)
+	}
+}
@@ -0,0 +1,158 @@
+// @APIVersion 1.0.0
+// @Title 全文检索服务
+// @Description 中文分词与倒排索引，供课程、课时、工具、作品的全文检索使用
+// @Contact xuchuangxin@icanmake.cn
+// @TermsOfServiceUrl https://maiyajia.com/
+// @License
+// @LicenseUrl
+
+package search
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maxWordLen 词典中词语的最大长度（按字计算）
+const maxWordLen = 8
+
+// Segmenter 中文分词器。
+// 中文部分采用基于词典的正向最大匹配，词典未收录的连续汉字按二元组切分，保证未登录词也能被检索到；
+// 英文和数字按单词切分并转为小写。索引时另外按单字索引（见 Chars），单字查询也能检索到。
+type Segmenter struct {
+	mu    sync.RWMutex
+	words map[string]bool
+}
+
+// NewSegmenter 创建分词器，words为初始词典
+func NewSegmenter(words ...string) *Segmenter {
+	seg := &Segmenter{words: make(map[string]bool)}
+	for _, w := range words {
+		seg.AddWord(w)
+	}
+	return seg
+}
+
+// AddWord 向词典添加一个词
+func (seg *Segmenter) AddWord(word string) {
+	word = strings.ToLower(strings.TrimSpace(word))
+	if word == "" || len([]rune(word)) > maxWordLen {
+		return
+	}
+	seg.mu.Lock()
+	seg.words[word] = true
+	seg.mu.Unlock()
+}
+
+// LoadDictionary 从词典文件加载词语，每行一个词，#开头为注释
+func (seg *Segmenter) LoadDictionary(filename string) error {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return seg.ReadDictionary(fp)
+}
+
+// ReadDictionary 从reader读取词典
+func (seg *Segmenter) ReadDictionary(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// 兼容“词语 词频 词性”格式的词典，只取第一列
+		seg.AddWord(strings.Fields(line)[0])
+	}
+	return scanner.Err()
+}
+
+// Cut 对文本分词，返回的词语均为小写
+func (seg *Segmenter) Cut(text string) []string {
+	var tokens []string
+	var latin []rune
+	var han []rune
+	flushLatin := func() {
+		if len(latin) > 0 {
+			tokens = append(tokens, strings.ToLower(string(latin)))
+			latin = latin[:0]
+		}
+	}
+	flushHan := func() {
+		if len(han) > 0 {
+			tokens = append(tokens, seg.cutHan(han)...)
+			han = han[:0]
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushLatin()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			latin = append(latin, r)
+		default:
+			flushLatin()
+			flushHan()
+		}
+	}
+	flushLatin()
+	flushHan()
+	return tokens
+}
+
+// Chars 文本中的每个汉字，索引时与分词结果一起索引
+func Chars(text string) []string {
+	var chars []string
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			chars = append(chars, string(r))
+		}
+	}
+	return chars
+}
+
+// cutHan 对一段连续的汉字做正向最大匹配，未匹配的单字与下一个字组成二元组
+func (seg *Segmenter) cutHan(han []rune) []string {
+	seg.mu.RLock()
+	defer seg.mu.RUnlock()
+	var tokens []string
+	var pending []rune // 未登录的连续汉字
+	flushPending := func() {
+		switch len(pending) {
+		case 0:
+		case 1:
+			tokens = append(tokens, string(pending))
+		default:
+			for i := 0; i+1 < len(pending); i++ {
+				tokens = append(tokens, string(pending[i:i+2]))
+			}
+		}
+		pending = pending[:0]
+	}
+	for i := 0; i < len(han); {
+		matched := 0
+		for l := maxWordLen; l >= 2; l-- {
+			if i+l <= len(han) && seg.words[string(han[i:i+l])] {
+				matched = l
+				break
+			}
+		}
+		if matched == 0 {
+			pending = append(pending, han[i])
+			i++
+			continue
+		}
+		flushPending()
+		tokens = append(tokens, string(han[i:i+matched]))
+		i += matched
+	}
+	flushPending()
+	return tokens
+}
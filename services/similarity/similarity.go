@@ -0,0 +1,246 @@
+// Package similarity 计算作品指纹并比较作品的相似度，用于发现互相抄袭的作品。
+// Scratch作品按积木结构（含参数和字段）切片后取最小哈希草图估计Jaccard相似度；
+// STL模型按与位置无关的几何特征（面片数、表面积、体积、包围盒、顶点到中心的距离分布）比较
+package similarity
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"maiyajia.com/services/scratch"
+	"maiyajia.com/services/stl"
+)
+
+// 指纹类型
+const (
+	KindScratch = "sb3"
+	KindMesh    = "stl"
+)
+
+const (
+	sketchSize  = 256 //草图保留的最小哈希数
+	shingleSize = 4   //积木序列切片长度
+	histBins    = 16  //距离分布的分组数
+)
+
+// Fingerprint 作品指纹
+type Fingerprint struct {
+	Kind     string    `bson:"kind" json:"kind"`
+	Digest   string    `bson:"digest" json:"-"`             //文件内容的sha1
+	Sketch   []int64   `bson:"sketch,omitempty" json:"-"`   //积木序列切片哈希中最小的若干个，升序
+	Features []float64 `bson:"features,omitempty" json:"-"` //几何特征
+}
+
+// Digest 计算文件内容的sha1
+func Digest(r io.Reader) (string, error) {
+	h := sha1.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Scratch 计算Scratch作品的指纹
+func Scratch(p *scratch.Project, digest string) Fingerprint {
+	set := make(map[int64]bool)
+	for _, t := range p.Targets {
+		for _, s := range t.Scripts() {
+			tokens := scriptTokens(t, s.ID, make(map[string]bool))
+			set[hash(strings.Join(tokens, " "))] = true
+			for i := 0; i+shingleSize <= len(tokens); i++ {
+				set[hash(strings.Join(tokens[i:i+shingleSize], " "))] = true
+			}
+		}
+	}
+	sketch := make([]int64, 0, len(set))
+	for h := range set {
+		sketch = append(sketch, h)
+	}
+	sort.Slice(sketch, func(i, j int) bool { return sketch[i] < sketch[j] })
+	if len(sketch) > sketchSize {
+		sketch = sketch[:sketchSize]
+	}
+	return Fingerprint{Kind: KindScratch, Digest: digest, Sketch: sketch}
+}
+
+// Mesh 计算STL模型的指纹
+func Mesh(m *stl.Mesh, digest string) Fingerprint {
+	min, max := m.Bounds()
+	size := max.Sub(min)
+	dims := []float64{size.X, size.Y, size.Z}
+	sort.Float64s(dims)
+	features := []float64{float64(len(m.Triangles)), m.Area(), m.Volume(), dims[0], dims[1], dims[2]}
+
+	c := m.Centroid()
+	var dists []float64
+	far := 0.0
+	for _, t := range m.Triangles {
+		for _, v := range t.V {
+			d := v.Sub(c).Len()
+			dists = append(dists, d)
+			far = math.Max(far, d)
+		}
+	}
+	hist := make([]float64, histBins)
+	for _, d := range dists {
+		i := histBins - 1
+		if far > 0 {
+			i = int(d / far * histBins)
+		}
+		if i >= histBins {
+			i = histBins - 1
+		}
+		hist[i] += 1 / float64(len(dists))
+	}
+	return Fingerprint{Kind: KindMesh, Digest: digest, Features: append(features, hist...)}
+}
+
+// Compare 比较两个指纹，返回0~1的相似度，类型不同时为0，文件内容相同时为1
+func Compare(a, b Fingerprint) float64 {
+	if a.Kind != b.Kind {
+		return 0
+	}
+	if a.Digest != "" && a.Digest == b.Digest {
+		return 1
+	}
+	switch a.Kind {
+	case KindScratch:
+		return compareSketch(a.Sketch, b.Sketch)
+	case KindMesh:
+		return compareFeatures(a.Features, b.Features)
+	}
+	return 0
+}
+
+// compareSketch 用两个草图并集中最小的 sketchSize 个哈希估计Jaccard相似度
+func compareSketch(a, b []int64) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	i, j, n, both := 0, 0, 0, 0
+	for n < sketchSize && (i < len(a) || j < len(b)) {
+		switch {
+		case j >= len(b) || (i < len(a) && a[i] < b[j]):
+			i++
+		case i >= len(a) || b[j] < a[i]:
+			j++
+		default:
+			both++
+			i++
+			j++
+		}
+		n++
+	}
+	return float64(both) / float64(n)
+}
+
+func compareFeatures(a, b []float64) float64 {
+	const scalars = 6
+	if len(a) != scalars+histBins || len(b) != len(a) {
+		return 0
+	}
+	s := 0.0
+	for i := 0; i < scalars; i++ {
+		if m := math.Max(math.Abs(a[i]), math.Abs(b[i])); m > 0 {
+			s += 1 - math.Abs(a[i]-b[i])/m
+		} else {
+			s++
+		}
+	}
+	s /= scalars
+	diff := 0.0
+	for i := scalars; i < len(a); i++ {
+		diff += math.Abs(a[i] - b[i])
+	}
+	return (s + 1 - diff/2) / 2
+}
+
+// scriptTokens 把脚本展开为积木序列：积木名、字段、参数（字面值或嵌套的积木），C形积木内部用 { } 包围
+func scriptTokens(t *scratch.Target, id string, seen map[string]bool) []string {
+	var tokens []string
+	for id != "" && !seen[id] {
+		seen[id] = true
+		b, ok := t.Blocks[id]
+		if !ok {
+			break
+		}
+		tokens = append(tokens, b.Opcode)
+		tokens = append(tokens, argTokens(t, b, seen)...)
+		for _, name := range []string{"SUBSTACK", "SUBSTACK2"} {
+			if sub := b.Input(name); sub != "" {
+				tokens = append(tokens, "{")
+				tokens = append(tokens, scriptTokens(t, sub, seen)...)
+				tokens = append(tokens, "}")
+			}
+		}
+		if b.Next == nil {
+			break
+		}
+		id = *b.Next
+	}
+	return tokens
+}
+
+// argTokens 积木的字段和参数，按名称排序
+func argTokens(t *scratch.Target, b *scratch.Block, seen map[string]bool) []string {
+	var tokens []string
+	var names []string
+	for name := range b.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tokens = append(tokens, name+"="+b.Field(name))
+	}
+	names = names[:0]
+	for name := range b.Inputs {
+		if name != "SUBSTACK" && name != "SUBSTACK2" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ref := b.Input(name)
+		arg, ok := t.Blocks[ref]
+		if ok && arg.Shadow {
+			// 下拉菜单等影子积木，取其字段值
+			for field := range arg.Fields {
+				tokens = append(tokens, name+"="+arg.Field(field))
+			}
+		} else if ok && !seen[ref] {
+			seen[ref] = true
+			tokens = append(tokens, "("+arg.Opcode)
+			tokens = append(tokens, argTokens(t, arg, seen)...)
+			tokens = append(tokens, ")")
+		} else if lit := literal(b, name); lit != "" {
+			tokens = append(tokens, name+"="+lit)
+		}
+	}
+	return tokens
+}
+
+// literal 参数中直接填写的值，如 [1, [4, "10"]]
+func literal(b *scratch.Block, name string) string {
+	var input []interface{}
+	if err := json.Unmarshal(b.Inputs[name], &input); err != nil || len(input) < 2 {
+		return ""
+	}
+	if v, ok := input[1].([]interface{}); ok && len(v) >= 2 {
+		if s, ok := v[1].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+func hash(s string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,66 @@
+package similarity
+
+import (
+	"fmt"
+	"testing"
+
+	"maiyajia.com/services/scratch"
+	"maiyajia.com/services/stl"
+)
+
+func testProject(t *testing.T, steps string, key string) *scratch.Project {
+	data := fmt.Sprintf(`{"targets": [{"isStage": false, "name": "小猫", "blocks": {
+	  "a": {"opcode": "event_whenkeypressed", "next": "b", "fields": {"KEY_OPTION": [%q, null]}, "topLevel": true},
+	  "b": {"opcode": "control_repeat", "next": "e", "parent": "a", "inputs": {"TIMES": [1, [6, "10"]], "SUBSTACK": [2, "c"]}},
+	  "c": {"opcode": "motion_movesteps", "next": "d", "parent": "b", "inputs": {"STEPS": [1, [4, %q]]}},
+	  "d": {"opcode": "motion_turnright", "next": null, "parent": "c", "inputs": {"DEGREES": [1, [4, "15"]]}},
+	  "e": {"opcode": "looks_say", "next": null, "parent": "b", "inputs": {"MESSAGE": [1, [10, "你好"]]}}
+	}}]}`, key, steps)
+	p, err := scratch.Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestScratch(t *testing.T) {
+	a := Scratch(testProject(t, "10", "space"), "1")
+	b := Scratch(testProject(t, "10", "space"), "2")
+	c := Scratch(testProject(t, "25", "left arrow"), "3")
+	if s := Compare(a, b); s != 1 {
+		t.Errorf("same structure = %v", s)
+	}
+	if s := Compare(a, c); s <= 0 || s >= 0.8 {
+		t.Errorf("different parameters = %v", s)
+	}
+}
+
+func cube(size float64, offset stl.Vec3) *stl.Mesh {
+	v := func(x, y, z float64) stl.Vec3 { return stl.Vec3{X: x * size, Y: y * size, Z: z * size}.Add(offset) }
+	quad := func(a, b, c, d stl.Vec3) []stl.Triangle {
+		return []stl.Triangle{{V: [3]stl.Vec3{a, b, c}}, {V: [3]stl.Vec3{a, c, d}}}
+	}
+	m := &stl.Mesh{}
+	m.Triangles = append(m.Triangles, quad(v(0, 0, 0), v(0, 1, 0), v(1, 1, 0), v(1, 0, 0))...)
+	m.Triangles = append(m.Triangles, quad(v(0, 0, 1), v(1, 0, 1), v(1, 1, 1), v(0, 1, 1))...)
+	m.Triangles = append(m.Triangles, quad(v(0, 0, 0), v(1, 0, 0), v(1, 0, 1), v(0, 0, 1))...)
+	m.Triangles = append(m.Triangles, quad(v(0, 1, 0), v(0, 1, 1), v(1, 1, 1), v(1, 1, 0))...)
+	m.Triangles = append(m.Triangles, quad(v(0, 0, 0), v(0, 0, 1), v(0, 1, 1), v(0, 1, 0))...)
+	m.Triangles = append(m.Triangles, quad(v(1, 0, 0), v(1, 1, 0), v(1, 1, 1), v(1, 0, 1))...)
+	return m
+}
+
+func TestMesh(t *testing.T) {
+	a := Mesh(cube(10, stl.Vec3{}), "1")
+	b := Mesh(cube(10, stl.Vec3{X: 50, Y: -3}), "2")
+	c := Mesh(cube(30, stl.Vec3{}), "3")
+	if s := Compare(a, b); s < 0.99 {
+		t.Errorf("moved cube = %v", s)
+	}
+	if s := Compare(a, c); s > Compare(a, b)-0.2 {
+		t.Errorf("bigger cube = %v", s)
+	}
+	if m := cube(10, stl.Vec3{}); m.Volume() < 999 || m.Volume() > 1001 {
+		t.Errorf("volume = %v", m.Volume())
+	}
+}
@@ -0,0 +1,76 @@
+package stl
+
+import "math"
+
+// Report 模型的几何检查结果
+type Report struct {
+	Triangles        int     `bson:"triangles" json:"triangles"`
+	Min              Vec3    `bson:"min" json:"min"`
+	Max              Vec3    `bson:"max" json:"max"`
+	Size             Vec3    `bson:"size" json:"size"`
+	Volume           float64 `bson:"volume" json:"volume"` //立方毫米，网格不封闭时仅供参考
+	Area             float64 `bson:"area" json:"area"`     //平方毫米
+	Watertight       bool    `bson:"watertight" json:"watertight"`
+	Manifold         bool    `bson:"manifold" json:"manifold"`
+	Oriented         bool    `bson:"oriented" json:"oriented"`                 //相邻面片的朝向一致
+	OpenEdges        int     `bson:"openEdges" json:"openEdges"`               //只属于一个面片的边（破洞）
+	NonManifoldEdges int     `bson:"nonManifoldEdges" json:"nonManifoldEdges"` //属于三个以上面片的边
+	FlippedEdges     int     `bson:"flippedEdges" json:"flippedEdges"`         //两侧面片朝向相反的边
+	Degenerate       int     `bson:"degenerate" json:"degenerate"`             //面积为0的面片
+}
+
+// Check 检查模型的尺寸和网格拓扑。顶点按模型尺寸的百万分之一合并后统计每条边相邻的面片：
+// 每条边恰好属于两个面片时网格封闭，且两个面片沿相反方向经过这条边时朝向一致
+func (m *Mesh) Check() Report {
+	r := Report{Triangles: len(m.Triangles), Area: m.Area(), Volume: m.Volume()}
+	r.Min, r.Max = m.Bounds()
+	r.Size = r.Max.Sub(r.Min)
+	if len(m.Triangles) == 0 {
+		return r
+	}
+	eps := math.Max(r.Size.X, math.Max(r.Size.Y, r.Size.Z)) * 1e-6
+	if eps == 0 {
+		eps = 1e-9
+	}
+	ids := make(map[[3]int64]int)
+	vertex := func(v Vec3) int {
+		key := [3]int64{int64(math.Round(v.X / eps)), int64(math.Round(v.Y / eps)), int64(math.Round(v.Z / eps))}
+		id, ok := ids[key]
+		if !ok {
+			id = len(ids)
+			ids[key] = id
+		}
+		return id
+	}
+	directed := make(map[[2]int]int)
+	undirected := make(map[[2]int]int)
+	for _, t := range m.Triangles {
+		v := [3]int{vertex(t.V[0]), vertex(t.V[1]), vertex(t.V[2])}
+		if v[0] == v[1] || v[1] == v[2] || v[2] == v[0] || t.Area() < eps*eps {
+			r.Degenerate++
+			continue
+		}
+		for i := 0; i < 3; i++ {
+			a, b := v[i], v[(i+1)%3]
+			directed[[2]int{a, b}]++
+			if a > b {
+				a, b = b, a
+			}
+			undirected[[2]int{a, b}]++
+		}
+	}
+	for e, n := range undirected {
+		switch {
+		case n == 1:
+			r.OpenEdges++
+		case n > 2:
+			r.NonManifoldEdges++
+		case directed[e] != 1:
+			r.FlippedEdges++
+		}
+	}
+	r.Manifold = r.NonManifoldEdges == 0
+	r.Watertight = r.Manifold && r.OpenEdges == 0 && len(undirected) > 0
+	r.Oriented = r.FlippedEdges == 0
+	return r
+}
@@ -0,0 +1,199 @@
+package stl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// ErrUnsupported 不支持的模型格式
+var ErrUnsupported = errors.New("stl: unsupported format")
+
+// 模型格式
+const (
+	FormatSTL      = "stl"       //二进制STL
+	FormatSTLASCII = "stl-ascii" //ASCII STL
+	FormatOBJ      = "obj"       //Wavefront OBJ
+	Format3MF      = "3mf"       //3D Manufacturing Format
+)
+
+// Unit 长度单位，MM 为一个单位等于多少毫米
+type Unit struct {
+	Name    string
+	MM      float64
+	ThreeMF string //3MF文件中的单位名称
+}
+
+var units = []Unit{
+	{Name: "mm", MM: 1, ThreeMF: "millimeter"},
+	{Name: "cm", MM: 10, ThreeMF: "centimeter"},
+	{Name: "m", MM: 1000, ThreeMF: "meter"},
+	{Name: "in", MM: 25.4, ThreeMF: "inch"},
+}
+
+// Millimeter 毫米，作品文件统一使用的单位
+var Millimeter = units[0]
+
+// ParseUnit 解析单位名称（mm、cm、m、in，也可以用3MF的名称），空字符串为毫米
+func ParseUnit(name string) (Unit, bool) {
+	if name == "" {
+		return Millimeter, true
+	}
+	for _, u := range units {
+		if strings.EqualFold(name, u.Name) || strings.EqualFold(name, u.ThreeMF) {
+			return u, true
+		}
+	}
+	return Unit{}, false
+}
+
+// FormatOf 按文件扩展名判断模型格式
+func FormatOf(filename string) string {
+	i := strings.LastIndex(filename, ".")
+	if i < 0 {
+		return ""
+	}
+	switch strings.ToLower(filename[i+1:]) {
+	case "stl":
+		return FormatSTL
+	case "obj":
+		return FormatOBJ
+	case "3mf":
+		return Format3MF
+	}
+	return ""
+}
+
+// Extension 模型格式的文件扩展名，不支持的格式返回空字符串
+func Extension(format string) string {
+	switch format {
+	case FormatSTL, FormatSTLASCII:
+		return "stl"
+	case FormatOBJ:
+		return "obj"
+	case Format3MF:
+		return "3mf"
+	}
+	return ""
+}
+
+// Scale 按比例缩放模型
+func (m *Mesh) Scale(s float64) {
+	for i := range m.Triangles {
+		t := &m.Triangles[i]
+		for j := range t.V {
+			t.V[j] = t.V[j].Scale(s)
+		}
+	}
+}
+
+// Decode 解析模型文件，坐标换算为毫米。STL和OBJ文件没有单位，按unit换算；3MF按文件中声明的单位换算
+func Decode(data []byte, format string, unit Unit) (*Mesh, error) {
+	var m *Mesh
+	var err error
+	switch format {
+	case FormatSTL, FormatSTLASCII:
+		m, err = Parse(data)
+	case FormatOBJ:
+		m, err = parseOBJ(data)
+	case Format3MF:
+		return parse3MF(data)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	if unit.MM != 1 {
+		m.Scale(unit.MM)
+	}
+	return m, nil
+}
+
+// Encode 把以毫米为单位的模型按format格式写出，坐标换算为unit
+func Encode(w io.Writer, m *Mesh, format string, unit Unit) error {
+	out := m
+	if unit.MM != 1 && format != Format3MF {
+		out = &Mesh{Name: m.Name, Triangles: append([]Triangle(nil), m.Triangles...)}
+		out.Scale(1 / unit.MM)
+	}
+	switch format {
+	case FormatSTL:
+		return writeBinary(w, out)
+	case FormatSTLASCII:
+		return writeASCII(w, out)
+	case FormatOBJ:
+		return writeOBJ(w, out)
+	case Format3MF:
+		return write3MF(w, m, unit)
+	}
+	return ErrUnsupported
+}
+
+// facetNormal 按顶点计算面片的单位法向
+func facetNormal(t Triangle) Vec3 {
+	return t.V[1].Sub(t.V[0]).Cross(t.V[2].Sub(t.V[0])).Normalize()
+}
+
+func writeBinary(w io.Writer, m *Mesh) error {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 80)
+	copy(header, m.Name)
+	bw.Write(header)
+	binary.Write(bw, binary.LittleEndian, uint32(len(m.Triangles)))
+	buf := make([]byte, 50)
+	put := func(b []byte, v Vec3) {
+		binary.LittleEndian.PutUint32(b[0:], math.Float32bits(float32(v.X)))
+		binary.LittleEndian.PutUint32(b[4:], math.Float32bits(float32(v.Y)))
+		binary.LittleEndian.PutUint32(b[8:], math.Float32bits(float32(v.Z)))
+	}
+	for _, t := range m.Triangles {
+		put(buf[0:], facetNormal(t))
+		put(buf[12:], t.V[0])
+		put(buf[24:], t.V[1])
+		put(buf[36:], t.V[2])
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeASCII(w io.Writer, m *Mesh) error {
+	bw := bufio.NewWriter(w)
+	name := strings.Join(strings.Fields(m.Name), "_")
+	fmt.Fprintf(bw, "solid %s\n", name)
+	for _, t := range m.Triangles {
+		n := facetNormal(t)
+		fmt.Fprintf(bw, "  facet normal %g %g %g\n    outer loop\n", n.X, n.Y, n.Z)
+		for _, v := range t.V {
+			fmt.Fprintf(bw, "      vertex %g %g %g\n", v.X, v.Y, v.Z)
+		}
+		fmt.Fprintf(bw, "    endloop\n  endfacet\n")
+	}
+	fmt.Fprintf(bw, "endsolid %s\n", name)
+	return bw.Flush()
+}
+
+// indexed 合并相同的顶点，返回顶点表和每个面片的顶点序号
+func (m *Mesh) indexed() ([]Vec3, [][3]int) {
+	ids := make(map[Vec3]int)
+	var vertices []Vec3
+	faces := make([][3]int, len(m.Triangles))
+	for i, t := range m.Triangles {
+		for j, v := range t.V {
+			id, ok := ids[v]
+			if !ok {
+				id = len(vertices)
+				ids[v] = id
+				vertices = append(vertices, v)
+			}
+			faces[i][j] = id
+		}
+	}
+	return vertices, faces
+}
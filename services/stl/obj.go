@@ -0,0 +1,89 @@
+package stl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseOBJ 解析Wavefront OBJ，只读取顶点和面，多边形按扇形拆分成三角形，忽略纹理、法向和材质
+func parseOBJ(data []byte) (*Mesh, error) {
+	m := &Mesh{}
+	var vertices []Vec3
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "o":
+			if m.Name == "" {
+				m.Name = strings.Join(fields[1:], " ")
+			}
+		case "v":
+			if len(fields) < 4 {
+				return nil, ErrFormat
+			}
+			var v [3]float64
+			for i := range v {
+				x, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil {
+					return nil, ErrFormat
+				}
+				v[i] = x
+			}
+			vertices = append(vertices, Vec3{v[0], v[1], v[2]})
+		case "f":
+			if len(fields) < 4 {
+				return nil, ErrFormat
+			}
+			face := make([]Vec3, len(fields)-1)
+			for i, f := range fields[1:] {
+				// 顶点的格式为 v、v/vt、v//vn 或 v/vt/vn，负数表示从当前末尾倒数
+				n, err := strconv.Atoi(strings.SplitN(f, "/", 2)[0])
+				if err != nil {
+					return nil, ErrFormat
+				}
+				if n < 0 {
+					n += len(vertices) + 1
+				}
+				if n < 1 || n > len(vertices) {
+					return nil, ErrFormat
+				}
+				face[i] = vertices[n-1]
+			}
+			for i := 1; i+1 < len(face); i++ {
+				t := Triangle{V: [3]Vec3{face[0], face[i], face[i+1]}}
+				t.Normal = facetNormal(t)
+				m.Triangles = append(m.Triangles, t)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(m.Triangles) == 0 {
+		return nil, ErrFormat
+	}
+	return m, nil
+}
+
+func writeOBJ(w io.Writer, m *Mesh) error {
+	bw := bufio.NewWriter(w)
+	vertices, faces := m.indexed()
+	if m.Name != "" {
+		fmt.Fprintf(bw, "o %s\n", strings.Join(strings.Fields(m.Name), "_"))
+	}
+	for _, v := range vertices {
+		fmt.Fprintf(bw, "v %g %g %g\n", v.X, v.Y, v.Z)
+	}
+	for _, f := range faces {
+		fmt.Fprintf(bw, "f %d %d %d\n", f[0]+1, f[1]+1, f[2]+1)
+	}
+	return bw.Flush()
+}
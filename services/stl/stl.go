@@ -0,0 +1,228 @@
+// Package stl 读取ASCII和二进制格式的STL三维模型，并在STL、OBJ、3MF格式之间转换
+package stl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrFormat 不是有效的STL文件
+var ErrFormat = errors.New("stl: invalid file")
+
+// 最大读取的文件大小
+const maxFileSize = 256 << 20
+
+// Vec3 三维向量
+type Vec3 struct {
+	X, Y, Z float64
+}
+
+// Add 向量加
+func (a Vec3) Add(b Vec3) Vec3 { return Vec3{a.X + b.X, a.Y + b.Y, a.Z + b.Z} }
+
+// Sub 向量减
+func (a Vec3) Sub(b Vec3) Vec3 { return Vec3{a.X - b.X, a.Y - b.Y, a.Z - b.Z} }
+
+// Scale 数乘
+func (a Vec3) Scale(s float64) Vec3 { return Vec3{a.X * s, a.Y * s, a.Z * s} }
+
+// Dot 点积
+func (a Vec3) Dot(b Vec3) float64 { return a.X*b.X + a.Y*b.Y + a.Z*b.Z }
+
+// Cross 叉积
+func (a Vec3) Cross(b Vec3) Vec3 {
+	return Vec3{a.Y*b.Z - a.Z*b.Y, a.Z*b.X - a.X*b.Z, a.X*b.Y - a.Y*b.X}
+}
+
+// Len 长度
+func (a Vec3) Len() float64 { return math.Sqrt(a.Dot(a)) }
+
+// Normalize 单位向量，零向量返回自身
+func (a Vec3) Normalize() Vec3 {
+	if l := a.Len(); l > 0 {
+		return a.Scale(1 / l)
+	}
+	return a
+}
+
+// Triangle 三角面片，V按右手法则排列
+type Triangle struct {
+	Normal Vec3
+	V      [3]Vec3
+}
+
+// Area 面积
+func (t Triangle) Area() float64 {
+	return t.V[1].Sub(t.V[0]).Cross(t.V[2].Sub(t.V[0])).Len() / 2
+}
+
+// Mesh 三角网格模型
+type Mesh struct {
+	Name      string
+	Triangles []Triangle
+}
+
+// Read 读取STL，自动识别ASCII和二进制格式
+func Read(r io.Reader) (*Mesh, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFileSize {
+		return nil, ErrFormat
+	}
+	return Parse(data)
+}
+
+// ReadFile 读取STL文件
+func ReadFile(filename string) (*Mesh, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Parse 解析STL数据。部分软件导出的二进制STL文件头也以solid开头，因此优先按长度判断二进制格式
+func Parse(data []byte) (*Mesh, error) {
+	if len(data) >= 84 {
+		n := binary.LittleEndian.Uint32(data[80:84])
+		if uint64(len(data)) == 84+50*uint64(n) {
+			return parseBinary(data, int(n))
+		}
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("solid")) {
+		return parseASCII(data)
+	}
+	return nil, ErrFormat
+}
+
+// Bounds 包围盒
+func (m *Mesh) Bounds() (min, max Vec3) {
+	if len(m.Triangles) == 0 {
+		return
+	}
+	min = m.Triangles[0].V[0]
+	max = min
+	for _, t := range m.Triangles {
+		for _, v := range t.V {
+			min = Vec3{math.Min(min.X, v.X), math.Min(min.Y, v.Y), math.Min(min.Z, v.Z)}
+			max = Vec3{math.Max(max.X, v.X), math.Max(max.Y, v.Y), math.Max(max.Z, v.Z)}
+		}
+	}
+	return
+}
+
+// Area 表面积
+func (m *Mesh) Area() float64 {
+	area := 0.0
+	for _, t := range m.Triangles {
+		area += t.Area()
+	}
+	return area
+}
+
+// Volume 体积（按面片与原点构成的有向四面体累加），网格不封闭时结果没有意义
+func (m *Mesh) Volume() float64 {
+	v := 0.0
+	for _, t := range m.Triangles {
+		v += t.V[0].Dot(t.V[1].Cross(t.V[2])) / 6
+	}
+	return math.Abs(v)
+}
+
+// Centroid 顶点的平均位置
+func (m *Mesh) Centroid() Vec3 {
+	var c Vec3
+	for _, t := range m.Triangles {
+		c = c.Add(t.V[0]).Add(t.V[1]).Add(t.V[2])
+	}
+	if n := len(m.Triangles) * 3; n > 0 {
+		c = c.Scale(1 / float64(n))
+	}
+	return c
+}
+
+func parseBinary(data []byte, n int) (*Mesh, error) {
+	m := &Mesh{Name: strings.TrimRight(string(data[:80]), "\x00 "), Triangles: make([]Triangle, n)}
+	vec := func(b []byte) Vec3 {
+		return Vec3{
+			float64(math.Float32frombits(binary.LittleEndian.Uint32(b[0:4]))),
+			float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4:8]))),
+			float64(math.Float32frombits(binary.LittleEndian.Uint32(b[8:12]))),
+		}
+	}
+	for i := 0; i < n; i++ {
+		b := data[84+50*i:]
+		m.Triangles[i] = Triangle{Normal: vec(b[0:]), V: [3]Vec3{vec(b[12:]), vec(b[24:]), vec(b[36:])}}
+	}
+	return m, nil
+}
+
+func parseASCII(data []byte) (*Mesh, error) {
+	m := &Mesh{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var t Triangle
+	nv := 0
+	parse := func(fields []string) (Vec3, error) {
+		if len(fields) != 3 {
+			return Vec3{}, ErrFormat
+		}
+		var v [3]float64
+		for i, f := range fields {
+			x, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return Vec3{}, ErrFormat
+			}
+			v[i] = x
+		}
+		return Vec3{v[0], v[1], v[2]}, nil
+	}
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var err error
+		switch fields[0] {
+		case "solid":
+			if m.Name == "" {
+				m.Name = strings.Join(fields[1:], " ")
+			}
+		case "facet":
+			if len(fields) < 2 || fields[1] != "normal" {
+				return nil, ErrFormat
+			}
+			t, nv = Triangle{}, 0
+			t.Normal, err = parse(fields[2:])
+		case "vertex":
+			if nv >= 3 {
+				return nil, ErrFormat
+			}
+			t.V[nv], err = parse(fields[1:])
+			nv++
+		case "endfacet":
+			if nv != 3 {
+				return nil, ErrFormat
+			}
+			m.Triangles = append(m.Triangles, t)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
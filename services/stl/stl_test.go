@@ -0,0 +1,171 @@
+package stl
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+const asciiTetra = `solid tetra
+facet normal 0 0 -1
+ outer loop
+  vertex 0 0 0
+  vertex 0 1 0
+  vertex 1 0 0
+ endloop
+endfacet
+facet normal 0 -1 0
+ outer loop
+  vertex 0 0 0
+  vertex 1 0 0
+  vertex 0 0 1
+ endloop
+endfacet
+facet normal -1 0 0
+ outer loop
+  vertex 0 0 0
+  vertex 0 0 1
+  vertex 0 1 0
+ endloop
+endfacet
+facet normal 1 1 1
+ outer loop
+  vertex 1 0 0
+  vertex 0 1 0
+  vertex 0 0 1
+ endloop
+endfacet
+endsolid tetra
+`
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]byte(asciiTetra))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "tetra" || len(m.Triangles) != 4 {
+		t.Fatalf("mesh = %+v", m)
+	}
+	if v := m.Volume(); math.Abs(v-1.0/6) > 1e-9 {
+		t.Errorf("volume = %v", v)
+	}
+
+	// 文件头以solid开头的二进制STL
+	var buf bytes.Buffer
+	header := make([]byte, 80)
+	copy(header, "solid exported")
+	buf.Write(header)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(m.Triangles)))
+	for _, tri := range m.Triangles {
+		for _, v := range append([]Vec3{tri.Normal}, tri.V[:]...) {
+			binary.Write(&buf, binary.LittleEndian, [3]float32{float32(v.X), float32(v.Y), float32(v.Z)})
+		}
+		buf.Write([]byte{0, 0})
+	}
+	b, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Triangles) != 4 || b.Triangles[3].V[2] != (Vec3{0, 0, 1}) {
+		t.Errorf("binary mesh = %+v", b.Triangles)
+	}
+	if _, err := Parse([]byte("solid x\nfacet normal 0 0\n")); err != ErrFormat {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	m, _ := Parse([]byte(asciiTetra))
+	r := m.Check()
+	if r.Triangles != 4 || !r.Watertight || !r.Manifold || !r.Oriented || r.Size != (Vec3{1, 1, 1}) {
+		t.Errorf("tetra report = %+v", r)
+	}
+
+	// 去掉一个面后有3条破洞边
+	open := &Mesh{Triangles: m.Triangles[:3]}
+	if r := open.Check(); r.Watertight || r.OpenEdges != 3 {
+		t.Errorf("open report = %+v", r)
+	}
+
+	// 翻转一个面的朝向
+	flipped := &Mesh{Triangles: append([]Triangle(nil), m.Triangles...)}
+	tri := flipped.Triangles[3]
+	tri.V[1], tri.V[2] = tri.V[2], tri.V[1]
+	flipped.Triangles[3] = tri
+	if r := flipped.Check(); !r.Watertight || r.Oriented || r.FlippedEdges != 3 {
+		t.Errorf("flipped report = %+v", r)
+	}
+
+	// 多一个共边的面片，边属于三个面片
+	extra := &Mesh{Triangles: append(append([]Triangle(nil), m.Triangles...), Triangle{V: [3]Vec3{{0, 0, 0}, {1, 0, 0}, {0, -1, 0}}})}
+	if r := extra.Check(); r.Manifold || r.NonManifoldEdges != 1 {
+		t.Errorf("non-manifold report = %+v", r)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	m, _ := Parse([]byte(asciiTetra))
+	cm, _ := ParseUnit("cm")
+	for _, format := range []string{FormatSTL, FormatSTLASCII, FormatOBJ, Format3MF} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, format, cm); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		back, err := Decode(buf.Bytes(), format, cm)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if len(back.Triangles) != 4 || math.Abs(back.Volume()-1.0/6) > 1e-6 {
+			t.Errorf("%s: triangles = %d volume = %v", format, len(back.Triangles), back.Volume())
+		}
+		if r := back.Check(); !r.Watertight || !r.Oriented {
+			t.Errorf("%s: report = %+v", format, r)
+		}
+	}
+
+	// 英寸的OBJ，四边形拆成两个三角形
+	in, _ := ParseUnit("inch")
+	q, err := Decode([]byte("v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\nf 1/1 2/2 3/3 -1/4\n"), FormatOBJ, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Triangles) != 2 || math.Abs(q.Area()-25.4*25.4) > 1e-6 {
+		t.Errorf("obj area = %v", q.Area())
+	}
+	if _, ok := ParseUnit("furlong"); ok {
+		t.Error("unknown unit accepted")
+	}
+	if FormatOf("a.3MF") != Format3MF || FormatOf("a.z1") != "" {
+		t.Error("format detection failed")
+	}
+}
+
+func TestParse3MF(t *testing.T) {
+	// 组件引用平移后的四面体，单位为厘米
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("3D/3dmodel.model")
+	w.Write([]byte(`<model unit="centimeter" xmlns="http://schemas.microsoft.com/3dmanufacturing/core/2015/02">
+ <resources>
+  <object id="1" type="model"><mesh>
+   <vertices><vertex x="0" y="0" z="0"/><vertex x="1" y="0" z="0"/><vertex x="0" y="1" z="0"/><vertex x="0" y="0" z="1"/></vertices>
+   <triangles><triangle v1="0" v2="2" v3="1"/><triangle v1="0" v2="1" v3="3"/><triangle v1="0" v2="3" v3="2"/><triangle v1="1" v2="2" v3="3"/></triangles>
+  </mesh></object>
+  <object id="2" type="model"><components><component objectid="1" transform="1 0 0 0 1 0 0 0 1 2 0 0"/></components></object>
+ </resources>
+ <build><item objectid="2" transform="1 0 0 0 1 0 0 0 1 0 0 3"/></build>
+</model>`))
+	zw.Close()
+	m, err := Decode(buf.Bytes(), Format3MF, Millimeter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if min, max := m.Bounds(); min != (Vec3{20, 0, 30}) || max != (Vec3{30, 10, 40}) {
+		t.Errorf("bounds = %v %v", min, max)
+	}
+	if _, err := Decode([]byte("not a zip"), Format3MF, Millimeter); err != ErrFormat {
+		t.Errorf("err = %v", err)
+	}
+}
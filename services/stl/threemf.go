@@ -0,0 +1,287 @@
+package stl
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// 3MF 文件内的路径和命名空间
+const (
+	threeMFModelPath = "3D/3dmodel.model"
+	threeMFCoreNS    = "http://schemas.microsoft.com/3dmanufacturing/core/2015/02"
+	threeMFRelType   = "http://schemas.microsoft.com/3dmanufacturing/2013/01/3dmodel"
+)
+
+// 3MF 单位换算为毫米的倍数，包括 Unit 中没有的单位
+var threeMFUnits = map[string]float64{
+	"micron":     0.001,
+	"millimeter": 1,
+	"centimeter": 10,
+	"inch":       25.4,
+	"foot":       304.8,
+	"meter":      1000,
+}
+
+type xmlModel struct {
+	Unit    string      `xml:"unit,attr"`
+	Objects []xmlObject `xml:"resources>object"`
+	Items   []xmlItem   `xml:"build>item"`
+}
+
+type xmlObject struct {
+	ID         int            `xml:"id,attr"`
+	Name       string         `xml:"name,attr"`
+	Vertices   []xmlVertex    `xml:"mesh>vertices>vertex"`
+	Triangles  []xmlTriangle  `xml:"mesh>triangles>triangle"`
+	Components []xmlComponent `xml:"components>component"`
+}
+
+type xmlVertex struct {
+	X float64 `xml:"x,attr"`
+	Y float64 `xml:"y,attr"`
+	Z float64 `xml:"z,attr"`
+}
+
+type xmlTriangle struct {
+	V1 int `xml:"v1,attr"`
+	V2 int `xml:"v2,attr"`
+	V3 int `xml:"v3,attr"`
+}
+
+type xmlComponent struct {
+	ObjectID  int    `xml:"objectid,attr"`
+	Transform string `xml:"transform,attr"`
+}
+
+type xmlItem struct {
+	ObjectID  int    `xml:"objectid,attr"`
+	Transform string `xml:"transform,attr"`
+}
+
+// transform 3MF 的仿射变换矩阵，按行存放 m00 m01 m02 m10 ... m32，点按行向量右乘
+type transform [12]float64
+
+var identity = transform{1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0}
+
+func parseTransform(s string) (transform, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return identity, nil
+	}
+	var t transform
+	if len(fields) != len(t) {
+		return t, ErrFormat
+	}
+	for i, f := range fields {
+		x, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return t, ErrFormat
+		}
+		t[i] = x
+	}
+	return t, nil
+}
+
+func (t transform) apply(v Vec3) Vec3 {
+	return Vec3{
+		v.X*t[0] + v.Y*t[3] + v.Z*t[6] + t[9],
+		v.X*t[1] + v.Y*t[4] + v.Z*t[7] + t[10],
+		v.X*t[2] + v.Y*t[5] + v.Z*t[8] + t[11],
+	}
+}
+
+// then 先做t再做u的变换
+func (t transform) then(u transform) transform {
+	var r transform
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 3; col++ {
+			x := t[row*3]*u[col] + t[row*3+1]*u[3+col] + t[row*3+2]*u[6+col]
+			if row == 3 {
+				x += u[9+col]
+			}
+			r[row*3+col] = x
+		}
+	}
+	return r
+}
+
+// parse3MF 解析3MF，把打印清单(build)中的所有物体按变换合并成一个网格，坐标换算为毫米
+func parse3MF(data []byte) (*Mesh, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, ErrFormat
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+	modelPath := threeMFModelPath
+	if rels, err := readZipFile(files["_rels/.rels"]); err == nil {
+		var r struct {
+			Relationships []struct {
+				Target string `xml:"Target,attr"`
+				Type   string `xml:"Type,attr"`
+			} `xml:"Relationship"`
+		}
+		if xml.Unmarshal(rels, &r) == nil {
+			for _, rel := range r.Relationships {
+				if rel.Type == threeMFRelType {
+					modelPath = strings.TrimPrefix(rel.Target, "/")
+				}
+			}
+		}
+	}
+	doc, err := readZipFile(files[modelPath])
+	if err != nil {
+		return nil, ErrFormat
+	}
+	var model xmlModel
+	if err := xml.Unmarshal(doc, &model); err != nil {
+		return nil, ErrFormat
+	}
+	scale := 1.0
+	if model.Unit != "" {
+		s, ok := threeMFUnits[model.Unit]
+		if !ok {
+			return nil, ErrFormat
+		}
+		scale = s
+	}
+	objects := make(map[int]*xmlObject)
+	for i := range model.Objects {
+		objects[model.Objects[i].ID] = &model.Objects[i]
+	}
+	m := &Mesh{}
+	// 组件可以引用其他物体，depth 防止循环引用
+	var add func(id int, t transform, depth int) error
+	add = func(id int, t transform, depth int) error {
+		obj, ok := objects[id]
+		if !ok || depth > 16 {
+			return ErrFormat
+		}
+		if m.Name == "" {
+			m.Name = obj.Name
+		}
+		for _, tri := range obj.Triangles {
+			var ft Triangle
+			for i, n := range [3]int{tri.V1, tri.V2, tri.V3} {
+				if n < 0 || n >= len(obj.Vertices) {
+					return ErrFormat
+				}
+				v := obj.Vertices[n]
+				ft.V[i] = t.apply(Vec3{v.X, v.Y, v.Z}).Scale(scale)
+			}
+			ft.Normal = facetNormal(ft)
+			m.Triangles = append(m.Triangles, ft)
+		}
+		for _, c := range obj.Components {
+			ct, err := parseTransform(c.Transform)
+			if err != nil {
+				return err
+			}
+			if err := add(c.ObjectID, ct.then(t), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, item := range model.Items {
+		t, err := parseTransform(item.Transform)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(item.ObjectID, t, 0); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.Triangles) == 0 {
+		return nil, ErrFormat
+	}
+	return m, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	if f == nil {
+		return nil, ErrFormat
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFileSize {
+		return nil, ErrFormat
+	}
+	return data, nil
+}
+
+// write3MF 写出只包含一个物体的3MF，坐标按unit换算
+func write3MF(w io.Writer, m *Mesh, unit Unit) error {
+	zw := zip.NewWriter(w)
+	parts := []struct {
+		name, content string
+	}{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+ <Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+ <Default Extension="model" ContentType="application/vnd.ms-package.3dmanufacturing-3dmodel+xml"/>
+</Types>
+`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+ <Relationship Target="/` + threeMFModelPath + `" Id="rel0" Type="` + threeMFRelType + `"/>
+</Relationships>
+`},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, p.content); err != nil {
+			return err
+		}
+	}
+	f, err := zw.Create(threeMFModelPath)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	vertices, faces := m.indexed()
+	s := 1 / unit.MM
+	fmt.Fprintf(bw, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<model unit=\"%s\" xml:lang=\"zh-CN\" xmlns=\"%s\">\n", unit.ThreeMF, threeMFCoreNS)
+	bw.WriteString(" <resources>\n  <object id=\"1\" type=\"model\"")
+	if m.Name != "" {
+		bw.WriteString(" name=\"")
+		xml.EscapeText(bw, []byte(m.Name))
+		bw.WriteString("\"")
+	}
+	bw.WriteString(">\n   <mesh>\n    <vertices>\n")
+	for _, v := range vertices {
+		fmt.Fprintf(bw, "     <vertex x=\"%g\" y=\"%g\" z=\"%g\"/>\n", v.X*s, v.Y*s, v.Z*s)
+	}
+	bw.WriteString("    </vertices>\n    <triangles>\n")
+	for _, t := range faces {
+		// 3MF 不允许退化的三角形
+		if t[0] == t[1] || t[1] == t[2] || t[0] == t[2] {
+			continue
+		}
+		fmt.Fprintf(bw, "     <triangle v1=\"%d\" v2=\"%d\" v3=\"%d\"/>\n", t[0], t[1], t[2])
+	}
+	bw.WriteString("    </triangles>\n   </mesh>\n  </object>\n </resources>\n <build>\n  <item objectid=\"1\"/>\n </build>\n</model>\n")
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local 本地目录存储
+type Local struct {
+	Dir string
+}
+
+// NewLocal 创建根目录为dir的本地存储
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+// Path 文件在本地的路径
+func (l *Local) Path(name string) string {
+	return filepath.Join(l.Dir, filepath.FromSlash(name))
+}
+
+// Open 读取文件
+func (l *Local) Open(name string) (io.ReadCloser, error) {
+	if !ValidName(name) {
+		return nil, ErrName
+	}
+	return os.Open(l.Path(name))
+}
+
+// Stat 查询文件的大小和修改时间
+func (l *Local) Stat(name string) (Info, error) {
+	if !ValidName(name) {
+		return Info{}, ErrName
+	}
+	info, err := os.Stat(l.Path(name))
+	if err != nil {
+		return Info{}, err
+	}
+	if info.IsDir() {
+		return Info{}, notExist("stat", name)
+	}
+	return Info{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Put 先写入同目录下的临时文件再改名，替换硬链接时不会修改其他链接的内容
+func (l *Local) Put(name string, r io.Reader) error {
+	if !ValidName(name) {
+		return ErrName
+	}
+	target := l.Path(name)
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	fp, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fp, r)
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// 临时文件的权限为0600，改为和 os.Create 创建的文件一样
+		os.Chmod(fp.Name(), 0644)
+		err = os.Rename(fp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(fp.Name())
+	}
+	return err
+}
+
+// PutFile 把本地文件移动到存储中，不在同一个文件系统时复制
+func (l *Local) PutFile(name, filename string) error {
+	if !ValidName(name) {
+		return ErrName
+	}
+	target := l.Path(name)
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(filename, target); err == nil {
+		return nil
+	}
+	fp, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	err = l.Put(name, fp)
+	fp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+// Copy 用硬链接复制文件，文件系统不支持硬链接时复制内容
+func (l *Local) Copy(src, dst string) error {
+	if !ValidName(src) || !ValidName(dst) {
+		return ErrName
+	}
+	target := l.Path(dst)
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".link")
+	os.Remove(tmp)
+	if err := os.Link(l.Path(src), tmp); err != nil {
+		fp, err := os.Open(l.Path(src))
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		return l.Put(dst, fp)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Remove 删除文件
+func (l *Local) Remove(name string) error {
+	if !ValidName(name) {
+		return ErrName
+	}
+	err := os.Remove(l.Path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Walk 遍历目录下的文件，跳过写入中的临时文件
+func (l *Local) Walk(dir string, fn func(info Info) error) error {
+	root := l.Dir
+	if dir != "" {
+		if !ValidName(dir) {
+			return ErrName
+		}
+		root = l.Path(dir)
+	}
+	err := filepath.Walk(root, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Dir, filename)
+		if err != nil {
+			return err
+		}
+		return fn(Info{Name: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) serve(w http.ResponseWriter, r *http.Request, name string) error {
+	if !ValidName(name) {
+		return ErrName
+	}
+	fp, err := os.Open(l.Path(name))
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return notExist("open", name)
+	}
+	http.ServeContent(w, r, path.Base(name), info.ModTime(), fp)
+	return nil
+}
@@ -0,0 +1,435 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptySum 空请求体的SHA-256
+const emptySum = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 兼容S3的对象存储，按路径访问 <Endpoint>/<Bucket>/<name>，请求使用AWS签名V4
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+// S3Error S3返回的错误
+type S3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s %s", e.Status, e.Code, e.Message)
+}
+
+// NewS3 创建S3存储，region 为空时为 us-east-1（MinIO的默认值）
+func NewS3(endpoint, region, bucket, accessKey, secretKey string) *S3 {
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    http.DefaultClient,
+	}
+}
+
+// Open 读取对象
+func (s *S3) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.get(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.responseError(resp, "open", name)
+	}
+	return resp.Body, nil
+}
+
+// Stat 查询对象的大小和修改时间
+func (s *S3) Stat(name string) (Info, error) {
+	if !ValidName(name) {
+		return Info{}, ErrName
+	}
+	resp, err := s.do("HEAD", name, nil, nil, nil, 0, emptySum)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, s.responseError(resp, "stat", name)
+	}
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Info{Name: name, Size: size, ModTime: modTime}, nil
+}
+
+// Put 先写入本地临时文件计算大小和SHA-256，再上传
+func (s *S3) Put(name string, r io.Reader) error {
+	if !ValidName(name) {
+		return ErrName
+	}
+	fp, err := ioutil.TempFile("", "s3put")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(fp, h), r)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return s.put(name, fp, size, hex.EncodeToString(h.Sum(nil)))
+}
+
+// PutFile 上传本地文件，上传成功后删除
+func (s *S3) PutFile(name, filename string) error {
+	if !ValidName(name) {
+		return ErrName
+	}
+	fp, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, fp)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.put(name, fp, size, hex.EncodeToString(h.Sum(nil))); err != nil {
+		return err
+	}
+	fp.Close()
+	return os.Remove(filename)
+}
+
+// Copy 在服务端复制对象，不经过本机
+func (s *S3) Copy(src, dst string) error {
+	if !ValidName(src) || !ValidName(dst) {
+		return ErrName
+	}
+	header := http.Header{"X-Amz-Copy-Source": {s.escapePath(src)}}
+	resp, err := s.do("PUT", dst, nil, header, nil, 0, emptySum)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return notExist("copy", src)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(resp, "copy", src)
+	}
+	// 复制失败时也可能返回200，错误在响应内容中
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(string(body), "<Error>") {
+		e := &S3Error{Status: resp.StatusCode}
+		xml.Unmarshal(body, e)
+		return e
+	}
+	return nil
+}
+
+// Remove 删除对象
+func (s *S3) Remove(name string) error {
+	if !ValidName(name) {
+		return ErrName
+	}
+	resp, err := s.do("DELETE", name, nil, nil, nil, 0, emptySum)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return s.responseError(resp, "remove", name)
+}
+
+// Walk 用 ListObjectsV2 分页遍历以 dir/ 开头的对象
+func (s *S3) Walk(dir string, fn func(info Info) error) error {
+	prefix := ""
+	if dir != "" {
+		if !ValidName(dir) {
+			return ErrName
+		}
+		prefix = dir + "/"
+	}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do("GET", "", query, nil, nil, 0, emptySum)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s.responseError(resp, "walk", dir)
+			resp.Body.Close()
+			return err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, obj := range result.Contents {
+			if strings.HasSuffix(obj.Key, "/") {
+				continue
+			}
+			if err := fn(Info{Name: obj.Key, Size: obj.Size, ModTime: obj.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+/*********************************************************************************************/
+/*********************************** 以下为本服务的内部函数 ***********************************/
+/*********************************** *********************************************************/
+
+// serve 转发Range和条件请求头，把S3的响应发送给客户端
+func (s *S3) serve(w http.ResponseWriter, r *http.Request, name string) error {
+	header := http.Header{}
+	for _, key := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+		if v := r.Header.Get(key); v != "" {
+			header.Set(key, v)
+		}
+	}
+	resp, err := s.get(name, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+	default:
+		return s.responseError(resp, "open", name)
+	}
+	for _, key := range []string{"Content-Length", "Content-Range", "ETag", "Last-Modified", "Accept-Ranges"} {
+		if v := resp.Header.Get(key); v != "" {
+			w.Header().Set(key, v)
+		}
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if ext := path.Ext(name); ext != "" && (contentType == "" || contentType == "application/octet-stream" || contentType == "binary/octet-stream") {
+		contentType = mimeType(ext)
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if r.Method != "HEAD" {
+		io.Copy(w, resp.Body)
+	}
+	return nil
+}
+
+func (s *S3) get(name string, header http.Header) (*http.Response, error) {
+	if !ValidName(name) {
+		return nil, ErrName
+	}
+	return s.do("GET", name, nil, header, nil, 0, emptySum)
+}
+
+func (s *S3) put(name string, body io.Reader, size int64, sum string) error {
+	header := http.Header{"Content-Type": {mimeType(path.Ext(name))}}
+	resp, err := s.do("PUT", name, nil, header, body, size, sum)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(resp, "put", name)
+	}
+	return nil
+}
+
+// do 发送签名的请求，name 为空时访问存储桶本身，sum 为请求体的SHA-256
+func (s *S3) do(method, name string, query url.Values, header http.Header, body io.Reader, size int64, sum string) (*http.Response, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/" + s.Bucket
+	if name != "" {
+		u.Path += "/" + name
+	}
+	u.RawPath = s.escapePath(name)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil && size > 0 {
+		req.Body = ioutil.NopCloser(body)
+		req.ContentLength = size
+	}
+	s.sign(req, sum, time.Now())
+	return s.Client.Do(req)
+}
+
+// escapePath 对象的路径 /<Bucket>/<name>，每一段按URI编码
+func (s *S3) escapePath(name string) string {
+	p := "/" + uriEncode(s.Bucket, false)
+	if name != "" {
+		p += "/" + uriEncode(name, true)
+	}
+	return p
+}
+
+// sign 按AWS签名V4计算 Authorization，签名Host和请求中的所有头
+func (s *S3) sign(req *http.Request, sum string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", sum)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for key := range headers {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, key := range names {
+		canonicalHeaders.WriteString(key + ":" + headers[key] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uri,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		sum,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// responseError 把错误响应转换为error，404 转换为文件不存在
+func (s *S3) responseError(resp *http.Response, op, name string) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return notExist(op, name)
+	}
+	e := &S3Error{Status: resp.StatusCode}
+	if body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096)); err == nil {
+		xml.Unmarshal(body, e)
+	}
+	return e
+}
+
+// mimeType 按扩展名得到 Content-Type，未知的扩展名为 application/octet-stream
+func mimeType(ext string) string {
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery 按参数名排序并按URI编码的查询参数
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, uriEncode(key, false)+"="+uriEncode(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode 除 A-Z a-z 0-9 - _ . ~ 以外的字节按 %XY 编码，keepSlash 时不编码 /
+func uriEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && keepSlash {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+		}
+	}
+	return b.String()
+}
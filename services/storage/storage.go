@@ -0,0 +1,178 @@
+// Package storage 文件存储后端：本地目录或兼容S3的对象存储（如MinIO）。
+// 文件名是以 / 分隔的相对路径，如 asset/works/<id>.stl；文件不存在时返回的错误满足 os.IsNotExist
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 存储后端
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ErrName 文件名不是有效的相对路径
+var ErrName = errors.New("storage: invalid name")
+
+// Info 文件信息
+type Info struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage 存储后端
+type Storage interface {
+	// Open 读取文件
+	Open(name string) (io.ReadCloser, error)
+	// Stat 查询文件的大小和修改时间
+	Stat(name string) (Info, error)
+	// Put 把r的内容保存为name，已存在时替换，读取方不会看到写了一半的文件
+	Put(name string, r io.Reader) error
+	// PutFile 把本地文件filename保存为name，保存后filename被删除
+	PutFile(name, filename string) error
+	// Copy 复制文件，dst 已存在时替换
+	Copy(src, dst string) error
+	// Remove 删除文件，文件不存在时不返回错误
+	Remove(name string) error
+	// Walk 遍历目录dir下的所有文件，dir 为空时遍历整个存储
+	Walk(dir string, fn func(info Info) error) error
+}
+
+// Config 存储后端的配置，Dir 为本地存储的根目录，其余为S3的配置
+type Config struct {
+	Backend   string
+	Dir       string
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// New 按配置创建存储后端
+func New(cfg Config) (Storage, error) {
+	switch cfg.Backend {
+	case "", BackendLocal:
+		return NewLocal(cfg.Dir), nil
+	case BackendS3:
+		if cfg.Endpoint == "" || cfg.Bucket == "" {
+			return nil, errors.New("storage: s3 endpoint and bucket are required")
+		}
+		return NewS3(cfg.Endpoint, cfg.Region, cfg.Bucket, cfg.AccessKey, cfg.SecretKey), nil
+	}
+	return nil, errors.New("storage: unknown backend " + cfg.Backend)
+}
+
+// ValidName 是否是有效的文件名：不以 / 开头、不含 .. 的规范相对路径
+func ValidName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return false
+	}
+	clean := path.Clean(name)
+	return clean == name && clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// ReadFile 读取文件的全部内容
+func ReadFile(st Storage, name string) ([]byte, error) {
+	rc, err := st.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// PutDir 把本地目录src下的所有文件保存到存储的dir目录下
+func PutDir(st Storage, dir, src string) error {
+	return filepath.Walk(src, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, filename)
+		if err != nil {
+			return err
+		}
+		fp, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		return st.Put(path.Join(dir, filepath.ToSlash(rel)), fp)
+	})
+}
+
+// RemoveAll 删除目录dir下的所有文件
+func RemoveAll(st Storage, dir string) error {
+	var names []string
+	err := st.Walk(dir, func(info Info) error {
+		names = append(names, info.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := st.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrate 把src中目录dir下的文件复制到dst，dst 中已有大小相同的文件时跳过，返回复制的文件数。
+// fn 在每个文件复制后调用，可以为nil
+func Migrate(src, dst Storage, dir string, fn func(info Info)) (int, error) {
+	copied := 0
+	err := src.Walk(dir, func(info Info) error {
+		if old, err := dst.Stat(info.Name); err == nil && old.Size == info.Size {
+			return nil
+		}
+		rc, err := src.Open(info.Name)
+		if err != nil {
+			return err
+		}
+		err = dst.Put(info.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		copied++
+		if fn != nil {
+			fn(info)
+		}
+		return nil
+	})
+	return copied, err
+}
+
+// Serve 发送存储中的文件，支持Range等条件请求。本地存储直接发送文件，S3 转发请求
+func Serve(w http.ResponseWriter, r *http.Request, st Storage, name string) error {
+	switch s := st.(type) {
+	case *Local:
+		return s.serve(w, r, name)
+	case *S3:
+		return s.serve(w, r, name)
+	}
+	rc, err := st.Open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", mimeType(path.Ext(name)))
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
@@ -0,0 +1,215 @@
+// Package upload 断点续传的分块上传。每个上传会话对应一个临时文件，分块按偏移量顺序追加写入并校验SHA-256，
+// 校验失败的分块会被丢弃；断线后按临时文件的大小从断点继续上传，全部接收后校验整个文件并原子地移动到目标位置
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNotFound 上传会话的临时文件不存在
+	ErrNotFound = errors.New("upload: session not found")
+	// ErrOffset 分块的偏移量与已接收的字节数不一致
+	ErrOffset = errors.New("upload: offset mismatch")
+	// ErrChecksum 分块或文件的SHA-256不一致
+	ErrChecksum = errors.New("upload: checksum mismatch")
+	// ErrSize 超出声明的文件大小
+	ErrSize = errors.New("upload: size exceeded")
+	// ErrIncomplete 文件还没有全部接收
+	ErrIncomplete = errors.New("upload: incomplete")
+	// ErrBusy 同一个会话正在写入另一个分块
+	ErrBusy = errors.New("upload: session busy")
+)
+
+// Store 上传临时文件的保存目录
+type Store struct {
+	Dir string
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// NewStore 创建保存在dir目录的上传存储
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir, busy: make(map[string]bool)}
+}
+
+// Create 为会话id创建空的临时文件
+func (s *Store) Create(id string) error {
+	if err := os.MkdirAll(s.Dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Offset 已接收的字节数，即下一个分块的偏移量
+func (s *Store) Offset(id string) (int64, error) {
+	info, err := os.Stat(s.path(id))
+	if os.IsNotExist(err) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Write 在offset处写入一个分块，size 为整个文件的大小，sum 为分块的SHA-256（十六进制，为空时不校验）。
+// 返回写入后已接收的字节数；写入失败或校验失败时丢弃该分块，已接收的字节数不变
+func (s *Store) Write(id string, offset, size int64, r io.Reader, sum string) (int64, error) {
+	if !s.lock(id) {
+		return 0, ErrBusy
+	}
+	defer s.unlock(id)
+	f, err := os.OpenFile(s.path(id), os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	received := info.Size()
+	if offset != received {
+		return received, ErrOffset
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return received, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(r, size-offset+1))
+	switch {
+	case err != nil:
+	case offset+n > size:
+		err = ErrSize
+	case sum != "" && !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), sum):
+		err = ErrChecksum
+	}
+	if err != nil {
+		if terr := f.Truncate(offset); terr != nil {
+			return received, terr
+		}
+		return received, err
+	}
+	return offset + n, nil
+}
+
+// Complete 检查文件已全部接收、SHA-256与sum一致（为空时不校验），然后把文件移动到dst。
+// 目标文件先写到同一目录的临时文件再改名，不会出现只写了一半的目标文件
+func (s *Store) Complete(id string, size int64, sum, dst string) error {
+	if !s.lock(id) {
+		return ErrBusy
+	}
+	defer s.unlock(id)
+	src := s.path(id)
+	received, err := s.Offset(id)
+	if err != nil {
+		return err
+	}
+	if received != size {
+		return ErrIncomplete
+	}
+	if sum != "" {
+		actual, err := fileSum(src)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(actual, sum) {
+			return ErrChecksum
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	// 临时目录和目标不在同一个文件系统时不能直接改名
+	tmp := dst + ".upload"
+	if err := copyFile(tmp, src); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Remove 删除会话的临时文件
+func (s *Store) Remove(id string) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.Dir, filepath.Base(id)+".part")
+}
+
+func (s *Store) lock(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy == nil {
+		s.busy = make(map[string]bool)
+	}
+	if s.busy[id] {
+		return false
+	}
+	s.busy[id] = true
+	return true
+}
+
+func (s *Store) unlock(id string) {
+	s.mu.Lock()
+	delete(s.busy, id)
+	s.mu.Unlock()
+}
+
+func fileSum(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
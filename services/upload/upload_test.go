@@ -0,0 +1,69 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sum(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewStore(filepath.Join(dir, "parts"))
+	data := []byte("0123456789abcdef")
+	size := int64(len(data))
+	if err := s.Create("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Write("a", 0, size, bytes.NewReader(data[:6]), sum(data[:6]))
+	if err != nil || n != 6 {
+		t.Fatalf("n = %d err = %v", n, err)
+	}
+	// 重复发送同一个分块（断线后不知道是否成功）
+	if n, err := s.Write("a", 0, size, bytes.NewReader(data[:6]), ""); err != ErrOffset || n != 6 {
+		t.Errorf("n = %d err = %v", n, err)
+	}
+	// 校验失败的分块被丢弃
+	if n, err := s.Write("a", 6, size, bytes.NewReader(data[6:10]), sum(data[:4])); err != ErrChecksum || n != 6 {
+		t.Errorf("n = %d err = %v", n, err)
+	}
+	if off, _ := s.Offset("a"); off != 6 {
+		t.Errorf("offset = %d", off)
+	}
+	if err := s.Complete("a", size, "", filepath.Join(dir, "out")); err != ErrIncomplete {
+		t.Errorf("err = %v", err)
+	}
+	// 超出声明的大小
+	if _, err := s.Write("a", 6, size, bytes.NewReader(append(data[6:], 'x')), ""); err != ErrSize {
+		t.Errorf("err = %v", err)
+	}
+	if n, err := s.Write("a", 6, size, bytes.NewReader(data[6:]), sum(data[6:])); err != nil || n != size {
+		t.Fatalf("n = %d err = %v", n, err)
+	}
+	if err := s.Complete("a", size, sum([]byte("other")), filepath.Join(dir, "out")); err != ErrChecksum {
+		t.Errorf("err = %v", err)
+	}
+	dst := filepath.Join(dir, "works", "out.sb3")
+	if err := s.Complete("a", size, sum(data), dst); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(dst); !bytes.Equal(got, data) {
+		t.Errorf("file = %q", got)
+	}
+	if _, err := s.Offset("a"); err != ErrNotFound {
+		t.Errorf("err = %v", err)
+	}
+}